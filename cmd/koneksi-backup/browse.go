@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/browse"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// browseTimeLayouts are the layouts accepted by --at, in local time.
+var browseTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseBrowseTime parses --at as an age such as 7d or as a time.
+func parseBrowseTime(value string) (time.Time, error) {
+	if age, err := config.ParseAge(value); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	for _, layout := range browseTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use an age such as 7d or a time such as 2024-05-01 13:00", value)
+}
+
+func browseCatalog(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var source browse.Source
+	switch {
+	case browseSnapshot != 0:
+		snapshot, err := db.GetSnapshot(browseSnapshot)
+		if err != nil {
+			return fmt.Errorf("snapshot %d not found: %w", browseSnapshot, err)
+		}
+		source = browse.Snapshot(db, snapshot)
+	case browseAt != "":
+		at, err := parseBrowseTime(browseAt)
+		if err != nil {
+			return err
+		}
+		source = browse.VersionsAt(db, at)
+	default:
+		source = browse.VersionsAt(db, time.Time{})
+	}
+
+	// Log lines would draw over the browser; failures are shown in it
+	restoreService := backup.NewRestoreService(newAPIClient(cfg, cfg.API.DirectoryID), zap.NewNop(), cfg.Backup.Concurrent)
+	restoreService.SetNoOwner(restoreNoOwner)
+	var targets []backup.Target
+	for _, replica := range replicaTargets(cfg) {
+		targets = append(targets, replica.Target)
+	}
+	restoreService.SetFallback(db, targets)
+
+	passwords := cfg.RestorePasswords()
+	if browsePassword != "" {
+		passwords = append([]string{browsePassword}, passwords...)
+	}
+
+	b, err := browse.New(source, restoreService, browseTo, passwords)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := browse.Run(ctx, b); err != nil && err != context.Canceled {
+		return err
+	}
+	return nil
+}
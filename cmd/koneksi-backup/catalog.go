@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+func catalogPush(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	result, err := backup.PushCatalog(context.Background(), newAPIClient(cfg, cfg.API.DirectoryID), db, cfg.CatalogPassword(), cfg.Catalog.Versions)
+	if err != nil {
+		return fmt.Errorf("failed to push catalog: %w", err)
+	}
+
+	fmt.Printf("Uploaded %s with %d backup records and %d snapshots.\n", result.Name, result.Records, result.Snapshots)
+	if result.Deleted > 0 {
+		fmt.Printf("Deleted %d old catalogs beyond the %d kept.\n", result.Deleted, cfg.Catalog.Versions)
+	}
+	return nil
+}
+
+func catalogRebuild(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	result, err := backup.RebuildCatalog(context.Background(), newAPIClient(cfg, cfg.API.DirectoryID), db, cfg.CatalogPassword(), catalogRebuildDryRun)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild catalog: %w", err)
+	}
+
+	if result.Catalog != nil {
+		fmt.Printf("Using the catalog uploaded at %s.\n", result.Catalog.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Println("No catalog found for the password; records are derived from the remote files.")
+	}
+	fmt.Printf("Found %d remote files, %d already in the local catalog.\n", result.Files, result.Known)
+
+	verb := "Added"
+	count := result.Inserted
+	if catalogRebuildDryRun {
+		verb, count = "Would add", len(result.Records)
+	}
+	fmt.Printf("%s %d backup records (%d from the catalog, %d from the remote listing).\n",
+		verb, count, result.FromCatalog, len(result.Records)-result.FromCatalog)
+
+	if catalogRebuildDryRun {
+		printRebuiltRecords(result.Records)
+	}
+	return nil
+}
+
+func printRebuiltRecords(records []database.BackupRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-20s %-10s %s\n", "Backup Time", "Size", "Path")
+	fmt.Printf("%-20s %-10s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 10), strings.Repeat("-", 40))
+	for _, r := range records {
+		fmt.Printf("%-20s %-10s %s\n", r.BackupTime.Local().Format("2006-01-02 15:04:05"), formatBytes(r.OriginalSize), r.FilePath)
+	}
+}
+
+func catalogRestore(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		// A new machine only has its credentials and the password
+		cfg = &config.Config{}
+		cfg.API.BaseURL = "https://koneksi-tyk-gateway-3rvca.ondigitalocean.app"
+		cfg.API.Timeout = 30
+		cfg.API.RetryCount = 3
+		cfg.Database.Path = "./backup.db"
+	}
+	if cfg.API.ClientID == "" {
+		cfg.API.ClientID = os.Getenv("KONEKSI_API_CLIENT_ID")
+	}
+	if cfg.API.ClientSecret == "" {
+		cfg.API.ClientSecret = os.Getenv("KONEKSI_API_CLIENT_SECRET")
+	}
+	if cfg.API.ClientID == "" || cfg.API.ClientSecret == "" {
+		return fmt.Errorf("API credentials are required; set KONEKSI_API_CLIENT_ID and KONEKSI_API_CLIENT_SECRET")
+	}
+
+	password := catalogRestorePassword
+	if password == "" {
+		password = cfg.CatalogPassword()
+	}
+	if password == "" {
+		return fmt.Errorf("a password is required; use --password or set KONEKSI_BACKUP_ENCRYPTION_PASSWORD")
+	}
+
+	directoryID := catalogRestoreDirectoryID
+	if directoryID == "" {
+		directoryID = cfg.API.DirectoryID
+	}
+	if directoryID == "" {
+		return fmt.Errorf("the backup directory is unknown; use --directory-id")
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	catalog, err := backup.RestoreCatalog(context.Background(), newAPIClient(cfg, directoryID), db, password)
+	if errors.Is(err, database.ErrNotEmpty) {
+		return fmt.Errorf("%s already holds backups; use 'catalog rebuild' or restore into a new database", cfg.Database.Path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore catalog: %w", err)
+	}
+
+	fmt.Printf("Restored the catalog uploaded at %s into %s.\n", catalog.CreatedAt.Local().Format("2006-01-02 15:04:05"), cfg.Database.Path)
+	fmt.Printf("Imported %d backup records, %d snapshots and %d remote directories.\n",
+		len(catalog.Records), len(catalog.Snapshots), len(catalog.Directories))
+	return nil
+}
+
+// catalogRoutine uploads the catalog every catalog.interval hours while
+// catalog uploads are enabled in the daemon's current configuration. The
+// first upload is due an interval after the newest uploaded catalog, so a
+// daemon restarted more often than the interval still uploads.
+func catalogRoutine(ctx context.Context, d *daemon, client *api.Client) {
+	var last time.Time
+	if d.config().Catalog.Enabled {
+		var err error
+		if last, err = backup.LastCatalogUpload(ctx, client); err != nil {
+			logger.Warn("failed to find the last catalog upload", zap.Error(err))
+		}
+	}
+
+	for {
+		interval := time.Duration(d.config().Catalog.Interval) * time.Hour
+		if interval <= 0 {
+			interval = 24 * time.Hour
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(max(time.Until(last.Add(interval)), 0)):
+		}
+
+		cfg := d.config()
+		last = time.Now()
+		if !cfg.Catalog.Enabled {
+			continue
+		}
+		result, err := backup.PushCatalog(ctx, client, d.db, cfg.CatalogPassword(), cfg.Catalog.Versions)
+		if err != nil {
+			logger.Error("failed to upload catalog", zap.Error(err))
+			continue
+		}
+		logger.Info("uploaded catalog",
+			zap.String("name", result.Name),
+			zap.Int("records", result.Records),
+			zap.Int("deleted", result.Deleted))
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/control"
+	"github.com/koneksi/backup-cli/internal/monitor"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// daemon implements control.Daemon for the run command.
+type daemon struct {
+	mu        sync.Mutex
+	cfg       *config.Config
+	service   *backup.Service
+	watcher   *monitor.Watcher
+	reporter  *report.Reporter
+	db        *database.DB
+	limiter   *api.Limiter
+	scheduler *backup.Scheduler
+	jobRunner *backup.JobRunner
+	startedAt time.Time
+}
+
+func (d *daemon) Status() (*control.Status, error) {
+	depth, err := d.service.QueueDepth()
+	if err != nil {
+		return nil, err
+	}
+	deadLetters, err := d.db.DeadLetterCount()
+	if err != nil {
+		return nil, err
+	}
+	targets, err := d.service.Targets()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := d.service.Workers()
+	uploads := []control.Upload{}
+	for _, w := range workers {
+		if w.State == backup.WorkerBusy && w.Path != "" {
+			uploads = append(uploads, control.Upload{
+				Path:      w.Path,
+				Size:      w.Size,
+				Worker:    w.ID,
+				Attempt:   w.Attempt,
+				StartedAt: w.Since,
+			})
+		}
+	}
+
+	rate, override := d.limiter.Rate()
+
+	return &control.Status{
+		PID:           os.Getpid(),
+		StartedAt:     d.startedAt,
+		Paused:        d.service.IsPaused(),
+		QueueDepth:    depth,
+		DeadLetters:   deadLetters,
+		ActiveUploads: uploads,
+		Workers:       workers,
+		Directories:   d.watcher.Status(),
+		Bandwidth:     control.Bandwidth{Rate: rate, Override: override},
+		Jobs:          d.scheduler.Jobs(),
+		Targets:       targets,
+	}, nil
+}
+
+func (d *daemon) Pause() error {
+	d.service.Pause()
+	return nil
+}
+
+func (d *daemon) Resume() error {
+	d.service.Resume()
+	return nil
+}
+
+func (d *daemon) Drain(timeout time.Duration) error {
+	return d.service.Drain(timeout)
+}
+
+func (d *daemon) Scan(path string) (int, error) {
+	logger.Info("scan requested", zap.String("path", path))
+	return d.watcher.Scan(path, d.service.ProcessChange)
+}
+
+func (d *daemon) AddDirectory(path, mode string) error {
+	if mode == "" {
+		mode = d.config().WatchMode(path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot watch %s: not a directory", path)
+	}
+
+	if err := d.watcher.AddDirectoryWithMode(path, mode); err != nil {
+		return err
+	}
+	logger.Info("watching directory", zap.String("path", path), zap.String("mode", mode))
+
+	return d.reporter.SetWatches(watchStatuses(d.watcher))
+}
+
+func (d *daemon) RemoveDirectory(path string) error {
+	if err := d.watcher.RemoveDirectory(path); err != nil {
+		return err
+	}
+	logger.Info("stopped watching directory", zap.String("path", path))
+
+	return d.reporter.SetWatches(watchStatuses(d.watcher))
+}
+
+func (d *daemon) RunJob(name string) error {
+	if err := d.scheduler.RunNow(name); err != nil {
+		return err
+	}
+	logger.Info("backup job started on request", zap.String("job", name))
+	return nil
+}
+
+func (d *daemon) SetBandwidth(rate int64) error {
+	d.limiter.SetOverride(rate)
+	logger.Info("bandwidth limit overridden", zap.Int64("bytes_per_second", rate))
+	return nil
+}
+
+func (d *daemon) ResetBandwidth() error {
+	d.limiter.ClearOverride()
+	logger.Info("bandwidth limit reset to schedule")
+	return nil
+}
+
+// Reload rereads the configuration file and applies it without
+// restarting: watched directories, exclude patterns, worker count,
+// compression, retry policy, bandwidth schedule, log level and the reports
+// of backup jobs. An invalid
+// configuration is rejected and the current one is kept. Directories added
+// at runtime with "watch add" are left alone.
+func (d *daemon) Reload() error {
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		return err
+	}
+	schedule, err := bandwidthSchedule(cfg)
+	if err != nil {
+		return err
+	}
+	jobs, err := backup.JobsFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := d.cfg
+
+	// The service builds its compressor first, so it is the only step
+	// that can still fail
+	if err := d.service.Reload(cfg); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Settings used only at startup keep their old values
+	cfg.API.DirectoryID = old.API.DirectoryID
+	warnRestartRequired(old, cfg)
+
+	d.watcher.SetExcludes(cfg.Backup.ExcludePatterns)
+	d.limiter.SetSchedule(schedule)
+	d.scheduler.SetJobs(jobs)
+	d.jobRunner.SetReport(cfg)
+	d.service.SetReplicas(replicaTargets(cfg))
+	applyLogLevel(cfg)
+
+	oldDirs := configuredDirectories(old)
+	newDirs := configuredDirectories(cfg)
+	for path, mode := range oldDirs {
+		if newMode, ok := newDirs[path]; ok && newMode == mode {
+			continue
+		}
+		if err := d.watcher.RemoveDirectory(path); err != nil {
+			logger.Error("failed to remove directory from watcher", zap.String("dir", path), zap.Error(err))
+			continue
+		}
+		logger.Info("stopped watching directory", zap.String("path", path))
+	}
+	for path, mode := range newDirs {
+		if oldMode, ok := oldDirs[path]; ok && oldMode == mode {
+			continue
+		}
+		if err := d.watcher.AddDirectoryWithMode(path, mode); err != nil {
+			logger.Error("failed to add directory to watcher", zap.String("dir", path), zap.Error(err))
+			continue
+		}
+		logger.Info("watching directory", zap.String("path", path), zap.String("mode", mode))
+	}
+
+	d.cfg = cfg
+	if err := d.reporter.SetWatches(watchStatuses(d.watcher)); err != nil {
+		logger.Error("failed to record watch status", zap.Error(err))
+	}
+
+	logger.Info("configuration reloaded",
+		zap.Int("directories", len(newDirs)),
+		zap.Int("jobs", len(jobs)),
+		zap.Int("concurrent", cfg.Backup.Concurrent),
+	)
+	return nil
+}
+
+// config returns the configuration currently in effect.
+func (d *daemon) config() *config.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg
+}
+
+// configuredDirectories maps the absolute path of every backup directory
+// to its watch mode.
+func configuredDirectories(cfg *config.Config) map[string]string {
+	dirs := make(map[string]string, len(cfg.Backup.Directories))
+	for _, dir := range cfg.DirectoryPaths() {
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			logger.Error("failed to resolve directory path", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		dirs[absPath] = cfg.WatchMode(dir)
+	}
+	return dirs
+}
+
+// warnRestartRequired logs settings that changed but only take effect
+// after a restart.
+func warnRestartRequired(old, cfg *config.Config) {
+	var changed []string
+	if old.Database.Path != cfg.Database.Path {
+		changed = append(changed, "database.path")
+	}
+	if old.Control != cfg.Control {
+		changed = append(changed, "control")
+	}
+	if old.Backup.Watch.PollInterval != cfg.Backup.Watch.PollInterval {
+		changed = append(changed, "backup.watch.poll_interval")
+	}
+	if old.Backup.RemoteLayout != cfg.Backup.RemoteLayout {
+		changed = append(changed, "backup.remote_layout")
+	}
+	// Job reports follow the new settings; the daemon's own report does not
+	if old.Report != cfg.Report {
+		changed = append(changed, "report")
+	}
+	if old.API.BaseURL != cfg.API.BaseURL || old.API.ClientID != cfg.API.ClientID || old.API.ClientSecret != cfg.API.ClientSecret {
+		changed = append(changed, "api credentials")
+	}
+	if len(changed) > 0 {
+		logger.Warn("some settings only take effect after a restart", zap.Strings("settings", changed))
+	}
+}
+
+// controlClient loads the configuration and returns a client for the
+// daemon's control socket.
+func controlClient() (*control.Client, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return control.NewClient(cfg.Control.Socket), nil
+}
+
+func pauseDaemon(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Pause(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("Backups paused. Running uploads will finish.")
+	return nil
+}
+
+func resumeDaemon(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Resume(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("Backups resumed.")
+	return nil
+}
+
+func drainDaemon(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Waiting up to %s for running uploads to finish...\n", drainTimeout)
+	if err := client.Drain(context.Background(), drainTimeout); err != nil {
+		return err
+	}
+	fmt.Println("Backups drained and paused. Use 'koneksi-backup resume' to continue.")
+	return nil
+}
+
+func scanDaemon(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+
+	path := ""
+	if len(args) > 0 {
+		if path, err = filepath.Abs(args[0]); err != nil {
+			return fmt.Errorf("failed to resolve path: %w", err)
+		}
+	}
+
+	files, err := client.Scan(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanned %d file(s); changed files were queued for backup.\n", files)
+	return nil
+}
+
+func watchAdd(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if err := client.AddDirectory(context.Background(), path, watchAddMode); err != nil {
+		return err
+	}
+	fmt.Printf("Watching %s\n", path)
+	return nil
+}
+
+func watchRemove(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if err := client.RemoveDirectory(context.Background(), path); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped watching %s\n", path)
+	return nil
+}
+
+func watchList(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+
+	status, err := client.Status(context.Background())
+	if err != nil {
+		return err
+	}
+	printWatches(status.Directories)
+	return nil
+}
+
+func reloadDaemon(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Reload(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("Configuration reloaded.")
+	return nil
+}
+
+func setBandwidth(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch {
+	case len(args) == 0:
+	case args[0] == "reset":
+		if err := client.ResetBandwidth(ctx); err != nil {
+			return err
+		}
+	default:
+		if err := client.SetBandwidth(ctx, args[0]); err != nil {
+			return err
+		}
+	}
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bandwidth limit: %s\n", formatBandwidth(status.Bandwidth))
+	return nil
+}
+
+// showLiveStatus prints the status of a running daemon. It returns
+// control.ErrNotRunning when no daemon is listening.
+func showLiveStatus(cfg *config.Config) error {
+	if !cfg.Control.Enabled {
+		return control.ErrNotRunning
+	}
+
+	client := control.NewClient(cfg.Control.Socket)
+	status, err := client.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	state := "running"
+	if status.Paused {
+		state = "paused"
+	}
+
+	fmt.Printf("Backup Daemon\n")
+	fmt.Printf("=============\n")
+	fmt.Printf("State: %s (pid %d)\n", state, status.PID)
+	fmt.Printf("Started: %s (up %s)\n", status.StartedAt.Format("2006-01-02 15:04:05"), time.Since(status.StartedAt).Round(time.Second))
+	fmt.Printf("Queue Depth: %d\n", status.QueueDepth)
+	fmt.Printf("Failed Files: %d\n", status.DeadLetters)
+	fmt.Printf("Bandwidth: %s\n", formatBandwidth(status.Bandwidth))
+
+	fmt.Printf("\nWorkers\n")
+	fmt.Printf("=======\n")
+	for _, w := range status.Workers {
+		if w.State == backup.WorkerBusy {
+			fmt.Printf("#%d %s: %s (%s, attempt %d, %s)\n", w.ID, w.State, w.Path, formatBytes(w.Size), w.Attempt, time.Since(w.Since).Round(time.Second))
+		} else {
+			fmt.Printf("#%d %s\n", w.ID, w.State)
+		}
+	}
+	fmt.Printf("Active uploads: %d\n", len(status.ActiveUploads))
+
+	if len(status.Jobs) > 0 {
+		fmt.Printf("\nScheduled Jobs\n")
+		fmt.Printf("==============\n")
+		printJobs(status.Jobs)
+	}
+
+	if len(status.Targets) > 0 {
+		fmt.Printf("\nReplica Targets\n")
+		fmt.Printf("===============\n")
+		fmt.Printf("%-20s %-6s %-8s %-8s %s\n", "Target", "Mode", "Copied", "Pending", "Failed")
+		for _, t := range status.Targets {
+			mode := "sync"
+			if t.Async {
+				mode = "async"
+			}
+			fmt.Printf("%-20s %-6s %-8d %-8d %d\n", t.Name, mode, t.Copied, t.Pending, t.Failed)
+		}
+	}
+
+	if len(status.Directories) > 0 {
+		fmt.Println()
+		printWatches(status.Directories)
+	}
+
+	return nil
+}
+
+func printWatches(watches []monitor.DirectoryStatus) {
+	fmt.Printf("Watched Directories\n")
+	fmt.Printf("===================\n")
+	for _, watch := range watches {
+		fmt.Printf("%s (mode: %s, source: %s)\n", watch.Path, watch.Mode, watch.Source)
+		if watch.Error != "" {
+			fmt.Printf("  error: %s\n", watch.Error)
+		}
+	}
+}
+
+func formatBandwidth(b control.Bandwidth) string {
+	rate := "unlimited"
+	if b.Rate > 0 {
+		rate = formatBytes(b.Rate) + "/s"
+	}
+	if b.Override {
+		return rate + " (override)"
+	}
+	return rate + " (schedule)"
+}
+
+// isNotRunning reports whether err means the daemon is not reachable.
+func isNotRunning(err error) bool {
+	return errors.Is(err, control.ErrNotRunning)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+func migrateDatabase(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	before, err := database.MigrationStatuses(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read schema status: %w", err)
+	}
+	if dbMigrateStatus {
+		printMigrationStatuses(before)
+		return nil
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+	db.Close()
+
+	after, err := database.MigrationStatuses(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read schema status: %w", err)
+	}
+	applied := 0
+	for i, s := range after {
+		if s.Applied() && (i >= len(before) || !before[i].Applied()) {
+			fmt.Printf("Applied migration %d: %s\n", s.Version, s.Description)
+			applied++
+		}
+	}
+	if applied == 0 {
+		fmt.Println("The database schema is up to date.")
+	}
+	return nil
+}
+
+func printMigrationStatuses(statuses []database.MigrationStatus) {
+	fmt.Printf("%-8s %-20s %s\n", "Version", "Applied", "Description")
+	fmt.Printf("%-8s %-20s %s\n", strings.Repeat("-", 8), strings.Repeat("-", 20), strings.Repeat("-", 30))
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied() {
+			applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-8d %-20s %s\n", s.Version, applied, s.Description)
+	}
+}
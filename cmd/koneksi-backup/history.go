@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// versionEntry is a file version in the output of history and find.
+type versionEntry struct {
+	Path           string    `json:"path"`
+	Version        int       `json:"version,omitempty"`
+	BackupTime     time.Time `json:"backup_time"`
+	Status         string    `json:"status"`
+	Size           int64     `json:"size,omitempty"`
+	CompressedSize int64     `json:"compressed_size,omitempty"`
+	Checksum       string    `json:"checksum"`
+	FileID         string    `json:"file_id,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// snapshotFileEntry is one side of a change in the output of diff.
+type snapshotFileEntry struct {
+	FileID   string `json:"file_id"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+type changeEntry struct {
+	Change string             `json:"change"`
+	Path   string             `json:"path"`
+	Old    *snapshotFileEntry `json:"old,omitempty"`
+	New    *snapshotFileEntry `json:"new,omitempty"`
+}
+
+// openCatalog opens the local database for the commands that query it.
+func openCatalog() (*database.DB, error) {
+	if err := checkOutputFormat(outputFormat); err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+func showHistory(cmd *cobra.Command, args []string) error {
+	if historyLimit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	db, err := openCatalog()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	records, err := db.GetBackupHistory(path, historyLimit)
+	if err != nil {
+		return fmt.Errorf("failed to get history: %w", err)
+	}
+
+	if len(records) == 0 && outputFormat == formatTable {
+		fmt.Printf("No backups of %s.\n", path)
+		return nil
+	}
+	return writeListing(os.Stdout, outputFormat, versionListing(records, false))
+}
+
+func findBackups(cmd *cobra.Command, args []string) error {
+	if findLimit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+	criteria := database.SearchCriteria{
+		FilePath: findPath,
+		Name:     findName,
+		Status:   findStatus,
+		Limit:    findLimit,
+	}
+	if findSince != "" {
+		age, err := config.ParseAge(findSince)
+		if err != nil {
+			return err
+		}
+		criteria.StartTime = time.Now().Add(-age)
+	}
+
+	db, err := openCatalog()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Only successful backups are recorded as versions; other statuses are
+	// those of the last backup attempt of each file
+	var records []database.BackupRecord
+	if criteria.Status == "" || criteria.Status == "success" {
+		records, err = db.SearchBackups(criteria)
+	} else {
+		var states []database.FileState
+		states, err = db.SearchFileStates(criteria)
+		for _, state := range states {
+			records = append(records, database.BackupRecord{
+				FilePath:   state.FilePath,
+				Checksum:   state.LastChecksum,
+				BackupTime: state.LastBackup,
+				Status:     state.Status,
+			})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("search failed: %w", err)
+	}
+
+	if len(records) == 0 && outputFormat == formatTable {
+		fmt.Println("No matching backups.")
+		return nil
+	}
+	return writeListing(os.Stdout, outputFormat, versionListing(records, true))
+}
+
+// versionListing lists records, with their paths when withPath is set.
+func versionListing(records []database.BackupRecord, withPath bool) listing {
+	header := []string{"Version", "Backed Up", "Status", "Size", "File ID"}
+	if withPath {
+		header = append([]string{"Path"}, header...)
+	}
+	l := listing{tableHeader: header, csvHeader: header}
+
+	entries := make([]versionEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, versionEntry{
+			Path:           r.FilePath,
+			Version:        r.Version,
+			BackupTime:     r.BackupTime,
+			Status:         r.Status,
+			Size:           r.OriginalSize,
+			CompressedSize: r.CompressedSize,
+			Checksum:       r.Checksum,
+			FileID:         r.FileID,
+			Error:          r.ErrorMessage,
+		})
+
+		// A failed attempt has no version, file ID or size
+		version, size, rawSize := "-", "-", ""
+		if r.Version != 0 {
+			version, size, rawSize = strconv.Itoa(r.Version), formatBytes(r.OriginalSize), strconv.FormatInt(r.OriginalSize, 10)
+		}
+		table := []string{version, r.BackupTime.Local().Format("2006-01-02 15:04:05"), r.Status, size, r.FileID}
+		csv := []string{strings.TrimPrefix(version, "-"), r.BackupTime.Format(time.RFC3339), r.Status, rawSize, r.FileID}
+		if withPath {
+			table = append([]string{r.FilePath}, table...)
+			csv = append([]string{r.FilePath}, csv...)
+		}
+		l.table = append(l.table, table)
+		l.csv = append(l.csv, csv)
+	}
+	l.json = entries
+	return l
+}
+
+func diffSnapshots(cmd *cobra.Command, args []string) error {
+	var ids [2]int64
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot ID %q", arg)
+		}
+		ids[i] = id
+	}
+
+	db, err := openCatalog()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	changes, err := db.DiffSnapshots(ids[0], ids[1])
+	if err != nil {
+		return fmt.Errorf("failed to compare snapshots: %w", err)
+	}
+
+	if len(changes) == 0 && outputFormat == formatTable {
+		fmt.Printf("Snapshots %d and %d contain the same files.\n", ids[0], ids[1])
+		return nil
+	}
+	return writeListing(os.Stdout, outputFormat, changeListing(changes))
+}
+
+func changeListing(changes []database.SnapshotChange) listing {
+	l := listing{
+		tableHeader: []string{"Change", "Size", "Path"},
+		csvHeader:   []string{"Change", "Path", "Old Size", "New Size", "Old File ID", "New File ID"},
+	}
+
+	entries := make([]changeEntry, 0, len(changes))
+	for _, c := range changes {
+		entry := changeEntry{Change: c.Kind, Path: c.FilePath}
+		var (
+			size                 string
+			oldSize, newSize     string
+			oldFileID, newFileID string
+		)
+		if c.Old != nil {
+			entry.Old = &snapshotFileEntry{FileID: c.Old.FileID, Checksum: c.Old.Checksum, Size: c.Old.Size}
+			oldSize, oldFileID = strconv.FormatInt(c.Old.Size, 10), c.Old.FileID
+			size = formatBytes(c.Old.Size)
+		}
+		if c.New != nil {
+			entry.New = &snapshotFileEntry{FileID: c.New.FileID, Checksum: c.New.Checksum, Size: c.New.Size}
+			newSize, newFileID = strconv.FormatInt(c.New.Size, 10), c.New.FileID
+			if c.Old != nil {
+				size += " -> " + formatBytes(c.New.Size)
+			} else {
+				size = formatBytes(c.New.Size)
+			}
+		}
+		entries = append(entries, entry)
+
+		l.table = append(l.table, []string{c.Kind, size, c.FilePath})
+		l.csv = append(l.csv, []string{c.Kind, c.FilePath, oldSize, newSize, oldFileID, newFileID})
+	}
+	l.json = entries
+	return l
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/control"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+func jobsList(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Prefer the schedule of the running daemon, which may have been
+	// reloaded since
+	if cfg.Control.Enabled {
+		status, err := control.NewClient(cfg.Control.Socket).Status(context.Background())
+		if err == nil {
+			printJobs(status.Jobs)
+			return nil
+		}
+		if !isNotRunning(err) {
+			logger.Warn("failed to query running daemon", zap.Error(err))
+		}
+	}
+
+	jobs, err := backup.JobsFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	now := time.Now()
+	statuses := make([]backup.JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, backup.JobStatus{
+			Name:     job.Name,
+			Schedule: job.Schedule.String(),
+			Mode:     job.Mode,
+			NextRun:  job.Schedule.Next(now),
+		})
+	}
+	printJobs(statuses)
+	fmt.Printf("\n(backup service is not running; jobs only run while it is)\n")
+	return nil
+}
+
+func printJobs(jobs []backup.JobStatus) {
+	if len(jobs) == 0 {
+		fmt.Println("No backup jobs configured.")
+		return
+	}
+
+	fmt.Printf("%-20s %-20s %-8s %s\n", "Job", "Schedule", "Mode", "Next Run")
+	fmt.Printf("%-20s %-20s %-8s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 20), strings.Repeat("-", 8), strings.Repeat("-", 19))
+	for _, job := range jobs {
+		next := "never"
+		if !job.NextRun.IsZero() {
+			next = job.NextRun.Format("2006-01-02 15:04:05")
+		}
+		if job.Running {
+			next += " (running)"
+		}
+		fmt.Printf("%-20s %-20s %-8s %s\n", job.Name, job.Schedule, job.Mode, next)
+	}
+}
+
+func jobsRun(cmd *cobra.Command, args []string) error {
+	client, err := controlClient()
+	if err != nil {
+		return err
+	}
+	if err := client.RunJob(context.Background(), args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Started job %s. Use 'koneksi-backup jobs snapshots %s' to see the result.\n", args[0], args[0])
+	return nil
+}
+
+func jobsSnapshots(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	job := ""
+	if len(args) > 0 {
+		job = args[0]
+	}
+	snapshots, err := db.ListSnapshots(job)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots.")
+		return nil
+	}
+
+	fmt.Printf("%-6s %-20s %-20s %-8s %-7s %s\n", "ID", "Job", "Started", "Status", "Files", "Size")
+	fmt.Printf("%-6s %-20s %-20s %-8s %-7s %s\n", strings.Repeat("-", 6), strings.Repeat("-", 20), strings.Repeat("-", 20), strings.Repeat("-", 8), strings.Repeat("-", 7), strings.Repeat("-", 10))
+	for _, s := range snapshots {
+		fmt.Printf("%-6d %-20s %-20s %-8s %-7d %s\n", s.ID, s.Job, s.StartedAt.Format("2006-01-02 15:04:05"), s.Status, s.Files, formatBytes(s.TotalSize))
+		if s.Error != "" {
+			fmt.Printf("%-6s %s\n", "", s.Error)
+		}
+	}
+
+	return nil
+}
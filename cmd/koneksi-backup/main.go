@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -18,6 +19,7 @@ import (
 	"github.com/koneksi/backup-cli/internal/auth"
 	"github.com/koneksi/backup-cli/internal/backup"
 	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/control"
 	"github.com/koneksi/backup-cli/internal/monitor"
 	"github.com/koneksi/backup-cli/internal/report"
 	"github.com/koneksi/backup-cli/pkg/archive"
@@ -28,6 +30,9 @@ import (
 var (
 	configFile string
 	logger     *zap.Logger
+	// logLevel is the level of logger; the run command changes it when
+	// the configuration is reloaded
+	logLevel zap.AtomicLevel
 )
 
 var rootCmd = &cobra.Command{
@@ -66,6 +71,248 @@ var statusCmd = &cobra.Command{
 	RunE:  showStatus,
 }
 
+var statusFailed bool
+
+var retryCmd = &cobra.Command{
+	Use:   "retry [path...]",
+	Short: "Requeue files from the dead-letter list",
+	Long:  `Move permanently failed backups back into the queue. Without arguments, all failed files are requeued.`,
+	RunE:  retryFailed,
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause uploads of the running backup service",
+	Long:  `Stop the running service from starting new uploads. Changes are still detected and queued.`,
+	Args:  cobra.NoArgs,
+	RunE:  pauseDaemon,
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume uploads of the running backup service",
+	Args:  cobra.NoArgs,
+	RunE:  resumeDaemon,
+}
+
+var drainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Pause the running backup service once uploads finish",
+	Long: `Pause the running service and wait for running uploads to finish. Uploads still
+running after the timeout are interrupted and requeued without counting the attempt.`,
+	Args: cobra.NoArgs,
+	RunE: drainDaemon,
+}
+
+var drainTimeout time.Duration
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [path]",
+	Short: "Rescan watched directories for changes",
+	Long:  `Ask the running service to walk a directory, or all watched directories, and queue files that changed.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  scanDaemon,
+}
+
+// Watch list commands for the running service
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Manage directories watched by the running service",
+	Long:  `Add or remove watched directories without restarting the service. Changes are not written to the configuration file.`,
+}
+
+var watchAddCmd = &cobra.Command{
+	Use:   "add [path]",
+	Short: "Start watching a directory",
+	Args:  cobra.ExactArgs(1),
+	RunE:  watchAdd,
+}
+
+var watchRemoveCmd = &cobra.Command{
+	Use:   "remove [path]",
+	Short: "Stop watching a directory",
+	Args:  cobra.ExactArgs(1),
+	RunE:  watchRemove,
+}
+
+var watchListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List watched directories",
+	Args:  cobra.NoArgs,
+	RunE:  watchList,
+}
+
+var watchAddMode string
+
+var bandwidthCmd = &cobra.Command{
+	Use:   "bandwidth [rate|reset]",
+	Short: "Show or change the bandwidth limit of the running service",
+	Long: `Without arguments, show the bandwidth limit in effect. A rate such as "2MB" or
+"unlimited" overrides the configured schedule until "reset" or a restart.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: setBandwidth,
+}
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage scheduled backup jobs",
+	Long:  `Backup jobs configured under backup.jobs run on a cron schedule while 'koneksi-backup run' is active.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List backup jobs and their next run",
+	Args:  cobra.NoArgs,
+	RunE:  jobsList,
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a backup job now in the running service",
+	Args:  cobra.ExactArgs(1),
+	RunE:  jobsRun,
+}
+
+var jobsSnapshotsCmd = &cobra.Command{
+	Use:   "snapshots [name]",
+	Short: "List snapshots taken by backup jobs",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  jobsSnapshots,
+}
+
+// outputFormat is the --format of history, find and diff.
+var outputFormat string
+
+var historyLimit int
+
+var historyCmd = &cobra.Command{
+	Use:   "history <path>",
+	Short: "List the backed up versions of a file",
+	Long: `List the versions of a file in the local backup catalog, newest first, with
+their sizes, backup times and Koneksi file IDs.`,
+	Args: cobra.ExactArgs(1),
+	RunE: showHistory,
+}
+
+var (
+	findName   string
+	findPath   string
+	findStatus string
+	findSince  string
+	findLimit  int
+)
+
+var findCmd = &cobra.Command{
+	Use:   "find",
+	Short: "Search the backup catalog",
+	Long: `Search the file versions in the local backup catalog, newest first. For
+example, the PDF files that failed to back up in the last week:
+
+  koneksi-backup find --name '*.pdf' --since 7d --status failed`,
+	Args: cobra.NoArgs,
+	RunE: findBackups,
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <snapshot> <snapshot>",
+	Short: "Compare the files of two snapshots",
+	Long: `List the files added, removed and changed between two job snapshots. Use
+'koneksi-backup jobs snapshots' to find their IDs.`,
+	Args: cobra.ExactArgs(2),
+	RunE: diffSnapshots,
+}
+
+var pruneDryRun bool
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old file versions and snapshots",
+	Long: `Remove the versions of backed up files that fall outside the prune rules
+and the job snapshots outside their retention, then delete the remote
+objects nothing refers to anymore. Use --dry-run to list what would be
+removed.`,
+	Args: cobra.NoArgs,
+	RunE: pruneBackups,
+}
+
+// Catalog commands
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Back up and rebuild the local backup catalog",
+	Long: `Keep a copy of the local backup catalog in Koneksi storage and rebuild the
+catalog from Koneksi when the database is lost.`,
+}
+
+var catalogPushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Upload the backup catalog to Koneksi",
+	Long: `Upload the backup records, snapshots and mirrored directories of the local
+database as a compressed catalog encrypted with catalog.password. Older
+catalogs beyond catalog.versions are deleted.`,
+	Args: cobra.NoArgs,
+	RunE: catalogPush,
+}
+
+var catalogRebuildDryRun bool
+
+var catalogRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Rebuild the backup catalog from Koneksi",
+	Long: `List the files stored in the backup directory and add the backup records
+missing from the local database. Records come from the newest catalog the
+catalog password decrypts when it lists a file; otherwise the path is derived from the mirrored
+remote directories, or is only the file name for backup.remote_layout flat.
+Use --dry-run to list what would be added.`,
+	Args: cobra.NoArgs,
+	RunE: catalogRebuild,
+}
+
+var (
+	catalogRestorePassword    string
+	catalogRestoreDirectoryID string
+)
+
+var catalogRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the backup catalog on a new machine",
+	Long: `Download the newest catalog encrypted with the password and import it into an
+empty database. Only the API credentials, from the configuration or from
+KONEKSI_API_CLIENT_ID and KONEKSI_API_CLIENT_SECRET, and the password are
+needed.`,
+	Args: cobra.NoArgs,
+	RunE: catalogRestore,
+}
+
+// Database commands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain the local backup database",
+}
+
+var dbMigrateStatus bool
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending database schema migrations",
+	Long: `Upgrade the schema of the local database to the current version. Migrations
+are also applied whenever the database is opened; use --status to list them
+and when each was applied without changing the database.`,
+	Args: cobra.NoArgs,
+	RunE: migrateDatabase,
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the configuration of the running backup service",
+	Long: `Reread the configuration file and apply it to the running service: watched
+directories, exclude patterns, concurrency, compression, retries, bandwidth
+schedule and log level. Queued work is kept. An invalid configuration is
+rejected and the running service keeps its current settings. Sending SIGHUP
+to the service does the same.`,
+	Args: cobra.NoArgs,
+	RunE: reloadDaemon,
+}
+
 var reportCmd = &cobra.Command{
 	Use:   "report",
 	Short: "Show the latest backup report",
@@ -78,12 +325,98 @@ var initCmd = &cobra.Command{
 	RunE:  initConfig,
 }
 
+// Conflict and planning flags of restore and restore-path
+var (
+	restoreOnConflict string
+	restoreDryRun     bool
+	restoreSavePlan   string
+	// restoreNoOwner is shared by every command that restores files
+	restoreNoOwner bool
+)
+
 var restoreCmd = &cobra.Command{
 	Use:   "restore [manifest-file] [target-directory]",
 	Short: "Restore files from a backup manifest",
-	Long:  `Restore files from a backup using a manifest file that contains file IDs and metadata.`,
-	Args:  cobra.ExactArgs(2),
-	RunE:  restoreBackup,
+	Long: `Restore files from a backup using a manifest file that contains file IDs and metadata.
+
+A file already in the target directory with other content is overwritten,
+unless --on-conflict says otherwise. --dry-run prints what the restore would
+do without downloading anything.`,
+	Args: cobra.ExactArgs(2),
+	RunE: restoreBackup,
+}
+
+var (
+	restorePathVersion  int
+	restorePathTo       string
+	restorePathInPlace  bool
+	restorePathPassword string
+)
+
+var restorePathCmd = &cobra.Command{
+	Use:   "restore-path <path>...",
+	Short: "Restore files by their original path",
+	Long: `Restore backed up files by path, looking up their versions and file IDs in
+the local backup catalog. A path can be a file, a directory, which restores
+every file below it, or a shell pattern such as '/srv/data/*.xlsx'.
+
+Files are written below --to, keeping their paths relative to the directory
+containing the path, or back to their original paths with --in-place.
+Encrypted uploads are decrypted with --password or the configured passwords.
+
+--on-conflict decides what happens to a current file with other content:
+skip keeps it, overwrite replaces it, rename first renames it to
+<file>.bak-<time>, and newer replaces it only when the backed up version was
+modified after it. The default is rename with --in-place and overwrite
+otherwise. Files that already have the content are left alone.
+
+--dry-run prints the plan instead: the files to download, their sizes, the
+conflicts and the space needed against the space free. --save-plan writes it
+as JSON, to run later with 'koneksi-backup restore-plan'.`,
+	Example: `  koneksi-backup restore-path /srv/data/reports/q3.xlsx --version 3 --to ./out
+  koneksi-backup restore-path /srv/data/reports --to ./out --on-conflict skip
+  koneksi-backup restore-path '/srv/data/*.xlsx' --in-place --on-conflict newer
+  koneksi-backup restore-path /srv/data --in-place --dry-run --save-plan plan.json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: restorePaths,
+}
+
+var restorePlanCmd = &cobra.Command{
+	Use:   "restore-plan <plan-file>",
+	Short: "Run a restore plan saved with --dry-run --save-plan",
+	Long: `Restore the files of a plan saved by 'restore-path --dry-run --save-plan' or
+'restore --dry-run --save-plan'. Files the plan skips are left alone. The
+others are checked again with the conflict policy of the plan, since the
+targets may have changed since it was made.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestorePlan,
+}
+
+var (
+	browseAt       string
+	browseSnapshot int64
+	browseTo       string
+	browsePassword string
+)
+
+var browseCmd = &cobra.Command{
+	Use:   "browse",
+	Short: "Browse the backup catalog and restore files interactively",
+	Long: `Open a terminal browser over the local backup catalog. Navigate the directory
+tree as it was backed up, preview the versions of files, select files and
+directories with space and restore them with r, following the progress of
+the restore as it runs.
+
+The newest versions are shown by default; --at shows the versions as of an
+earlier time and --snapshot the files of a job snapshot. Restores go below
+the directory asked for, --to by default, or back to the original paths
+when it is left empty.`,
+	Example: `  koneksi-backup browse
+  koneksi-backup browse --at "2024-05-01 13:00" --to ./out
+  koneksi-backup browse --at 7d
+  koneksi-backup browse --snapshot 42`,
+	Args: cobra.NoArgs,
+	RunE: browseCatalog,
 }
 
 var manifestCmd = &cobra.Command{
@@ -119,14 +452,20 @@ var dirCreateCmd = &cobra.Command{
 var dirRemoveCmd = &cobra.Command{
 	Use:   "remove [directory-id]",
 	Short: "Remove a directory",
-	Long:  `Remove a backup directory and all its contents.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  removeDirectory,
+	Long: `Remove a backup directory from Koneksi storage after confirmation.
+
+A directory that still contains files or subdirectories is only removed with
+--recursive, which deletes its contents as well. Use --dry-run to list what
+would be removed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: removeDirectory,
 }
 
 var (
 	dirDescription string
 	dirForceRemove bool
+	dirRecursive   bool
+	dirDryRun      bool
 )
 
 // Auth management commands
@@ -237,14 +576,82 @@ func init() {
 	backupCmd.Flags().BoolVar(&encryptFiles, "encrypt", false, "encrypt files before backup")
 	backupCmd.Flags().StringVar(&encryptPassword, "encrypt-password", "", "password for encryption (required if --encrypt is set)")
 
+	// Add flags for status command
+	statusCmd.Flags().BoolVar(&statusFailed, "failed", false, "list files that failed permanently (dead-letter list)")
+
+	// Add flags for drain command
+	drainCmd.Flags().DurationVar(&drainTimeout, "timeout", control.DefaultDrainTimeout, "how long to wait for running uploads")
+
+	// Add watch subcommands
+	watchAddCmd.Flags().StringVar(&watchAddMode, "mode", "", "watch mode: auto, inotify or poll (default from config)")
+	watchCmd.AddCommand(watchAddCmd)
+	watchCmd.AddCommand(watchRemoveCmd)
+	watchCmd.AddCommand(watchListCmd)
+
+	// Add jobs subcommands
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsRunCmd)
+	jobsCmd.AddCommand(jobsSnapshotsCmd)
+
+	// Add flags for catalog query commands
+	for _, cmd := range []*cobra.Command{historyCmd, findCmd, diffCmd} {
+		cmd.Flags().StringVarP(&outputFormat, "format", "o", formatTable, "output format: table, json or csv")
+	}
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 50, "maximum number of versions to list")
+	findCmd.Flags().StringVar(&findName, "name", "", "shell pattern the file name must match, such as '*.pdf'")
+	findCmd.Flags().StringVar(&findPath, "path", "", "text the file path must contain")
+	findCmd.Flags().StringVar(&findStatus, "status", "", "backup status: success or failed")
+	findCmd.Flags().StringVar(&findSince, "since", "", "only versions backed up within this age, such as 7d or 12h")
+	findCmd.Flags().IntVarP(&findLimit, "limit", "n", 100, "maximum number of versions to list")
+
+	// Add flags for prune command
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "list what would be removed without removing it")
+
+	// Add catalog subcommands
+	catalogRebuildCmd.Flags().BoolVar(&catalogRebuildDryRun, "dry-run", false, "list the records that would be added without adding them")
+	catalogRestoreCmd.Flags().StringVar(&catalogRestorePassword, "password", "", "password the catalog is encrypted with")
+	catalogRestoreCmd.Flags().StringVar(&catalogRestoreDirectoryID, "directory-id", "", "backup directory holding the catalog (default api.directory_id)")
+	catalogCmd.AddCommand(catalogPushCmd)
+	catalogCmd.AddCommand(catalogRebuildCmd)
+	catalogCmd.AddCommand(catalogRestoreCmd)
+
+	// Add db subcommands
+	dbMigrateCmd.Flags().BoolVar(&dbMigrateStatus, "status", false, "list the migrations and whether they were applied")
+	dbCmd.AddCommand(dbMigrateCmd)
+
 	// Add flags for restore command
 	restoreCmd.Flags().BoolVar(&autoExtract, "auto-extract", false, "automatically extract tar.gz files after restore")
 	restoreCmd.Flags().BoolVar(&decryptFiles, "decrypt", false, "decrypt files after restore")
 	restoreCmd.Flags().StringVar(&encryptPassword, "decrypt-password", "", "password for decryption (required if --decrypt is set)")
+	for _, cmd := range []*cobra.Command{restoreCmd, restorePathCmd} {
+		cmd.Flags().StringVar(&restoreOnConflict, "on-conflict", "", "what to do with a current file with other content: skip, overwrite, rename or newer")
+		cmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "print the restore plan without restoring")
+		cmd.Flags().StringVar(&restoreSavePlan, "save-plan", "", "with --dry-run, save the plan as JSON to this file")
+	}
+	restorePlanCmd.Flags().StringVar(&restorePathPassword, "password", "", "password encrypted files were backed up with (default the configured ones)")
+	for _, cmd := range []*cobra.Command{restoreCmd, restorePathCmd, restorePlanCmd, browseCmd} {
+		cmd.Flags().BoolVar(&restoreNoOwner, "no-owner", false, "keep restored files owned by the current user instead of their backed up owner")
+	}
+
+	// Add flags for restore-path command
+	restorePathCmd.Flags().IntVar(&restorePathVersion, "version", 0, "version to restore instead of the newest (single file only)")
+	restorePathCmd.Flags().StringVar(&restorePathTo, "to", "", "directory to restore the files below")
+	restorePathCmd.Flags().BoolVar(&restorePathInPlace, "in-place", false, "restore the files to their original paths")
+	restorePathCmd.Flags().StringVar(&restorePathPassword, "password", "", "password encrypted files were backed up with (default the configured ones)")
+	restorePathCmd.MarkFlagsMutuallyExclusive("to", "in-place")
+	restorePathCmd.MarkFlagsOneRequired("to", "in-place")
+
+	browseCmd.Flags().StringVar(&browseAt, "at", "", "show the versions as of a time, such as '2024-05-01 13:00', or an age, such as 7d")
+	browseCmd.Flags().Int64Var(&browseSnapshot, "snapshot", 0, "show the files of a job snapshot")
+	browseCmd.Flags().StringVar(&browseTo, "to", "", "directory offered to restore to (default the original paths)")
+	browseCmd.Flags().StringVar(&browsePassword, "password", "", "password encrypted files were backed up with (default the configured ones)")
+	browseCmd.MarkFlagsMutuallyExclusive("at", "snapshot")
 
 	// Add flags for directory commands
 	dirCreateCmd.Flags().StringVarP(&dirDescription, "description", "d", "", "Directory description")
 	dirRemoveCmd.Flags().BoolVarP(&dirForceRemove, "force", "f", false, "Force remove without confirmation")
+	dirRemoveCmd.Flags().BoolVarP(&dirRecursive, "recursive", "r", false, "Remove the directory with all files and subdirectories in it")
+	dirRemoveCmd.Flags().BoolVar(&dirDryRun, "dry-run", false, "List what would be removed without removing it")
 
 	// Add directory subcommands
 	dirCmd.AddCommand(dirListCmd)
@@ -297,9 +704,27 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(backupCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(retryCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(drainCmd)
+	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(bandwidthCmd)
+	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(jobsCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(findCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(catalogCmd)
+	rootCmd.AddCommand(dbCmd)
 	rootCmd.AddCommand(reportCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(restorePathCmd)
+	rootCmd.AddCommand(restorePlanCmd)
+	rootCmd.AddCommand(browseCmd)
 	rootCmd.AddCommand(manifestCmd)
 	rootCmd.AddCommand(dirCmd)
 	rootCmd.AddCommand(authCmd)
@@ -317,6 +742,8 @@ func initializeLogger() {
 	config.EncoderConfig.TimeKey = "timestamp"
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
+	logLevel = config.Level
+
 	var err error
 	logger, err = config.Build()
 	if err != nil {
@@ -325,11 +752,12 @@ func initializeLogger() {
 	}
 }
 
-func runBackupService(cmd *cobra.Command, args []string) error {
-	// Load configuration
+// loadDaemonConfig loads and validates the configuration for the run
+// command. It is used at startup and again on every reload.
+func loadDaemonConfig() (*config.Config, error) {
 	cfg, err := config.Load(configFile)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
 	// Use credentials from config if not set
@@ -340,38 +768,60 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 		cfg.API.ClientSecret = os.Getenv("KONEKSI_API_CLIENT_SECRET")
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if _, err := bandwidthSchedule(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.Backup.Concurrent < 1 {
+		return nil, fmt.Errorf("invalid configuration: backup.concurrent must be at least 1")
+	}
+	if _, err := backup.JobsFromConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.Log.Level != "" {
+		if _, err := zapcore.ParseLevel(cfg.Log.Level); err != nil {
+			return nil, fmt.Errorf("invalid configuration: log.level: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// applyLogLevel sets the level of logger from log.level.
+func applyLogLevel(cfg *config.Config) {
+	if cfg.Log.Level == "" {
+		return
+	}
+	if level, err := zapcore.ParseLevel(cfg.Log.Level); err == nil {
+		logLevel.SetLevel(level)
+	}
+}
+
+func runBackupService(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := loadDaemonConfig()
+	if err != nil {
+		return err
+	}
+
 	logger.Debug("API credentials",
 		zap.String("clientID", cfg.API.ClientID),
 		zap.Bool("hasSecret", cfg.API.ClientSecret != ""),
 	)
 
-	if err := cfg.Validate(); err != nil {
-		return fmt.Errorf("invalid configuration: %w", err)
-	}
-
 	// Configure logger based on config
-	if cfg.Log.Level != "" {
-		level, err := zapcore.ParseLevel(cfg.Log.Level)
-		if err == nil {
-			logger = logger.WithOptions(zap.IncreaseLevel(level))
-		}
-	}
+	applyLogLevel(cfg)
 
 	logger.Info("starting Koneksi Backup Service",
 		zap.String("version", "1.0.0"),
 		zap.Int("directories", len(cfg.Backup.Directories)),
+		zap.Int("jobs", len(cfg.Backup.Jobs)),
 	)
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		cfg.API.DirectoryID,
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, cfg.API.DirectoryID)
 
 	// Test API connection
 	ctx := context.Background()
@@ -419,6 +869,7 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create watcher: %w", err)
 	}
 	defer watcher.Close()
+	watcher.SetPollInterval(time.Duration(cfg.Backup.Watch.PollInterval) * time.Second)
 
 	// Create backup service
 	backupService, err := backup.NewService(
@@ -431,6 +882,12 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create backup service: %w", err)
 	}
+	backupService.SetReplicas(replicaTargets(cfg))
+
+	// Create scheduler for backup.jobs; the configuration was validated above
+	jobs, _ := backup.JobsFromConfig(cfg)
+	jobRunner := backup.NewJobRunner(apiClient, logger, cfg, db)
+	scheduler := backup.NewScheduler(jobRunner, logger, jobs)
 
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
@@ -439,11 +896,57 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	pauseSig, resumeSig := pauseSignals()
+	pauseChan := make(chan os.Signal, 1)
+	if pauseSig != nil {
+		signal.Notify(pauseChan, pauseSig, resumeSig)
+	}
+
+	reloadChan := make(chan os.Signal, 1)
+	if sig := reloadSignal(); sig != nil {
+		signal.Notify(reloadChan, sig)
+	}
+
 	// Start services
 	watcher.Start(ctx)
 	backupService.Start(ctx)
+	scheduler.Start(ctx)
+
+	// Add directories to watch
+	for _, dir := range cfg.DirectoryPaths() {
+		absPath, err := filepath.Abs(dir)
+		if err != nil {
+			logger.Error("failed to resolve directory path", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+
+		mode := cfg.WatchMode(dir)
+		if err := watcher.AddDirectoryWithMode(absPath, mode); err != nil {
+			logger.Error("failed to add directory to watcher", zap.String("dir", absPath), zap.Error(err))
+			continue
+		}
 
-	// Start database cleanup routine
+		logger.Info("watching directory", zap.String("path", absPath), zap.String("mode", mode))
+	}
+
+	if err := reporter.SetWatches(watchStatuses(watcher)); err != nil {
+		logger.Error("failed to record watch status", zap.Error(err))
+	}
+
+	d := &daemon{
+		cfg:       cfg,
+		service:   backupService,
+		watcher:   watcher,
+		reporter:  reporter,
+		db:        db,
+		limiter:   bandwidthLimiter(cfg),
+		scheduler: scheduler,
+		jobRunner: jobRunner,
+		startedAt: time.Now(),
+	}
+
+	// Start database cleanup routine; directories with their own retention
+	// are cleaned up separately
 	go func() {
 		ticker := time.NewTicker(24 * time.Hour)
 		defer ticker.Stop()
@@ -453,27 +956,27 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if err := db.CleanupOldRecords(cfg.Database.Retention); err != nil {
+				current := d.config()
+				if err := db.CleanupOldRecordsByPath(current.Database.Retention, current.RecordRetention()); err != nil {
 					logger.Error("failed to cleanup old database records", zap.Error(err))
 				}
 			}
 		}
 	}()
 
-	// Add directories to watch
-	for _, dir := range cfg.Backup.Directories {
-		absPath, err := filepath.Abs(dir)
-		if err != nil {
-			logger.Error("failed to resolve directory path", zap.String("dir", dir), zap.Error(err))
-			continue
-		}
+	// Start pruning old versions when prune is enabled
+	go pruneRoutine(ctx, d, apiClient)
 
-		if err := watcher.AddDirectory(absPath); err != nil {
-			logger.Error("failed to add directory to watcher", zap.String("dir", absPath), zap.Error(err))
-			continue
-		}
+	// Start uploading the catalog when enabled
+	go catalogRoutine(ctx, d, apiClient)
 
-		logger.Info("watching directory", zap.String("path", absPath))
+	// Start control server for status and runtime commands
+	if cfg.Control.Enabled {
+		controlServer := control.NewServer(cfg.Control.Socket, d, logger)
+		if err := controlServer.Start(); err != nil {
+			return fmt.Errorf("failed to start control server: %w", err)
+		}
+		defer controlServer.Close()
 	}
 
 	// Main event loop
@@ -486,6 +989,10 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 				backupService.ProcessChange(change)
 			case err := <-watcher.Errors():
 				logger.Error("watcher error", zap.Error(err))
+				// A directory may have switched to polling
+				if err := reporter.SetWatches(watchStatuses(watcher)); err != nil {
+					logger.Error("failed to record watch status", zap.Error(err))
+				}
 			}
 		}
 	}()
@@ -497,18 +1004,39 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 	logger.Info("backup service started, monitoring directories...")
 
 	// Wait for shutdown signal
-	select {
-	case <-sigChan:
-		logger.Info("shutdown signal received")
-	case <-ctx.Done():
-		logger.Info("context cancelled")
+wait:
+	for {
+		select {
+		case sig := <-pauseChan:
+			if sig == pauseSig {
+				backupService.Pause()
+			} else {
+				backupService.Resume()
+			}
+		case <-reloadChan:
+			if err := d.Reload(); err != nil {
+				logger.Error("configuration reload rejected, keeping current configuration", zap.Error(err))
+			}
+		case <-sigChan:
+			logger.Info("shutdown signal received")
+			break wait
+		case <-ctx.Done():
+			logger.Info("context cancelled")
+			break wait
+		}
 	}
 
-	// Graceful shutdown
+	// Graceful shutdown: let running uploads finish, then requeue the rest
 	logger.Info("shutting down backup service...")
+	shutdownTimeout := time.Duration(d.config().Backup.ShutdownTimeout) * time.Second
+	if err := backupService.Drain(shutdownTimeout); err != nil {
+		logger.Warn("shutdown did not drain cleanly", zap.Error(err))
+	}
 
-	// Stop services
+	// Stop services; running jobs are interrupted and their snapshots
+	// marked as failed
 	cancel()
+	scheduler.Wait()
 	backupService.Stop()
 
 	// Finish report
@@ -524,6 +1052,125 @@ func runBackupService(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// newAPIClient creates a Koneksi API client from the configuration.
+func newAPIClient(cfg *config.Config, directoryID string) *api.Client {
+	client := api.NewClient(
+		cfg.API.BaseURL,
+		cfg.API.ClientID,
+		cfg.API.ClientSecret,
+		directoryID,
+		time.Duration(cfg.API.Timeout)*time.Second,
+		cfg.API.RetryCount,
+		logger,
+	)
+
+	policy := api.DefaultRetryPolicy(cfg.API.RetryCount)
+	if cfg.API.RetryBaseDelay > 0 {
+		policy.BaseDelay = time.Duration(cfg.API.RetryBaseDelay) * time.Second
+	}
+	if cfg.API.RetryMaxDelay > 0 {
+		policy.MaxDelay = time.Duration(cfg.API.RetryMaxDelay) * time.Second
+	}
+	client.SetRetryPolicy(policy)
+	client.SetBandwidthLimiter(bandwidthLimiter(cfg))
+
+	return client
+}
+
+// replicaTargets builds the secondary targets of the targets section.
+// Koneksi targets take unset API settings from the api section.
+func replicaTargets(cfg *config.Config) []backup.Replica {
+	replicas := make([]backup.Replica, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		var target backup.Target
+		switch t.Type {
+		case config.TargetLocal:
+			target = backup.NewLocalTarget(t.Name, t.Path)
+		default:
+			targetCfg := *cfg
+			if t.API.BaseURL != "" {
+				targetCfg.API.BaseURL = t.API.BaseURL
+			}
+			if t.API.ClientID != "" {
+				targetCfg.API.ClientID = t.API.ClientID
+			}
+			if t.API.ClientSecret != "" {
+				targetCfg.API.ClientSecret = t.API.ClientSecret
+			}
+			if t.API.DirectoryID != "" {
+				targetCfg.API.DirectoryID = t.API.DirectoryID
+			}
+			target = backup.NewKoneksiTarget(t.Name, newAPIClient(&targetCfg, targetCfg.API.DirectoryID))
+		}
+		replicas = append(replicas, backup.Replica{Target: target, Async: t.Async})
+	}
+	return replicas
+}
+
+// sharedLimiter throttles every API client of the process together.
+var sharedLimiter *api.Limiter
+
+// bandwidthLimiter returns the limiter for api.bandwidth_limit. It is
+// created even without a configured limit so the rate can be changed at
+// runtime.
+func bandwidthLimiter(cfg *config.Config) *api.Limiter {
+	if sharedLimiter != nil {
+		return sharedLimiter
+	}
+
+	schedule, err := bandwidthSchedule(cfg)
+	if err != nil {
+		logger.Warn("ignoring invalid bandwidth limit", zap.Error(err))
+		schedule = api.BandwidthSchedule{}
+	}
+
+	sharedLimiter = api.NewLimiter(schedule)
+	return sharedLimiter
+}
+
+// bandwidthSchedule converts the api.bandwidth_limit settings.
+func bandwidthSchedule(cfg *config.Config) (api.BandwidthSchedule, error) {
+	limit := cfg.API.BandwidthLimit
+
+	rate, err := api.ParseRate(limit.Rate)
+	if err != nil {
+		return api.BandwidthSchedule{}, fmt.Errorf("api.bandwidth_limit.rate: %w", err)
+	}
+	schedule := api.BandwidthSchedule{Rate: rate}
+
+	for i, w := range limit.Schedule {
+		start, err := api.ParseTimeOfDay(w.Start)
+		if err != nil {
+			return api.BandwidthSchedule{}, fmt.Errorf("api.bandwidth_limit.schedule[%d].start: %w", i, err)
+		}
+		end, err := api.ParseTimeOfDay(w.End)
+		if err != nil {
+			return api.BandwidthSchedule{}, fmt.Errorf("api.bandwidth_limit.schedule[%d].end: %w", i, err)
+		}
+		windowRate, err := api.ParseRate(w.Rate)
+		if err != nil {
+			return api.BandwidthSchedule{}, fmt.Errorf("api.bandwidth_limit.schedule[%d].rate: %w", i, err)
+		}
+		schedule.Windows = append(schedule.Windows, api.BandwidthWindow{Start: start, End: end, Rate: windowRate})
+	}
+
+	return schedule, nil
+}
+
+func watchStatuses(watcher *monitor.Watcher) []report.WatchStatus {
+	statuses := watcher.Status()
+	watches := make([]report.WatchStatus, 0, len(statuses))
+	for _, status := range statuses {
+		watches = append(watches, report.WatchStatus{
+			Path:   status.Path,
+			Mode:   status.Mode,
+			Source: status.Source,
+			Error:  status.Error,
+		})
+	}
+	return watches
+}
+
 func performBackup(cmd *cobra.Command, args []string) error {
 	targetPath := args[0]
 
@@ -571,15 +1218,7 @@ func performBackup(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		cfg.API.DirectoryID,
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, cfg.API.DirectoryID)
 
 	// Test API connection
 	ctx := context.Background()
@@ -632,6 +1271,9 @@ func performBackup(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create backup service: %w", err)
 	}
+	backupService.SetReplicas(replicaTargets(cfg))
+	// The queue is shared with a running daemon; leave its tasks to it
+	backupService.SetBatch(fmt.Sprintf("backup-%d-%d", os.Getpid(), time.Now().UnixNano()))
 
 	// Check if path exists
 	info, err := os.Stat(targetPath)
@@ -692,8 +1334,9 @@ func performBackup(cmd *cobra.Command, args []string) error {
 		info = archiveInfo
 	}
 
-	// Handle encryption if enabled
-	if encryptFiles && !info.IsDir() {
+	// Handle encryption if enabled, unless the policy of the file's backup
+	// directory already encrypts it
+	if encryptFiles && !info.IsDir() && backupService.Policy(fileToBackup).Password == "" {
 		fmt.Println("Encrypting file before backup...")
 		encryptor := encryption.NewEncryptor(cfg.Backup.Encryption.Password)
 		encryptedPath := fileToBackup + ".enc"
@@ -774,8 +1417,9 @@ func backupDirectory(ctx context.Context, service *backup.Service, dirPath strin
 			return nil
 		}
 
-		// Skip directories
+		// Directories are not uploaded, only their metadata is recorded
 		if info.IsDir() {
+			service.ProcessChange(monitor.FileChange{Path: path, Operation: "manual", Timestamp: time.Now(), IsDir: true})
 			return nil
 		}
 
@@ -828,6 +1472,17 @@ func showStatus(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if statusFailed {
+		return showFailed(cfg)
+	}
+
+	// Prefer the live state of a running daemon over the last report
+	if err := showLiveStatus(cfg); err == nil {
+		return nil
+	} else if !isNotRunning(err) {
+		logger.Warn("failed to query running daemon", zap.Error(err))
+	}
+
 	reporter, err := report.NewReporter(
 		logger,
 		cfg.Report.Directory,
@@ -854,6 +1509,76 @@ func showStatus(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Failed: %d\n", report.Failed)
 	fmt.Printf("Total Size: %d bytes\n", report.TotalSize)
 
+	if len(report.Watches) > 0 {
+		fmt.Printf("\nWatched Directories\n")
+		fmt.Printf("===================\n")
+		for _, watch := range report.Watches {
+			fmt.Printf("%s (mode: %s, source: %s)\n", watch.Path, watch.Mode, watch.Source)
+			if watch.Error != "" {
+				fmt.Printf("  error: %s\n", watch.Error)
+			}
+		}
+	}
+	fmt.Printf("\n(backup service is not running; showing the latest report)\n")
+
+	return nil
+}
+
+func showFailed(cfg *config.Config) error {
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	letters, err := db.ListDeadLetters()
+	if err != nil {
+		return fmt.Errorf("failed to list failed files: %w", err)
+	}
+
+	if len(letters) == 0 {
+		fmt.Println("No failed files.")
+		return nil
+	}
+
+	fmt.Printf("%-20s %-8s %s\n", "Failed At", "Attempts", "File / Error")
+	fmt.Printf("%-20s %-8s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 8), strings.Repeat("-", 40))
+	for _, letter := range letters {
+		fmt.Printf("%-20s %-8d %s\n", letter.FailedAt.Format("2006-01-02 15:04:05"), letter.Attempts, letter.FilePath)
+		fmt.Printf("%-20s %-8s   %s\n", "", "", letter.LastError)
+	}
+	fmt.Printf("\n%d failed file(s). Use 'koneksi-backup retry' to requeue them.\n", len(letters))
+
+	return nil
+}
+
+func retryFailed(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		absPath, err := filepath.Abs(arg)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path %s: %w", arg, err)
+		}
+		paths = append(paths, absPath)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	requeued, err := db.RequeueDeadLetters(paths)
+	if err != nil {
+		return fmt.Errorf("failed to requeue files: %w", err)
+	}
+
+	fmt.Printf("Requeued %d file(s). They will be backed up by the running service or the next 'koneksi-backup run'.\n", requeued)
 	return nil
 }
 
@@ -900,20 +1625,43 @@ api:
   directory_id: ""  # Leave empty to create a new directory on startup
   timeout: 30
   retry_count: 3
+  retry_base_delay: 1  # seconds before the first retry, doubled on each retry
+  retry_max_delay: 30  # upper bound for a single retry delay in seconds
+  bandwidth_limit:
+    rate: "unlimited"  # shared by all uploads and downloads, e.g. "2MB" per second
+    schedule: []  # e.g. [{start: "08:00", end: "18:00", rate: "2MB"}]
 
 backup:
   directories:
     - "/path/to/backup/directory1"
     - "/path/to/backup/directory2"
+    # Entries can also override the settings below for one directory:
+    # - path: "/srv/media"
+    #   max_file_size: 21474836480  # 20GB
+    #   compression:
+    #     enabled: false
+    # - path: "/home/finance"
+    #   exclude_patterns: ["*.bak"]  # in addition to the global patterns
+    #   encryption:
+    #     enabled: true
+    #     password: ""  # defaults to backup.encryption.password
+    #   retention: 30  # days backup records are kept, see database.retention
+    #   prune: {keep_daily: 30, keep_monthly: 12}  # replaces the prune rules
   exclude_patterns:
     - "*.tmp"
     - "*.log"
     - ".git"
     - "node_modules"
     - "__pycache__"
+  watch:
+    mode: "auto"         # auto (inotify with polling fallback), inotify or poll
+    poll_interval: 60    # seconds between scans of polled directories
+    poll_directories: [] # directories that are always polled, e.g. NFS/SMB mounts
   check_interval: 300  # seconds
   max_file_size: 1073741824  # 1GB in bytes
   concurrent: 5
+  shutdown_timeout: 30  # seconds to let running uploads finish on shutdown
+  remote_layout: "flat"  # flat, or mirror to recreate the local directory tree in Koneksi
   compression:
     enabled: false  # Enable compression for backups
     level: 6       # Compression level (1-9)
@@ -921,6 +1669,29 @@ backup:
   encryption:
     enabled: false  # Enable encryption for backups
     password: ""    # Encryption password (can also use KONEKSI_BACKUP_ENCRYPTION_PASSWORD env var)
+  retry:
+    max_attempts: 10  # Attempts before a file is moved to the failed list
+    base_delay: 5     # Seconds before the first retry, doubled on each attempt
+    max_delay: 3600   # Upper bound for the retry delay in seconds
+  jobs: []  # scheduled backups, e.g. [{name: "db", schedule: "30 2 * * *", paths: ["/var/backups/db"], mode: "archive"}]
+
+targets: []  # extra copies, e.g. [{name: "nas", type: "local", path: "/mnt/nas/backup"}, {name: "offsite", type: "koneksi", async: true, api: {directory_id: "..."}}]
+
+prune:
+  enabled: false  # prune old file versions while the service runs
+  interval: 24    # hours between runs
+  keep_last: 0    # rules keeping versions of each file; zero disables a rule
+  keep_hourly: 0
+  keep_daily: 0
+  keep_weekly: 0
+  keep_monthly: 0
+  keep_within: ""  # e.g. "30d", counted back from the newest version
+
+catalog:
+  enabled: false  # upload an encrypted copy of the catalog while the service runs
+  interval: 24    # hours between uploads
+  versions: 7     # uploaded catalogs kept; 0 keeps all
+  password: ""    # defaults to backup.encryption.password or KONEKSI_BACKUP_ENCRYPTION_PASSWORD
 
 report:
   directory: "./reports"
@@ -935,6 +1706,10 @@ log:
 database:
   path: "./backup.db"
   retention: 90  # days
+
+control:
+  enabled: true
+  socket: ""  # defaults to ~/.koneksi-backup/control.sock
 `
 
 	if err := os.WriteFile(configPath, []byte(defaultConfig), 0644); err != nil {
@@ -951,6 +1726,14 @@ func restoreBackup(cmd *cobra.Command, args []string) error {
 	manifestFile := args[0]
 	targetDir := args[1]
 
+	if restoreSavePlan != "" && !restoreDryRun {
+		return fmt.Errorf("--save-plan needs --dry-run")
+	}
+	policy, err := parseOnConflict(restoreOnConflict)
+	if err != nil {
+		return err
+	}
+
 	// Load configuration
 	cfg, err := config.Load(configFile)
 	if err != nil {
@@ -974,15 +1757,15 @@ func restoreBackup(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		cfg.API.DirectoryID,
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, cfg.API.DirectoryID)
+
+	if restoreDryRun {
+		plan, err := backup.NewRestoreService(apiClient, logger, cfg.Backup.Concurrent).PlanManifest(manifestFile, targetDir, policy)
+		if err != nil {
+			return err
+		}
+		return showPlan(plan, restoreSavePlan)
+	}
 
 	// Test API connection
 	ctx := context.Background()
@@ -992,12 +1775,31 @@ func restoreBackup(cmd *cobra.Command, args []string) error {
 
 	// Create restore service
 	restoreService := backup.NewRestoreService(apiClient, logger, cfg.Backup.Concurrent)
+	restoreService.SetNoOwner(restoreNoOwner)
+
+	// Fall back to the secondary targets when a download fails. Their
+	// copies are recorded in the local database.
+	if len(cfg.Targets) > 0 {
+		if _, err := os.Stat(cfg.Database.Path); err == nil {
+			db, err := database.New(cfg.Database.Path)
+			if err != nil {
+				logger.Warn("failed to open database, restoring from the primary target only", zap.Error(err))
+			} else {
+				defer db.Close()
+				var targets []backup.Target
+				for _, replica := range replicaTargets(cfg) {
+					targets = append(targets, replica.Target)
+				}
+				restoreService.SetFallback(db, targets)
+			}
+		}
+	}
 
 	fmt.Printf("Starting restore from manifest: %s\n", manifestFile)
 	fmt.Printf("Target directory: %s\n", targetDir)
 
 	// Perform restore
-	if err := restoreService.RestoreFromManifest(ctx, manifestFile, targetDir); err != nil {
+	if err := restoreService.RestoreFromManifest(ctx, manifestFile, targetDir, policy); err != nil {
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
@@ -1007,6 +1809,9 @@ func restoreBackup(cmd *cobra.Command, args []string) error {
 	fmt.Printf("- Total files: %d\n", progress.TotalFiles)
 	fmt.Printf("- Restored: %d\n", progress.RestoredFiles)
 	fmt.Printf("- Failed: %d\n", progress.FailedFiles)
+	if progress.SkippedFiles > 0 {
+		fmt.Printf("- Skipped: %d\n", progress.SkippedFiles)
+	}
 	fmt.Printf("- Duration: %s\n", time.Since(progress.StartTime))
 
 	// Handle decryption if needed
@@ -1124,15 +1929,7 @@ func createManifest(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		cfg.API.DirectoryID,
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, cfg.API.DirectoryID)
 
 	// Create restore service
 	restoreService := backup.NewRestoreService(apiClient, logger, 1)
@@ -1183,15 +1980,7 @@ func listDirectories(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		"", // No default directory for directory management
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, "") // No default directory for directory management
 
 	ctx := context.Background()
 
@@ -1254,15 +2043,7 @@ func createDirectory(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		"",
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, "")
 
 	ctx := context.Background()
 
@@ -1320,15 +2101,7 @@ func removeDirectory(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API client
-	apiClient := api.NewClient(
-		cfg.API.BaseURL,
-		cfg.API.ClientID,
-		cfg.API.ClientSecret,
-		"",
-		time.Duration(cfg.API.Timeout)*time.Second,
-		cfg.API.RetryCount,
-		logger,
-	)
+	apiClient := newAPIClient(cfg, "")
 
 	ctx := context.Background()
 
@@ -1343,22 +2116,57 @@ func removeDirectory(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to list directories: %w", err)
 	}
 
-	var targetDir *api.DirectoryInfo
+	name := dirID
 	for _, dir := range directories {
 		if dir.ID == dirID {
-			targetDir = &dir
+			name = dir.Name
 			break
 		}
 	}
 
-	if targetDir == nil {
+	entries, err := listRemoteTree(ctx, apiClient, dirID, "", dirRecursive)
+	if errors.Is(err, api.ErrNotFound) {
 		return fmt.Errorf("directory not found: %s", dirID)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to list directory contents: %w", err)
+	}
+
+	var files, subdirs int
+	var size int64
+	for _, e := range entries {
+		if e.dir {
+			subdirs++
+		} else {
+			files++
+			size += e.size
+		}
+	}
+
+	if dirDryRun {
+		if len(entries) > 0 && !dirRecursive {
+			fmt.Printf("Directory '%s' (ID: %s) is not empty; it can only be removed with --recursive.\n", name, dirID)
+		} else {
+			fmt.Printf("Would remove directory '%s' (ID: %s) with %d files totaling %s and %d subdirectories.\n", name, dirID, files, formatBytes(size), subdirs)
+		}
+		for _, e := range entries {
+			if e.dir {
+				fmt.Printf("  %s/\n", e.path)
+			} else {
+				fmt.Printf("  %s (%s)\n", e.path, formatBytes(e.size))
+			}
+		}
+		return nil
+	}
+
+	if len(entries) > 0 && !dirRecursive {
+		return fmt.Errorf("directory %s is not empty: use --recursive to remove it with its contents", dirID)
+	}
 
 	// Confirm removal if not forced
 	if !dirForceRemove {
-		fmt.Printf("Are you sure you want to remove directory '%s' (ID: %s)?\n", targetDir.Name, dirID)
-		fmt.Printf("This directory contains %d files totaling %s.\n", targetDir.FileCount, formatBytes(targetDir.TotalSize))
+		fmt.Printf("Are you sure you want to remove directory '%s' (ID: %s)?\n", name, dirID)
+		fmt.Printf("This directory contains %d files totaling %s and %d subdirectories.\n", files, formatBytes(size), subdirs)
 		fmt.Print("This action cannot be undone. Type 'yes' to confirm: ")
 
 		var response string
@@ -1372,13 +2180,51 @@ func removeDirectory(cmd *cobra.Command, args []string) error {
 	// Remove directory
 	fmt.Printf("Removing directory %s...\n", dirID)
 
-	// Note: Actual deletion would require API support
-	fmt.Printf("Directory removal functionality not yet implemented in API.\n")
-	fmt.Printf("Would remove directory: %s (%s)\n", targetDir.Name, dirID)
+	if err := apiClient.DeleteDirectory(ctx, dirID, dirRecursive); err != nil {
+		if errors.Is(err, api.ErrConflict) {
+			return fmt.Errorf("directory %s is no longer empty: use --recursive to remove it with its contents", dirID)
+		}
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
 
+	fmt.Printf("Directory '%s' removed.\n", name)
 	return nil
 }
 
+// remoteEntry is a file or subdirectory below a remote directory.
+type remoteEntry struct {
+	path string
+	size int64
+	dir  bool
+}
+
+// listRemoteTree lists the files and subdirectories of a remote directory,
+// and with recursive everything below the subdirectories as well. Paths
+// are relative to the listed directory.
+func listRemoteTree(ctx context.Context, client *api.Client, dirID, prefix string, recursive bool) ([]remoteEntry, error) {
+	contents, err := client.GetDirectory(ctx, dirID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []remoteEntry
+	for _, f := range contents.Files {
+		entries = append(entries, remoteEntry{path: prefix + f.Name, size: f.Size})
+	}
+	for _, d := range contents.Subdirectories {
+		entries = append(entries, remoteEntry{path: prefix + d.Name, dir: true})
+		if !recursive {
+			continue
+		}
+		below, err := listRemoteTree(ctx, client, d.ID, prefix+d.Name+"/", recursive)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, below...)
+	}
+	return entries, nil
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
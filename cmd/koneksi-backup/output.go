@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Output formats of the commands that list catalog entries.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatCSV:
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q: use table, json or csv", format)
+}
+
+// listing is the output of a command in every format. Table rows are meant
+// for reading, with sizes and times formatted; CSV rows hold the raw values.
+type listing struct {
+	tableHeader []string
+	table       [][]string
+	csvHeader   []string
+	csv         [][]string
+	json        interface{}
+}
+
+func writeListing(w io.Writer, format string, l listing) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(l.json)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(l.csvHeader); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(l.csv); err != nil {
+			return err
+		}
+		return cw.Error()
+	default:
+		return writeTable(w, l.tableHeader, l.table)
+	}
+}
+
+// writeTable prints rows in columns under the header, like the other
+// listings of the CLI. The last column is not padded.
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	line := func(cells []string) error {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i == len(cells)-1 {
+				b.WriteString(cell)
+				break
+			}
+			b.WriteString(cell)
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+1))
+		}
+		_, err := io.WriteString(w, strings.TrimRight(b.String(), " ")+"\n")
+		return err
+	}
+
+	dashes := make([]string, len(header))
+	for i, width := range widths {
+		dashes[i] = strings.Repeat("-", width)
+	}
+	if err := line(header); err != nil {
+		return err
+	}
+	if err := line(dashes); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := line(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// newPruner returns a pruner for cfg that deletes remote objects from the
+// primary target of client and the replication targets.
+func newPruner(cfg *config.Config, db *database.DB, client *api.Client) (*backup.Pruner, error) {
+	jobs, err := backup.JobsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []backup.Target{backup.NewKoneksiTarget(config.PrimaryTarget, client)}
+	for _, replica := range replicaTargets(cfg) {
+		targets = append(targets, replica.Target)
+	}
+	return backup.NewPruner(db, logger, cfg, jobs, targets)
+}
+
+func pruneBackups(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	pruner, err := newPruner(cfg, db, newAPIClient(cfg, cfg.API.DirectoryID))
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	result, err := pruner.Prune(context.Background(), pruneDryRun)
+	if err != nil {
+		return fmt.Errorf("prune failed: %w", err)
+	}
+
+	verb := "Removed"
+	if pruneDryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d of %d file versions and %d snapshots.\n", verb, len(result.Versions), len(result.Versions)+result.Kept, len(result.Snapshots))
+
+	if pruneDryRun {
+		printPrunedVersions(result.Versions)
+		printPrunedSnapshots(result.Snapshots)
+		return nil
+	}
+
+	fmt.Printf("Deleted %d remote objects", result.Deleted)
+	if result.Deferred > 0 {
+		fmt.Printf("; %d wait for their target to support deletion", result.Deferred)
+	}
+	if result.Failed > 0 {
+		fmt.Printf("; %d failed and will be retried", result.Failed)
+	}
+	fmt.Println(".")
+	return nil
+}
+
+func printPrunedVersions(versions []database.BackupRecord) {
+	if len(versions) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-20s %-10s %s\n", "Backup Time", "Size", "Path")
+	fmt.Printf("%-20s %-10s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 10), strings.Repeat("-", 40))
+	for _, v := range versions {
+		fmt.Printf("%-20s %-10s %s\n", v.BackupTime.Local().Format("2006-01-02 15:04:05"), formatBytes(v.OriginalSize), v.FilePath)
+	}
+}
+
+func printPrunedSnapshots(snapshots []database.Snapshot) {
+	if len(snapshots) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-6s %-20s %-20s %s\n", "ID", "Job", "Started", "Status")
+	fmt.Printf("%-6s %-20s %-20s %s\n", strings.Repeat("-", 6), strings.Repeat("-", 20), strings.Repeat("-", 20), strings.Repeat("-", 8))
+	for _, s := range snapshots {
+		fmt.Printf("%-6d %-20s %-20s %s\n", s.ID, s.Job, s.StartedAt.Format("2006-01-02 15:04:05"), s.Status)
+	}
+}
+
+// pruneRoutine prunes every prune.interval hours while prune is enabled in
+// the daemon's current configuration.
+func pruneRoutine(ctx context.Context, d *daemon, client *api.Client) {
+	for {
+		interval := time.Duration(d.config().Prune.Interval) * time.Hour
+		if interval <= 0 {
+			interval = 24 * time.Hour
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		cfg := d.config()
+		if !cfg.Prune.Enabled {
+			continue
+		}
+		pruner, err := newPruner(cfg, d.db, client)
+		if err != nil {
+			logger.Error("failed to create pruner", zap.Error(err))
+			continue
+		}
+		if _, err := pruner.Prune(ctx, false); err != nil {
+			logger.Error("prune failed", zap.Error(err))
+		}
+	}
+}
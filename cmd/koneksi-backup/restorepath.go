@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// newPathRestoreService returns a restore service downloading from the
+// primary target, falling back to the replication targets, and setting
+// owners unless --no-owner is given.
+func newPathRestoreService(cfg *config.Config, db *database.DB) *backup.RestoreService {
+	restoreService := backup.NewRestoreService(newAPIClient(cfg, cfg.API.DirectoryID), logger, cfg.Backup.Concurrent)
+	var targets []backup.Target
+	for _, replica := range replicaTargets(cfg) {
+		targets = append(targets, replica.Target)
+	}
+	restoreService.SetFallback(db, targets)
+	restoreService.SetNoOwner(restoreNoOwner)
+	return restoreService
+}
+
+// parseOnConflict parses --on-conflict, which is empty for the default.
+func parseOnConflict(value string) (backup.ConflictPolicy, error) {
+	if value == "" {
+		return "", nil
+	}
+	return backup.ParseConflictPolicy(value)
+}
+
+func restorePaths(cmd *cobra.Command, args []string) error {
+	if restorePathVersion != 0 && len(args) > 1 {
+		return fmt.Errorf("--version needs a single path")
+	}
+	if restoreSavePlan != "" && !restoreDryRun {
+		return fmt.Errorf("--save-plan needs --dry-run")
+	}
+	policy, err := parseOnConflict(restoreOnConflict)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	restoreService := newPathRestoreService(cfg, db)
+
+	opts := backup.PathRestoreOptions{
+		Version:    restorePathVersion,
+		InPlace:    restorePathInPlace,
+		OnConflict: policy,
+		Passwords:  cfg.RestorePasswords(),
+	}
+	if restorePathPassword != "" {
+		opts.Passwords = append([]string{restorePathPassword}, opts.Passwords...)
+	}
+	if restorePathTo != "" {
+		if opts.To, err = filepath.Abs(restorePathTo); err != nil {
+			return fmt.Errorf("invalid target directory: %w", err)
+		}
+	}
+
+	patterns := make([]string, len(args))
+	for i, arg := range args {
+		if patterns[i], err = filepath.Abs(arg); err != nil {
+			return fmt.Errorf("invalid path %s: %w", arg, err)
+		}
+	}
+
+	if restoreDryRun {
+		plan, err := restoreService.PlanPaths(db, patterns, opts)
+		if err != nil {
+			return err
+		}
+		return showPlan(plan, restoreSavePlan)
+	}
+
+	ctx := context.Background()
+	for _, pattern := range patterns {
+		restored, err := restoreService.RestorePaths(ctx, db, pattern, opts)
+		if err != nil {
+			return err
+		}
+		printRestored(restored)
+	}
+	return restoreSummary(restoreService.GetProgress())
+}
+
+func runRestorePlan(cmd *cobra.Command, args []string) error {
+	plan, err := backup.LoadRestorePlan(args[0])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	passwords := cfg.RestorePasswords()
+	if restorePathPassword != "" {
+		passwords = append([]string{restorePathPassword}, passwords...)
+	}
+
+	restoreService := newPathRestoreService(cfg, db)
+	restored, err := restoreService.ExecutePlan(context.Background(), plan, passwords)
+	if err != nil {
+		return err
+	}
+	printRestored(restored)
+	return restoreSummary(restoreService.GetProgress())
+}
+
+// showPlan prints a restore plan and, when path is set, saves it there.
+func showPlan(plan *backup.RestorePlan, path string) error {
+	rows := make([][]string, 0, len(plan.Files))
+	for _, f := range plan.Files {
+		version := ""
+		if f.Version != 0 {
+			version = fmt.Sprint(f.Version)
+		}
+		rows = append(rows, []string{string(f.Action), version, formatBytes(f.Size), f.Target})
+	}
+	if err := writeTable(os.Stdout, []string{"ACTION", "VERSION", "SIZE", "TARGET"}, rows); err != nil {
+		return err
+	}
+
+	s := plan.Summary
+	fmt.Printf("\n%d files to download (%s), %d conflicts (on conflict: %s), %d skipped, %d unchanged.\n",
+		s.Files, formatBytes(s.Bytes), s.Conflicts, plan.OnConflict, s.Skipped, s.Unchanged)
+	if s.Directories > 0 {
+		fmt.Printf("%d directories to create.\n", s.Directories)
+	}
+	short := false
+	for _, space := range s.Space {
+		free := "unknown"
+		if space.Free >= 0 {
+			free = formatBytes(space.Free)
+		}
+		fmt.Printf("Needs %s on the filesystem of %s, %s free", formatBytes(space.Needed), space.Path, free)
+		if !space.Sufficient() {
+			fmt.Print(": NOT ENOUGH SPACE")
+			short = true
+		}
+		fmt.Println(".")
+	}
+
+	if path != "" {
+		if err := plan.Save(path); err != nil {
+			return err
+		}
+		fmt.Printf("Plan saved to %s; run it with 'koneksi-backup restore-plan %s'.\n", path, path)
+	}
+	if short {
+		return fmt.Errorf("not enough free space to restore")
+	}
+	return nil
+}
+
+func printRestored(restored []backup.RestoredFile) {
+	for _, f := range restored {
+		switch {
+		case f.Action == backup.ActionUnchanged:
+			fmt.Printf("%s is already at version %d\n", f.Target, f.Record.Version)
+		case f.Action == backup.ActionSkip:
+			fmt.Printf("Skipped %s: a different file is there\n", f.Target)
+		case f.Backup != "":
+			fmt.Printf("Restored %s version %d (previous file kept as %s)\n", f.Target, f.Record.Version, f.Backup)
+		case f.Action == backup.ActionOverwrite:
+			fmt.Printf("Restored %s version %d to %s, replacing the previous file\n", f.Record.FilePath, f.Record.Version, f.Target)
+		default:
+			fmt.Printf("Restored %s version %d to %s\n", f.Record.FilePath, f.Record.Version, f.Target)
+		}
+	}
+}
+
+// restoreSummary prints the failures and totals of a restore, and fails
+// when files could not be restored.
+func restoreSummary(progress backup.RestoreProgress) error {
+	for _, e := range progress.Errors {
+		fmt.Printf("Failed to restore %s: %s\n", e.FilePath, e.Error)
+	}
+	fmt.Printf("\nRestored %d of %d files (%s)", progress.RestoredFiles, progress.TotalFiles, formatBytes(progress.RestoredSize))
+	if progress.SkippedFiles > 0 {
+		fmt.Printf(", skipped %d", progress.SkippedFiles)
+	}
+	fmt.Println(".")
+	if progress.FailedFiles > 0 {
+		return fmt.Errorf("%d files could not be restored", progress.FailedFiles)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// pauseSignals returns the signals that pause (SIGUSR1) and resume
+// (SIGUSR2) the backup service.
+func pauseSignals() (pause, resume os.Signal) {
+	return syscall.SIGUSR1, syscall.SIGUSR2
+}
+
+// reloadSignal returns the signal that reloads the configuration.
+func reloadSignal() os.Signal {
+	return syscall.SIGHUP
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// pauseSignals returns nil on Windows, which has no user signals; use the
+// pause and resume commands instead.
+func pauseSignals() (pause, resume os.Signal) {
+	return nil, nil
+}
+
+// reloadSignal returns nil on Windows; use the reload command instead.
+func reloadSignal() os.Signal {
+	return nil
+}
@@ -0,0 +1,485 @@
+// Package apitest provides an in-memory Koneksi API for tests.
+package apitest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Directory is a directory stored by the server. The top-level directories
+// have no parent.
+type Directory struct {
+	ID          string
+	Name        string
+	Description string
+	ParentID    string
+	CreatedAt   time.Time
+}
+
+// File is a file stored by the server.
+type File struct {
+	ID          string
+	Name        string
+	DirectoryID string
+	Hash        string
+	Data        []byte
+	CreatedAt   time.Time
+}
+
+// Server serves the endpoints used by api.Client from memory: health,
+// directories, file listings, uploads, downloads, deletes, renames and
+// moves.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	nextID      int
+	dirsCreated int
+	dirs        map[string]*Directory
+	files       map[string]*File
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		dirs:  make(map[string]*Directory),
+		files: make(map[string]*File),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/check-health", func(w http.ResponseWriter, r *http.Request) {
+		writeData(w, http.StatusOK, nil)
+	})
+	mux.HandleFunc("GET /api/clients/v1/directories", s.listDirectories)
+	mux.HandleFunc("POST /api/clients/v1/directories", s.createDirectory)
+	mux.HandleFunc("GET /api/clients/v1/directories/{id}", s.getDirectory)
+	mux.HandleFunc("DELETE /api/clients/v1/directories/{id}", s.deleteDirectory)
+	mux.HandleFunc("GET /api/clients/v1/directories/{id}/files", s.listFiles)
+	mux.HandleFunc("POST /api/clients/v1/files", s.uploadFile)
+	mux.HandleFunc("GET /api/clients/v1/files/{id}/download", s.downloadFile)
+	mux.HandleFunc("PATCH /api/clients/v1/files/{id}", s.updateFile)
+	mux.HandleFunc("DELETE /api/clients/v1/files/{id}", s.deleteFile)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddDirectory creates a directory below parentID, or at the top level
+// when parentID is empty, and returns its ID.
+func (s *Server) AddDirectory(name, parentID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addDirectoryLocked(name, "", parentID)
+}
+
+// AddFile stores a file in a directory and returns its ID.
+func (s *Server) AddFile(directoryID, name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addFileLocked(directoryID, name, data)
+}
+
+// Directory returns a stored directory.
+func (s *Server) Directory(id string) (Directory, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, ok := s.dirs[id]
+	if !ok {
+		return Directory{}, false
+	}
+	return *dir, true
+}
+
+// File returns a stored file.
+func (s *Server) File(id string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[id]
+	if !ok {
+		return File{}, false
+	}
+	return *file, true
+}
+
+// Files returns the files of a directory ordered by ID.
+func (s *Server) Files(directoryID string) []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []File
+	for _, f := range s.files {
+		if f.DirectoryID == directoryID {
+			files = append(files, *f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return fileSeq(files[i].ID) < fileSeq(files[j].ID) })
+	return files
+}
+
+// FindFile returns the most recently stored file with a name.
+func (s *Server) FindFile(name string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *File
+	for _, f := range s.files {
+		if f.Name == name && (found == nil || fileSeq(f.ID) > fileSeq(found.ID)) {
+			found = f
+		}
+	}
+	if found == nil {
+		return File{}, false
+	}
+	return *found, true
+}
+
+// DirectoriesCreated returns the number of directories created so far,
+// including deleted ones.
+func (s *Server) DirectoriesCreated() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirsCreated
+}
+
+// Path returns the names of the directories from the top level down to
+// id, joined by "/".
+func (s *Server) Path(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := ""
+	for dir, ok := s.dirs[id]; ok; dir, ok = s.dirs[dir.ParentID] {
+		if path == "" {
+			path = dir.Name
+		} else {
+			path = dir.Name + "/" + path
+		}
+	}
+	return path
+}
+
+// DeleteDirectoryTree removes a directory with everything below it, as if
+// it was deleted by another client.
+func (s *Server) DeleteDirectoryTree(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteTreeLocked(id)
+}
+
+func (s *Server) newIDLocked(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+func (s *Server) addDirectoryLocked(name, description, parentID string) string {
+	id := s.newIDLocked("dir")
+	s.dirsCreated++
+	s.dirs[id] = &Directory{ID: id, Name: name, Description: description, ParentID: parentID, CreatedAt: time.Now()}
+	return id
+}
+
+// fileSeq returns the sequence number of an ID, which orders IDs by
+// creation.
+func fileSeq(id string) int {
+	n, _ := strconv.Atoi(id[strings.LastIndex(id, "-")+1:])
+	return n
+}
+
+func (s *Server) addFileLocked(directoryID, name string, data []byte) string {
+	id := s.newIDLocked("file")
+	sum := sha256.Sum256(data)
+	s.files[id] = &File{ID: id, Name: name, DirectoryID: directoryID, Hash: hex.EncodeToString(sum[:]), Data: data, CreatedAt: time.Now()}
+	return id
+}
+
+func (s *Server) deleteTreeLocked(id string) {
+	for _, f := range s.files {
+		if f.DirectoryID == id {
+			delete(s.files, f.ID)
+		}
+	}
+	for _, d := range s.dirs {
+		if d.ParentID == id {
+			s.deleteTreeLocked(d.ID)
+		}
+	}
+	delete(s.dirs, id)
+}
+
+// directoryJSONLocked describes a directory the way the API lists it.
+func (s *Server) directoryJSONLocked(dir *Directory) map[string]interface{} {
+	count, size := 0, 0
+	for _, f := range s.files {
+		if f.DirectoryID == dir.ID {
+			count++
+			size += len(f.Data)
+		}
+	}
+	return map[string]interface{}{
+		"id":          dir.ID,
+		"name":        dir.Name,
+		"description": dir.Description,
+		"created_at":  dir.CreatedAt.Format(time.RFC3339),
+		"file_count":  count,
+		"total_size":  size,
+	}
+}
+
+func (s *Server) listDirectories(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.dirs))
+	for id := range s.dirs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	dirs := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		dirs = append(dirs, s.directoryJSONLocked(s.dirs[id]))
+	}
+	writeData(w, http.StatusOK, dirs)
+}
+
+func (s *Server) createDirectory(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		ParentID    string `json:"parent_directory_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid directory")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dirs[req.ParentID]; req.ParentID != "" && !ok {
+		writeError(w, http.StatusNotFound, "parent directory not found")
+		return
+	}
+	id := s.addDirectoryLocked(req.Name, req.Description, req.ParentID)
+	writeData(w, http.StatusCreated, s.directoryJSONLocked(s.dirs[id]))
+}
+
+func (s *Server) getDirectory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.dirs[id]; !ok {
+		writeError(w, http.StatusNotFound, "directory not found")
+		return
+	}
+
+	files := s.filesJSONLocked(id)
+
+	subdirs := []map[string]interface{}{}
+	for _, d := range s.dirs {
+		if d.ParentID == id {
+			subdirs = append(subdirs, s.directoryJSONLocked(d))
+		}
+	}
+	sort.Slice(subdirs, func(i, j int) bool { return subdirs[i]["id"].(string) < subdirs[j]["id"].(string) })
+
+	writeData(w, http.StatusOK, map[string]interface{}{"id": id, "files": files, "subdirectories": subdirs})
+}
+
+// listFiles returns a page of the files in a directory, ordered by ID.
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.dirs[id]; !ok {
+		writeError(w, http.StatusNotFound, "directory not found")
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	files := s.filesJSONLocked(id)
+	start := (page - 1) * limit
+	if start > len(files) {
+		start = len(files)
+	}
+	end := start + limit
+	if end > len(files) {
+		end = len(files)
+	}
+	writeData(w, http.StatusOK, map[string]interface{}{
+		"files": files[start:end],
+		"page":  page,
+		"limit": limit,
+		"total": len(files),
+	})
+}
+
+func (s *Server) filesJSONLocked(directoryID string) []map[string]interface{} {
+	var stored []*File
+	for _, f := range s.files {
+		if f.DirectoryID == directoryID {
+			stored = append(stored, f)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool { return fileSeq(stored[i].ID) < fileSeq(stored[j].ID) })
+
+	files := make([]map[string]interface{}, 0, len(stored))
+	for _, f := range stored {
+		files = append(files, map[string]interface{}{
+			"id":         f.ID,
+			"name":       f.Name,
+			"hash":       f.Hash,
+			"size":       len(f.Data),
+			"created_at": f.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return files
+}
+
+func (s *Server) deleteDirectory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.dirs[id]; !ok {
+		writeError(w, http.StatusNotFound, "directory not found")
+		return
+	}
+	if r.URL.Query().Get("recursive") != "true" {
+		for _, f := range s.files {
+			if f.DirectoryID == id {
+				writeError(w, http.StatusConflict, "directory is not empty")
+				return
+			}
+		}
+		for _, d := range s.dirs {
+			if d.ParentID == id {
+				writeError(w, http.StatusConflict, "directory is not empty")
+				return
+			}
+		}
+	}
+	s.deleteTreeLocked(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	directoryID := r.URL.Query().Get("directory_id")
+	if _, ok := s.dirs[directoryID]; directoryID != "" && !ok {
+		writeError(w, http.StatusNotFound, "directory not found")
+		return
+	}
+	id := s.addFileLocked(directoryID, header.Filename, data)
+	f := s.files[id]
+	writeData(w, http.StatusOK, map[string]interface{}{
+		"file_id":      f.ID,
+		"name":         f.Name,
+		"directory_id": f.DirectoryID,
+		"hash":         f.Hash,
+		"size":         len(f.Data),
+	})
+}
+
+func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	f, ok := s.files[r.PathValue("id")]
+	var data []byte
+	if ok {
+		data = f.Data
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func (s *Server) updateFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		DirectoryID string `json:"directory_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if req.DirectoryID != "" {
+		if _, ok := s.dirs[req.DirectoryID]; !ok {
+			writeError(w, http.StatusNotFound, "directory not found")
+			return
+		}
+		f.DirectoryID = req.DirectoryID
+	}
+	if req.Name != "" {
+		f.Name = req.Name
+	}
+	writeData(w, http.StatusOK, map[string]interface{}{"id": f.ID, "name": f.Name, "directory_id": f.DirectoryID})
+}
+
+func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.files[id]; !ok {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	delete(s.files, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "error", "message": message})
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limiterChunk is the largest read charged to the limiter at once, so a
+// single large read does not stall other streams for long.
+const limiterChunk = 32 * 1024
+
+// BandwidthWindow limits the rate during a time of day. End may be earlier
+// than Start for windows that span midnight.
+type BandwidthWindow struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration
+	Rate  int64 // bytes per second, 0 for unlimited
+}
+
+// contains reports whether the time of day falls inside the window.
+func (w BandwidthWindow) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// BandwidthSchedule is a default rate with optional time-of-day windows.
+// The first matching window wins.
+type BandwidthSchedule struct {
+	Rate    int64
+	Windows []BandwidthWindow
+}
+
+// RateAt returns the rate in bytes per second that applies at t. Windows
+// follow the wall clock, also on days when daylight saving time changes.
+func (s BandwidthSchedule) RateAt(t time.Time) int64 {
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	for _, w := range s.Windows {
+		if w.contains(offset) {
+			return w.Rate
+		}
+	}
+	return s.Rate
+}
+
+// Limiter is a token bucket shared by every upload and download stream of
+// the clients it is attached to. The bucket holds one second worth of
+// tokens; reads may overdraw it and then wait until the debt is repaid.
+type Limiter struct {
+	mu          sync.Mutex
+	schedule    BandwidthSchedule
+	override    int64
+	hasOverride bool
+	tokens      float64
+	last        time.Time
+	now         func() time.Time
+}
+
+// NewLimiter creates a limiter following the given schedule.
+func NewLimiter(schedule BandwidthSchedule) *Limiter {
+	return &Limiter{
+		schedule: schedule,
+		now:      time.Now,
+	}
+}
+
+// SetSchedule replaces the configured schedule.
+func (l *Limiter) SetSchedule(schedule BandwidthSchedule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = schedule
+}
+
+// SetOverride pins the rate regardless of the schedule until ClearOverride
+// is called. A rate of 0 disables limiting.
+func (l *Limiter) SetOverride(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.override = rate
+	l.hasOverride = true
+}
+
+// ClearOverride returns to the configured schedule.
+func (l *Limiter) ClearOverride() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hasOverride = false
+}
+
+// Rate returns the rate currently in effect in bytes per second, and
+// whether it comes from a runtime override.
+func (l *Limiter) Rate() (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rateLocked(), l.hasOverride
+}
+
+func (l *Limiter) rateLocked() int64 {
+	if l.hasOverride {
+		return l.override
+	}
+	return l.schedule.RateAt(l.now())
+}
+
+// WaitN charges n bytes to the bucket and blocks until the bucket is no
+// longer overdrawn or ctx is done. Rate changes take effect while waiting.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.refillLocked()
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		rate := l.refillLocked()
+		if rate <= 0 || l.tokens >= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		// Wake up regularly so schedule changes and overrides apply
+		if wait > time.Second {
+			wait = time.Second
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// refillLocked adds the tokens earned since the last call and returns the
+// current rate. An unlimited rate clears any outstanding debt.
+func (l *Limiter) refillLocked() int64 {
+	now := l.now()
+	rate := l.rateLocked()
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+	return rate
+}
+
+// Reader wraps r so that reads are throttled by the limiter.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limiterChunk {
+		p = p[:limiterChunk]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.WaitN(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ParseRate parses a bandwidth such as "2MB", "512KB" or "1048576" into
+// bytes per second. An empty string, "0" or "unlimited" means no limit.
+func ParseRate(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "/S")
+	if s == "" || s == "0" || s == "UNLIMITED" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(s, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", value)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseTimeOfDay parses "HH:MM" into an offset from midnight.
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
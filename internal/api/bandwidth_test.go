@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBandwidthScheduleRateAt(t *testing.T) {
+	schedule := BandwidthSchedule{
+		Rate: 0,
+		Windows: []BandwidthWindow{
+			{Start: 8 * time.Hour, End: 18 * time.Hour, Rate: 2 << 20},
+			{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 10 << 20},
+		},
+	}
+
+	tests := []struct {
+		hour, minute int
+		want         int64
+	}{
+		{7, 59, 0},
+		{8, 0, 2 << 20},
+		{17, 59, 2 << 20},
+		{18, 0, 0},
+		{23, 30, 10 << 20},
+		{3, 0, 10 << 20},
+		{6, 0, 0},
+	}
+
+	for _, tt := range tests {
+		at := time.Date(2024, 1, 1, tt.hour, tt.minute, 0, 0, time.Local)
+		if got := schedule.RateAt(at); got != tt.want {
+			t.Errorf("%02d:%02d: expected %d, got %d", tt.hour, tt.minute, tt.want, got)
+		}
+	}
+
+	// On the day clocks go forward 08:30 is only 7.5 hours after midnight
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	if got := schedule.RateAt(time.Date(2024, 3, 31, 8, 30, 0, 0, berlin)); got != 2<<20 {
+		t.Errorf("08:30 on a DST change: expected %d, got %d", 2<<20, got)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]int64{
+		"":          0,
+		"unlimited": 0,
+		"1048576":   1 << 20,
+		"512KB":     512 << 10,
+		"2MB":       2 << 20,
+		"2 MB/s":    2 << 20,
+		"1.5m":      3 << 19,
+		"1GB":       1 << 30,
+	}
+	for input, want := range tests {
+		got, err := ParseRate(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: expected %d, got %d", input, want, got)
+		}
+	}
+
+	for _, input := range []string{"fast", "-1MB", "MB"} {
+		if _, err := ParseRate(input); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
+
+func TestLimiterThrottlesReads(t *testing.T) {
+	limiter := NewLimiter(BandwidthSchedule{Rate: 1 << 20})
+	data := make([]byte, 512<<10)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.Reader(context.Background(), bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("expected %d bytes, got %d", len(data), n)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("512KB at 1MB/s finished too quickly: %v", elapsed)
+	}
+}
+
+func TestLimiterOverride(t *testing.T) {
+	limiter := NewLimiter(BandwidthSchedule{Rate: 1024})
+
+	limiter.SetOverride(0)
+	if rate, overridden := limiter.Rate(); rate != 0 || !overridden {
+		t.Errorf("expected unlimited override, got %d (overridden=%v)", rate, overridden)
+	}
+
+	start := time.Now()
+	if err := limiter.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited override should not wait, took %v", elapsed)
+	}
+
+	limiter.ClearOverride()
+	if rate, overridden := limiter.Rate(); rate != 1024 || overridden {
+		t.Errorf("expected schedule rate after clearing override, got %d (overridden=%v)", rate, overridden)
+	}
+}
+
+func TestLimiterWaitStopsOnCancel(t *testing.T) {
+	limiter := NewLimiter(BandwidthSchedule{Rate: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.WaitN(ctx, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+}
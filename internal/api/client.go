@@ -8,7 +8,9 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
@@ -21,7 +23,8 @@ type Client struct {
 	DirectoryID  string
 	HttpClient   *http.Client
 	logger       *zap.Logger
-	retryCount   int
+	retryPolicy  RetryPolicy
+	limiter      *Limiter
 }
 
 type ErrorResponse struct {
@@ -52,6 +55,7 @@ type FileUploadResponse struct {
 type DirectoryCreateRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	ParentID    string `json:"parent_directory_id,omitempty"`
 }
 
 type DirectoryResponse struct {
@@ -61,6 +65,61 @@ type DirectoryResponse struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// FileInfo describes a file stored in a directory.
+type FileInfo struct {
+	ID        string
+	Name      string
+	Hash      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// fileJSON is a file as the API returns it.
+type fileJSON struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (f fileJSON) info() FileInfo {
+	createdAt, _ := time.Parse(time.RFC3339, f.CreatedAt)
+	return FileInfo{ID: f.ID, Name: f.Name, Hash: f.Hash, Size: f.Size, CreatedAt: createdAt}
+}
+
+// FileList is one page of the files in a directory.
+type FileList struct {
+	Files    []FileInfo
+	Page     int
+	PageSize int
+	// Total is the number of files in the directory, or 0 when the API
+	// does not report it
+	Total int
+}
+
+// HasMore reports whether pages after this one may hold more files.
+func (l *FileList) HasMore() bool {
+	if l.Total > 0 {
+		return l.Page*l.PageSize < l.Total
+	}
+	return len(l.Files) >= l.PageSize
+}
+
+// DirectoryContents lists the files and direct subdirectories of a
+// directory.
+type DirectoryContents struct {
+	Files          []FileInfo
+	Subdirectories []DirectoryInfo
+}
+
+// FileUpdateRequest changes the name or directory of a file; empty fields
+// are left alone.
+type FileUpdateRequest struct {
+	Name        string `json:"name,omitempty"`
+	DirectoryID string `json:"directory_id,omitempty"`
+}
+
 type DirectoryInfo struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -79,11 +138,22 @@ func NewClient(baseURL, clientID, clientSecret, directoryID string, timeout time
 		HttpClient: &http.Client{
 			Timeout: timeout,
 		},
-		logger:     logger,
-		retryCount: retryCount,
+		logger:      logger,
+		retryPolicy: DefaultRetryPolicy(retryCount),
 	}
 }
 
+// SetRetryPolicy replaces the policy used for retrying failed requests.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetBandwidthLimiter throttles request bodies and downloads through limiter.
+// The same limiter may be shared by several clients.
+func (c *Client) SetBandwidthLimiter(limiter *Limiter) {
+	c.limiter = limiter
+}
+
 func (c *Client) HealthCheck(ctx context.Context) error {
 	resp, err := c.doRequest(ctx, "GET", "/api/check-health", nil)
 	if err != nil {
@@ -105,6 +175,12 @@ func (c *Client) HealthCheck(ctx context.Context) error {
 }
 
 func (c *Client) UploadFile(ctx context.Context, filePath string, fileData io.Reader, size int64, checksum string) (*FileUploadResponse, error) {
+	return c.UploadFileTo(ctx, c.DirectoryID, filePath, fileData, size, checksum)
+}
+
+// UploadFileTo uploads a file into the given directory instead of the
+// client's default one.
+func (c *Client) UploadFileTo(ctx context.Context, directoryID, filePath string, fileData io.Reader, size int64, checksum string) (*FileUploadResponse, error) {
 	// Using the correct files endpoint
 	endpoint := "/api/clients/v1/files"
 
@@ -129,17 +205,6 @@ func (c *Client) UploadFile(ctx context.Context, filePath string, fileData io.Re
 		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+endpoint, &buf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("Client-ID", c.ClientID)
-	req.Header.Set("Client-Secret", c.ClientSecret)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
 	// Debug log headers
 	c.logger.Debug("upload request headers",
 		zap.String("Client-ID", c.ClientID),
@@ -148,12 +213,19 @@ func (c *Client) UploadFile(ctx context.Context, filePath string, fileData io.Re
 	)
 
 	// Add directory_id query parameter if provided
-	if c.DirectoryID != "" {
-		req.URL.RawQuery = fmt.Sprintf("directory_id=%s", c.DirectoryID)
-	}
-
-	// Execute request
-	resp, err := c.HttpClient.Do(req)
+	query := url.Values{}
+	if directoryID != "" {
+		query.Set("directory_id", directoryID)
+	}
+
+	// Execute request; the form is kept in memory so retries resend it
+	resp, err := c.do(ctx, request{
+		method:      "POST",
+		endpoint:    endpoint,
+		query:       query,
+		contentType: writer.FormDataContentType(),
+		body:        buf.Bytes(),
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -221,46 +293,223 @@ func (c *Client) GetFileIDByHash(ctx context.Context, hash string) (string, erro
 		return "", fmt.Errorf("directory ID not set")
 	}
 
-	endpoint := fmt.Sprintf("/api/clients/v1/directories/%s", c.DirectoryID)
-	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	contents, err := c.GetDirectory(ctx, c.DirectoryID)
 	if err != nil {
 		return "", err
 	}
+
+	// Find file by hash
+	for _, file := range contents.Files {
+		if file.Hash == hash {
+			return file.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("file with hash %s not found in directory", hash)
+}
+
+// GetDirectory returns the files and subdirectories of a directory.
+func (c *Client) GetDirectory(ctx context.Context, directoryID string) (*DirectoryContents, error) {
+	endpoint := fmt.Sprintf("/api/clients/v1/directories/%s", url.PathEscape(directoryID))
+	resp, err := c.doRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", c.parseError(resp)
+		return nil, c.parseError(resp)
 	}
 
 	var dirResp struct {
 		Data struct {
-			Files []struct {
-				ID   string `json:"id"`
-				Hash string `json:"hash"`
-			} `json:"files"`
+			Files          []fileJSON `json:"files"`
+			Subdirectories []struct {
+				ID          string `json:"id"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				CreatedAt   string `json:"created_at"`
+				FileCount   int    `json:"file_count"`
+				TotalSize   int64  `json:"total_size"`
+			} `json:"subdirectories"`
 		} `json:"data"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&dirResp); err != nil {
-		return "", fmt.Errorf("failed to decode directory response: %w", err)
+		return nil, fmt.Errorf("failed to decode directory response: %w", err)
 	}
 
-	// Find file by hash
-	for _, file := range dirResp.Data.Files {
-		if file.Hash == hash {
-			return file.ID, nil
+	contents := &DirectoryContents{}
+	for _, f := range dirResp.Data.Files {
+		contents.Files = append(contents.Files, f.info())
+	}
+	for _, dir := range dirResp.Data.Subdirectories {
+		createdAt, _ := time.Parse(time.RFC3339, dir.CreatedAt)
+		contents.Subdirectories = append(contents.Subdirectories, DirectoryInfo{
+			ID:          dir.ID,
+			Name:        dir.Name,
+			Description: dir.Description,
+			CreatedAt:   createdAt,
+			FileCount:   dir.FileCount,
+			TotalSize:   dir.TotalSize,
+		})
+	}
+	return contents, nil
+}
+
+// DefaultPageSize is the number of files ListAllFiles requests per page.
+const DefaultPageSize = 100
+
+// ListFiles returns a page of the files in a directory. Pages start at 1.
+func (c *Client) ListFiles(ctx context.Context, directoryID string, page, pageSize int) (*FileList, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("limit", strconv.Itoa(pageSize))
+
+	resp, err := c.do(ctx, request{
+		method:      "GET",
+		endpoint:    fmt.Sprintf("/api/clients/v1/directories/%s/files", url.PathEscape(directoryID)),
+		query:       query,
+		contentType: "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.parseError(resp)
+	}
+
+	var listResp struct {
+		Data struct {
+			Files []fileJSON `json:"files"`
+			Page  int        `json:"page"`
+			Limit int        `json:"limit"`
+			Total int        `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode file list: %w", err)
+	}
+
+	list := &FileList{Page: page, PageSize: pageSize, Total: listResp.Data.Total}
+	if listResp.Data.Limit > 0 {
+		list.PageSize = listResp.Data.Limit
+	}
+	for _, f := range listResp.Data.Files {
+		list.Files = append(list.Files, f.info())
+	}
+	return list, nil
+}
+
+// ListAllFiles returns the files in a directory, requesting one page after
+// the other.
+func (c *Client) ListAllFiles(ctx context.Context, directoryID string) ([]FileInfo, error) {
+	var files []FileInfo
+	for page := 1; ; page++ {
+		list, err := c.ListFiles(ctx, directoryID, page, DefaultPageSize)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, list.Files...)
+		if !list.HasMore() || len(list.Files) == 0 {
+			return files, nil
 		}
 	}
+}
 
-	return "", fmt.Errorf("file with hash %s not found in directory", hash)
+// DeleteDirectory deletes a directory. A directory that still contains
+// files or subdirectories is only deleted with recursive, which deletes
+// them as well; otherwise ErrConflict is returned.
+func (c *Client) DeleteDirectory(ctx context.Context, directoryID string, recursive bool) error {
+	query := url.Values{}
+	if recursive {
+		query.Set("recursive", "true")
+	}
+
+	resp, err := c.do(ctx, request{
+		method:      "DELETE",
+		endpoint:    fmt.Sprintf("/api/clients/v1/directories/%s", url.PathEscape(directoryID)),
+		query:       query,
+		contentType: "application/json",
+	})
+	if err != nil {
+		return err
+	}
+	return c.expectSuccess(resp)
+}
+
+// DeleteFile deletes a file. Deleting a file that does not exist returns
+// ErrNotFound.
+func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
+	resp, err := c.doRequest(ctx, "DELETE", fmt.Sprintf("/api/clients/v1/files/%s", url.PathEscape(fileID)), nil)
+	if err != nil {
+		return err
+	}
+	return c.expectSuccess(resp)
+}
+
+// RenameFile changes the name of a file within its directory.
+func (c *Client) RenameFile(ctx context.Context, fileID, name string) error {
+	if name == "" {
+		return fmt.Errorf("file name is required")
+	}
+	return c.updateFile(ctx, fileID, FileUpdateRequest{Name: name})
+}
+
+// MoveFile moves a file into another directory. A missing file or
+// directory returns ErrNotFound.
+func (c *Client) MoveFile(ctx context.Context, fileID, directoryID string) error {
+	if directoryID == "" {
+		return fmt.Errorf("directory ID is required")
+	}
+	return c.updateFile(ctx, fileID, FileUpdateRequest{DirectoryID: directoryID})
+}
+
+func (c *Client) updateFile(ctx context.Context, fileID string, update FileUpdateRequest) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doRequest(ctx, "PATCH", fmt.Sprintf("/api/clients/v1/files/%s", url.PathEscape(fileID)), body)
+	if err != nil {
+		return err
+	}
+	return c.expectSuccess(resp)
+}
+
+// expectSuccess closes resp and returns the API error for a non-2xx status.
+func (c *Client) expectSuccess(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return c.parseError(resp)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
 }
 
 func (c *Client) CreateDirectory(ctx context.Context, name, description string) (*DirectoryResponse, error) {
+	return c.CreateSubdirectory(ctx, "", name, description)
+}
+
+// CreateSubdirectory creates a directory inside parentID, or at the top
+// level when parentID is empty.
+func (c *Client) CreateSubdirectory(ctx context.Context, parentID, name, description string) (*DirectoryResponse, error) {
 	endpoint := "/api/clients/v1/directories"
 
 	reqBody := DirectoryCreateRequest{
 		Name:        name,
 		Description: description,
+		ParentID:    parentID,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -268,7 +517,7 @@ func (c *Client) CreateDirectory(ctx context.Context, name, description string)
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.doRequest(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+	resp, err := c.doRequest(ctx, "POST", endpoint, jsonData)
 	if err != nil {
 		return nil, err
 	}
@@ -383,6 +632,9 @@ func (c *Client) DownloadFile(ctx context.Context, fileID string) (io.ReadCloser
 	}
 
 	// Return the response body - caller is responsible for closing it
+	if c.limiter != nil {
+		return limitedReadCloser{Reader: c.limiter.Reader(ctx, resp.Body), Closer: resp.Body}, nil
+	}
 	return resp.Body, nil
 }
 
@@ -405,55 +657,96 @@ func (c *Client) GetPeers(ctx context.Context) ([]interface{}, error) {
 	return peers, nil
 }
 
-func (c *Client) doRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
-	url := c.BaseURL + endpoint
+// request describes an API call. The body is kept as bytes so that it can
+// be replayed on every attempt.
+type request struct {
+	method      string
+	endpoint    string
+	query       url.Values
+	contentType string
+	body        []byte
+}
+
+func (c *Client) doRequest(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	return c.do(ctx, request{
+		method:      method,
+		endpoint:    endpoint,
+		contentType: "application/json",
+		body:        body,
+	})
+}
+
+// do sends the request, retrying network errors and retryable statuses
+// according to the client's retry policy. Other responses, including client
+// errors, are returned to the caller. Waiting between attempts stops as soon
+// as ctx is cancelled.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	reqURL := c.BaseURL + r.endpoint
+	if len(r.query) > 0 {
+		reqURL += "?" + r.query.Encode()
+	}
 
 	var lastErr error
-	for i := 0; i <= c.retryCount; i++ {
+	var retryAfter time.Duration
+	for i := 0; i <= c.retryPolicy.MaxRetries; i++ {
 		if i > 0 {
-			// Exponential backoff
-			time.Sleep(time.Duration(i*i) * time.Second)
-			c.logger.Info("retrying request", zap.String("url", url), zap.Int("attempt", i+1))
+			delay := c.retryPolicy.delay(i, retryAfter)
+			c.logger.Info("retrying request",
+				zap.String("url", reqURL),
+				zap.Int("attempt", i+1),
+				zap.Duration("delay", delay),
+			)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, fmt.Errorf("request cancelled: %w", err)
+			}
+		}
+
+		var body io.Reader
+		if r.body != nil {
+			body = c.limiter.Reader(ctx, bytes.NewReader(r.body))
 		}
 
-		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		req, err := http.NewRequestWithContext(ctx, r.method, reqURL, body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
+		if r.body != nil {
+			// The throttled reader hides the length from net/http
+			req.ContentLength = int64(len(r.body))
+		}
 
 		req.Header.Set("Client-ID", c.ClientID)
 		req.Header.Set("Client-Secret", c.ClientSecret)
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", r.contentType)
 
 		resp, err := c.HttpClient.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+			}
 			lastErr = err
-			c.logger.Error("request failed", zap.String("url", url), zap.Error(err))
+			retryAfter = 0
+			c.logger.Error("request failed", zap.String("url", reqURL), zap.Error(err))
 			continue
 		}
 
-		// Don't retry on client errors
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		if !retryableStatus(resp.StatusCode) {
 			return resp, nil
 		}
 
-		// Retry on server errors
-		if resp.StatusCode >= 500 {
-			lastErr = fmt.Errorf("server error: %d", resp.StatusCode)
-			resp.Body.Close()
-			continue
-		}
-
-		return resp, nil
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		lastErr = c.parseError(resp)
+		resp.Body.Close()
+		c.logger.Warn("request failed", zap.String("url", reqURL), zap.Error(lastErr))
 	}
 
-	return nil, fmt.Errorf("request failed after %d attempts: %w", c.retryCount+1, lastErr)
+	return nil, fmt.Errorf("request failed after %d attempts: %w", c.retryPolicy.MaxRetries+1, lastErr)
 }
 
 func (c *Client) parseError(resp *http.Response) error {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("status %d: failed to read error response", resp.StatusCode)
+		return &APIError{StatusCode: resp.StatusCode, Message: "failed to read error response"}
 	}
 
 	// Log raw error response
@@ -469,18 +762,18 @@ func (c *Client) parseError(resp *http.Response) error {
 		var altErr map[string]interface{}
 		if err := json.Unmarshal(body, &altErr); err == nil {
 			if msg, ok := altErr["message"].(string); ok {
-				return fmt.Errorf("API error (status %d): %s", resp.StatusCode, msg)
+				return &APIError{StatusCode: resp.StatusCode, Message: msg}
 			}
 			if msg, ok := altErr["error"].(string); ok {
-				return fmt.Errorf("API error (status %d): %s", resp.StatusCode, msg)
+				return &APIError{StatusCode: resp.StatusCode, Message: msg}
 			}
 		}
-		return fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+		return &APIError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	if errResp.Error != "" {
-		return fmt.Errorf("API error %s: %s", errResp.Code, errResp.Error)
+		return &APIError{StatusCode: resp.StatusCode, Code: errResp.Code, Message: errResp.Error}
 	}
 
-	return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+	return &APIError{StatusCode: resp.StatusCode, Message: string(body)}
 }
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL, "id", "secret", "dir-1", 5*time.Second, 3, zap.NewNop())
+	client.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+	return client
+}
+
+func TestClientRetryReplaysBody(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		attempt := len(bodies)
+		mu.Unlock()
+
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":{"file_id":"file-1","name":"test.txt","size":12},"status":"success"}`))
+	})
+
+	resp, err := client.UploadFile(context.Background(), "/tmp/test.txt", strings.NewReader("test content"), 12, "")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if resp.FileID != "file-1" {
+		t.Errorf("expected file id file-1, got %s", resp.FileID)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(bodies))
+	}
+	if bodies[1] == "" || bodies[0] != bodies[1] {
+		t.Error("retried request should resend the same body")
+	}
+	if !strings.Contains(bodies[1], "test content") {
+		t.Error("retried body is missing the file content")
+	}
+}
+
+func TestClientRetriesRateLimit(t *testing.T) {
+	attempts := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	if err := client.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestClientClassifiedErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		target error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusInternalServerError, ErrServer},
+	}
+
+	for _, tt := range tests {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(`{"message":"nope"}`))
+		})
+
+		_, err := client.DownloadFile(context.Background(), "file-1")
+		if !errors.Is(err, tt.target) {
+			t.Errorf("status %d: expected %v, got %v", tt.status, tt.target, err)
+		}
+	}
+}
+
+func TestClientRetryStopsOnCancel(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	client.SetRetryPolicy(RetryPolicy{MaxRetries: 5, BaseDelay: time.Minute, MaxDelay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.HealthCheck(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retry did not stop on cancellation, took %v", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != 3*time.Second {
+		t.Errorf("expected 3s, got %v", d)
+	}
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(future); d <= 0 || d > 10*time.Second {
+		t.Errorf("unexpected delay for HTTP date: %v", d)
+	}
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("expected 0 for invalid value, got %v", d)
+	}
+
+	policy := DefaultRetryPolicy(3)
+	if d := policy.delay(1, parseRetryAfter("86400")); d != policy.MaxDelay {
+		t.Errorf("expected Retry-After to be capped at %v, got %v", policy.MaxDelay, d)
+	}
+	if d := policy.delay(1, 2*time.Second); d != 2*time.Second {
+		t.Errorf("expected 2s, got %v", d)
+	}
+}
+
+func TestClientDeleteRenameMove(t *testing.T) {
+	server := apitest.NewServer(t)
+	root := server.AddDirectory("root", "")
+	docs := server.AddDirectory("docs", root)
+	fileID := server.AddFile(root, "a.txt", []byte("hello"))
+
+	client := NewClient(server.URL, "id", "secret", root, 5*time.Second, 0, zap.NewNop())
+	ctx := context.Background()
+
+	if err := client.RenameFile(ctx, fileID, "b.txt"); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	if err := client.MoveFile(ctx, fileID, docs); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if f, _ := server.File(fileID); f.Name != "b.txt" || f.DirectoryID != docs {
+		t.Errorf("unexpected file after rename and move: %+v", f)
+	}
+
+	if err := client.MoveFile(ctx, fileID, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound moving to a missing directory, got %v", err)
+	}
+
+	contents, err := client.GetDirectory(ctx, root)
+	if err != nil {
+		t.Fatalf("failed to get directory: %v", err)
+	}
+	if len(contents.Files) != 0 || len(contents.Subdirectories) != 1 || contents.Subdirectories[0].ID != docs {
+		t.Errorf("unexpected contents: %+v", contents)
+	}
+
+	if err := client.DeleteDirectory(ctx, docs, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict deleting a directory that is not empty, got %v", err)
+	}
+	if err := client.DeleteFile(ctx, fileID); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := client.DeleteFile(ctx, fileID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+	if err := client.DeleteDirectory(ctx, docs, false); err != nil {
+		t.Errorf("failed to delete empty directory: %v", err)
+	}
+}
+
+func TestClientDeleteDirectoryRecursive(t *testing.T) {
+	server := apitest.NewServer(t)
+	root := server.AddDirectory("root", "")
+	sub := server.AddDirectory("sub", root)
+	fileID := server.AddFile(sub, "a.txt", []byte("hello"))
+
+	client := NewClient(server.URL, "id", "secret", root, 5*time.Second, 0, zap.NewNop())
+	if err := client.DeleteDirectory(context.Background(), root, true); err != nil {
+		t.Fatalf("recursive delete failed: %v", err)
+	}
+	if _, ok := server.Directory(sub); ok {
+		t.Error("subdirectory still exists")
+	}
+	if _, ok := server.File(fileID); ok {
+		t.Error("file still exists")
+	}
+}
+
+func TestClientListFilesPaginates(t *testing.T) {
+	server := apitest.NewServer(t)
+	root := server.AddDirectory("root", "")
+	for i := 0; i < 2*DefaultPageSize+5; i++ {
+		server.AddFile(root, fmt.Sprintf("f%d.txt", i), []byte{byte(i)})
+	}
+
+	client := NewClient(server.URL, "id", "secret", root, 5*time.Second, 0, zap.NewNop())
+	ctx := context.Background()
+
+	list, err := client.ListFiles(ctx, root, 3, DefaultPageSize)
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(list.Files) != 5 || list.HasMore() || list.Files[0].Name != fmt.Sprintf("f%d.txt", 2*DefaultPageSize) {
+		t.Errorf("unexpected last page: %d files, more %v", len(list.Files), list.HasMore())
+	}
+
+	files, err := client.ListAllFiles(ctx, root)
+	if err != nil {
+		t.Fatalf("failed to list all files: %v", err)
+	}
+	if len(files) != 2*DefaultPageSize+5 {
+		t.Fatalf("expected %d files, got %d", 2*DefaultPageSize+5, len(files))
+	}
+	for i, f := range files {
+		if f.Name != fmt.Sprintf("f%d.txt", i) || f.CreatedAt.IsZero() {
+			t.Fatalf("unexpected file %d: %+v", i, f)
+		}
+	}
+
+	if _, err := client.ListFiles(ctx, "missing", 1, 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing directory, got %v", err)
+	}
+}
@@ -0,0 +1,698 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+	"go.uber.org/zap"
+)
+
+// CatalogDirectory is the remote directory, below the client's directory,
+// that holds the uploaded catalogs. It is not part of the backed up files.
+const CatalogDirectory = ".koneksi-catalog"
+
+const (
+	catalogFormat     = 2
+	catalogPrefix     = "catalog-"
+	catalogSuffix     = ".json.gz.enc"
+	catalogTimeLayout = "20060102T150405.000Z"
+)
+
+// ErrNoCatalog is returned when no catalog was uploaded, or none that the
+// given password decrypts.
+var ErrNoCatalog = errors.New("no catalog found")
+
+// Catalog is the uploaded copy of the backup database. Files are uploaded
+// whole, so the records and snapshot files list every stored object.
+type Catalog struct {
+	Format    int               `json:"format"`
+	CreatedAt time.Time         `json:"created_at"`
+	Records   []CatalogRecord   `json:"records"`
+	Snapshots []CatalogSnapshot `json:"snapshots,omitempty"`
+	// Directories are the mirrored remote directories
+	Directories []CatalogRemoteDirectory `json:"directories,omitempty"`
+	// LocalDirectories are the backed up directories
+	LocalDirectories []CatalogLocalDirectory `json:"local_directories,omitempty"`
+	// KeySalt is the salt of the installation's key IDs
+	KeySalt []byte `json:"key_salt,omitempty"`
+}
+
+// CatalogRecord is a file version in the catalog.
+type CatalogRecord struct {
+	FilePath       string            `json:"file_path"`
+	FileID         string            `json:"file_id"`
+	Checksum       string            `json:"checksum"`
+	OriginalSize   int64             `json:"original_size"`
+	CompressedSize int64             `json:"compressed_size"`
+	Compressed     bool              `json:"compressed"`
+	Mode           os.FileMode       `json:"mode,omitempty"`
+	ModTime        time.Time         `json:"mod_time,omitzero"`
+	Owner          *metadata.Owner   `json:"owner,omitempty"`
+	LinkTarget     string            `json:"link_target,omitempty"`
+	Xattrs         map[string][]byte `json:"xattrs,omitempty"`
+	Encrypted      *bool             `json:"encrypted,omitempty"`
+	KeyID          string            `json:"key_id,omitempty"`
+	SnapshotID     int64             `json:"snapshot_id,omitempty"`
+	BackupTime     time.Time         `json:"backup_time"`
+	Operation      string            `json:"operation,omitempty"`
+	Copies         []CatalogCopy     `json:"copies,omitempty"`
+}
+
+// CatalogLocalDirectory is the metadata of a backed up directory.
+type CatalogLocalDirectory struct {
+	Path    string            `json:"path"`
+	Mode    os.FileMode       `json:"mode"`
+	ModTime time.Time         `json:"mod_time,omitzero"`
+	Owner   *metadata.Owner   `json:"owner,omitempty"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// CatalogCopy is the copy of a file version on a replication target.
+type CatalogCopy struct {
+	Target    string    `json:"target"`
+	FileID    string    `json:"file_id,omitempty"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CatalogSnapshot is a job snapshot in the catalog.
+type CatalogSnapshot struct {
+	ID         int64                 `json:"id"`
+	Job        string                `json:"job"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Status     string                `json:"status"`
+	FileCount  int                   `json:"file_count"`
+	TotalSize  int64                 `json:"total_size"`
+	Error      string                `json:"error,omitempty"`
+	Files      []CatalogSnapshotFile `json:"files"`
+}
+
+// CatalogSnapshotFile is a file of a snapshot.
+type CatalogSnapshotFile struct {
+	FilePath  string `json:"file_path"`
+	FileID    string `json:"file_id"`
+	Checksum  string `json:"checksum"`
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+	KeyID     string `json:"key_id,omitempty"`
+}
+
+// CatalogRemoteDirectory is a mirrored remote directory in the catalog.
+type CatalogRemoteDirectory struct {
+	BaseID      string `json:"base_id"`
+	Path        string `json:"path"`
+	DirectoryID string `json:"directory_id"`
+}
+
+func (r CatalogRecord) backupRecord() database.BackupRecord {
+	return database.BackupRecord{
+		FilePath:       r.FilePath,
+		FileID:         r.FileID,
+		Checksum:       r.Checksum,
+		OriginalSize:   r.OriginalSize,
+		CompressedSize: r.CompressedSize,
+		IsCompressed:   r.Compressed,
+		Mode:           r.Mode,
+		ModTime:        r.ModTime,
+		Owner:          r.Owner,
+		LinkTarget:     r.LinkTarget,
+		Xattrs:         r.Xattrs,
+		Encrypted:      r.Encrypted,
+		KeyID:          r.KeyID,
+		SnapshotID:     r.SnapshotID,
+		BackupTime:     r.BackupTime,
+		Status:         "success",
+		Operation:      r.Operation,
+	}
+}
+
+// ExportCatalog returns the catalog of db.
+func ExportCatalog(db *database.DB) (*Catalog, error) {
+	exported, err := db.ExportCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{Format: catalogFormat, CreatedAt: time.Now().UTC(), KeySalt: exported.KeySalt}
+	for _, r := range exported.Records {
+		record := CatalogRecord{
+			FilePath:       r.FilePath,
+			FileID:         r.FileID,
+			Checksum:       r.Checksum,
+			OriginalSize:   r.OriginalSize,
+			CompressedSize: r.CompressedSize,
+			Compressed:     r.IsCompressed,
+			Mode:           r.Mode,
+			ModTime:        r.ModTime,
+			Owner:          r.Owner,
+			LinkTarget:     r.LinkTarget,
+			Xattrs:         r.Xattrs,
+			Encrypted:      r.Encrypted,
+			KeyID:          r.KeyID,
+			SnapshotID:     r.SnapshotID,
+			BackupTime:     r.BackupTime,
+			Operation:      r.Operation,
+		}
+		for _, c := range r.Copies {
+			record.Copies = append(record.Copies, CatalogCopy{Target: c.Target, FileID: c.FileID, Status: c.Status, UpdatedAt: c.UpdatedAt})
+		}
+		catalog.Records = append(catalog.Records, record)
+	}
+	for _, s := range exported.Snapshots {
+		snapshot := CatalogSnapshot{
+			ID:         s.ID,
+			Job:        s.Job,
+			StartedAt:  s.StartedAt,
+			FinishedAt: s.FinishedAt,
+			Status:     s.Status,
+			FileCount:  s.Files,
+			TotalSize:  s.TotalSize,
+			Error:      s.Error,
+			Files:      []CatalogSnapshotFile{},
+		}
+		for _, f := range s.SnapshotFiles {
+			snapshot.Files = append(snapshot.Files, CatalogSnapshotFile{FilePath: f.FilePath, FileID: f.FileID, Checksum: f.Checksum, Size: f.Size, Encrypted: f.Encrypted, KeyID: f.KeyID})
+		}
+		catalog.Snapshots = append(catalog.Snapshots, snapshot)
+	}
+	for _, d := range exported.RemoteDirectories {
+		catalog.Directories = append(catalog.Directories, CatalogRemoteDirectory{BaseID: d.BaseID, Path: d.Path, DirectoryID: d.DirectoryID})
+	}
+	for _, d := range exported.Directories {
+		catalog.LocalDirectories = append(catalog.LocalDirectories, CatalogLocalDirectory{Path: d.Path, Mode: d.Mode, ModTime: d.ModTime, Owner: d.Owner, Xattrs: d.Xattrs})
+	}
+	return catalog, nil
+}
+
+// databaseCatalog converts a catalog for database.ImportCatalog.
+func (c *Catalog) databaseCatalog() *database.Catalog {
+	imported := &database.Catalog{KeySalt: c.KeySalt}
+	for _, r := range c.Records {
+		record := database.CatalogRecord{BackupRecord: r.backupRecord()}
+		for _, cp := range r.Copies {
+			record.Copies = append(record.Copies, database.TargetCopy{Target: cp.Target, FileID: cp.FileID, Status: cp.Status, UpdatedAt: cp.UpdatedAt})
+		}
+		imported.Records = append(imported.Records, record)
+	}
+	for _, s := range c.Snapshots {
+		snapshot := database.CatalogSnapshot{Snapshot: database.Snapshot{
+			ID:         s.ID,
+			Job:        s.Job,
+			StartedAt:  s.StartedAt,
+			FinishedAt: s.FinishedAt,
+			Status:     s.Status,
+			Files:      s.FileCount,
+			TotalSize:  s.TotalSize,
+			Error:      s.Error,
+		}}
+		for _, f := range s.Files {
+			snapshot.SnapshotFiles = append(snapshot.SnapshotFiles, database.SnapshotFile{FilePath: f.FilePath, FileID: f.FileID, Checksum: f.Checksum, Size: f.Size, Encrypted: f.Encrypted, KeyID: f.KeyID})
+		}
+		imported.Snapshots = append(imported.Snapshots, snapshot)
+	}
+	for _, d := range c.Directories {
+		imported.RemoteDirectories = append(imported.RemoteDirectories, database.RemoteDirectory{BaseID: d.BaseID, Path: d.Path, DirectoryID: d.DirectoryID})
+	}
+	for _, d := range c.LocalDirectories {
+		imported.Directories = append(imported.Directories, database.Directory{Path: d.Path, Mode: d.Mode, ModTime: d.ModTime, Owner: d.Owner, Xattrs: d.Xattrs})
+	}
+	return imported
+}
+
+// WriteCatalog writes a catalog as gzip-compressed JSON encrypted with
+// password.
+func WriteCatalog(w io.Writer, catalog *Catalog, password string) error {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if err := json.NewEncoder(gz).Encode(catalog); err != nil {
+		gz.Close()
+		return fmt.Errorf("failed to encode catalog: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress catalog: %w", err)
+	}
+
+	if err := encryption.NewEncryptor(password).Encrypt(w, &compressed); err != nil {
+		return fmt.Errorf("failed to encrypt catalog: %w", err)
+	}
+	return nil
+}
+
+// ReadCatalog reads a catalog written by WriteCatalog. A wrong password
+// fails with encryption.ErrDecrypt.
+func ReadCatalog(r io.Reader, password string) (*Catalog, error) {
+	var compressed bytes.Buffer
+	if err := encryption.NewEncryptor(password).Decrypt(&compressed, r); err != nil {
+		return nil, fmt.Errorf("failed to decrypt catalog: %w", err)
+	}
+
+	gz, err := gzip.NewReader(&compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress catalog: %w", err)
+	}
+	defer gz.Close()
+
+	var catalog Catalog
+	if err := json.NewDecoder(gz).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("failed to decode catalog: %w", err)
+	}
+	if catalog.Format != catalogFormat {
+		return nil, fmt.Errorf("unsupported catalog format %d", catalog.Format)
+	}
+	return &catalog, nil
+}
+
+// catalogObject is an uploaded catalog. Its name holds the upload time,
+// the key salt of the installation and the key ID of the password it is
+// encrypted with, so that the newest catalog a password decrypts is found
+// without downloading the others. Catalogs named before key salts have no
+// salt and an ID that is not checked.
+type catalogObject struct {
+	api.FileInfo
+	CreatedAt time.Time
+	Salt      []byte
+	KeyID     string
+}
+
+func catalogObjectName(createdAt time.Time, salt []byte, keyID string) string {
+	return catalogPrefix + createdAt.UTC().Format(catalogTimeLayout) + "-" + hex.EncodeToString(salt) + "-" + keyID + catalogSuffix
+}
+
+// parseCatalogObject reports whether f is an uploaded catalog.
+func parseCatalogObject(f api.FileInfo) (catalogObject, bool) {
+	if !strings.HasPrefix(f.Name, catalogPrefix) || !strings.HasSuffix(f.Name, catalogSuffix) {
+		return catalogObject{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(f.Name, catalogPrefix), catalogSuffix), "-")
+	if len(parts) != 2 && len(parts) != 3 {
+		return catalogObject{}, false
+	}
+	createdAt, err := time.Parse(catalogTimeLayout, parts[0])
+	if err != nil {
+		return catalogObject{}, false
+	}
+
+	object := catalogObject{FileInfo: f, CreatedAt: createdAt, KeyID: parts[len(parts)-1]}
+	if len(parts) == 3 {
+		if object.Salt, err = hex.DecodeString(parts[1]); err != nil || len(object.Salt) == 0 {
+			return catalogObject{}, false
+		}
+	}
+	return object, true
+}
+
+// listCatalogObjects returns the uploaded catalogs, newest first.
+func listCatalogObjects(ctx context.Context, client *api.Client, directoryID string) ([]catalogObject, error) {
+	files, err := client.ListAllFiles(ctx, directoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalog directory: %w", err)
+	}
+
+	var objects []catalogObject
+	for _, f := range files {
+		if object, ok := parseCatalogObject(f); ok {
+			objects = append(objects, object)
+		}
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		if !objects[i].CreatedAt.Equal(objects[j].CreatedAt) {
+			return objects[i].CreatedAt.After(objects[j].CreatedAt)
+		}
+		return objects[i].FileInfo.CreatedAt.After(objects[j].FileInfo.CreatedAt)
+	})
+	return objects, nil
+}
+
+// catalogDirectoryID returns the ID of the catalog directory below the
+// client's directory. Without create, a missing directory is returned as
+// an empty ID.
+func catalogDirectoryID(ctx context.Context, client *api.Client, create bool) (string, error) {
+	contents, err := client.GetDirectory(ctx, client.DirectoryID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list directory: %w", err)
+	}
+	for _, dir := range contents.Subdirectories {
+		if dir.Name == CatalogDirectory {
+			return dir.ID, nil
+		}
+	}
+	if !create {
+		return "", nil
+	}
+
+	resp, err := client.CreateSubdirectory(ctx, client.DirectoryID, CatalogDirectory, "Koneksi backup catalog")
+	if err != nil {
+		return "", fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	return resp.DirectoryID, nil
+}
+
+// PushResult describes an uploaded catalog.
+type PushResult struct {
+	Name      string
+	Records   int
+	Snapshots int
+	// Deleted is the number of old catalogs removed beyond the kept
+	// versions
+	Deleted int
+}
+
+// PushCatalog uploads the catalog of db, encrypted with password, into
+// the catalog directory. Of the uploaded catalogs the newest versions are
+// kept, or all of them when versions is 0.
+func PushCatalog(ctx context.Context, client *api.Client, db *database.DB, password string, versions int) (*PushResult, error) {
+	if password == "" {
+		return nil, fmt.Errorf("a password is required to encrypt the catalog")
+	}
+
+	salt, err := db.KeySalt()
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := ExportCatalog(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCatalog(&buf, catalog, password); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+
+	directoryID, err := catalogDirectoryID(ctx, client, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PushResult{
+		Name:      catalogObjectName(catalog.CreatedAt, salt, encryption.KeyID(password, salt)),
+		Records:   len(catalog.Records),
+		Snapshots: len(catalog.Snapshots),
+	}
+	size := int64(buf.Len())
+	if _, err := client.UploadFileTo(ctx, directoryID, result.Name, &buf, size, hex.EncodeToString(sum[:])); err != nil {
+		return nil, fmt.Errorf("failed to upload catalog: %w", err)
+	}
+
+	if versions <= 0 {
+		return result, nil
+	}
+	objects, err := listCatalogObjects(ctx, client, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	for i := versions; i < len(objects); i++ {
+		if err := client.DeleteFile(ctx, objects[i].ID); err != nil && !errors.Is(err, api.ErrNotFound) {
+			return nil, fmt.Errorf("failed to delete old catalog %s: %w", objects[i].Name, err)
+		}
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// LastCatalogUpload returns when the newest catalog was uploaded, or the
+// zero time when none was.
+func LastCatalogUpload(ctx context.Context, client *api.Client) (time.Time, error) {
+	directoryID, err := catalogDirectoryID(ctx, client, false)
+	if err != nil || directoryID == "" {
+		return time.Time{}, err
+	}
+	objects, err := listCatalogObjects(ctx, client, directoryID)
+	if err != nil || len(objects) == 0 {
+		return time.Time{}, err
+	}
+	return objects[0].CreatedAt, nil
+}
+
+// pullCatalog downloads the newest catalog encrypted with password. It
+// returns ErrNoCatalog when there is none.
+func pullCatalog(ctx context.Context, client *api.Client, password string) (*Catalog, error) {
+	directoryID, err := catalogDirectoryID(ctx, client, false)
+	if err != nil {
+		return nil, err
+	}
+	if directoryID == "" {
+		return nil, ErrNoCatalog
+	}
+	objects, err := listCatalogObjects(ctx, client, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrNoCatalog
+	}
+
+	// Key IDs are derived once per installation salt
+	keyIDs := make(map[string]string)
+	for _, object := range objects {
+		if object.Salt != nil {
+			salt := string(object.Salt)
+			if _, ok := keyIDs[salt]; !ok {
+				keyIDs[salt] = encryption.KeyID(password, object.Salt)
+			}
+			if object.KeyID != keyIDs[salt] {
+				continue
+			}
+		}
+
+		catalog, err := downloadCatalog(ctx, client, object, password)
+		if object.Salt == nil && errors.Is(err, encryption.ErrDecrypt) {
+			continue
+		}
+		return catalog, err
+	}
+	return nil, fmt.Errorf("%w: the %d uploaded catalogs are encrypted with another password", ErrNoCatalog, len(objects))
+}
+
+func downloadCatalog(ctx context.Context, client *api.Client, object catalogObject, password string) (*Catalog, error) {
+	body, err := client.DownloadFile(ctx, object.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download catalog: %w", err)
+	}
+	defer body.Close()
+	return ReadCatalog(body, password)
+}
+
+// RestoreCatalog fills an empty database with the newest catalog encrypted
+// with password, as the first step on a new machine.
+func RestoreCatalog(ctx context.Context, client *api.Client, db *database.DB, password string) (*Catalog, error) {
+	catalog, err := pullCatalog(ctx, client, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ImportCatalog(catalog.databaseCatalog()); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// RebuildResult describes a catalog rebuild.
+type RebuildResult struct {
+	// Catalog is the uploaded catalog used, if one was found
+	Catalog *Catalog
+	// Files is the number of remote files found
+	Files int
+	// Known counts the remote files the database already refers to
+	Known int
+	// Records are the recovered backup records; those from the catalog
+	// count FromCatalog, the others were derived from the listing
+	Records     []database.BackupRecord
+	FromCatalog int
+	// Inserted is the number of records added to the database
+	Inserted int
+}
+
+// RebuildCatalog recovers the backup records of the files stored below
+// the client's directory. A record comes from the newest catalog password
+// decrypts when it lists the file; otherwise its path is derived from the
+// mirrored remote directories, and its checksum and sizes from the stored
+// file. Without a password no catalog is used. Mirrored directories are
+// cached again for backup.remote_layout mirror. A dry run changes nothing.
+func RebuildCatalog(ctx context.Context, client *api.Client, db *database.DB, password string, dryRun bool) (*RebuildResult, error) {
+	var (
+		catalog *Catalog
+		err     error
+	)
+	if password != "" {
+		catalog, err = pullCatalog(ctx, client, password)
+		if err != nil && !errors.Is(err, ErrNoCatalog) {
+			return nil, err
+		}
+	}
+
+	result := &RebuildResult{Catalog: catalog}
+	byFileID := make(map[string]CatalogRecord)
+	if catalog != nil {
+		for _, r := range catalog.Records {
+			byFileID[r.FileID] = r
+		}
+	}
+
+	baseID := client.DirectoryID
+	err = walkRemoteTree(ctx, client, baseID, nil, func(parts []string, directoryID string, files []api.FileInfo) error {
+		if len(parts) > 0 && !dryRun {
+			if err := db.SetRemoteDirectory(baseID, path.Join(parts...), directoryID); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range files {
+			result.Files++
+			known, err := db.FileIDReferenced(config.PrimaryTarget, f.ID)
+			if err != nil {
+				return err
+			}
+			if known {
+				result.Known++
+				continue
+			}
+
+			if r, ok := byFileID[f.ID]; ok {
+				result.Records = append(result.Records, r.backupRecord())
+				result.FromCatalog++
+				continue
+			}
+			result.Records = append(result.Records, listedRecord(parts, f))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun || len(result.Records) == 0 {
+		return result, nil
+	}
+	if result.Inserted, err = db.ImportBackupRecords(result.Records); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rebuildOperation is the operation of records derived from a remote
+// listing. Their checksum is that of the uploaded, possibly compressed or
+// encrypted, data rather than of the content.
+const rebuildOperation = "rebuild"
+
+// rebuilt reports whether r was derived from a remote listing.
+func rebuilt(r database.BackupRecord) bool {
+	return r.Operation == rebuildOperation
+}
+
+// listedRecord derives a backup record from a remote file in the
+// directories named parts. Encrypted files lose their .enc suffix, which
+// records them as encrypted.
+func listedRecord(parts []string, f api.FileInfo) database.BackupRecord {
+	name := f.Name
+	encrypted := strings.HasSuffix(name, ".enc")
+	if encrypted {
+		name = encryption.GetDecryptedFileName(name)
+	}
+	filePath := name
+	if dir := localPath(parts); dir != "" {
+		filePath = filepath.Join(dir, name)
+	}
+
+	backupTime := f.CreatedAt
+	if backupTime.IsZero() {
+		backupTime = time.Now()
+	}
+	return database.BackupRecord{
+		FilePath:       filePath,
+		FileID:         f.ID,
+		Checksum:       f.Hash,
+		OriginalSize:   f.Size,
+		CompressedSize: f.Size,
+		Encrypted:      &encrypted,
+		BackupTime:     backupTime,
+		Status:         "success",
+		Operation:      rebuildOperation,
+	}
+}
+
+// adoptRebuilt returns the rebuilt version r as a record of the content
+// with checksum when its upload holds that content, encrypted as the policy
+// asks, so the file is not uploaded again. It returns nil otherwise.
+func (s *Service) adoptRebuilt(ctx context.Context, r database.BackupRecord, checksum, keyID string, policy Policy) *database.BackupRecord {
+	if r.Encrypted == nil || *r.Encrypted != (keyID != "") {
+		return nil
+	}
+	reader, err := s.client.DownloadFile(ctx, r.FileID)
+	if err != nil {
+		s.logger.Warn("failed to download rebuilt version", zap.String("path", r.FilePath), zap.Error(err))
+		return nil
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		s.logger.Warn("failed to download rebuilt version", zap.String("path", r.FilePath), zap.Error(err))
+		return nil
+	}
+
+	content, compressed, err := decodeRebuilt(data, r, []string{policy.Password})
+	if err != nil || contentChecksum(content) != checksum {
+		return nil
+	}
+	adopted := r
+	adopted.Checksum = checksum
+	adopted.OriginalSize = int64(len(content))
+	adopted.CompressedSize = int64(len(data))
+	adopted.IsCompressed = compressed
+	setRecordEncryption(&adopted, keyID)
+	return &adopted
+}
+
+// walkRemoteTree calls fn with the files of a remote directory and then
+// walks its subdirectories, skipping the catalog directory. parts are the
+// names leading from the client's directory to directoryID.
+func walkRemoteTree(ctx context.Context, client *api.Client, directoryID string, parts []string, fn func(parts []string, directoryID string, files []api.FileInfo) error) error {
+	files, err := client.ListAllFiles(ctx, directoryID)
+	if err != nil {
+		return fmt.Errorf("failed to list files of %s: %w", remoteName(parts), err)
+	}
+	if err := fn(parts, directoryID, files); err != nil {
+		return err
+	}
+
+	contents, err := client.GetDirectory(ctx, directoryID)
+	if err != nil {
+		return fmt.Errorf("failed to list directories of %s: %w", remoteName(parts), err)
+	}
+	for _, dir := range contents.Subdirectories {
+		if len(parts) == 0 && dir.Name == CatalogDirectory {
+			continue
+		}
+		sub := append(parts[:len(parts):len(parts)], dir.Name)
+		if err := walkRemoteTree(ctx, client, dir.ID, sub, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remoteName(parts []string) string {
+	if len(parts) == 0 {
+		return "the backup directory"
+	}
+	return path.Join(parts...)
+}
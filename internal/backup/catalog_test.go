@@ -0,0 +1,304 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"go.uber.org/zap"
+)
+
+func TestRebuildCatalog(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	ctx := context.Background()
+
+	// Back up two files into mirrored directories
+	db, err := database.New(filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+	cfg.Backup.RemoteLayout = config.LayoutMirror
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	docs := filepath.Join(t.TempDir(), "docs")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file := filepath.Join(docs, name)
+		writeTestFile(t, file, "content of "+name)
+		if err := service.processBackup(ctx, BackupTask{FilePath: file, Operation: "create", Size: 13}); err != nil {
+			t.Fatalf("backup failed: %v", err)
+		}
+	}
+
+	if pushed, err := PushCatalog(ctx, client, db, "secret", 1); err != nil || pushed.Records != 2 {
+		t.Fatalf("failed to push catalog: %+v, %v", pushed, err)
+	}
+
+	// A file uploaded after the catalog only shows up in the listing
+	parts, _ := remotePath(docs)
+	docsID, _ := db.GetRemoteDirectory(base, path.Join(parts...))
+	lateID := server.AddFile(docsID, "c.txt.enc", []byte("encrypted"))
+
+	fresh, err := database.New(filepath.Join(t.TempDir(), "new.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer fresh.Close()
+
+	result, err := RebuildCatalog(ctx, client, fresh, "secret", true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if result.Catalog == nil || result.Files != 3 || len(result.Records) != 3 || result.FromCatalog != 2 {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if history, _ := fresh.GetBackupHistory(filepath.Join(docs, "a.txt"), 1); len(history) != 0 {
+		t.Error("dry run added records")
+	}
+
+	result, err = RebuildCatalog(ctx, client, fresh, "secret", false)
+	if err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if result.Inserted != 3 {
+		t.Errorf("expected 3 records added, got %d", result.Inserted)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file := filepath.Join(docs, name)
+		want, _ := db.GetBackupHistory(file, 1)
+		got, _ := fresh.GetBackupHistory(file, 1)
+		if len(want) != 1 || len(got) != 1 || got[0].FileID != want[0].FileID || got[0].Checksum != want[0].Checksum {
+			t.Errorf("%s: rebuilt %+v, want %+v", name, got, want)
+		}
+		if state, _ := fresh.GetFileState(file); state == nil || state.LastChecksum != want[0].Checksum {
+			t.Errorf("%s: unexpected file state %+v", name, state)
+		}
+	}
+	late, _ := fresh.GetBackupHistory(filepath.Join(docs, "c.txt"), 1)
+	if len(late) != 1 || late[0].FileID != lateID {
+		t.Errorf("unexpected record for the file missing from the catalog: %+v", late)
+	}
+	if id, _ := fresh.GetRemoteDirectory(base, path.Join(parts...)); id != docsID {
+		t.Errorf("mirrored directory cached as %q, want %q", id, docsID)
+	}
+
+	// Rebuilding again finds nothing new
+	result, err = RebuildCatalog(ctx, client, fresh, "secret", false)
+	if err != nil {
+		t.Fatalf("second rebuild failed: %v", err)
+	}
+	if result.Known != 3 || len(result.Records) != 0 {
+		t.Errorf("unexpected second rebuild: %+v", result)
+	}
+}
+
+func TestRebuildRestoreEncrypted(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	ctx := context.Background()
+
+	// A compressed and encrypted file, lost with its database
+	private := filepath.Join(t.TempDir(), "private")
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+	cfg.Backup.RemoteLayout = config.LayoutMirror
+	cfg.Backup.Directories = []config.Directory{{
+		Path:        private,
+		Compression: &config.Compression{Enabled: true, Format: "gzip"},
+		Encryption:  &config.Encryption{Enabled: true, Password: "pw"},
+	}}
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	lost, err := database.New(filepath.Join(t.TempDir(), "lost.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer lost.Close()
+	service, err := NewService(client, logger, reporter, cfg, lost)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	file := filepath.Join(private, "key.txt")
+	content := "the secret key"
+	writeTestFile(t, file, content)
+	task := BackupTask{FilePath: file, Operation: "create", Size: int64(len(content))}
+	if err := service.processBackup(ctx, task); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	db, err := database.New(filepath.Join(t.TempDir(), "new.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	if result, err := RebuildCatalog(ctx, client, db, "", false); err != nil || result.Inserted != 1 {
+		t.Fatalf("rebuild failed: %+v, %v", result, err)
+	}
+	rebuiltRecord, _ := db.LatestBackupRecord(file)
+	if rebuiltRecord == nil || rebuiltRecord.Encrypted == nil || !*rebuiltRecord.Encrypted {
+		t.Fatalf("rebuilt record not marked encrypted: %+v", rebuiltRecord)
+	}
+
+	// Restored decrypted and decompressed, and only with the password
+	out := t.TempDir()
+	restore := NewRestoreService(client, logger, 1)
+	if _, err := restore.RestorePaths(ctx, db, file, PathRestoreOptions{To: out, Passwords: []string{"other", "pw"}}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "key.txt")); err != nil || string(data) != content {
+		t.Errorf("restored %q, %v, want %q", data, err, content)
+	}
+	restore = NewRestoreService(client, logger, 1)
+	if _, err := restore.RestorePaths(ctx, db, file, PathRestoreOptions{To: t.TempDir()}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if progress := restore.GetProgress(); progress.FailedFiles != 1 {
+		t.Errorf("expected the restore without the password to fail, got %+v", progress)
+	}
+
+	// Backing up the unchanged file adopts the rebuilt upload
+	service, err = NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	if err := service.processBackup(ctx, task); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	parts, _ := remotePath(private)
+	privateID, _ := db.GetRemoteDirectory(base, path.Join(parts...))
+	if uploaded := len(server.Files(privateID)); uploaded != 1 {
+		t.Errorf("expected the rebuilt upload to be reused, got %d uploads", uploaded)
+	}
+	latest, _ := db.LatestBackupRecord(file)
+	if latest == nil || latest.FileID != rebuiltRecord.FileID || latest.Checksum != contentChecksum([]byte(content)) ||
+		!latest.IsCompressed || latest.KeyID == "" {
+		t.Errorf("unexpected adopted version: %+v", latest)
+	}
+}
+
+func TestPushRestoreCatalog(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, zap.NewNop())
+	ctx := context.Background()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	recordID, _ := db.InsertBackupRecord(database.BackupRecord{FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1", BackupTime: now, Status: "success"})
+	db.SetTargetCopy(database.TargetCopy{RecordID: recordID, Target: "offsite", FileID: "r1", Status: database.CopySuccess})
+	snapshotID, _ := db.CreateSnapshot("daily", now)
+	db.AddSnapshotFile(database.SnapshotFile{SnapshotID: snapshotID, FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1"})
+	db.FinishSnapshot(snapshotID, database.SnapshotSuccess, "")
+
+	if last, err := LastCatalogUpload(ctx, client); err != nil || !last.IsZero() {
+		t.Errorf("expected no catalog upload yet, got %v, %v", last, err)
+	}
+	if _, err := PushCatalog(ctx, client, db, "", 0); err == nil {
+		t.Error("expected an error pushing without a password")
+	}
+
+	// Only the newest two catalogs are kept
+	for i := 0; i < 3; i++ {
+		if _, err := PushCatalog(ctx, client, db, "password", 2); err != nil {
+			t.Fatalf("push %d failed: %v", i, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	catalogID, _ := catalogDirectoryID(ctx, client, false)
+	objects, err := listCatalogObjects(ctx, client, catalogID)
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("expected 2 catalogs, got %d, %v", len(objects), err)
+	}
+	if last, err := LastCatalogUpload(ctx, client); err != nil || !last.Equal(objects[0].CreatedAt) {
+		t.Errorf("expected the last upload at %v, got %v, %v", objects[0].CreatedAt, last, err)
+	}
+	salt, _ := db.KeySalt()
+	if len(salt) == 0 || !bytes.Equal(objects[0].Salt, salt) || objects[0].KeyID != encryption.KeyID("password", salt) {
+		t.Errorf("catalog named with salt %x and key ID %q", objects[0].Salt, objects[0].KeyID)
+	}
+	stored, _ := server.File(objects[0].ID)
+	if strings.Contains(string(stored.Data), "/data/a.txt") {
+		t.Error("catalog uploaded unencrypted")
+	}
+
+	fresh, err := database.New(filepath.Join(t.TempDir(), "new.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer fresh.Close()
+
+	if _, err := RestoreCatalog(ctx, client, fresh, "wrong"); !errors.Is(err, ErrNoCatalog) {
+		t.Errorf("expected ErrNoCatalog with a wrong password, got %v", err)
+	}
+
+	catalog, err := RestoreCatalog(ctx, client, fresh, "password")
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if len(catalog.Records) != 1 || len(catalog.Snapshots) != 1 {
+		t.Errorf("unexpected catalog: %+v", catalog)
+	}
+	record, _ := fresh.GetBackupRecord("/data/a.txt", "c1")
+	if record == nil {
+		t.Fatal("record not restored")
+	}
+	if copies, _ := fresh.ListTargetCopies(record.ID); len(copies) != 1 || copies[0].FileID != "r1" {
+		t.Errorf("unexpected copies: %+v", copies)
+	}
+	if snapshots, _ := fresh.ListSnapshots("daily"); len(snapshots) != 1 || snapshots[0].Files != 1 {
+		t.Errorf("unexpected snapshots: %+v", snapshots)
+	}
+
+	if restored, _ := fresh.KeySalt(); !bytes.Equal(restored, salt) {
+		t.Errorf("key salt not restored: %x, want %x", restored, salt)
+	}
+
+	if _, err := RestoreCatalog(ctx, client, fresh, "password"); !errors.Is(err, database.ErrNotEmpty) {
+		t.Errorf("expected ErrNotEmpty restoring twice, got %v", err)
+	}
+
+	// Catalogs named before key salts are found by decrypting them
+	var legacy bytes.Buffer
+	if err := WriteCatalog(&legacy, &Catalog{Format: catalogFormat, CreatedAt: now}, "old-password"); err != nil {
+		t.Fatalf("failed to write catalog: %v", err)
+	}
+	server.AddFile(catalogID, catalogPrefix+now.Format(catalogTimeLayout)+"-0123456789abcdef"+catalogSuffix, legacy.Bytes())
+	if _, err := pullCatalog(ctx, client, "old-password"); err != nil {
+		t.Errorf("legacy catalog not found: %v", err)
+	}
+	if _, err := pullCatalog(ctx, client, "wrong"); !errors.Is(err, ErrNoCatalog) {
+		t.Errorf("expected ErrNoCatalog with a wrong password, got %v", err)
+	}
+}
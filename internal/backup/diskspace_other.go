@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package backup
+
+import "errors"
+
+func diskSpace(dir string) (int64, string, error) {
+	return 0, "", errors.New("free space not supported on this platform")
+}
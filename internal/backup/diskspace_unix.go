@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package backup
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskSpace returns the space available to the user on the filesystem of
+// dir, and an ID of the filesystem.
+func diskSpace(dir string) (int64, string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, "", err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), fmt.Sprint(st.Fsid), nil
+}
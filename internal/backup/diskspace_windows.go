@@ -0,0 +1,23 @@
+//go:build windows
+
+package backup
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace returns the space available to the user on the volume of dir,
+// and the name of the volume.
+func diskSpace(dir string) (int64, string, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, &total, &totalFree); err != nil {
+		return 0, "", err
+	}
+	return int64(free), filepath.VolumeName(dir), nil
+}
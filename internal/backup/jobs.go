@@ -0,0 +1,577 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/cron"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/archive"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+	"go.uber.org/zap"
+)
+
+// Job is a scheduled backup job resolved from backup.jobs.
+type Job struct {
+	Name     string
+	Schedule *cron.Schedule
+	// Paths are absolute
+	Paths    []string
+	Mode     string
+	Excludes []string
+	// Keep selects the successful and partial snapshots that are kept;
+	// any snapshot younger than KeepDays is kept as well
+	Keep     KeepRules
+	KeepDays int
+	// Password encrypts uploads when set
+	Password string
+}
+
+// JobsFromConfig resolves backup.jobs. Exclude patterns are the global
+// ones followed by the job's own.
+func JobsFromConfig(cfg *config.Config) ([]Job, error) {
+	jobs := make([]Job, 0, len(cfg.Backup.Jobs))
+	for _, j := range cfg.Backup.Jobs {
+		schedule, err := cron.Parse(j.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("backup job %q: %w", j.Name, err)
+		}
+
+		keep, err := keepRulesFromConfig(j.Retention.KeepPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("backup job %q: %w", j.Name, err)
+		}
+
+		job := Job{
+			Name:     j.Name,
+			Schedule: schedule,
+			Mode:     j.Mode,
+			Excludes: append(append([]string{}, cfg.Backup.ExcludePatterns...), j.ExcludePatterns...),
+			Keep:     keep,
+			KeepDays: j.Retention.KeepDays,
+		}
+		if job.Mode == "" {
+			job.Mode = config.JobModeFiles
+		}
+		if j.Encryption.Enabled {
+			job.Password = cfg.JobPassword(j)
+		}
+
+		for _, p := range j.Paths {
+			absPath, err := filepath.Abs(p)
+			if err != nil {
+				return nil, fmt.Errorf("backup job %q: failed to resolve path %s: %w", j.Name, p, err)
+			}
+			job.Paths = append(job.Paths, absPath)
+		}
+
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// JobRunner runs scheduled backup jobs. Every run records a snapshot in the
+// database and writes its own report.
+type JobRunner struct {
+	client          *api.Client
+	logger          *zap.Logger
+	db              *database.DB
+	keys            *keyIDs
+	tree            *RemoteTree
+	mu              sync.Mutex
+	reportDir       string
+	reportFormat    string
+	reportRetention int
+}
+
+func NewJobRunner(client *api.Client, logger *zap.Logger, cfg *config.Config, db *database.DB) *JobRunner {
+	return &JobRunner{
+		client:          client,
+		logger:          logger,
+		db:              db,
+		keys:            newKeyIDs(db),
+		tree:            remoteTreeFromConfig(client, cfg, db),
+		reportDir:       cfg.Report.Directory,
+		reportFormat:    cfg.Report.Format,
+		reportRetention: cfg.Report.Retention,
+	}
+}
+
+// SetReport applies changed report settings. Runs in progress keep
+// writing to the report they started.
+func (r *JobRunner) SetReport(cfg *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reportDir = cfg.Report.Directory
+	r.reportFormat = cfg.Report.Format
+	r.reportRetention = cfg.Report.Retention
+}
+
+// newReporter creates the reporter of a run with the current settings.
+func (r *JobRunner) newReporter() (*report.Reporter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return report.NewReporter(r.logger, r.reportDir, r.reportFormat, r.reportRetention)
+}
+
+// jobRun holds the state of a single run.
+type jobRun struct {
+	runner     *JobRunner
+	job        Job
+	keyID      string
+	snapshotID int64
+	reporter   *report.Reporter
+	previous   map[string]database.SnapshotFile
+	uploaded   int
+	unchanged  int
+	failed     int
+	bytes      int64
+}
+
+// Run runs job once and returns its snapshot. Files whose content and
+// encryption match the previous snapshot are not uploaded again; the new snapshot refers to
+// the earlier upload. The snapshot is failed when nothing could be backed
+// up, partial when some paths failed, and successful otherwise.
+func (r *JobRunner) Run(ctx context.Context, job Job) (*database.Snapshot, error) {
+	startTime := time.Now()
+	snapshotID, err := r.db.CreateSnapshot(job.Name, startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, err := r.newReporter()
+	if err != nil {
+		r.db.FinishSnapshot(snapshotID, database.SnapshotFailed, err.Error())
+		return nil, err
+	}
+	reporter.StartJobReport(job.Name, snapshotID)
+
+	r.logger.Info("backup job started",
+		zap.String("job", job.Name),
+		zap.Int64("snapshot", snapshotID),
+		zap.String("mode", job.Mode),
+	)
+
+	keyID, err := r.keys.get(job.Password)
+	if err != nil {
+		r.db.FinishSnapshot(snapshotID, database.SnapshotFailed, err.Error())
+		return nil, err
+	}
+
+	previous, err := r.db.LatestSnapshotFiles(job.Name)
+	if err != nil {
+		r.logger.Warn("failed to load previous snapshot, uploading all files", zap.String("job", job.Name), zap.Error(err))
+		previous = map[string]database.SnapshotFile{}
+	}
+
+	run := &jobRun{
+		runner:     r,
+		job:        job,
+		keyID:      keyID,
+		snapshotID: snapshotID,
+		reporter:   reporter,
+		previous:   previous,
+	}
+
+	var runErr error
+	for _, path := range job.Paths {
+		if job.Mode == config.JobModeArchive {
+			runErr = run.archivePath(ctx, path)
+		} else {
+			runErr = run.walkPath(ctx, path)
+		}
+		if runErr != nil {
+			break
+		}
+	}
+
+	status := database.SnapshotSuccess
+	errMsg := ""
+	switch {
+	case runErr != nil:
+		status = database.SnapshotFailed
+		errMsg = runErr.Error()
+	case run.failed > 0 && run.uploaded+run.unchanged == 0:
+		status = database.SnapshotFailed
+		errMsg = fmt.Sprintf("%d path(s) failed", run.failed)
+	case run.failed > 0:
+		status = database.SnapshotPartial
+		errMsg = fmt.Sprintf("%d path(s) failed", run.failed)
+	}
+
+	if err := r.db.FinishSnapshot(snapshotID, status, errMsg); err != nil {
+		return nil, err
+	}
+
+	stats := map[string]interface{}{
+		"job":            job.Name,
+		"snapshot_id":    snapshotID,
+		"status":         status,
+		"uploaded":       run.uploaded,
+		"unchanged":      run.unchanged,
+		"failed":         run.failed,
+		"bytes_uploaded": run.bytes,
+	}
+	if err := reporter.FinishReport(stats); err != nil {
+		r.logger.Error("failed to finish job report", zap.String("job", job.Name), zap.Error(err))
+	}
+
+	r.logger.Info("backup job finished",
+		zap.String("job", job.Name),
+		zap.Int64("snapshot", snapshotID),
+		zap.String("status", status),
+		zap.Int("uploaded", run.uploaded),
+		zap.Int("unchanged", run.unchanged),
+		zap.Int("failed", run.failed),
+		zap.Duration("duration", time.Since(startTime)),
+	)
+
+	r.applyRetention(job, snapshotID)
+
+	return r.db.GetSnapshot(snapshotID)
+}
+
+// walkPath backs up every file below path that is not excluded.
+func (run *jobRun) walkPath(ctx context.Context, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			run.fail(path, fmt.Errorf("failed to access path: %w", err))
+			return nil
+		}
+		if matchesExclude(path, run.job.Excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			run.saveDirectory(path)
+			return nil
+		}
+		// Symbolic links are backed up as links, as the service does
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		run.backup(ctx, path, path, info.Size())
+		return nil
+	})
+}
+
+// archivePath uploads a directory as a single tar.gz archive. A path that
+// is a regular file is uploaded as is.
+func (run *jobRun) archivePath(ctx context.Context, path string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		run.fail(path, fmt.Errorf("failed to access path: %w", err))
+		return nil
+	}
+	if !info.IsDir() {
+		run.backup(ctx, path, path, info.Size())
+		return nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "koneksi-job-*")
+	if err != nil {
+		run.fail(path, fmt.Errorf("failed to create temp directory: %w", err))
+		return nil
+	}
+	defer os.RemoveAll(tempDir)
+
+	name := fmt.Sprintf("%s-%s-%s.tar.gz", run.job.Name, filepath.Base(path), time.Now().Format("20060102-150405"))
+	archivePath := filepath.Join(tempDir, name)
+	if err := archive.CompressDirectory(path, archivePath); err != nil {
+		run.fail(path, fmt.Errorf("failed to compress directory: %w", err))
+		return nil
+	}
+
+	archiveInfo, err := os.Stat(archivePath)
+	if err != nil {
+		run.fail(path, fmt.Errorf("failed to stat archive: %w", err))
+		return nil
+	}
+
+	run.backup(ctx, path, archivePath, archiveInfo.Size())
+	return nil
+}
+
+// backup adds source to the snapshot as path, uploading it unless the
+// previous snapshot already has the same content.
+func (run *jobRun) backup(ctx context.Context, path, source string, size int64) {
+	startTime := time.Now()
+	encrypted := run.job.Password != ""
+
+	meta, err := metadata.Capture(path)
+	if err != nil {
+		run.fail(path, fmt.Errorf("failed to read file metadata: %w", err))
+		return
+	}
+	name := source
+	if meta.IsSymlink() && source == path {
+		// A symbolic link is backed up as its target
+		if source, err = linkSource(meta.Link); err != nil {
+			run.fail(path, err)
+			return
+		}
+		defer os.Remove(source)
+		name, size = path, int64(len(meta.Link))
+	}
+	checksum, err := checksumFile(source)
+	if err != nil {
+		run.fail(path, fmt.Errorf("failed to calculate checksum: %w", err))
+		return
+	}
+
+	entry := database.SnapshotFile{
+		SnapshotID: run.snapshotID,
+		FilePath:   path,
+		Checksum:   checksum,
+		Size:       size,
+		Encrypted:  encrypted,
+		KeyID:      run.keyID,
+	}
+
+	if prev, ok := run.previous[path]; ok && prev.Checksum == checksum && prev.Encrypted == encrypted && prev.KeyID == run.keyID {
+		entry.FileID = prev.FileID
+		if err := run.runner.db.AddSnapshotFile(entry); err != nil {
+			run.fail(path, err)
+			return
+		}
+		run.unchanged++
+		return
+	}
+
+	fileID, uploadSize, err := run.runner.upload(ctx, filepath.Dir(path), name, source, checksum, run.job.Password)
+	if err != nil {
+		run.fail(path, err)
+		return
+	}
+
+	entry.FileID = fileID
+	if err := run.runner.db.AddSnapshotFile(entry); err != nil {
+		run.fail(path, err)
+		return
+	}
+	run.uploaded++
+	run.bytes += uploadSize
+
+	run.reporter.AddResult(report.BackupResult{
+		FilePath:       path,
+		FileID:         fileID,
+		Operation:      "scheduled",
+		Success:        true,
+		StartTime:      startTime,
+		EndTime:        time.Now(),
+		Size:           size,
+		CompressedSize: uploadSize,
+		Checksum:       checksum,
+		Compressed:     run.job.Mode == config.JobModeArchive,
+		Metadata:       meta,
+	})
+
+	record := database.BackupRecord{
+		FilePath:       path,
+		FileID:         fileID,
+		Checksum:       checksum,
+		OriginalSize:   size,
+		CompressedSize: uploadSize,
+		IsCompressed:   run.job.Mode == config.JobModeArchive,
+		SnapshotID:     run.snapshotID,
+		BackupTime:     time.Now(),
+		Status:         "success",
+		Operation:      "scheduled",
+	}
+	setRecordMetadata(&record, meta)
+	setRecordEncryption(&record, run.keyID)
+	if _, err := run.runner.db.InsertBackupRecord(record); err != nil {
+		run.runner.logger.Debug("failed to save backup record to database", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// saveDirectory records the metadata of a directory the job walks.
+func (run *jobRun) saveDirectory(path string) {
+	meta, err := metadata.Capture(path)
+	if err == nil {
+		err = run.runner.db.SaveDirectory(directoryRecord(path, meta))
+	}
+	if err != nil {
+		run.runner.logger.Debug("failed to save directory", zap.String("path", path), zap.Error(err))
+	}
+}
+
+func (run *jobRun) fail(path string, err error) {
+	run.failed++
+	run.runner.logger.Error("backup job failed to back up path",
+		zap.String("job", run.job.Name),
+		zap.String("path", path),
+		zap.Error(err),
+	)
+
+	now := time.Now()
+	run.reporter.AddResult(report.BackupResult{
+		FilePath:  path,
+		Operation: "scheduled",
+		Error:     err,
+		StartTime: now,
+		EndTime:   now,
+	})
+}
+
+// linkSource writes the target of a symbolic link to a temporary file, to be
+// uploaded as the content of the link. The caller removes it.
+func linkSource(target string) (string, error) {
+	file, err := os.CreateTemp("", "koneksi-job-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = file.WriteString(target)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// upload sends source to Koneksi under name, encrypting it first when a
+// password is given, and returns the file ID and the number of bytes
+// uploaded. With the mirror layout the file goes into the mirror of the
+// local directory dir.
+func (r *JobRunner) upload(ctx context.Context, dir, name, source, checksum, password string) (string, int64, error) {
+	uploadPath := source
+	if password != "" {
+		tempFile, err := os.CreateTemp("", "koneksi-job-*.enc")
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tempFile.Close()
+		defer os.Remove(tempFile.Name())
+
+		if err := encryption.NewEncryptor(password).EncryptFile(source, tempFile.Name()); err != nil {
+			return "", 0, fmt.Errorf("failed to encrypt file: %w", err)
+		}
+		uploadPath, name = tempFile.Name(), encryption.GetEncryptedFileName(name)
+	}
+
+	file, err := os.Open(uploadPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	var resp *api.FileUploadResponse
+	if r.tree != nil {
+		resp, err = r.tree.Upload(ctx, dir, name, file, info.Size(), checksum)
+	} else {
+		resp, err = r.client.UploadFile(ctx, name, file, info.Size(), checksum)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to upload file: %w", err)
+	}
+	return resp.FileID, info.Size(), nil
+}
+
+// applyRetention removes snapshots that fall outside the job's retention
+// rules. The snapshot just taken is always kept. Uploaded files only the
+// removed snapshots referred to are queued for deletion by prune.
+func (r *JobRunner) applyRetention(job Job, current int64) {
+	if job.Keep.IsZero() && job.KeepDays == 0 {
+		return
+	}
+
+	snapshots, err := r.db.ListSnapshots(job.Name)
+	if err != nil {
+		r.logger.Error("failed to apply snapshot retention", zap.String("job", job.Name), zap.Error(err))
+		return
+	}
+
+	for _, s := range expiredSnapshots(job, snapshots, current) {
+		if err := r.db.DeleteSnapshot(s.ID); err != nil {
+			r.logger.Error("failed to remove expired snapshot", zap.Int64("snapshot", s.ID), zap.Error(err))
+			continue
+		}
+		r.logger.Info("removed expired snapshot",
+			zap.String("job", job.Name),
+			zap.Int64("snapshot", s.ID),
+			zap.Time("started_at", s.StartedAt),
+		)
+	}
+}
+
+// expiredSnapshots returns the snapshots, newest first, that fall outside
+// the job's retention rules. Successful and partial snapshots are selected
+// by the keep rules; any snapshot younger than KeepDays and current are
+// kept as well. Running snapshots are never expired.
+func expiredSnapshots(job Job, snapshots []database.Snapshot, current int64) []database.Snapshot {
+	if job.Keep.IsZero() && job.KeepDays == 0 {
+		return nil
+	}
+
+	var (
+		usable []database.Snapshot
+		times  []time.Time
+	)
+	for _, s := range snapshots {
+		if s.Status == database.SnapshotSuccess || s.Status == database.SnapshotPartial {
+			usable = append(usable, s)
+			times = append(times, s.StartedAt)
+		}
+	}
+
+	keep := map[int64]bool{current: true}
+	for i, reasons := range job.Keep.Select(times) {
+		if len(reasons) > 0 {
+			keep[usable[i].ID] = true
+		}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -job.KeepDays)
+	var expired []database.Snapshot
+	for _, s := range snapshots {
+		if s.Status == database.SnapshotRunning || keep[s.ID] {
+			continue
+		}
+		if job.KeepDays > 0 && s.StartedAt.After(cutoff) {
+			continue
+		}
+		expired = append(expired, s)
+	}
+	return expired
+}
+
+// matchesExclude reports whether path matches one of the patterns, either
+// by base name glob or as a path prefix, like the watcher does.
+func matchesExclude(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		matched, err := filepath.Match(pattern, filepath.Base(path))
+		if err == nil && matched {
+			return true
+		}
+		if filepath.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
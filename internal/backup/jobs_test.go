@@ -0,0 +1,380 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/cron"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+// uploadServer accepts uploads and records the uploaded file names.
+type uploadServer struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (u *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file.Close()
+
+	u.mu.Lock()
+	u.names = append(u.names, header.Filename)
+	id := len(u.names)
+	u.mu.Unlock()
+
+	fmt.Fprintf(w, `{"status":"success","data":{"file_id":"file-%d","name":%q}}`, id, header.Filename)
+}
+
+func (u *uploadServer) uploads() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.names...)
+}
+
+func newTestJobRunner(t *testing.T) (*JobRunner, *uploadServer, *database.DB) {
+	t.Helper()
+
+	uploads := &uploadServer{}
+	server := httptest.NewServer(uploads)
+	t.Cleanup(server.Close)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := &config.Config{}
+	cfg.Report.Directory = t.TempDir()
+	cfg.Report.Format = "json"
+	cfg.Report.Retention = 10
+
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", "dir", time.Minute, 0, logger)
+	return NewJobRunner(client, logger, cfg, db), uploads, db
+}
+
+func newTestJob(t *testing.T, name, mode string, paths ...string) Job {
+	t.Helper()
+	schedule, err := cron.Parse("@daily")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %v", err)
+	}
+	return Job{Name: name, Schedule: schedule, Mode: mode, Paths: paths}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func TestJobRunner_FilesModeUploadsOnlyChanges(t *testing.T) {
+	runner, uploads, db := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "bravo")
+	writeTestFile(t, filepath.Join(dir, "skip.tmp"), "temporary")
+
+	job := newTestJob(t, "docs", config.JobModeFiles, dir)
+	job.Excludes = []string{"*.tmp"}
+
+	first, err := runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if first.Status != database.SnapshotSuccess || first.Files != 2 {
+		t.Fatalf("unexpected first snapshot: %+v", first)
+	}
+	if got := len(uploads.uploads()); got != 2 {
+		t.Fatalf("expected 2 uploads, got %d", got)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha changed")
+
+	second, err := runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if second.Status != database.SnapshotSuccess || second.Files != 2 {
+		t.Fatalf("unexpected second snapshot: %+v", second)
+	}
+	if got := len(uploads.uploads()); got != 3 {
+		t.Errorf("only the changed file should be uploaded again, got %d uploads", got)
+	}
+
+	files, err := db.ListSnapshotFiles(second.ID)
+	if err != nil {
+		t.Fatalf("failed to list snapshot files: %v", err)
+	}
+	byPath := map[string]string{}
+	for _, f := range files {
+		byPath[f.FilePath] = f.FileID
+	}
+	if byPath[filepath.Join(dir, "a.txt")] != "file-3" {
+		t.Errorf("changed file should refer to the new upload, got %q", byPath[filepath.Join(dir, "a.txt")])
+	}
+	if byPath[filepath.Join(dir, "sub", "b.txt")] != "file-2" {
+		t.Errorf("unchanged file should refer to the earlier upload, got %q", byPath[filepath.Join(dir, "sub", "b.txt")])
+	}
+}
+
+func TestJobRunner_FilesModeBacksUpLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links are POSIX")
+	}
+	runner, uploads, db := newTestJobRunner(t)
+
+	// A link to a file, a dangling link and a link to a directory
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "sub", "a.txt"), "alpha")
+	links := map[string]string{"current": "sub/a.txt", "dangling": "missing.txt", "dir": "sub"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("failed to create link: %v", err)
+		}
+	}
+
+	job := newTestJob(t, "links", config.JobModeFiles, dir)
+	snapshot, err := runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if snapshot.Status != database.SnapshotSuccess || snapshot.Files != 4 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+	if got := len(uploads.uploads()); got != 4 {
+		t.Fatalf("expected 4 uploads, got %d: %v", got, uploads.uploads())
+	}
+	for name, target := range links {
+		link := filepath.Join(dir, name)
+		record, _ := db.LatestBackupRecord(link)
+		if record == nil || record.LinkTarget != target || record.Mode&os.ModeSymlink == 0 ||
+			record.Checksum != contentChecksum([]byte(target)) || record.OriginalSize != int64(len(target)) {
+			t.Errorf("%s not backed up as a link: %+v", name, record)
+		}
+	}
+	if !slices.Contains(uploads.uploads(), "current") {
+		t.Errorf("link uploaded under another name: %v", uploads.uploads())
+	}
+
+	// Unchanged links are not uploaded again
+	if _, err := runner.Run(context.Background(), job); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if got := len(uploads.uploads()); got != 4 {
+		t.Errorf("expected no new uploads, got %d", got)
+	}
+}
+
+func TestJobRunner_ArchiveModeWithEncryption(t *testing.T) {
+	runner, uploads, db := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "dump.sql"), "CREATE TABLE t (id INTEGER);")
+
+	job := newTestJob(t, "dumps", config.JobModeArchive, dir)
+	job.Password = "secret"
+
+	snapshot, err := runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if snapshot.Status != database.SnapshotSuccess || snapshot.Files != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	names := uploads.uploads()
+	if len(names) != 1 || !strings.HasPrefix(names[0], "dumps-") || !strings.HasSuffix(names[0], ".tar.gz.enc") {
+		t.Errorf("expected one encrypted archive upload, got %v", names)
+	}
+
+	files, err := db.ListSnapshotFiles(snapshot.ID)
+	if err != nil {
+		t.Fatalf("failed to list snapshot files: %v", err)
+	}
+	if len(files) != 1 || files[0].FilePath != dir || !files[0].Encrypted {
+		t.Errorf("unexpected snapshot files: %+v", files)
+	}
+}
+
+func TestJobRunner_MissingPathIsPartial(t *testing.T) {
+	runner, _, _ := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha")
+
+	job := newTestJob(t, "mixed", config.JobModeFiles, dir, filepath.Join(dir, "missing"))
+	snapshot, err := runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if snapshot.Status != database.SnapshotPartial {
+		t.Errorf("expected partial snapshot, got %s", snapshot.Status)
+	}
+
+	job.Paths = []string{filepath.Join(dir, "missing")}
+	snapshot, err = runner.Run(context.Background(), job)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if snapshot.Status != database.SnapshotFailed {
+		t.Errorf("expected failed snapshot, got %s", snapshot.Status)
+	}
+}
+
+func TestJobRunner_RetentionKeepsLastSnapshots(t *testing.T) {
+	runner, _, db := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha")
+
+	job := newTestJob(t, "keep", config.JobModeFiles, dir)
+	job.Keep.Last = 2
+
+	var last *database.Snapshot
+	for i := 0; i < 4; i++ {
+		snapshot, err := runner.Run(context.Background(), job)
+		if err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+		last = snapshot
+	}
+
+	snapshots, err := db.ListSnapshots("keep")
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots after retention, got %d", len(snapshots))
+	}
+	if snapshots[0].ID != last.ID {
+		t.Errorf("latest snapshot should be kept")
+	}
+}
+
+func TestJobRunner_SetReportMovesReports(t *testing.T) {
+	runner, _, _ := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha")
+
+	cfg := &config.Config{}
+	cfg.Report.Directory = t.TempDir()
+	cfg.Report.Format = "json"
+	cfg.Report.Retention = 10
+	runner.SetReport(cfg)
+
+	if _, err := runner.Run(context.Background(), newTestJob(t, "docs", config.JobModeFiles, dir)); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	reports, err := os.ReadDir(cfg.Report.Directory)
+	if err != nil {
+		t.Fatalf("failed to read report directory: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Errorf("expected the report in the new directory, found %d files", len(reports))
+	}
+}
+
+func TestScheduler_RunNow(t *testing.T) {
+	runner, uploads, db := newTestJobRunner(t)
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "alpha")
+
+	scheduler := NewScheduler(runner, zap.NewNop(), []Job{newTestJob(t, "manual", config.JobModeFiles, dir)})
+
+	if err := scheduler.RunNow("manual"); err == nil {
+		t.Error("expected an error before the scheduler is started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.Start(ctx)
+
+	if err := scheduler.RunNow("unknown"); err == nil {
+		t.Error("expected an error for an unknown job")
+	}
+	if err := scheduler.RunNow("manual"); err != nil {
+		t.Fatalf("run now failed: %v", err)
+	}
+
+	jobs := scheduler.Jobs()
+	if len(jobs) != 1 || jobs[0].Name != "manual" || jobs[0].NextRun.IsZero() {
+		t.Errorf("unexpected job status: %+v", jobs)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for scheduler.Jobs()[0].Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	scheduler.Wait()
+
+	snapshots, err := db.ListSnapshots("manual")
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(snapshots))
+	}
+	if snapshots[0].Status != database.SnapshotSuccess {
+		t.Errorf("expected a successful snapshot, got %s", snapshots[0].Status)
+	}
+	if got := len(uploads.uploads()); got != 1 {
+		t.Errorf("expected 1 upload, got %d", got)
+	}
+}
+
+func TestJobsFromConfig(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.ExcludePatterns = []string{"*.tmp"}
+	cfg.Backup.Encryption.Password = "global"
+
+	job := config.Job{Name: "db", Schedule: "30 2 * * *", Paths: []string{"relative"}}
+	job.ExcludePatterns = []string{"*.log"}
+	job.Encryption.Enabled = true
+	cfg.Backup.Jobs = []config.Job{job}
+
+	jobs, err := JobsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 job, got %d", len(jobs))
+	}
+	got := jobs[0]
+	if got.Mode != config.JobModeFiles || got.Password != "global" || len(got.Excludes) != 2 || !filepath.IsAbs(got.Paths[0]) {
+		t.Errorf("unexpected job: %+v", got)
+	}
+
+	cfg.Backup.Jobs[0].Schedule = "every night"
+	if _, err := JobsFromConfig(cfg); err == nil {
+		t.Error("expected an invalid schedule to be rejected")
+	}
+}
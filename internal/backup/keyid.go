@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"sync"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+)
+
+// keyIDs derives the key IDs recorded for encrypted uploads with the key
+// salt of the installation, once per password as derivation is slow.
+type keyIDs struct {
+	db  *database.DB
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newKeyIDs(db *database.DB) *keyIDs {
+	return &keyIDs{db: db, ids: make(map[string]string)}
+}
+
+// get returns the key ID of password, or "" for no password.
+func (k *keyIDs) get(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id, ok := k.ids[password]; ok {
+		return id, nil
+	}
+	salt, err := k.db.KeySalt()
+	if err != nil {
+		return "", err
+	}
+	k.ids[password] = encryption.KeyID(password, salt)
+	return k.ids[password], nil
+}
+
+// setRecordEncryption records how a version was uploaded: encrypted with
+// the password of keyID, or not at all when keyID is empty.
+func setRecordEncryption(r *database.BackupRecord, keyID string) {
+	encrypted := keyID != ""
+	r.Encrypted, r.KeyID = &encrypted, keyID
+}
+
+// sameEncryption reports whether a version was uploaded as the password of
+// keyID would upload it now. Versions from before encryption was recorded
+// are not known to be.
+func sameEncryption(r database.BackupRecord, keyID string) bool {
+	return r.Encrypted != nil && *r.Encrypted == (keyID != "") && r.KeyID == keyID
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+)
+
+// recordMetadata returns the metadata backed up with a version, or nil for
+// a version backed up before its mode was recorded.
+func recordMetadata(r database.BackupRecord) *metadata.Metadata {
+	if r.Mode == 0 && r.LinkTarget == "" {
+		return nil
+	}
+	return &metadata.Metadata{
+		Mode:    r.Mode,
+		ModTime: r.ModTime,
+		Owner:   r.Owner,
+		Link:    r.LinkTarget,
+		Xattrs:  r.Xattrs,
+	}
+}
+
+// setRecordMetadata records m as the metadata of a version.
+func setRecordMetadata(r *database.BackupRecord, m *metadata.Metadata) {
+	r.Mode, r.ModTime, r.Owner, r.LinkTarget, r.Xattrs = m.Mode, m.ModTime, m.Owner, m.Link, m.Xattrs
+}
+
+// sameMetadata reports whether a version was backed up with metadata m.
+// Modification times are stored to the millisecond.
+func sameMetadata(r database.BackupRecord, m *metadata.Metadata) bool {
+	current := *m
+	if !m.ModTime.IsZero() {
+		current.ModTime = time.UnixMilli(m.ModTime.UnixMilli())
+	}
+	return recordMetadata(r).Equal(&current)
+}
+
+// directoryRecord returns the database entry of a directory with metadata m.
+func directoryRecord(path string, m *metadata.Metadata) database.Directory {
+	return database.Directory{Path: path, Mode: m.Mode, ModTime: m.ModTime, Owner: m.Owner, Xattrs: m.Xattrs}
+}
+
+// pathChecksum returns the checksum of the content of the file at path,
+// which is the target of a symbolic link.
+func pathChecksum(path string, m *metadata.Metadata) (string, error) {
+	if m.IsSymlink() {
+		return contentChecksum([]byte(m.Link)), nil
+	}
+	return checksumFile(path)
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/monitor"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestBackupRestoreMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("modes and symbolic links are POSIX")
+	}
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	ctx := context.Background()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	// A script, a link to it and an empty private directory
+	data := filepath.Join(t.TempDir(), "data")
+	script := filepath.Join(data, "bin", "run.sh")
+	link := filepath.Join(data, "current")
+	empty := filepath.Join(data, "empty")
+	writeTestFile(t, script, "#!/bin/sh\n")
+	if err := os.Symlink("bin/run.sh", link); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+	if err := os.Mkdir(empty, 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	modTime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	if err := os.Chmod(script, 0750); err != nil {
+		t.Fatalf("failed to set mode: %v", err)
+	}
+	for _, path := range []string{script, empty} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set times: %v", err)
+		}
+	}
+
+	for _, dir := range []string{data, filepath.Dir(script), empty} {
+		service.ProcessChange(monitor.FileChange{Path: dir, Operation: "scan", IsDir: true})
+	}
+	for _, path := range []string{script, link} {
+		if err := service.processBackup(ctx, BackupTask{FilePath: path, Operation: "scan"}); err != nil {
+			t.Fatalf("backup of %s failed: %v", path, err)
+		}
+	}
+	record, _ := db.LatestBackupRecord(link)
+	if record == nil || record.LinkTarget != "bin/run.sh" || record.Owner == nil {
+		t.Fatalf("link not backed up as a link: %+v", record)
+	}
+
+	// A chmod is a new version without a new upload
+	if err := os.Chmod(script, 0700); err != nil {
+		t.Fatalf("failed to set mode: %v", err)
+	}
+	if err := service.processBackup(ctx, BackupTask{FilePath: script, Operation: "chmod"}); err != nil {
+		t.Fatalf("backup of chmod failed: %v", err)
+	}
+	history, _ := db.GetBackupHistory(script, 10)
+	if len(history) != 2 || history[0].Mode.Perm() != 0700 || history[0].FileID != history[1].FileID {
+		t.Errorf("expected a second version sharing the upload, got %+v", history)
+	}
+	if uploaded := len(server.Files(base)); uploaded != 2 {
+		t.Errorf("expected 2 uploads, got %d", uploaded)
+	}
+
+	out := t.TempDir()
+	restoreService := NewRestoreService(client, logger, 2)
+	if _, err := restoreService.RestorePaths(ctx, db, data, PathRestoreOptions{To: out}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if progress := restoreService.GetProgress(); progress.RestoredFiles != 2 || progress.FailedFiles != 0 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+
+	info, err := os.Stat(filepath.Join(out, "data", "bin", "run.sh"))
+	if err != nil || info.Mode().Perm() != 0700 || !info.ModTime().Equal(modTime) {
+		t.Errorf("script restored without its metadata: %v, %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(out, "data", "current")); err != nil || target != "bin/run.sh" {
+		t.Errorf("expected the link to be restored, got %q, %v", target, err)
+	}
+	info, err = os.Stat(filepath.Join(out, "data", "empty"))
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0700 || !info.ModTime().Equal(modTime) {
+		t.Errorf("empty directory not restored: %v, %v", info, err)
+	}
+
+	// An empty directory restores on its own
+	out = t.TempDir()
+	if _, err := NewRestoreService(client, logger, 1).RestorePaths(ctx, db, empty, PathRestoreOptions{To: out}); err != nil {
+		t.Fatalf("restore of the empty directory failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(out, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("empty directory not restored: %v, %v", info, err)
+	}
+}
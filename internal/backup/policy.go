@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/compression"
+)
+
+// Policy is the set of backup settings in effect for a path: those of the
+// backup directory containing it, or the global ones for paths outside
+// backup.directories.
+type Policy struct {
+	// Root is the backup directory the policy belongs to; empty for the
+	// global policy
+	Root        string
+	MaxFileSize int64
+	// Excludes are the directory's own patterns; the global ones are
+	// applied by the watcher
+	Excludes   []string
+	Compress   bool
+	Compressor compression.Compressor
+	// Password encrypts uploads when set
+	Password string
+	// Prune selects the versions prune keeps
+	Prune KeepRules
+}
+
+// policies resolves the policy of a path.
+type policies struct {
+	global Policy
+	// roots are ordered by descending path length, so the most specific
+	// directory is found first
+	roots []Policy
+}
+
+func policiesFromConfig(cfg *config.Config) (*policies, error) {
+	global, err := newPolicy(cfg, config.Directory{})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policies{global: global}
+	for _, dir := range cfg.Backup.Directories {
+		policy, err := newPolicy(cfg, dir)
+		if err != nil {
+			return nil, fmt.Errorf("backup directory %s: %w", dir.Path, err)
+		}
+		p.roots = append(p.roots, policy)
+	}
+	sort.SliceStable(p.roots, func(i, j int) bool {
+		return len(p.roots[i].Root) > len(p.roots[j].Root)
+	})
+	return p, nil
+}
+
+// newPolicy resolves the settings of a backup directory against the global
+// ones. The zero Directory yields the global policy.
+func newPolicy(cfg *config.Config, dir config.Directory) (Policy, error) {
+	policy := Policy{
+		MaxFileSize: cfg.Backup.MaxFileSize,
+		Excludes:    dir.ExcludePatterns,
+	}
+
+	if dir.Path != "" {
+		absPath, err := filepath.Abs(dir.Path)
+		if err != nil {
+			return Policy{}, fmt.Errorf("failed to resolve path: %w", err)
+		}
+		policy.Root = absPath
+	}
+	if dir.MaxFileSize != nil {
+		policy.MaxFileSize = *dir.MaxFileSize
+	}
+
+	settings := cfg.DirectoryCompression(dir)
+	compressor, err := newCompressor(settings)
+	if err != nil {
+		return Policy{}, err
+	}
+	policy.Compress = settings.Enabled
+	policy.Compressor = compressor
+
+	if cfg.DirectoryEncryption(dir).Enabled {
+		policy.Password = cfg.DirectoryPassword(dir)
+	}
+
+	if policy.Prune, err = keepRulesFromConfig(cfg.DirectoryPrune(dir)); err != nil {
+		return Policy{}, fmt.Errorf("prune: %w", err)
+	}
+	return policy, nil
+}
+
+func newCompressor(settings config.Compression) (compression.Compressor, error) {
+	if !settings.Enabled {
+		return compression.NewCompressor("none", 0)
+	}
+
+	compressor, err := compression.NewCompressor(settings.Format, settings.Level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %w", err)
+	}
+	return compressor, nil
+}
+
+// resolve returns the policy of the innermost backup directory containing
+// path, or the global policy.
+func (p *policies) resolve(path string) Policy {
+	for _, policy := range p.roots {
+		if isWithin(path, policy.Root) {
+			return policy
+		}
+	}
+	return p.global
+}
+
+// isWithin reports whether path is root or a descendant of root.
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
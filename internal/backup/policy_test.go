@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/monitor"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestPoliciesResolve(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024
+	cfg.Backup.Compression = config.Compression{Enabled: true, Format: "gzip", Level: 6}
+	cfg.Backup.Encryption.Password = "global"
+
+	mediaMax := int64(20 << 30)
+	cfg.Backup.Directories = []config.Directory{
+		{Path: "/srv"},
+		{Path: "/srv/media", MaxFileSize: &mediaMax, Compression: &config.Compression{Enabled: false}},
+		{Path: "/home/finance", Encryption: &config.Encryption{Enabled: true, Password: "finance"}},
+		{Path: "/home/shared", Encryption: &config.Encryption{Enabled: true}},
+	}
+
+	p, err := policiesFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed to build policies: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		root     string
+		maxSize  int64
+		compress bool
+		password string
+	}{
+		{"/srv/www/index.html", "/srv", 1024, true, ""},
+		{"/srv/media/movie.mkv", "/srv/media", mediaMax, false, ""},
+		{"/srv/media", "/srv/media", mediaMax, false, ""},
+		{"/srv/mediaserver/app.conf", "/srv", 1024, true, ""},
+		{"/home/finance/ledger.xlsx", "/home/finance", 1024, true, "finance"},
+		{"/home/shared/notes.txt", "/home/shared", 1024, true, "global"},
+		{"/tmp/other.txt", "", 1024, true, ""},
+	}
+	for _, tt := range tests {
+		policy := p.resolve(tt.path)
+		if policy.Root != tt.root || policy.MaxFileSize != tt.maxSize || policy.Compress != tt.compress || policy.Password != tt.password {
+			t.Errorf("%s: unexpected policy %+v", tt.path, policy)
+		}
+	}
+
+	// An unsupported compression format in a directory fails as a whole
+	cfg.Backup.Directories[1].Compression = &config.Compression{Enabled: true, Format: "brotli"}
+	if _, err := policiesFromConfig(cfg); err == nil {
+		t.Error("expected an unsupported compression format to be rejected")
+	}
+}
+
+func TestBackupService_DirectoryPolicy(t *testing.T) {
+	uploads := &uploadServer{}
+	server := httptest.NewServer(uploads)
+	defer server.Close()
+
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	plain := t.TempDir()
+	secret := t.TempDir()
+	secretMax := int64(100)
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+	cfg.Backup.Directories = []config.Directory{
+		{Path: plain},
+		{
+			Path:            secret,
+			ExcludePatterns: []string{"*.bak"},
+			MaxFileSize:     &secretMax,
+			Encryption:      &config.Encryption{Enabled: true, Password: "finance"},
+		},
+	}
+
+	client := api.NewClient(server.URL, "id", "secret", "dir", time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	changes := []struct {
+		path    string
+		content string
+	}{
+		{filepath.Join(plain, "notes.txt"), "plain notes"},
+		{filepath.Join(secret, "ledger.csv"), "account,amount"},
+		{filepath.Join(secret, "ledger.bak"), "excluded by the directory"},
+		{filepath.Join(secret, "large.csv"), strings.Repeat("x", 200)},
+	}
+	for _, c := range changes {
+		writeTestFile(t, c.path, c.content)
+		service.ProcessChange(monitor.FileChange{
+			Path:      c.path,
+			Operation: "create",
+			Timestamp: time.Now(),
+			Size:      int64(len(c.content)),
+		})
+	}
+
+	if depth, _ := service.QueueDepth(); depth != 2 {
+		t.Fatalf("expected 2 queued files, got %d", depth)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(uploads.uploads()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	service.Stop()
+
+	names := map[string]bool{}
+	for _, name := range uploads.uploads() {
+		names[filepath.Base(name)] = true
+	}
+	if len(names) != 2 || !names["notes.txt"] || !names["ledger.csv.enc"] {
+		t.Errorf("expected notes.txt in plain and ledger.csv encrypted, got %v", names)
+	}
+}
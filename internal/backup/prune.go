@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+// Deleter is implemented by targets that can delete stored files. Objects
+// on targets without it stay queued until they gain support.
+type Deleter interface {
+	Delete(ctx context.Context, fileID string) error
+}
+
+// pruneBatchSize is the number of backup records removed per transaction.
+const pruneBatchSize = 500
+
+// PruneResult describes what a prune removed, or would remove in a dry
+// run.
+type PruneResult struct {
+	// Versions are the removed backup records, grouped by file
+	Versions []database.BackupRecord
+	// Kept is the number of versions kept
+	Kept      int
+	Snapshots []database.Snapshot
+	// Deleted, Deferred and Failed count the queued remote objects that
+	// were deleted, wait for a target that can delete files, or failed
+	Deleted  int
+	Deferred int
+	Failed   int
+}
+
+// Pruner removes old file versions and job snapshots according to their
+// keep rules, then deletes the remote objects nothing refers to anymore.
+type Pruner struct {
+	db       *database.DB
+	logger   *zap.Logger
+	policies *policies
+	jobs     []Job
+	targets  map[string]Target
+}
+
+// NewPruner returns a pruner using the prune rules of cfg for file versions
+// and the retention of jobs for snapshots. targets are the targets remote
+// objects are deleted from, including the primary one.
+func NewPruner(db *database.DB, logger *zap.Logger, cfg *config.Config, jobs []Job, targets []Target) (*Pruner, error) {
+	policies, err := policiesFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pruner{
+		db:       db,
+		logger:   logger,
+		policies: policies,
+		jobs:     jobs,
+		targets:  make(map[string]Target, len(targets)),
+	}
+	for _, t := range targets {
+		p.targets[t.Name()] = t
+	}
+	return p, nil
+}
+
+// Prune removes the expired versions and snapshots and deletes remote
+// objects queued for deletion. A dry run only reports what would be
+// removed.
+func (p *Pruner) Prune(ctx context.Context, dryRun bool) (*PruneResult, error) {
+	result := &PruneResult{}
+
+	err := p.db.ForEachFileVersions(func(versions []database.BackupRecord) error {
+		rules := p.policies.resolve(versions[0].FilePath).Prune
+		if rules.IsZero() {
+			result.Kept += len(versions)
+			return nil
+		}
+
+		times := make([]time.Time, len(versions))
+		for i, v := range versions {
+			times[i] = v.BackupTime
+		}
+		for i, reasons := range rules.Select(times) {
+			if len(reasons) > 0 {
+				result.Kept++
+				continue
+			}
+			result.Versions = append(result.Versions, versions[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range p.jobs {
+		snapshots, err := p.db.ListSnapshots(job.Name)
+		if err != nil {
+			return nil, err
+		}
+		result.Snapshots = append(result.Snapshots, expiredSnapshots(job, snapshots, 0)...)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	for start := 0; start < len(result.Versions); start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > len(result.Versions) {
+			end = len(result.Versions)
+		}
+		ids := make([]int64, 0, end-start)
+		for _, v := range result.Versions[start:end] {
+			ids = append(ids, v.ID)
+		}
+		if _, err := p.db.DeleteBackupRecords(ids); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range result.Snapshots {
+		if err := p.db.DeleteSnapshot(s.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.deleteRemote(ctx, result); err != nil {
+		return nil, err
+	}
+
+	p.logger.Info("prune finished",
+		zap.Int("versions_removed", len(result.Versions)),
+		zap.Int("versions_kept", result.Kept),
+		zap.Int("snapshots_removed", len(result.Snapshots)),
+		zap.Int("objects_deleted", result.Deleted),
+		zap.Int("objects_deferred", result.Deferred),
+		zap.Int("objects_failed", result.Failed),
+	)
+	return result, nil
+}
+
+// deleteRemote deletes the queued remote objects from their targets.
+// Objects referred to again, for example by a new snapshot of unchanged
+// content, are dropped from the queue instead.
+func (p *Pruner) deleteRemote(ctx context.Context, result *PruneResult) error {
+	deletions, err := p.db.PendingDeletions()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deletions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		referenced, err := p.db.FileIDReferenced(d.Target, d.FileID)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			if err := p.db.CompleteDeletion(d.Target, d.FileID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		deleter, ok := p.targets[d.Target].(Deleter)
+		if !ok {
+			result.Deferred++
+			continue
+		}
+
+		err = deleter.Delete(ctx, d.FileID)
+		if err != nil && !errors.Is(err, api.ErrNotFound) {
+			result.Failed++
+			p.logger.Warn("failed to delete remote object",
+				zap.String("target", d.Target),
+				zap.String("fileID", d.FileID),
+				zap.Error(err),
+			)
+			if err := p.db.FailDeletion(d.Target, d.FileID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.db.CompleteDeletion(d.Target, d.FileID); err != nil {
+			return fmt.Errorf("object %s deleted from %s: %w", d.FileID, d.Target, err)
+		}
+		result.Deleted++
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestPruner_PrunesVersionsAndDeletesObjects(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	nasDir := t.TempDir()
+	nas := NewLocalTarget("nas", nasDir)
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, zap.NewNop())
+	primary := NewKoneksiTarget(config.PrimaryTarget, client)
+
+	remote := map[string]string{}
+	for _, name := range []string{"p1", "p2", "p3", "s-old"} {
+		remote[name] = server.AddFile(base, name, []byte(name))
+	}
+
+	// Three versions of a file, each with a copy on the local target
+	now := time.Now()
+	var copies []string
+	for i, name := range []string{"p1", "p2", "p3"} {
+		fileID := remote[name]
+		id, err := db.InsertBackupRecord(database.BackupRecord{
+			FilePath:   "/data/a.txt",
+			FileID:     fileID,
+			Checksum:   fileID,
+			BackupTime: now.Add(time.Duration(i-2) * time.Hour),
+			Status:     "success",
+		})
+		if err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+		copyID, err := nas.Upload(ctx, "a.txt", strings.NewReader(name), 2, name)
+		if err != nil {
+			t.Fatalf("failed to upload copy: %v", err)
+		}
+		copies = append(copies, copyID)
+		if err := db.SetTargetCopy(database.TargetCopy{RecordID: id, Target: "nas", FileID: copyID, Status: database.CopySuccess}); err != nil {
+			t.Fatalf("failed to set target copy: %v", err)
+		}
+	}
+
+	// Two snapshots of a job; the newer one still refers to p1
+	for i, name := range []string{"s-old", "p1"} {
+		fileID := remote[name]
+		id, err := db.CreateSnapshot("db", now.Add(time.Duration(i-1)*time.Hour))
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		if err := db.AddSnapshotFile(database.SnapshotFile{SnapshotID: id, FilePath: "/dumps/db.sql", FileID: fileID, Checksum: fileID}); err != nil {
+			t.Fatalf("failed to add snapshot file: %v", err)
+		}
+		if err := db.FinishSnapshot(id, database.SnapshotSuccess, ""); err != nil {
+			t.Fatalf("failed to finish snapshot: %v", err)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Prune.KeepLast = 1
+	jobs := []Job{{Name: "db", Keep: KeepRules{Last: 1}}}
+	pruner, err := NewPruner(db, zap.NewNop(), cfg, jobs, []Target{primary, nas})
+	if err != nil {
+		t.Fatalf("failed to create pruner: %v", err)
+	}
+
+	result, err := pruner.Prune(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(result.Versions) != 2 || result.Kept != 1 || len(result.Snapshots) != 1 {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if record, _ := db.GetBackupRecord("/data/a.txt", remote["p1"]); record == nil {
+		t.Error("dry run removed a version")
+	}
+
+	result, err = pruner.Prune(ctx, false)
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	// Both copies are deleted from the local target and p2 and s-old from
+	// the primary; p1 is still referenced
+	if result.Deleted != 4 || result.Deferred != 0 || result.Failed != 0 {
+		t.Errorf("unexpected deletions: %+v", result)
+	}
+	for i, copyID := range copies {
+		_, err := os.Stat(filepath.Join(nasDir, filepath.FromSlash(copyID)))
+		if exists := err == nil; exists != (i == 2) {
+			t.Errorf("copy %d: exists %v", i, exists)
+		}
+	}
+
+	if record, _ := db.GetBackupRecord("/data/a.txt", remote["p3"]); record == nil {
+		t.Error("newest version was removed")
+	}
+	if snapshots, _ := db.ListSnapshots("db"); len(snapshots) != 1 {
+		t.Errorf("expected 1 snapshot, got %d", len(snapshots))
+	}
+
+	for name, fileID := range remote {
+		_, exists := server.File(fileID)
+		if kept := name == "p1" || name == "p3"; exists != kept {
+			t.Errorf("primary object %s: exists %v", name, exists)
+		}
+	}
+
+	pending, err := db.PendingDeletions()
+	if err != nil {
+		t.Fatalf("failed to list deletions: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("unexpected deletion queue: %+v", pending)
+	}
+}
@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// remoteDirLocks serializes the creation of each remote directory, so that
+// the workers of the service and the job runner never create the same
+// directory twice. Directories already created are looked up without it.
+var remoteDirLocks = pathLocks{locks: make(map[string]*pathLock)}
+
+// pathLocks holds a mutex per path, kept while it is held or waited for.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks the mutex of key and returns the function unlocking it.
+func (l *pathLocks) lock(key string) func() {
+	l.mu.Lock()
+	pl := l.locks[key]
+	if pl == nil {
+		pl = &pathLock{}
+		l.locks[key] = pl
+	}
+	pl.waiters++
+	l.mu.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+		l.mu.Lock()
+		if pl.waiters--; pl.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// RemoteTree mirrors local directories as Koneksi subdirectories of the
+// client's directory. A local directory /home/user/docs is mirrored as
+// home/user/docs, C:\Users as C/Users. The IDs of created directories are
+// cached in the database, so each one is created only once.
+type RemoteTree struct {
+	client *api.Client
+	db     *database.DB
+}
+
+func NewRemoteTree(client *api.Client, db *database.DB) *RemoteTree {
+	return &RemoteTree{client: client, db: db}
+}
+
+// remoteTreeFromConfig returns the tree for backup.remote_layout mirror, or
+// nil when files are uploaded flat.
+func remoteTreeFromConfig(client *api.Client, cfg *config.Config, db *database.DB) *RemoteTree {
+	if cfg.Backup.RemoteLayout != config.LayoutMirror {
+		return nil
+	}
+	return NewRemoteTree(client, db)
+}
+
+// DirectoryFor returns the ID of the remote directory mirroring the local
+// directory dir, creating it and any missing parents.
+func (t *RemoteTree) DirectoryFor(ctx context.Context, dir string) (string, error) {
+	parts, err := remotePath(dir)
+	if err != nil {
+		return "", err
+	}
+
+	baseID := t.client.DirectoryID
+	if len(parts) == 0 {
+		return baseID, nil
+	}
+	directoryID, err := t.db.GetRemoteDirectory(baseID, path.Join(parts...))
+	if err != nil || directoryID != "" {
+		return directoryID, err
+	}
+
+	parentID := baseID
+	for i := range parts {
+		key := path.Join(parts[:i+1]...)
+		directoryID, err := t.db.GetRemoteDirectory(baseID, key)
+		if err != nil {
+			return "", err
+		}
+		if directoryID == "" {
+			if directoryID, err = t.createDirectory(ctx, parentID, parts[:i+1], dir); err != nil {
+				return "", err
+			}
+		}
+		parentID = directoryID
+	}
+	return parentID, nil
+}
+
+// createDirectory creates the remote directory named parts in its parent
+// parentID, unless another worker created it meanwhile, and returns its ID.
+func (t *RemoteTree) createDirectory(ctx context.Context, parentID string, parts []string, dir string) (string, error) {
+	baseID := t.client.DirectoryID
+	key := path.Join(parts...)
+	unlock := remoteDirLocks.lock(baseID + "/" + key)
+	defer unlock()
+
+	directoryID, err := t.db.GetRemoteDirectory(baseID, key)
+	if err != nil || directoryID != "" {
+		return directoryID, err
+	}
+
+	resp, err := t.client.CreateSubdirectory(ctx, parentID, parts[len(parts)-1], "Mirror of "+dir)
+	if err != nil {
+		if errors.Is(err, api.ErrNotFound) && len(parts) > 1 {
+			// A cached parent was deleted remotely. It is created
+			// again on the next attempt, so the error is not
+			// wrapped to keep it retryable
+			parent := path.Join(parts[:len(parts)-1]...)
+			if forgetErr := t.db.DeleteRemoteDirectory(baseID, parent); forgetErr != nil {
+				return "", forgetErr
+			}
+			return "", fmt.Errorf("remote directory %s no longer exists: %v", parent, err)
+		}
+		return "", fmt.Errorf("failed to create remote directory %s: %w", key, err)
+	}
+	if err := t.db.SetRemoteDirectory(baseID, key, resp.DirectoryID); err != nil {
+		return "", err
+	}
+	return resp.DirectoryID, nil
+}
+
+// Forget drops the cached IDs of the remote directory mirroring dir and its
+// descendants, so that they are created again when next needed.
+func (t *RemoteTree) Forget(dir string) error {
+	parts, err := remotePath(dir)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(parts...)
+	unlock := remoteDirLocks.lock(t.client.DirectoryID + "/" + key)
+	defer unlock()
+	return t.db.DeleteRemoteDirectory(t.client.DirectoryID, key)
+}
+
+// Upload uploads a file into the remote directory mirroring its local
+// directory dir. When that directory was deleted remotely it is created
+// again and the upload repeated once.
+func (t *RemoteTree) Upload(ctx context.Context, dir, name string, data io.ReadSeeker, size int64, checksum string) (*api.FileUploadResponse, error) {
+	directoryID, err := t.DirectoryFor(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.client.UploadFileTo(ctx, directoryID, name, data, size, checksum)
+	if !errors.Is(err, api.ErrNotFound) {
+		return resp, err
+	}
+
+	if err := t.Forget(dir); err != nil {
+		return nil, err
+	}
+	if directoryID, err = t.DirectoryFor(ctx, dir); err != nil {
+		return nil, err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind file: %w", err)
+	}
+	return t.client.UploadFileTo(ctx, directoryID, name, data, size, checksum)
+}
+
+// remotePath splits an absolute local directory into the names of the
+// remote directories mirroring it. A Windows volume becomes a directory
+// named after its drive letter.
+func remotePath(dir string) ([]string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	volume := filepath.VolumeName(absDir)
+	rest := filepath.ToSlash(strings.TrimPrefix(absDir, volume))
+
+	var parts []string
+	if volume = strings.Trim(strings.TrimSuffix(volume, ":"), `\/`); volume != "" {
+		parts = append(parts, strings.ReplaceAll(filepath.ToSlash(volume), "/", "_"))
+	}
+	for _, name := range strings.Split(rest, "/") {
+		if name != "" {
+			parts = append(parts, name)
+		}
+	}
+	return parts, nil
+}
+
+// localPath returns the local directory mirrored by the remote directories
+// named parts, as split by remotePath.
+func localPath(parts []string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	if runtime.GOOS == "windows" && len(parts[0]) == 1 {
+		return filepath.Join(append([]string{parts[0] + `:\`}, parts[1:]...)...)
+	}
+	return filepath.FromSlash("/" + path.Join(parts...))
+}
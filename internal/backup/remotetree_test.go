@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestBackupService_MirrorLayout(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+	cfg.Backup.RemoteLayout = config.LayoutMirror
+
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	root := t.TempDir()
+	backupFile := func(path, content string) error {
+		writeTestFile(t, path, content)
+		return service.processBackup(context.Background(), BackupTask{FilePath: path, Operation: "create", Size: int64(len(content))})
+	}
+
+	docs := filepath.Join(root, "docs")
+	if err := backupFile(filepath.Join(docs, "a.txt"), "first"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	created := server.DirectoriesCreated()
+
+	// A sibling file reuses the cached directories
+	if err := backupFile(filepath.Join(docs, "b.txt"), "second"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if got := server.DirectoriesCreated(); got != created {
+		t.Errorf("expected no new directories, got %d instead of %d", got, created)
+	}
+
+	parts, _ := remotePath(docs)
+	want := "base/" + strings.Join(parts, "/")
+	uploadedInto := func(name string) string {
+		file, _ := server.FindFile(name)
+		return server.Path(file.DirectoryID)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got := uploadedInto(name); got != want {
+			t.Errorf("%s uploaded into %q, want %q", name, got, want)
+		}
+	}
+
+	// A mirrored directory deleted remotely is created again
+	file, _ := server.FindFile("a.txt")
+	server.DeleteDirectoryTree(file.DirectoryID)
+	if err := backupFile(filepath.Join(docs, "c.txt"), "third"); err != nil {
+		t.Fatalf("backup after remote deletion failed: %v", err)
+	}
+	if got := uploadedInto("c.txt"); got != want {
+		t.Errorf("c.txt uploaded into %q, want %q", got, want)
+	}
+}
+
+func TestRemoteTreeConcurrentDirectories(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, zap.NewNop())
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Workers of the service and of the job runner share the directories
+	trees := []*RemoteTree{NewRemoteTree(client, db), NewRemoteTree(client, db)}
+	root := t.TempDir()
+	dirs := []string{filepath.Join(root, "a"), filepath.Join(root, "b")}
+	created := server.DirectoriesCreated()
+	ids := make([]string, 8)
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = trees[i%2].DirectoryFor(context.Background(), dirs[i%2])
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("DirectoryFor failed: %v", err)
+		}
+		if ids[i] != ids[i%2] {
+			t.Errorf("%s mirrored as %s and %s", dirs[i%2], ids[i], ids[i%2])
+		}
+	}
+	parts, _ := remotePath(root)
+	if got, want := server.DirectoriesCreated()-created, len(parts)+2; got != want {
+		t.Errorf("expected %d directories created, got %d", want, got)
+	}
+}
@@ -0,0 +1,215 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+	"go.uber.org/zap"
+)
+
+// replicationBatch is the number of pending copies loaded at a time.
+const replicationBatch = 20
+
+// replicationInterval bounds how long pending copies wait when the
+// replicator is not woken, for example after a restart.
+const replicationInterval = time.Minute
+
+// TargetStatus describes the copies held by a secondary target.
+type TargetStatus struct {
+	Name    string `json:"name"`
+	Async   bool   `json:"async"`
+	Copied  int    `json:"copied"`
+	Pending int    `json:"pending"`
+	Failed  int    `json:"failed"`
+}
+
+// SetReplicas replaces the secondary targets. Files already backed up are
+// not copied to new targets; pending copies for a removed target fail.
+func (s *Service) SetReplicas(replicas []Replica) {
+	s.mu.Lock()
+	s.replicas = replicas
+	s.mu.Unlock()
+
+	s.notifyReplicator()
+}
+
+// Targets returns the copy counts of every configured secondary target.
+func (s *Service) Targets() ([]TargetStatus, error) {
+	s.mu.RLock()
+	replicas := s.replicas
+	s.mu.RUnlock()
+
+	counts, err := s.db.TargetCopyCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]TargetStatus, 0, len(replicas))
+	for _, replica := range replicas {
+		name := replica.Target.Name()
+		statuses = append(statuses, TargetStatus{
+			Name:    name,
+			Async:   replica.Async,
+			Copied:  counts[name][database.CopySuccess],
+			Pending: counts[name][database.CopyPending],
+			Failed:  counts[name][database.CopyFailed],
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
+
+func (s *Service) notifyReplicator() {
+	select {
+	case s.replicate <- struct{}{}:
+	default:
+	}
+}
+
+// replicateRoutine makes the copies queued for asynchronous replicas. Copies
+// left when the service stops stay pending until the next start.
+func (s *Service) replicateRoutine(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		s.replicatePending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-s.replicate:
+		case <-time.After(replicationInterval):
+		}
+	}
+}
+
+func (s *Service) replicatePending(ctx context.Context) {
+	for {
+		copies, err := s.db.PendingCopies(replicationBatch)
+		if err != nil {
+			s.logger.Error("failed to load pending copies", zap.Error(err))
+			return
+		}
+		if len(copies) == 0 {
+			return
+		}
+
+		for _, c := range copies {
+			if !s.canReplicate(ctx) || !s.replicateCopy(ctx, c) {
+				return
+			}
+		}
+	}
+}
+
+// canReplicate reports whether another copy may be started.
+func (s *Service) canReplicate(ctx context.Context) bool {
+	if ctx.Err() != nil || s.IsPaused() {
+		return false
+	}
+	select {
+	case <-s.stopping:
+		return false
+	default:
+		return true
+	}
+}
+
+// replicateCopy copies a file to an asynchronous replica. The file is read
+// again, so the copy fails when it changed after the primary upload; the
+// newer content is queued by its own backup. Other failures leave the copy
+// pending, retried with backoff. It returns false when the copy was
+// interrupted or could not be saved.
+func (s *Service) replicateCopy(ctx context.Context, pending database.PendingCopy) bool {
+	c := pending.TargetCopy
+	c.Status = database.CopyFailed
+
+	target := s.replicaTarget(c.Target)
+	meta, err := metadata.Capture(pending.FilePath)
+	var checksum string
+	if err == nil {
+		checksum, err = pathChecksum(pending.FilePath, meta)
+	}
+	switch {
+	case target == nil:
+		c.Error = "target is no longer configured"
+	case err != nil:
+		c.Error = fmt.Sprintf("failed to read file: %v", err)
+	case checksum != pending.Checksum:
+		c.Error = "file changed before it was copied"
+	default:
+		c.FileID, err = s.copyToTarget(ctx, target, pending.FilePath, meta, checksum)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Interrupted by shutdown; try again on the next start
+				return false
+			}
+			return s.retryCopy(pending, err)
+		}
+		c.Status, c.Error = database.CopySuccess, ""
+	}
+
+	if c.Status == database.CopySuccess {
+		s.logger.Info("file copied to target", zap.String("path", pending.FilePath), zap.String("target", c.Target))
+	} else {
+		s.logger.Warn("failed to copy file to target", zap.String("path", pending.FilePath), zap.String("target", c.Target), zap.String("error", c.Error))
+	}
+	if err := s.db.SetTargetCopy(c); err != nil {
+		s.logger.Error("failed to save target copy", zap.String("path", pending.FilePath), zap.String("target", c.Target), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// copyToTarget uploads a file to a replica as the backup policy prepares it.
+func (s *Service) copyToTarget(ctx context.Context, target Target, path string, meta *metadata.Metadata, checksum string) (string, error) {
+	payload, err := s.preparePayload(path, meta, s.Policy(path))
+	if err != nil {
+		return "", err
+	}
+	defer payload.Close()
+	return s.uploadCopy(ctx, target, payload, checksum)
+}
+
+// retryCopy schedules the next attempt of a copy that failed with err, after
+// the backoff of the retry policy.
+func (s *Service) retryCopy(pending database.PendingCopy, err error) bool {
+	s.mu.RLock()
+	policy := s.retryPolicy
+	s.mu.RUnlock()
+
+	c := pending.TargetCopy
+	c.Error = err.Error()
+	delay := policy.Backoff(c.Attempts + 1)
+	s.logger.Warn("failed to copy file to target, scheduling retry",
+		zap.String("path", pending.FilePath),
+		zap.String("target", c.Target),
+		zap.Int("attempt", c.Attempts+1),
+		zap.Duration("delay", delay),
+		zap.Error(err),
+	)
+	if err := s.db.RetryTargetCopy(c, time.Now().Add(delay)); err != nil {
+		s.logger.Error("failed to save target copy", zap.String("path", pending.FilePath), zap.String("target", c.Target), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+func (s *Service) replicaTarget(name string) Target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, replica := range s.replicas {
+		if replica.Target.Name() == name {
+			return replica.Target
+		}
+	}
+	return nil
+}
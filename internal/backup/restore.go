@@ -12,6 +12,9 @@ import (
 
 	"github.com/koneksi/backup-cli/internal/api"
 	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"github.com/koneksi/backup-cli/pkg/metadata"
 	"go.uber.org/zap"
 )
 
@@ -19,15 +22,19 @@ type RestoreService struct {
 	client     *api.Client
 	logger     *zap.Logger
 	concurrent int
-	wg         sync.WaitGroup
 	mu         sync.RWMutex
 	progress   *RestoreProgress
+	db         *database.DB
+	targets    map[string]Target
+	noOwner    bool
 }
 
 type RestoreProgress struct {
 	TotalFiles    int
 	RestoredFiles int
 	FailedFiles   int
+	// SkippedFiles are kept as they were because of a conflict
+	SkippedFiles  int
 	TotalSize     int64
 	RestoredSize  int64
 	StartTime     time.Time
@@ -70,6 +77,9 @@ type FileManifestEntry struct {
 	Checksum     string      `json:"checksum"`
 	BackupTime   time.Time   `json:"backup_time"`
 	Permissions  os.FileMode `json:"permissions"`
+	// Metadata is the file's mode, owner, times, link target and extended
+	// attributes; manifests written before it was recorded have none
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
 }
 
 func NewRestoreService(client *api.Client, logger *zap.Logger, concurrent int) *RestoreService {
@@ -84,39 +94,45 @@ func NewRestoreService(client *api.Client, logger *zap.Logger, concurrent int) *
 	}
 }
 
-// RestoreFromManifest restores files based on a backup manifest
-func (r *RestoreService) RestoreFromManifest(ctx context.Context, manifestPath, targetDir string) error {
+// RestoreFromManifest restores files based on a backup manifest. A file
+// already in targetDir with other content is handled as policy says,
+// overwritten by default.
+func (r *RestoreService) RestoreFromManifest(ctx context.Context, manifestPath, targetDir string, policy ConflictPolicy) error {
 	manifest, err := r.loadManifest(manifestPath)
 	if err != nil {
 		return fmt.Errorf("failed to load manifest: %w", err)
 	}
 
+	plan := manifestPlan(manifest, targetDir, policy)
 	r.logger.Info("starting restore from manifest",
 		zap.String("backupID", manifest.BackupID),
 		zap.Int("files", len(manifest.Files)),
 		zap.String("targetDir", targetDir),
+		zap.String("onConflict", string(plan.OnConflict)),
 	)
 
-	// Create restore queue
-	restoreQueue := make(chan FileManifestEntry, len(manifest.Files))
-	for _, file := range manifest.Files {
-		restoreQueue <- file
+	if _, err := r.ExecutePlan(ctx, plan, nil); err != nil {
+		return err
 	}
-	close(restoreQueue)
 
-	r.progress.TotalFiles = len(manifest.Files)
+	// Generate restore report
+	return r.generateRestoreReport(manifest, targetDir)
+}
 
-	// Start worker pool
-	for i := 0; i < r.concurrent; i++ {
-		r.wg.Add(1)
-		go r.restoreWorker(ctx, restoreQueue, targetDir)
+// SetFallback lets the service download a file from a secondary target
+// when the primary download fails. The copies of a file are looked up in db.
+func (r *RestoreService) SetFallback(db *database.DB, targets []Target) {
+	r.db = db
+	r.targets = make(map[string]Target, len(targets))
+	for _, target := range targets {
+		r.targets[target.Name()] = target
 	}
+}
 
-	// Wait for completion
-	r.wg.Wait()
-
-	// Generate restore report
-	return r.generateRestoreReport(manifest, targetDir)
+// SetNoOwner makes the service leave restored files owned by the user
+// restoring them instead of their backed up owner, which only root can set.
+func (r *RestoreService) SetNoOwner(noOwner bool) {
+	r.noOwner = noOwner
 }
 
 // RestoreFile restores a single file by its ID
@@ -182,12 +198,22 @@ func (r *RestoreService) CreateManifestFromReport(reportPath, manifestPath strin
 	// Add successful files to manifest
 	for _, result := range report.Results {
 		if result.Success && result.FileID != "" {
+			// Encrypted files keep their .enc suffix so that restore
+			// --decrypt recognises them
+			filePath := result.FilePath
+			if result.Encrypted {
+				filePath = encryption.GetEncryptedFileName(filePath)
+			}
 			entry := FileManifestEntry{
-				FilePath:   result.FilePath,
+				FilePath:   filePath,
 				FileID:     result.FileID,
 				Size:       result.Size,
 				Checksum:   result.Checksum,
 				BackupTime: result.EndTime,
+				Metadata:   result.Metadata,
+			}
+			if result.Metadata != nil {
+				entry.Permissions = result.Metadata.Mode
 			}
 			manifest.Files = append(manifest.Files, entry)
 		}
@@ -211,83 +237,15 @@ func (r *RestoreService) CreateManifestFromReport(reportPath, manifestPath strin
 	return nil
 }
 
-func (r *RestoreService) restoreWorker(ctx context.Context, queue chan FileManifestEntry, targetDir string) {
-	defer r.wg.Done()
-
-	for entry := range queue {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			r.restoreFile(ctx, entry, targetDir)
-		}
-	}
-}
-
-func (r *RestoreService) restoreFile(ctx context.Context, entry FileManifestEntry, targetDir string) {
-	// Sanitize the file path from the manifest to use only the base name
-	cleanPath := filepath.Base(entry.FilePath)
-
-	// Calculate target path
-	targetPath := filepath.Join(targetDir, cleanPath)
-
-	// Check if file already exists and matches checksum
-	if r.fileExists(targetPath, entry.Checksum) {
-		r.logger.Debug("file already exists with correct checksum, skipping",
-			zap.String("path", targetPath),
-		)
-		r.updateProgress(true, entry.Size)
-		return
-	}
-
-	// Download file
-	fileData, err := r.downloadFile(ctx, entry.FileID)
-	if err != nil {
-		r.logger.Error("failed to download file",
-			zap.String("fileID", entry.FileID),
-			zap.String("path", entry.FilePath),
-			zap.Error(err),
-		)
-		r.recordError(entry.FilePath, entry.FileID, err.Error())
-		r.updateProgress(false, 0)
-		return
-	}
-
-	// Ensure target directory exists
-	targetFileDir := filepath.Dir(targetPath)
-	if err := os.MkdirAll(targetFileDir, 0755); err != nil {
-		r.logger.Error("failed to create directory",
-			zap.String("dir", targetFileDir),
-			zap.Error(err),
-		)
-		r.recordError(entry.FilePath, entry.FileID, err.Error())
-		r.updateProgress(false, 0)
-		return
-	}
-
-	// Write file
-	if err := os.WriteFile(targetPath, fileData, entry.Permissions); err != nil {
-		r.logger.Error("failed to write file",
-			zap.String("path", targetPath),
-			zap.Error(err),
-		)
-		r.recordError(entry.FilePath, entry.FileID, err.Error())
-		r.updateProgress(false, 0)
-		return
-	}
-
-	r.logger.Info("file restored",
-		zap.String("path", targetPath),
-		zap.Int64("size", entry.Size),
-	)
-	r.updateProgress(true, entry.Size)
-}
-
 func (r *RestoreService) downloadFile(ctx context.Context, fileID string) ([]byte, error) {
 	// Use the API client's download method
 	reader, err := r.client.DownloadFile(ctx, fileID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+		var fallbackErr error
+		reader, fallbackErr = r.downloadCopy(ctx, fileID)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
 	}
 	defer reader.Close()
 	
@@ -300,21 +258,39 @@ func (r *RestoreService) downloadFile(ctx context.Context, fileID string) ([]byt
 	return data, nil
 }
 
-func (r *RestoreService) fileExists(path, checksum string) bool {
-	info, err := os.Stat(path)
-	if err != nil || info.IsDir() {
-		return false
+// downloadCopy downloads a file from the first secondary target that has a
+// copy of it.
+func (r *RestoreService) downloadCopy(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if r.db == nil {
+		return nil, fmt.Errorf("no fallback targets")
 	}
 
-	// Calculate checksum of existing file
-	existingChecksum, _ := r.calculateFileChecksum(path)
-	return existingChecksum == checksum
-}
+	copies, err := r.db.CopiesForFileID(fileID)
+	if err != nil {
+		return nil, err
+	}
 
-func (r *RestoreService) calculateFileChecksum(path string) (string, error) {
-	// This would calculate SHA256 checksum
-	// Implementation omitted for brevity
-	return "", nil
+	for _, c := range copies {
+		target, ok := r.targets[c.Target]
+		if !ok {
+			continue
+		}
+		reader, err := target.Download(ctx, c.FileID)
+		if err != nil {
+			r.logger.Warn("failed to download copy",
+				zap.String("fileID", fileID),
+				zap.String("target", c.Target),
+				zap.Error(err),
+			)
+			continue
+		}
+		r.logger.Warn("primary download failed, restoring from target",
+			zap.String("fileID", fileID),
+			zap.String("target", c.Target),
+		)
+		return reader, nil
+	}
+	return nil, fmt.Errorf("no copy of file %s available", fileID)
 }
 
 func (r *RestoreService) loadManifest(path string) (*RestoreManifest, error) {
@@ -343,6 +319,12 @@ func (r *RestoreService) updateProgress(success bool, size int64) {
 	}
 }
 
+func (r *RestoreService) recordSkip() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress.SkippedFiles++
+}
+
 func (r *RestoreService) recordError(filePath, fileID, errMsg string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -371,6 +353,7 @@ func (r *RestoreService) generateRestoreReport(manifest *RestoreManifest, target
 		"total_files":    r.progress.TotalFiles,
 		"restored_files": r.progress.RestoredFiles,
 		"failed_files":   r.progress.FailedFiles,
+		"skipped_files":  r.progress.SkippedFiles,
 		"total_size":     r.progress.TotalSize,
 		"restored_size":  r.progress.RestoredSize,
 		"success_rate":   float64(r.progress.RestoredFiles) / float64(r.progress.TotalFiles) * 100,
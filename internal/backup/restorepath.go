@@ -0,0 +1,478 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/archive"
+	"github.com/koneksi/backup-cli/pkg/compression"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+	"go.uber.org/zap"
+)
+
+// ErrNoMatch is returned by RestorePaths when no backed up file matches.
+var ErrNoMatch = errors.New("no backed up file matches")
+
+// ErrContentMismatch is returned when downloaded data cannot be turned
+// into content with the recorded checksum, usually because the upload was
+// encrypted with a password that was not given.
+var ErrContentMismatch = errors.New("restored content does not match the backup checksum")
+
+// PathRestoreOptions selects what RestorePaths restores and where.
+type PathRestoreOptions struct {
+	// Version restores that version instead of the newest. The pattern
+	// must then match a single file.
+	Version int
+	// To is the directory files are restored below. They keep their paths
+	// relative to the directory containing the pattern, or containing its
+	// first component with a wildcard, so restoring /srv/data/reports to
+	// ./out writes ./out/reports/...
+	To string
+	// InPlace restores files to their original paths
+	InPlace bool
+	// OnConflict decides what happens to a current file with other
+	// content. By default it is renamed with a .bak-<time> suffix when
+	// restoring in place, and overwritten otherwise.
+	OnConflict ConflictPolicy
+	// Passwords are tried in turn to decrypt encrypted uploads
+	Passwords []string
+}
+
+func (o PathRestoreOptions) conflictPolicy() ConflictPolicy {
+	switch {
+	case o.OnConflict != "":
+		return o.OnConflict
+	case o.InPlace:
+		return ConflictRename
+	default:
+		return ConflictOverwrite
+	}
+}
+
+// RestoredFile is a file handled by a restore.
+type RestoredFile struct {
+	Record database.BackupRecord
+	Target string
+	// Action is what the restore did at Target
+	Action RestoreAction
+	// Backup is where the file previously at Target was moved
+	Backup string
+}
+
+// RestorePaths restores the backed up files matching pattern, an absolute
+// path or shell pattern, resolving paths and versions through db. A
+// directory, or a pattern matching one, restores every file below it.
+// Files that fail are recorded in the progress; see GetProgress.
+func (r *RestoreService) RestorePaths(ctx context.Context, db *database.DB, pattern string, opts PathRestoreOptions) ([]RestoredFile, error) {
+	plan, err := pathPlan(db, pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("starting restore by path",
+		zap.String("pattern", pattern),
+		zap.Int("files", len(plan.Files)),
+		zap.Bool("inPlace", opts.InPlace),
+		zap.String("onConflict", string(plan.OnConflict)),
+	)
+	return r.ExecutePlan(ctx, plan, opts.Passwords)
+}
+
+// RestoreRecords restores the given versions of files, like RestorePaths.
+// When restoring to a directory, files keep their paths relative to base.
+func (r *RestoreService) RestoreRecords(ctx context.Context, records []database.BackupRecord, base string, opts PathRestoreOptions) ([]RestoredFile, error) {
+	plan, err := recordsPlan(records, base, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.ExecutePlan(ctx, plan, opts.Passwords)
+}
+
+// pathPlan returns an unresolved plan restoring the files matching
+// pattern.
+func pathPlan(db *database.DB, pattern string, opts PathRestoreOptions) (*RestorePlan, error) {
+	if opts.InPlace == (opts.To != "") {
+		return nil, fmt.Errorf("either a target directory or an in-place restore is required")
+	}
+	records, base, err := matchBackups(db, pattern, opts.Version)
+	// The directories matching are restored as well, so that a directory
+	// with no files left restores too
+	var dirs []database.Directory
+	if opts.Version == 0 && (err == nil || errors.Is(err, ErrNoMatch)) {
+		clean := filepath.Clean(pattern)
+		base = patternBase(clean)
+		dirs, err = matchDirectories(db, clean, base)
+		if err == nil && len(records) == 0 && len(dirs) == 0 {
+			err = fmt.Errorf("%w %s", ErrNoMatch, clean)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := recordsPlan(records, base, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		target, err := restoreTarget(d.Path, base, opts)
+		if err != nil {
+			return nil, err
+		}
+		plan.Directories = append(plan.Directories, PlannedDirectory{
+			Path:    d.Path,
+			Mode:    d.Mode,
+			ModTime: d.ModTime,
+			Owner:   d.Owner,
+			Xattrs:  d.Xattrs,
+			Target:  target,
+		})
+	}
+	return plan, nil
+}
+
+// recordsPlan returns an unresolved plan restoring records, to paths
+// relative to base below opts.To or to their own paths in place.
+func recordsPlan(records []database.BackupRecord, base string, opts PathRestoreOptions) (*RestorePlan, error) {
+	if opts.InPlace == (opts.To != "") {
+		return nil, fmt.Errorf("either a target directory or an in-place restore is required")
+	}
+
+	files := make([]PlannedFile, len(records))
+	for i, record := range records {
+		target, err := restoreTarget(record.FilePath, base, opts)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = PlannedFile{
+			Path:       record.FilePath,
+			Version:    record.Version,
+			FileID:     record.FileID,
+			Checksum:   record.Checksum,
+			Size:       record.OriginalSize,
+			Mode:       record.Mode,
+			ModTime:    record.ModTime,
+			Owner:      record.Owner,
+			LinkTarget: record.LinkTarget,
+			Xattrs:     record.Xattrs,
+			Encrypted:  record.Encrypted,
+			BackupTime: record.BackupTime,
+			Rebuilt:    rebuilt(record),
+			Target:     target,
+		}
+	}
+	return newRestorePlan(files, opts.conflictPolicy()), nil
+}
+
+// restoreTarget returns where path is restored: relative to base below
+// opts.To, or to itself in place.
+func restoreTarget(path, base string, opts PathRestoreOptions) (string, error) {
+	if opts.InPlace {
+		return path, nil
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve target of %s: %w", path, err)
+	}
+	return filepath.Join(opts.To, rel), nil
+}
+
+// matchDirectories returns the backed up directories matching pattern, or
+// within a directory matching it, where base is patternBase(pattern).
+func matchDirectories(db *database.DB, pattern, base string) ([]database.Directory, error) {
+	if !hasMeta(pattern) {
+		return db.DirectoriesUnder(pattern)
+	}
+	candidates, err := db.DirectoriesUnder(base)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []database.Directory
+	for _, d := range candidates {
+		if matchesOrWithin(pattern, base, d.Path) {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+// matchBackups returns the backup records matching pattern and the
+// directory their target paths are relative to.
+func matchBackups(db *database.DB, pattern string, version int) ([]database.BackupRecord, string, error) {
+	pattern = filepath.Clean(pattern)
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	var (
+		records []database.BackupRecord
+		base    = patternBase(pattern)
+		err     error
+	)
+	if !hasMeta(pattern) {
+		if records, err = db.LatestVersionsUnder(pattern, time.Time{}); err != nil {
+			return nil, "", err
+		}
+	} else {
+		candidates, err := db.LatestVersionsUnder(base, time.Time{})
+		if err != nil {
+			return nil, "", err
+		}
+		for _, record := range candidates {
+			if matchesOrWithin(pattern, base, record.FilePath) {
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil, "", fmt.Errorf("%w %s", ErrNoMatch, pattern)
+	}
+
+	if version != 0 {
+		if len(records) != 1 {
+			return nil, "", fmt.Errorf("a version can only be restored for a single file, %s matches %d", pattern, len(records))
+		}
+		record, err := db.GetFileVersion(records[0].FilePath, version)
+		if err != nil {
+			return nil, "", err
+		}
+		if record == nil {
+			return nil, "", fmt.Errorf("%s has no version %d", records[0].FilePath, version)
+		}
+		records = []database.BackupRecord{*record}
+	}
+	return records, base, nil
+}
+
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
+
+// patternBase returns the directory the target paths of the files matching
+// a clean pattern are relative to.
+func patternBase(pattern string) string {
+	if !hasMeta(pattern) {
+		return filepath.Dir(pattern)
+	}
+	return globBase(pattern)
+}
+
+// globBase returns the directory containing the first component of
+// pattern with a wildcard.
+func globBase(pattern string) string {
+	dir := pattern
+	for hasMeta(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// matchesOrWithin reports whether path, or one of its parent directories
+// below base, matches pattern.
+func matchesOrWithin(pattern, base, path string) bool {
+	for p := path; p != base && isWithin(p, base); p = filepath.Dir(p) {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeUpload returns the content of a backed up file from the data
+// uploaded for it. Data uploaded unencrypted is used as is, and encrypted
+// data is decrypted with each password in turn, until, decompressed when it
+// is, it has the recorded checksum. Versions from before encryption was
+// recorded are tried both ways. Rebuilt versions have the checksum of the
+// data itself; see decodeRebuilt.
+func decodeUpload(data []byte, record database.BackupRecord, passwords []string) ([]byte, error) {
+	if rebuilt(record) {
+		content, _, err := decodeRebuilt(data, record, passwords)
+		return content, err
+	}
+	if record.Encrypted == nil || !*record.Encrypted {
+		if content, ok := matchContent(data, record.Checksum); ok {
+			return content, nil
+		}
+		if record.Encrypted != nil {
+			return nil, ErrContentMismatch
+		}
+	}
+	for _, password := range passwords {
+		var decrypted bytes.Buffer
+		if err := encryption.NewEncryptor(password).Decrypt(&decrypted, bytes.NewReader(data)); err != nil {
+			continue
+		}
+		if content, ok := matchContent(decrypted.Bytes(), record.Checksum); ok {
+			return content, nil
+		}
+	}
+	return nil, ErrContentMismatch
+}
+
+// decodeRebuilt returns the content of a version rebuilt from a remote
+// listing, whose checksum is that of the uploaded data, and whether it was
+// compressed. The data is decrypted when its name marked it encrypted, or
+// when a password decrypts it for versions rebuilt before that was recorded,
+// and decompressed when its format says it is compressed.
+func decodeRebuilt(data []byte, record database.BackupRecord, passwords []string) ([]byte, bool, error) {
+	if contentChecksum(data) != record.Checksum {
+		return nil, false, ErrContentMismatch
+	}
+	if record.Encrypted == nil || *record.Encrypted {
+		for _, password := range passwords {
+			var decrypted bytes.Buffer
+			if err := encryption.NewEncryptor(password).Decrypt(&decrypted, bytes.NewReader(data)); err == nil {
+				return decompressed(decrypted.Bytes())
+			}
+		}
+		if record.Encrypted != nil {
+			return nil, false, ErrContentMismatch
+		}
+	}
+	return decompressed(data)
+}
+
+// matchContent returns data, or data decompressed, if it has checksum.
+func matchContent(data []byte, checksum string) ([]byte, bool) {
+	if contentChecksum(data) == checksum {
+		return data, true
+	}
+	plain, ok := decompress(data)
+	if !ok || contentChecksum(plain) != checksum {
+		return nil, false
+	}
+	return plain, true
+}
+
+// decompressed returns data decompressed, or data itself when it is not
+// compressed, and whether it was.
+func decompressed(data []byte) ([]byte, bool, error) {
+	if plain, ok := decompress(data); ok {
+		return plain, true, nil
+	}
+	return data, false, nil
+}
+
+// decompress decompresses data in the format its leading bytes tell.
+func decompress(data []byte) ([]byte, bool) {
+	var format string
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		format = "gzip"
+	case len(data) >= 1 && data[0] == 0x78:
+		format = "zlib"
+	default:
+		return nil, false
+	}
+	compressor, err := compression.NewCompressor(format, 0)
+	if err != nil {
+		return nil, false
+	}
+	plain, err := compressor.Decompress(data)
+	if err != nil {
+		return nil, false
+	}
+	return plain, true
+}
+
+func contentChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeRestoredFile writes content to path through a temporary file, so an
+// interrupted restore does not leave a truncated file, and applies the
+// backed up metadata.
+func (r *RestoreService) writeRestoredFile(path string, content []byte, record database.BackupRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	meta := recordMetadata(record)
+	if meta == nil {
+		meta = &metadata.Metadata{Mode: 0644, ModTime: record.ModTime}
+	}
+	r.applyMetadata(tmp.Name(), meta)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// writeRestoredLink creates the symbolic link of record at path, replacing
+// what is there, and applies the backed up metadata.
+func (r *RestoreService) writeRestoredLink(path string, record database.BackupRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.restore-%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := os.Symlink(record.LinkTarget, tmp); err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	r.applyMetadata(tmp, recordMetadata(record))
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+	return nil
+}
+
+// applyMetadata applies backed up metadata to a restored file. What cannot
+// be applied, such as the owner when not restoring as root, is logged and
+// does not fail the restore.
+func (r *RestoreService) applyMetadata(path string, meta *metadata.Metadata) {
+	if err := metadata.Apply(path, meta, metadata.ApplyOptions{NoOwner: r.noOwner}); err != nil {
+		r.logger.Warn("failed to restore file metadata", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// extractArchive extracts a tar.gz archive held in memory into dir.
+func extractArchive(content []byte, dir string) error {
+	tmp, err := os.CreateTemp("", "koneksi-restore-*.tar.gz")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	if err := archive.DecompressArchive(tmp.Name(), dir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+	return nil
+}
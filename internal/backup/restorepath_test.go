@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestRestorePaths(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	ctx := context.Background()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Reports are compressed and private files encrypted
+	data := filepath.Join(t.TempDir(), "data")
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 2
+	cfg.Backup.Directories = []config.Directory{
+		{Path: filepath.Join(data, "reports"), Compression: &config.Compression{Enabled: true, Format: "gzip"}},
+		{Path: filepath.Join(data, "private"), Encryption: &config.Encryption{Enabled: true, Password: "pw"}},
+	}
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	backupFile := func(rel, content string) string {
+		file := filepath.Join(data, rel)
+		writeTestFile(t, file, content)
+		if err := service.processBackup(ctx, BackupTask{FilePath: file, Operation: "modify", Size: int64(len(content))}); err != nil {
+			t.Fatalf("backup of %s failed: %v", rel, err)
+		}
+		return file
+	}
+	q3 := backupFile("reports/q3.xlsx", "q3 first draft")
+	backupFile("reports/q3.xlsx", "q3 final")
+	backupFile("reports/2024/q4.xlsx", "q4")
+	backupFile("reports/notes.txt", "notes")
+	secret := backupFile("private/key.txt", "secret")
+	if record, _ := db.LatestBackupRecord(q3); record == nil || !record.IsCompressed {
+		t.Fatalf("expected a compressed upload, got %+v", record)
+	}
+
+	restore := func(pattern string, opts PathRestoreOptions) []RestoredFile {
+		t.Helper()
+		restored, err := NewRestoreService(client, logger, 2).RestorePaths(ctx, db, pattern, opts)
+		if err != nil {
+			t.Fatalf("restore of %s failed: %v", pattern, err)
+		}
+		return restored
+	}
+	assertContent := func(path, want string) {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", path, want, got, err)
+		}
+	}
+
+	// An earlier version of one file
+	out := t.TempDir()
+	restored := restore(q3, PathRestoreOptions{Version: 1, To: out})
+	if len(restored) != 1 || restored[0].Record.Version != 1 {
+		t.Fatalf("unexpected restore: %+v", restored)
+	}
+	assertContent(filepath.Join(out, "q3.xlsx"), "q3 first draft")
+
+	// A directory keeps its own name below the target
+	out = t.TempDir()
+	if restored := restore(filepath.Join(data, "reports"), PathRestoreOptions{To: out}); len(restored) != 3 {
+		t.Fatalf("expected 3 files restored, got %+v", restored)
+	}
+	assertContent(filepath.Join(out, "reports", "q3.xlsx"), "q3 final")
+	assertContent(filepath.Join(out, "reports", "2024", "q4.xlsx"), "q4")
+
+	// A pattern restores the files it matches, and the files below the
+	// directories it matches
+	out = t.TempDir()
+	if restored := restore(filepath.Join(data, "*", "*.xlsx"), PathRestoreOptions{To: out}); len(restored) != 1 {
+		t.Fatalf("expected q3 only, got %+v", restored)
+	}
+	assertContent(filepath.Join(out, "reports", "q3.xlsx"), "q3 final")
+	out = t.TempDir()
+	if restored := restore(filepath.Join(data, "reports", "20*"), PathRestoreOptions{To: out}); len(restored) != 1 {
+		t.Fatalf("expected q4 only, got %+v", restored)
+	}
+	assertContent(filepath.Join(out, "2024", "q4.xlsx"), "q4")
+
+	// Encrypted uploads need the password
+	service2 := NewRestoreService(client, logger, 1)
+	if _, err := service2.RestorePaths(ctx, db, secret, PathRestoreOptions{To: t.TempDir()}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if progress := service2.GetProgress(); progress.FailedFiles != 1 || !strings.Contains(progress.Errors[0].Error, ErrContentMismatch.Error()) {
+		t.Errorf("expected the restore without password to fail, got %+v", progress)
+	}
+	out = t.TempDir()
+	restore(secret, PathRestoreOptions{To: out, Passwords: []string{"other", "pw"}})
+	assertContent(filepath.Join(out, "key.txt"), "secret")
+
+	// In place, the current file is kept aside
+	writeTestFile(t, q3, "q3 edited by mistake")
+	restored = restore(q3, PathRestoreOptions{InPlace: true})
+	if len(restored) != 1 || restored[0].Backup == "" {
+		t.Fatalf("expected the current file to be backed up, got %+v", restored)
+	}
+	assertContent(q3, "q3 final")
+	assertContent(restored[0].Backup, "q3 edited by mistake")
+
+	// A file with the content already is left alone
+	if restored := restore(q3, PathRestoreOptions{InPlace: true}); len(restored) != 1 || restored[0].Action != ActionUnchanged {
+		t.Errorf("expected the file to be unchanged, got %+v", restored)
+	}
+
+	if _, err := NewRestoreService(client, logger, 1).RestorePaths(ctx, db, filepath.Join(data, "missing"), PathRestoreOptions{To: out}); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+	if _, err := NewRestoreService(client, logger, 1).RestorePaths(ctx, db, filepath.Join(data, "reports"), PathRestoreOptions{Version: 1, To: out}); err == nil {
+		t.Error("expected a version of several files to be rejected")
+	}
+}
@@ -0,0 +1,602 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/metadata"
+	"go.uber.org/zap"
+)
+
+// ConflictPolicy decides what a restore does with a file already at the
+// target path with other content.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the current file
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the current file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename first renames the current file to <file>.bak-<time>
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictNewer replaces the current file when the backed up version
+	// was modified after it, and keeps it otherwise
+	ConflictNewer ConflictPolicy = "newer"
+)
+
+// ParseConflictPolicy parses the name of a conflict policy.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename, ConflictNewer:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q: must be skip, overwrite, rename or newer", name)
+}
+
+// RestoreAction is what a restore does for one file.
+type RestoreAction string
+
+const (
+	// ActionCreate writes a file where there is none
+	ActionCreate RestoreAction = "create"
+	// ActionUnchanged leaves a file that already has the content
+	ActionUnchanged RestoreAction = "unchanged"
+	// ActionSkip keeps a file with other content
+	ActionSkip RestoreAction = "skip"
+	// ActionOverwrite replaces a file with other content
+	ActionOverwrite RestoreAction = "overwrite"
+	// ActionRename renames a file with other content and writes a new one
+	ActionRename RestoreAction = "rename"
+)
+
+// writes reports whether the action downloads and writes the file.
+func (a RestoreAction) writes() bool {
+	return a == ActionCreate || a == ActionOverwrite || a == ActionRename
+}
+
+// restorePlanVersion is the format version of saved restore plans.
+const restorePlanVersion = 1
+
+// RestorePlan lists what a restore does for each file. Plans are built by
+// PlanPaths and PlanManifest, can be saved as JSON and are carried out by
+// ExecutePlan.
+type RestorePlan struct {
+	Version    int            `json:"version"`
+	CreatedAt  time.Time      `json:"created_at"`
+	OnConflict ConflictPolicy `json:"on_conflict"`
+	Files      []PlannedFile  `json:"files"`
+	// Directories are the backed up directories below the files restored
+	Directories []PlannedDirectory `json:"directories,omitempty"`
+	Summary     PlanSummary        `json:"summary"`
+}
+
+// PlannedFile is a version of a file to restore and what restoring it does.
+type PlannedFile struct {
+	Path       string            `json:"path"`
+	Version    int               `json:"version,omitempty"`
+	FileID     string            `json:"file_id"`
+	Checksum   string            `json:"checksum"`
+	Size       int64             `json:"size"`
+	Mode       os.FileMode       `json:"mode,omitempty"`
+	ModTime    time.Time         `json:"mod_time,omitzero"`
+	Owner      *metadata.Owner   `json:"owner,omitempty"`
+	LinkTarget string            `json:"link_target,omitempty"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+	Encrypted  *bool             `json:"encrypted,omitempty"`
+	BackupTime time.Time         `json:"backup_time,omitzero"`
+	// Raw files are written as downloaded, as restores from a manifest do,
+	// instead of decoded and checked against Checksum
+	Raw bool `json:"raw,omitempty"`
+	// Rebuilt files were recorded from a remote listing: Checksum is that
+	// of the uploaded data, checked before it is decoded
+	Rebuilt bool          `json:"rebuilt,omitempty"`
+	Target  string        `json:"target"`
+	Action  RestoreAction `json:"action,omitempty"`
+	// Existing describes the file at Target when the plan was made
+	Existing *ExistingFile `json:"existing,omitempty"`
+}
+
+// PlannedDirectory is a backed up directory. A restore creates it when it
+// is missing and, once its files are restored, applies its metadata;
+// directories already at their target are left as they are.
+type PlannedDirectory struct {
+	Path    string            `json:"path"`
+	Mode    os.FileMode       `json:"mode"`
+	ModTime time.Time         `json:"mod_time,omitzero"`
+	Owner   *metadata.Owner   `json:"owner,omitempty"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"`
+	Target  string            `json:"target"`
+	// Action is ActionCreate for a missing directory and ActionUnchanged
+	// for one that exists
+	Action RestoreAction `json:"action,omitempty"`
+}
+
+// ExistingFile is a file found at the target of a restore.
+type ExistingFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// PlanSummary totals a restore plan.
+type PlanSummary struct {
+	// Files and Bytes are the files to download and their sizes
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	Conflicts int   `json:"conflicts"`
+	Skipped   int   `json:"skipped"`
+	Unchanged int   `json:"unchanged"`
+	// Directories are the directories to create
+	Directories int `json:"directories"`
+	// Space is the space needed on each filesystem written to
+	Space []SpaceEstimate `json:"space"`
+}
+
+// SpaceEstimate is the space a restore needs on one filesystem.
+type SpaceEstimate struct {
+	// Path is a directory on the filesystem
+	Path string `json:"path"`
+	// Needed is the size of the files written, less the size of the files
+	// they overwrite
+	Needed int64 `json:"needed"`
+	// Free is the space available, or -1 when it is not known
+	Free int64 `json:"free"`
+}
+
+// Sufficient reports whether the filesystem has the space needed, or
+// whether its free space is not known.
+func (s SpaceEstimate) Sufficient() bool {
+	return s.Free < 0 || s.Needed <= s.Free
+}
+
+// record returns the backup record the file is restored from.
+func (f *PlannedFile) record() database.BackupRecord {
+	return database.BackupRecord{
+		FilePath:     f.Path,
+		Version:      f.Version,
+		FileID:       f.FileID,
+		Checksum:     f.Checksum,
+		OriginalSize: f.Size,
+		Mode:         f.Mode,
+		ModTime:      f.ModTime,
+		Owner:        f.Owner,
+		LinkTarget:   f.LinkTarget,
+		Xattrs:       f.Xattrs,
+		Encrypted:    f.Encrypted,
+		BackupTime:   f.BackupTime,
+		Status:       "success",
+		Operation:    f.operation(),
+	}
+}
+
+// operation returns the operation record gives a rebuilt file, so it is
+// decoded as one.
+func (f *PlannedFile) operation() string {
+	if f.Rebuilt {
+		return rebuildOperation
+	}
+	return ""
+}
+
+// isSymlink reports whether the file is a symbolic link, which is restored
+// from LinkTarget without a download.
+func (f *PlannedFile) isSymlink() bool {
+	return f.Mode&os.ModeSymlink != 0 && f.LinkTarget != ""
+}
+
+// resolve decides what restoring the file to its target does under
+// policy, looking at what is at the target now.
+func (f *PlannedFile) resolve(policy ConflictPolicy) error {
+	info, err := os.Lstat(f.Target)
+	if os.IsNotExist(err) {
+		f.Action, f.Existing = ActionCreate, nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", f.Target, err)
+	}
+	f.Existing = &ExistingFile{Size: info.Size(), ModTime: info.ModTime()}
+
+	switch {
+	case f.isSymlink():
+		if link, err := os.Readlink(f.Target); err == nil && link == f.LinkTarget {
+			f.Action = ActionUnchanged
+			return nil
+		}
+	// An archived directory is extracted over a current one
+	case !f.Mode.IsDir() && info.Mode().IsRegular():
+		if checksum, err := checksumFile(f.Target); err == nil && checksum == f.Checksum {
+			f.Action = ActionUnchanged
+			return nil
+		}
+	}
+
+	switch policy {
+	case ConflictSkip:
+		f.Action = ActionSkip
+	case ConflictRename:
+		f.Action = ActionRename
+	case ConflictNewer:
+		modTime := f.ModTime
+		if modTime.IsZero() {
+			modTime = f.BackupTime
+		}
+		if modTime.After(info.ModTime()) {
+			f.Action = ActionOverwrite
+		} else {
+			f.Action = ActionSkip
+		}
+	default:
+		f.Action = ActionOverwrite
+	}
+	return nil
+}
+
+// newRestorePlan returns a plan restoring files under policy. The actions
+// are decided by resolve.
+func newRestorePlan(files []PlannedFile, policy ConflictPolicy) *RestorePlan {
+	return &RestorePlan{
+		Version:    restorePlanVersion,
+		CreatedAt:  time.Now(),
+		OnConflict: policy,
+		Files:      files,
+	}
+}
+
+// resolve decides the action of every file and directory and totals the
+// plan.
+func (p *RestorePlan) resolve() error {
+	for i := range p.Files {
+		if err := p.Files[i].resolve(p.OnConflict); err != nil {
+			return err
+		}
+	}
+	p.Summary = summarizePlan(p.Files)
+	for i := range p.Directories {
+		d := &p.Directories[i]
+		if _, err := os.Lstat(d.Target); os.IsNotExist(err) {
+			d.Action = ActionCreate
+			p.Summary.Directories++
+		} else {
+			d.Action = ActionUnchanged
+		}
+	}
+	return nil
+}
+
+func summarizePlan(files []PlannedFile) PlanSummary {
+	var summary PlanSummary
+	space := make(map[string]*SpaceEstimate)
+	// The filesystems of directories already looked up
+	filesystems := make(map[string]string)
+
+	for _, f := range files {
+		switch f.Action {
+		case ActionUnchanged:
+			summary.Unchanged++
+			continue
+		case ActionSkip:
+			summary.Skipped++
+			summary.Conflicts++
+			continue
+		case ActionOverwrite, ActionRename:
+			summary.Conflicts++
+		}
+		summary.Files++
+		summary.Bytes += f.Size
+
+		needed := f.Size
+		if f.Action == ActionOverwrite && f.Existing != nil && !f.Mode.IsDir() {
+			needed -= f.Existing.Size
+		}
+
+		dir := existingDir(filepath.Dir(f.Target))
+		fs, ok := filesystems[dir]
+		if !ok {
+			free, id, err := diskSpace(dir)
+			if err != nil {
+				free, id = -1, dir
+			}
+			fs = id
+			filesystems[dir] = fs
+			if space[fs] == nil {
+				space[fs] = &SpaceEstimate{Path: dir, Free: free}
+			}
+		}
+		space[fs].Needed += needed
+	}
+
+	for _, estimate := range space {
+		estimate.Needed = max(estimate.Needed, 0)
+		summary.Space = append(summary.Space, *estimate)
+	}
+	sort.Slice(summary.Space, func(i, j int) bool { return summary.Space[i].Path < summary.Space[j].Path })
+	return summary
+}
+
+// existingDir returns dir, or its deepest parent that exists.
+func existingDir(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// Save writes the plan to path as JSON.
+func (p *RestorePlan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal restore plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write restore plan: %w", err)
+	}
+	return nil
+}
+
+// LoadRestorePlan reads a plan saved by RestorePlan.Save.
+func LoadRestorePlan(path string) (*RestorePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore plan: %w", err)
+	}
+	var plan RestorePlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse restore plan: %w", err)
+	}
+	if plan.Version != restorePlanVersion {
+		return nil, fmt.Errorf("unsupported restore plan version %d", plan.Version)
+	}
+	if _, err := ParseConflictPolicy(string(plan.OnConflict)); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// PlanPaths plans restoring the backed up files matching patterns, like
+// RestorePaths, without writing anything.
+func (r *RestoreService) PlanPaths(db *database.DB, patterns []string, opts PathRestoreOptions) (*RestorePlan, error) {
+	var (
+		files []PlannedFile
+		dirs  []PlannedDirectory
+	)
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		plan, err := pathPlan(db, pattern, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range plan.Files {
+			if !seen[f.Target] {
+				seen[f.Target] = true
+				files = append(files, f)
+			}
+		}
+		for _, d := range plan.Directories {
+			if !seen[d.Target] {
+				seen[d.Target] = true
+				dirs = append(dirs, d)
+			}
+		}
+	}
+
+	plan := newRestorePlan(files, opts.conflictPolicy())
+	plan.Directories = dirs
+	if err := plan.resolve(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanManifest plans restoring the files of a manifest to targetDir, like
+// RestoreFromManifest, without writing anything.
+func (r *RestoreService) PlanManifest(manifestPath, targetDir string, policy ConflictPolicy) (*RestorePlan, error) {
+	manifest, err := r.loadManifest(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+	plan := manifestPlan(manifest, targetDir, policy)
+	if err := plan.resolve(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// manifestPlan returns an unresolved plan restoring the files of manifest
+// to targetDir under their base names.
+func manifestPlan(manifest *RestoreManifest, targetDir string, policy ConflictPolicy) *RestorePlan {
+	if policy == "" {
+		policy = ConflictOverwrite
+	}
+	files := make([]PlannedFile, 0, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		f := PlannedFile{
+			Path:       entry.FilePath,
+			FileID:     entry.FileID,
+			Checksum:   entry.Checksum,
+			Size:       entry.Size,
+			Mode:       entry.Permissions,
+			BackupTime: entry.BackupTime,
+			Raw:        true,
+			// Manifest paths are not trusted: only the base name is used
+			Target: filepath.Join(targetDir, filepath.Base(entry.FilePath)),
+		}
+		if m := entry.Metadata; m != nil {
+			f.Mode, f.ModTime, f.Owner, f.LinkTarget, f.Xattrs = m.Mode, m.ModTime, m.Owner, m.Link, m.Xattrs
+		}
+		files = append(files, f)
+	}
+	return newRestorePlan(files, policy)
+}
+
+// ExecutePlan carries out a restore plan. Files planned to be skipped are
+// left alone; the others are checked again under the plan's conflict
+// policy, as their targets may have changed since planning. Missing
+// directories are created first and get their metadata last, so that a
+// read-only directory is filled before it becomes read-only. Files that
+// fail are recorded in the progress; see GetProgress.
+func (r *RestoreService) ExecutePlan(ctx context.Context, plan *RestorePlan, passwords []string) ([]RestoredFile, error) {
+	created := r.createDirectories(plan.Directories)
+
+	r.mu.Lock()
+	r.progress.TotalFiles += len(plan.Files)
+	for _, f := range plan.Files {
+		r.progress.TotalSize += f.Size
+	}
+	r.mu.Unlock()
+
+	results := make([]*RestoredFile, len(plan.Files))
+	queue := make(chan int, len(plan.Files))
+	for i := range plan.Files {
+		queue <- i
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(r.concurrent, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				f := plan.Files[i]
+				restored, err := r.restorePlanned(ctx, f, plan.OnConflict, passwords)
+				if err != nil {
+					r.logger.Error("failed to restore file",
+						zap.String("path", f.Path),
+						zap.String("fileID", f.FileID),
+						zap.Error(err),
+					)
+					r.recordError(f.Path, f.FileID, err.Error())
+					r.updateProgress(false, 0)
+					continue
+				}
+				results[i] = restored
+				if restored.Action == ActionSkip {
+					r.recordSkip()
+				} else {
+					r.updateProgress(true, f.Size)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil {
+		r.finishDirectories(created)
+	}
+
+	restored := make([]RestoredFile, 0, len(plan.Files))
+	for _, result := range results {
+		if result != nil {
+			restored = append(restored, *result)
+		}
+	}
+	return restored, ctx.Err()
+}
+
+// restorePlanned restores one file of a plan. An archived directory is
+// extracted into the target.
+func (r *RestoreService) restorePlanned(ctx context.Context, f PlannedFile, policy ConflictPolicy, passwords []string) (*RestoredFile, error) {
+	if f.Action != ActionSkip {
+		if err := f.resolve(policy); err != nil {
+			return nil, err
+		}
+	}
+	record := f.record()
+	restored := &RestoredFile{Record: record, Target: f.Target, Action: f.Action}
+	if !f.Action.writes() {
+		return restored, nil
+	}
+
+	var content []byte
+	if !f.isSymlink() {
+		data, err := r.downloadFile(ctx, f.FileID)
+		if err != nil {
+			return nil, err
+		}
+		content = data
+		if !f.Raw {
+			if content, err = decodeUpload(data, record, passwords); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if f.Action == ActionRename {
+		restored.Backup = f.Target + ".bak-" + time.Now().Format("20060102-150405")
+		if err := os.Rename(f.Target, restored.Backup); err != nil {
+			return nil, fmt.Errorf("failed to back up current file: %w", err)
+		}
+	}
+
+	var err error
+	switch {
+	case f.isSymlink():
+		err = r.writeRestoredLink(f.Target, record)
+	case f.Mode.IsDir():
+		err = extractArchive(content, f.Target)
+	default:
+		err = r.writeRestoredFile(f.Target, content, record)
+	}
+	if err != nil {
+		// Put the current file back
+		if restored.Backup != "" {
+			os.RemoveAll(f.Target)
+			os.Rename(restored.Backup, f.Target)
+		}
+		return nil, err
+	}
+
+	r.logger.Info("file restored",
+		zap.String("path", f.Path),
+		zap.Int("version", f.Version),
+		zap.String("target", f.Target),
+		zap.String("action", string(f.Action)),
+	)
+	return restored, nil
+}
+
+// createDirectories creates the planned directories that are missing and
+// returns them.
+func (r *RestoreService) createDirectories(dirs []PlannedDirectory) []PlannedDirectory {
+	var created []PlannedDirectory
+	for _, d := range dirs {
+		if _, err := os.Lstat(d.Target); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(d.Target, 0755); err != nil {
+			r.logger.Warn("failed to create directory", zap.String("path", d.Target), zap.Error(err))
+			continue
+		}
+		created = append(created, d)
+	}
+	return created
+}
+
+// finishDirectories applies the metadata of the created directories, the
+// deepest first, as filling a directory changes its modification time.
+func (r *RestoreService) finishDirectories(created []PlannedDirectory) {
+	sort.Slice(created, func(i, j int) bool { return created[i].Target > created[j].Target })
+	for _, d := range created {
+		r.applyMetadata(d.Target, &metadata.Metadata{
+			Mode:    d.Mode,
+			ModTime: d.ModTime,
+			Owner:   d.Owner,
+			Xattrs:  d.Xattrs,
+		})
+	}
+}
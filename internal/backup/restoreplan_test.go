@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestRestorePlanConflictPolicies(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+
+	src := filepath.Join(t.TempDir(), "src")
+	hour := time.Now().Add(-time.Hour)
+	record := func(name, content string, modTime time.Time) database.BackupRecord {
+		return database.BackupRecord{
+			FilePath:     filepath.Join(src, name),
+			Version:      1,
+			FileID:       server.AddFile(base, name, []byte(content)),
+			Checksum:     contentChecksum([]byte(content)),
+			OriginalSize: int64(len(content)),
+			ModTime:      modTime,
+			Status:       "success",
+		}
+	}
+	records := []database.BackupRecord{
+		record("new.txt", "new", hour),
+		record("same.txt", "same", hour),
+		record("old.txt", "backed up an hour ago", hour),
+		record("future.txt", "backed up later", time.Now().Add(time.Hour)),
+	}
+
+	// Every file but new.txt is at the target already
+	out := t.TempDir()
+	writeTestFile(t, filepath.Join(out, "same.txt"), "same")
+	writeTestFile(t, filepath.Join(out, "old.txt"), "edited since")
+	writeTestFile(t, filepath.Join(out, "future.txt"), "edited before")
+
+	plan := func(policy ConflictPolicy) *RestorePlan {
+		t.Helper()
+		p, err := recordsPlan(records, src, PathRestoreOptions{To: out, OnConflict: policy})
+		if err != nil {
+			t.Fatalf("failed to plan: %v", err)
+		}
+		if err := p.resolve(); err != nil {
+			t.Fatalf("failed to resolve plan: %v", err)
+		}
+		return p
+	}
+	actions := func(p *RestorePlan) []RestoreAction {
+		var got []RestoreAction
+		for _, f := range p.Files {
+			got = append(got, f.Action)
+		}
+		return got
+	}
+
+	tests := []struct {
+		policy ConflictPolicy
+		want   []RestoreAction
+	}{
+		{ConflictSkip, []RestoreAction{ActionCreate, ActionUnchanged, ActionSkip, ActionSkip}},
+		{ConflictOverwrite, []RestoreAction{ActionCreate, ActionUnchanged, ActionOverwrite, ActionOverwrite}},
+		{ConflictRename, []RestoreAction{ActionCreate, ActionUnchanged, ActionRename, ActionRename}},
+		{ConflictNewer, []RestoreAction{ActionCreate, ActionUnchanged, ActionSkip, ActionOverwrite}},
+	}
+	for _, tt := range tests {
+		p := plan(tt.policy)
+		got := actions(p)
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected %v, got %v", tt.policy, tt.want, got)
+				break
+			}
+		}
+	}
+
+	// The summary counts the downloads and the space they need: the new
+	// file, and the overwritten file less the one it replaces
+	p := plan(ConflictNewer)
+	s := p.Summary
+	if s.Files != 2 || s.Bytes != 3+15 || s.Conflicts != 2 || s.Skipped != 1 || s.Unchanged != 1 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+	if len(s.Space) != 1 || s.Space[0].Needed != 3+15-13 || !s.Space[0].Sufficient() {
+		t.Errorf("unexpected space estimate: %+v", s.Space)
+	}
+
+	// A saved plan runs later; nothing is written before
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Save(planFile); err != nil {
+		t.Fatalf("failed to save plan: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "new.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected planning not to restore, got %v", err)
+	}
+	loaded, err := LoadRestorePlan(planFile)
+	if err != nil {
+		t.Fatalf("failed to load plan: %v", err)
+	}
+
+	service := NewRestoreService(client, logger, 2)
+	restored, err := service.ExecutePlan(context.Background(), loaded, nil)
+	if err != nil {
+		t.Fatalf("failed to run plan: %v", err)
+	}
+	if len(restored) != 4 {
+		t.Fatalf("expected 4 files handled, got %+v", restored)
+	}
+	for path, want := range map[string]string{
+		"new.txt":    "new",
+		"same.txt":   "same",
+		"old.txt":    "edited since",
+		"future.txt": "backed up later",
+	} {
+		if data, err := os.ReadFile(filepath.Join(out, path)); err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", path, want, data, err)
+		}
+	}
+	if progress := service.GetProgress(); progress.RestoredFiles != 3 || progress.SkippedFiles != 1 || progress.FailedFiles != 0 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+
+	// Renaming keeps the current file aside
+	writeTestFile(t, filepath.Join(out, "old.txt"), "edited again")
+	restored, err = service.ExecutePlan(context.Background(), plan(ConflictRename), nil)
+	if err != nil {
+		t.Fatalf("failed to run plan: %v", err)
+	}
+	for _, f := range restored {
+		if f.Record.FilePath != records[2].FilePath {
+			continue
+		}
+		if f.Action != ActionRename || f.Backup == "" {
+			t.Fatalf("expected old.txt to be renamed, got %+v", f)
+		}
+		if data, _ := os.ReadFile(f.Backup); string(data) != "edited again" {
+			t.Errorf("unexpected kept file %q", data)
+		}
+	}
+}
+
+func TestPlanManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := RestoreManifest{
+		Version: "1.0",
+		Files: []FileManifestEntry{
+			{FilePath: "/srv/a.txt", FileID: "f1", Size: 5, Checksum: contentChecksum([]byte("hello"))},
+			{FilePath: "/srv/nested/b.txt", FileID: "f2", Size: 7},
+		},
+	}
+	data, _ := json.Marshal(manifest)
+	manifestFile := filepath.Join(dir, "manifest.json")
+	if err := os.WriteFile(manifestFile, data, 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	out := t.TempDir()
+	writeTestFile(t, filepath.Join(out, "b.txt"), "current")
+
+	plan, err := NewRestoreService(nil, zap.NewNop(), 1).PlanManifest(manifestFile, out, ConflictSkip)
+	if err != nil {
+		t.Fatalf("failed to plan: %v", err)
+	}
+	if len(plan.Files) != 2 || plan.Files[0].Action != ActionCreate || plan.Files[1].Action != ActionSkip {
+		t.Fatalf("unexpected plan: %+v", plan.Files)
+	}
+	if f := plan.Files[1]; f.Target != filepath.Join(out, "b.txt") || !f.Raw || f.Existing == nil || f.Existing.Size != 7 {
+		t.Errorf("unexpected planned file: %+v", f)
+	}
+	if plan.Summary.Files != 1 || plan.Summary.Bytes != 5 || plan.Summary.Conflicts != 1 {
+		t.Errorf("unexpected summary: %+v", plan.Summary)
+	}
+
+	if _, err := ParseConflictPolicy("replace"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/config"
+)
+
+// Keep reasons reported by KeepRules.Select.
+const (
+	KeepLast    = "last"
+	KeepHourly  = "hourly"
+	KeepDaily   = "daily"
+	KeepWeekly  = "weekly"
+	KeepMonthly = "monthly"
+	KeepWithin  = "within"
+)
+
+// KeepRules select the versions of a file or the snapshots of a job that
+// are kept. An item is kept when any rule selects it; zero disables a
+// rule.
+type KeepRules struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	// Within keeps items younger than the newest item minus Within
+	Within time.Duration
+}
+
+func keepRulesFromConfig(k config.KeepPolicy) (KeepRules, error) {
+	within, err := k.Within()
+	if err != nil {
+		return KeepRules{}, err
+	}
+	return KeepRules{
+		Last:    k.KeepLast,
+		Hourly:  k.KeepHourly,
+		Daily:   k.KeepDaily,
+		Weekly:  k.KeepWeekly,
+		Monthly: k.KeepMonthly,
+		Within:  within,
+	}, nil
+}
+
+// IsZero reports whether no rule is set. Callers keep everything then.
+func (r KeepRules) IsZero() bool {
+	return r == KeepRules{}
+}
+
+// Select returns for each of times, which must be sorted newest first, the
+// reasons it is kept; items with no reason are removed. The hourly, daily,
+// weekly and monthly rules keep the newest item of each of the last n
+// periods that have one. Any rule keeps the newest item.
+func (r KeepRules) Select(times []time.Time) [][]string {
+	reasons := make([][]string, len(times))
+	if len(times) == 0 {
+		return reasons
+	}
+
+	for i := 0; i < r.Last && i < len(times); i++ {
+		reasons[i] = append(reasons[i], KeepLast)
+	}
+
+	buckets := []struct {
+		reason string
+		n      int
+		key    func(time.Time) string
+	}{
+		{KeepHourly, r.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{KeepDaily, r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{KeepWeekly, r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{KeepMonthly, r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, b := range buckets {
+		last, kept := "", 0
+		for i := 0; i < len(times) && kept < b.n; i++ {
+			key := b.key(times[i].Local())
+			if key == last {
+				continue
+			}
+			last = key
+			kept++
+			reasons[i] = append(reasons[i], b.reason)
+		}
+	}
+
+	if r.Within > 0 {
+		cutoff := times[0].Add(-r.Within)
+		for i, t := range times {
+			if t.After(cutoff) || t.Equal(cutoff) {
+				reasons[i] = append(reasons[i], KeepWithin)
+			}
+		}
+	}
+	return reasons
+}
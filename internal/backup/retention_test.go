@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepRulesSelect(t *testing.T) {
+	newest := time.Date(2024, 3, 15, 18, 0, 0, 0, time.Local)
+	// Versions every 12 hours over 70 days, newest first
+	times := make([]time.Time, 140)
+	for i := range times {
+		times[i] = newest.Add(-time.Duration(i) * 12 * time.Hour)
+	}
+
+	tests := []struct {
+		name  string
+		rules KeepRules
+		kept  []int
+	}{
+		{"none", KeepRules{}, nil},
+		{"last", KeepRules{Last: 3}, []int{0, 1, 2}},
+		{"daily", KeepRules{Daily: 3}, []int{0, 2, 4}},
+		{"hourly", KeepRules{Hourly: 2}, []int{0, 1}},
+		{"within", KeepRules{Within: 24 * time.Hour}, []int{0, 1, 2}},
+		// 2024-03-15 is a Friday; the newest version of the two
+		// previous weeks is on Sunday evening
+		{"weekly", KeepRules{Weekly: 3}, []int{0, 10, 24}},
+		// The newest versions of February and January are on the 29th
+		// and 31st
+		{"monthly", KeepRules{Monthly: 3}, []int{0, 30, 88}},
+		{"combined", KeepRules{Last: 2, Daily: 2}, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		var kept []int
+		for i, reasons := range tt.rules.Select(times) {
+			if len(reasons) > 0 {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) != len(tt.kept) {
+			t.Errorf("%s: kept %v, want %v", tt.name, kept, tt.kept)
+			continue
+		}
+		for i := range kept {
+			if kept[i] != tt.kept[i] {
+				t.Errorf("%s: kept %v, want %v", tt.name, kept, tt.kept)
+				break
+			}
+		}
+	}
+
+	reasons := KeepRules{Last: 1, Daily: 1, Monthly: 1}.Select(times)[0]
+	if got := strings.Join(reasons, ","); got != "last,daily,monthly" {
+		t.Errorf("unexpected reasons for the newest version: %s", got)
+	}
+}
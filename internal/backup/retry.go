@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+)
+
+// RetryPolicy controls how failed uploads are rescheduled.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used for settings missing from the configuration.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    time.Hour,
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of failed attempts: exponential growth capped at MaxDelay, with jitter
+// between half and the full delay so failed uploads do not retry in lockstep.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// isPermanent reports whether retrying err cannot succeed: the file is gone
+// or the API rejected the request with a client error. Timeouts and rate
+// limiting are treated as transient.
+func isPermanent(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return false
+		}
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{10, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			delay := policy.Backoff(tt.attempts)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Errorf("attempt %d: delay %v outside [%v, %v]", tt.attempts, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	_, statErr := os.Open("/definitely/does/not/exist")
+
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"missing file", fmt.Errorf("failed to calculate checksum: %w", statErr), true},
+		{"bad request", fmt.Errorf("failed to upload file: %w", &api.APIError{StatusCode: 400}), true},
+		{"rate limited", &api.APIError{StatusCode: 429}, false},
+		{"server error", &api.APIError{StatusCode: 503}, false},
+		{"network error", errors.New("connection reset by peer"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.permanent {
+			t.Errorf("%s: expected permanent=%v, got %v", tt.name, tt.permanent, got)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrJobRunning is returned when a job is started while its previous run
+// is still in progress.
+var ErrJobRunning = errors.New("job is already running")
+
+// schedulerMaxSleep bounds how long the scheduler sleeps, so that clock
+// changes and system suspend are noticed.
+const schedulerMaxSleep = time.Minute
+
+// JobStatus describes a scheduled job.
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Mode     string    `json:"mode"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+}
+
+// Scheduler runs backup jobs on their cron schedules. A job never runs
+// twice at the same time; a run that is due while the previous one is
+// still going is skipped. Runs missed while the daemon was stopped are
+// not caught up.
+type Scheduler struct {
+	runner  *JobRunner
+	logger  *zap.Logger
+	mu      sync.Mutex
+	jobs    map[string]Job
+	next    map[string]time.Time
+	running map[string]bool
+	ctx     context.Context
+	wake    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewScheduler(runner *JobRunner, logger *zap.Logger, jobs []Job) *Scheduler {
+	s := &Scheduler{
+		runner:  runner,
+		logger:  logger,
+		jobs:    make(map[string]Job),
+		next:    make(map[string]time.Time),
+		running: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+	s.SetJobs(jobs)
+	return s
+}
+
+// SetJobs replaces the scheduled jobs. Runs in progress continue; jobs
+// whose schedule is unchanged keep their next run time.
+func (s *Scheduler) SetJobs(jobs []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	next := make(map[string]time.Time, len(jobs))
+	byName := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		byName[job.Name] = job
+		if old, ok := s.jobs[job.Name]; ok && old.Schedule.String() == job.Schedule.String() {
+			next[job.Name] = s.next[job.Name]
+			continue
+		}
+		next[job.Name] = job.Schedule.Next(now)
+	}
+	s.jobs, s.next = byName, next
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the scheduler until ctx is cancelled. Snapshots left running
+// by a previous process are marked as failed first.
+func (s *Scheduler) Start(ctx context.Context) {
+	if n, err := s.runner.db.AbortRunningSnapshots("interrupted before the run finished"); err != nil {
+		s.logger.Error("failed to clean up interrupted snapshots", zap.Error(err))
+	} else if n > 0 {
+		s.logger.Warn("marked interrupted snapshots as failed", zap.Int("count", n))
+	}
+
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	go s.loop(ctx)
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		var earliest time.Time
+		for name, job := range s.jobs {
+			next := s.next[name]
+			if next.IsZero() {
+				continue
+			}
+			if !next.After(now) {
+				if err := s.startLocked(job); err != nil {
+					s.logger.Warn("skipping scheduled job run", zap.String("job", name), zap.Error(err))
+				}
+				next = job.Schedule.Next(now)
+				s.next[name] = next
+			}
+			if !next.IsZero() && (earliest.IsZero() || next.Before(earliest)) {
+				earliest = next
+			}
+		}
+		s.mu.Unlock()
+
+		sleep := schedulerMaxSleep
+		if !earliest.IsZero() && time.Until(earliest) < sleep {
+			sleep = time.Until(earliest)
+		}
+		timer := time.NewTimer(sleep)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// RunNow starts a run of the named job immediately.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("unknown job %q", name)
+	}
+	return s.startLocked(job)
+}
+
+func (s *Scheduler) startLocked(job Job) error {
+	if s.ctx == nil {
+		return fmt.Errorf("scheduler is not running")
+	}
+	if s.running[job.Name] {
+		return ErrJobRunning
+	}
+
+	ctx := s.ctx
+	s.running[job.Name] = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if _, err := s.runner.Run(ctx, job); err != nil {
+			s.logger.Error("backup job failed", zap.String("job", job.Name), zap.Error(err))
+		}
+
+		s.mu.Lock()
+		delete(s.running, job.Name)
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+// Jobs returns the status of every scheduled job, sorted by name.
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for name, job := range s.jobs {
+		statuses = append(statuses, JobStatus{
+			Name:     name,
+			Schedule: job.Schedule.String(),
+			Mode:     job.Mode,
+			NextRun:  s.next[name],
+			Running:  s.running[name],
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Wait blocks until all running jobs have returned. Cancel the context
+// passed to Start first to interrupt them.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
@@ -8,6 +8,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,22 +20,63 @@ import (
 	"github.com/koneksi/backup-cli/internal/report"
 	"github.com/koneksi/backup-cli/pkg/compression"
 	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
+	"github.com/koneksi/backup-cli/pkg/metadata"
 	"go.uber.org/zap"
 )
 
+// leaseDuration is how long a worker owns a queued task before another
+// worker may pick it up. Workers renew the lease while a task is running.
+const leaseDuration = 2 * time.Minute
+
+// queuePollInterval bounds how long idle workers wait before checking the
+// queue for tasks that became due, such as scheduled retries.
+const queuePollInterval = time.Second
+
 type Service struct {
 	client       *api.Client
 	logger       *zap.Logger
 	reporter     *report.Reporter
-	maxFileSize  int64
 	concurrent   int
-	backupQueue  chan BackupTask
+	wake         chan struct{}
+	stopping     chan struct{}
+	stopOnce     sync.Once
 	wg           sync.WaitGroup
 	mu           sync.RWMutex
-	backupState  map[string]*FileBackupState
-	compressor   compression.Compressor
-	compression  bool
+	backupState  *stateCache
+	policies     *policies
+	tree         *RemoteTree
+	replicas     []Replica
+	replicate    chan struct{}
 	db           *database.DB
+	keys         *keyIDs
+	retryPolicy  RetryPolicy
+	paused       bool
+	workers      map[int]*WorkerStatus
+	active       int
+	cancels      map[int]context.CancelFunc
+	ctx          context.Context
+	nextWorker   int
+	quit         map[int]chan struct{}
+	batch        string
+}
+
+// Worker states reported by Workers.
+const (
+	WorkerIdle   = "idle"
+	WorkerBusy   = "busy"
+	WorkerPaused = "paused"
+)
+
+// WorkerStatus describes what a backup worker is doing. Path, Size and
+// Attempt are set while the worker is busy with a task.
+type WorkerStatus struct {
+	ID      int       `json:"id"`
+	State   string    `json:"state"`
+	Path    string    `json:"path,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Since   time.Time `json:"since"`
 }
 
 type BackupTask struct {
@@ -62,32 +106,37 @@ type BackupResult struct {
 	CompressedSize int64
 	Checksum       string
 	Compressed     bool
+	Encrypted      bool
+	Metadata       *metadata.Metadata
 }
 
 func NewService(client *api.Client, logger *zap.Logger, reporter *report.Reporter, cfg *config.Config, db *database.DB) (*Service, error) {
-	var compressor compression.Compressor
-	var err error
-	
-	if cfg.Backup.Compression.Enabled {
-		compressor, err = compression.NewCompressor(cfg.Backup.Compression.Format, cfg.Backup.Compression.Level)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create compressor: %w", err)
-		}
-	} else {
-		compressor, _ = compression.NewCompressor("none", 0)
+	if db == nil {
+		return nil, fmt.Errorf("database is required for the backup queue")
+	}
+
+	policies, err := policiesFromConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	service := &Service{
 		client:      client,
 		logger:      logger,
 		reporter:    reporter,
-		maxFileSize: cfg.Backup.MaxFileSize,
 		concurrent:  cfg.Backup.Concurrent,
-		backupQueue: make(chan BackupTask, 1000),
-		backupState: make(map[string]*FileBackupState),
-		compressor:  compressor,
-		compression: cfg.Backup.Compression.Enabled,
+		wake:        make(chan struct{}, 1),
+		stopping:    make(chan struct{}),
+		backupState: newStateCache(stateCacheSize),
+		policies:    policies,
+		tree:        remoteTreeFromConfig(client, cfg, db),
+		replicate:   make(chan struct{}, 1),
 		db:          db,
+		keys:        newKeyIDs(db),
+		retryPolicy: retryPolicyFromConfig(cfg),
+		workers:     make(map[int]*WorkerStatus),
+		cancels:     make(map[int]context.CancelFunc),
+		quit:        make(map[int]chan struct{}),
 	}
 
 	// Load existing file states from database
@@ -98,30 +147,131 @@ func NewService(client *api.Client, logger *zap.Logger, reporter *report.Reporte
 	return service, nil
 }
 
+func retryPolicyFromConfig(cfg *config.Config) RetryPolicy {
+	policy := DefaultRetryPolicy
+	if cfg.Backup.Retry.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.Backup.Retry.MaxAttempts
+	}
+	if cfg.Backup.Retry.BaseDelay > 0 {
+		policy.BaseDelay = time.Duration(cfg.Backup.Retry.BaseDelay) * time.Second
+	}
+	if cfg.Backup.Retry.MaxDelay > 0 {
+		policy.MaxDelay = time.Duration(cfg.Backup.Retry.MaxDelay) * time.Second
+	}
+	return policy
+}
+
 func (s *Service) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
 	// Start worker pool
-	for i := 0; i < s.concurrent; i++ {
-		s.wg.Add(1)
-		go s.worker(ctx, i)
-	}
+	s.Resize(s.concurrent)
+
+	// Start copying to asynchronous replicas
+	s.wg.Add(1)
+	go s.replicateRoutine(ctx)
 
 	// Start periodic state cleanup
 	go s.cleanupRoutine(ctx)
 }
 
+// Resize grows or shrinks the worker pool to n workers. Workers that are
+// removed finish their current task first, so no queued work is lost.
+func (s *Service) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.concurrent = n
+	if s.ctx == nil {
+		// Not started yet; Start uses the new size
+		return
+	}
+
+	for len(s.quit) < n {
+		id := s.nextWorker
+		s.nextWorker++
+		quit := make(chan struct{})
+		s.quit[id] = quit
+		s.wg.Add(1)
+		go s.worker(s.ctx, id, quit)
+	}
+
+	if len(s.quit) > n {
+		ids := make([]int, 0, len(s.quit))
+		for id := range s.quit {
+			ids = append(ids, id)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+		for _, id := range ids[:len(ids)-n] {
+			close(s.quit[id])
+			delete(s.quit, id)
+		}
+	}
+}
+
+// SetBatch makes the service queue its tasks as part of batch and process
+// only the tasks of that batch, so Stop does not wait for work queued by
+// other processes sharing the database. It must be called before Start.
+func (s *Service) SetBatch(batch string) {
+	s.batch = batch
+}
+
+// Reload applies changed settings: the global and per-directory policies,
+// retry policy and the number of workers. The new policies are built before
+// anything is changed, so an invalid configuration leaves the service as
+// it was.
+func (s *Service) Reload(cfg *config.Config) error {
+	policies, err := policiesFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.retryPolicy = retryPolicyFromConfig(cfg)
+	s.mu.Unlock()
+
+	s.Resize(cfg.Backup.Concurrent)
+	return nil
+}
+
+// Policy returns the backup policy in effect for path.
+func (s *Service) Policy(path string) Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policies.resolve(path)
+}
+
 func (s *Service) ProcessChange(change monitor.FileChange) {
-	// Skip directories for backup
+	policy := s.Policy(change.Path)
+
+	// Skip files excluded by their backup directory
+	if matchesExclude(change.Path, policy.Excludes) {
+		s.logger.Debug("file excluded by directory policy", zap.String("path", change.Path), zap.String("root", policy.Root))
+		return
+	}
+
+	// Directories are not uploaded; their metadata is recorded so that a
+	// restore recreates them, empty ones included
 	if change.IsDir {
-		s.logger.Debug("skipping directory", zap.String("path", change.Path))
+		if change.Operation != "delete" {
+			s.saveDirectory(change.Path)
+		}
 		return
 	}
 
 	// Skip files that are too large
-	if change.Size > s.maxFileSize {
+	if change.Size > policy.MaxFileSize {
 		s.logger.Warn("file too large for backup",
 			zap.String("path", change.Path),
 			zap.Int64("size", change.Size),
-			zap.Int64("maxSize", s.maxFileSize),
+			zap.Int64("maxSize", policy.MaxFileSize),
 		)
 		return
 	}
@@ -146,44 +296,350 @@ func (s *Service) ProcessChange(change monitor.FileChange) {
 		zap.Int64("size", task.Size),
 	)
 
+	// Changes for a path that is already queued are coalesced into one task
+	if err := s.db.EnqueueBatchTask(s.batch, task.FilePath, task.Operation, task.Size); err != nil {
+		s.logger.Error("failed to queue backup task", zap.String("path", task.FilePath), zap.Error(err))
+		return
+	}
+	s.logger.Debug("queued backup task", zap.String("path", task.FilePath))
+	s.notifyWorkers()
+}
+
+// saveDirectory records the metadata of a directory.
+func (s *Service) saveDirectory(path string) {
+	meta, err := metadata.Capture(path)
+	if err != nil {
+		s.logger.Debug("failed to read directory metadata", zap.String("path", path), zap.Error(err))
+		return
+	}
+	if err := s.db.SaveDirectory(directoryRecord(path, meta)); err != nil {
+		s.logger.Error("failed to save directory", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// notifyWorkers wakes one idle worker. A worker that finds a task wakes the
+// next one, so a burst of changes fans out across the pool.
+func (s *Service) notifyWorkers() {
 	select {
-	case s.backupQueue <- task:
-		s.logger.Debug("queued backup task", zap.String("path", task.FilePath))
+	case s.wake <- struct{}{}:
 	default:
-		s.logger.Warn("backup queue full, dropping task", zap.String("path", task.FilePath))
 	}
 }
 
-func (s *Service) worker(ctx context.Context, id int) {
+func (s *Service) worker(ctx context.Context, id int, quit <-chan struct{}) {
 	defer s.wg.Done()
 	s.logger.Info("backup worker started", zap.Int("worker_id", id))
 
+	defer s.removeWorker(id)
+
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			s.logger.Info("backup worker stopping", zap.Int("worker_id", id))
 			return
-		case task, ok := <-s.backupQueue:
-			if !ok {
-				s.logger.Info("backup queue closed, worker stopping", zap.Int("worker_id", id))
+		}
+
+		select {
+		case <-quit:
+			s.logger.Info("backup worker removed from pool", zap.Int("worker_id", id))
+			return
+		default:
+		}
+
+		if !s.beginWork() {
+			s.setWorkerState(id, WorkerPaused, nil)
+			if !s.waitForWork(ctx, id, quit) {
+				return
+			}
+			continue
+		}
+
+		queued, err := s.db.LeaseBatchTask(leaseDuration, s.batch)
+		if err != nil {
+			s.logger.Error("failed to lease backup task", zap.Int("worker_id", id), zap.Error(err))
+		}
+
+		if queued == nil {
+			s.endWork(id)
+			s.setWorkerState(id, WorkerIdle, nil)
+			if !s.waitForWork(ctx, id, quit) {
+				return
+			}
+			continue
+		}
+
+		s.notifyWorkers()
+		s.setWorkerState(id, WorkerBusy, queued)
+		s.runTask(ctx, id, queued)
+		s.endWork(id)
+	}
+}
+
+// beginWork reserves a slot for a worker about to lease a task. It fails
+// while the service is paused, so Drain never misses a task being leased.
+func (s *Service) beginWork() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused {
+		return false
+	}
+	s.active++
+	return true
+}
+
+func (s *Service) endWork(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	delete(s.cancels, id)
+}
+
+// waitForWork blocks an idle or paused worker until it is woken or the
+// poll interval passes. It returns false when the worker should exit, which
+// happens only once the queue has no more ready work.
+func (s *Service) waitForWork(ctx context.Context, id int, quit <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		s.logger.Info("backup worker stopping", zap.Int("worker_id", id))
+		return false
+	case <-quit:
+		s.logger.Info("backup worker removed from pool", zap.Int("worker_id", id))
+		return false
+	case <-s.stopping:
+		s.logger.Info("backup queue drained, worker stopping", zap.Int("worker_id", id))
+		return false
+	case <-s.wake:
+	case <-time.After(queuePollInterval):
+	}
+	return true
+}
+
+// Pause stops workers from starting new tasks. Tasks already running are
+// finished; queued and newly detected changes wait until Resume.
+func (s *Service) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused {
+		s.paused = true
+		s.logger.Info("backup service paused")
+	}
+}
+
+// Drain pauses the service and waits up to timeout for running tasks to
+// finish. Tasks still running after the timeout are cancelled and put back
+// in the queue without counting the attempt, so they restart from the
+// beginning after Resume or the next start. The service stays paused.
+func (s *Service) Drain(timeout time.Duration) error {
+	s.Pause()
+
+	deadline := time.Now().Add(timeout)
+	for s.activeTasks() > 0 {
+		if time.Now().After(deadline) {
+			interrupted := s.cancelTasks()
+			s.logger.Warn("drain timed out, interrupting running uploads", zap.Int("tasks", interrupted))
+			for s.activeTasks() > 0 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return fmt.Errorf("drain timed out after %s: %d upload(s) interrupted and requeued", timeout, interrupted)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.logger.Info("backup service drained")
+	return nil
+}
+
+func (s *Service) activeTasks() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// cancelTasks cancels every running task and returns how many there were.
+func (s *Service) cancelTasks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	return len(s.cancels)
+}
+
+// Resume lets workers pick up queued tasks again.
+func (s *Service) Resume() {
+	s.mu.Lock()
+	wasPaused := s.paused
+	s.paused = false
+	s.mu.Unlock()
+
+	if wasPaused {
+		s.logger.Info("backup service resumed")
+		s.notifyWorkers()
+		s.notifyReplicator()
+	}
+}
+
+// IsPaused reports whether the service is paused.
+func (s *Service) IsPaused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paused
+}
+
+// Workers returns the state of every running worker, ordered by id.
+func (s *Service) Workers() []WorkerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := make([]WorkerStatus, 0, len(s.workers))
+	for _, w := range s.workers {
+		workers = append(workers, *w)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	return workers
+}
+
+// QueueDepth returns the number of tasks waiting in the backup queue,
+// including the ones being processed.
+func (s *Service) QueueDepth() (int, error) {
+	return s.db.QueueDepth()
+}
+
+func (s *Service) setWorkerState(id int, state string, task *database.QueueTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.workers[id]
+	if !ok {
+		w = &WorkerStatus{ID: id}
+		s.workers[id] = w
+	}
+	if w.State == state && task == nil {
+		return
+	}
+
+	*w = WorkerStatus{ID: id, State: state, Since: time.Now()}
+	if task != nil {
+		w.Path = task.FilePath
+		w.Size = task.Size
+		w.Attempt = task.Attempts
+	}
+}
+
+func (s *Service) removeWorker(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.workers, id)
+}
+
+// runTask processes a leased task, keeping the lease alive while it runs,
+// and acknowledges it afterwards. Work interrupted by shutdown or Drain is
+// released back to the queue so it resumes on the next start.
+func (s *Service) runTask(ctx context.Context, workerID int, queued *database.QueueTask) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	s.cancels[workerID] = cancel
+	s.mu.Unlock()
+
+	task := BackupTask{
+		FilePath:  queued.FilePath,
+		Operation: queued.Operation,
+		Timestamp: queued.EnqueuedAt,
+		Size:      queued.Size,
+	}
+
+	s.logger.Info("worker processing backup task",
+		zap.Int("worker_id", workerID),
+		zap.String("path", task.FilePath),
+		zap.Int("attempt", queued.Attempts),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
 				return
+			case <-ticker.C:
+				if err := s.db.RenewLease(queued.ID, leaseDuration); err != nil {
+					s.logger.Warn("failed to renew task lease", zap.String("path", task.FilePath), zap.Error(err))
+				}
 			}
-			s.logger.Info("worker processing backup task", 
-				zap.Int("worker_id", id),
-				zap.String("path", task.FilePath),
-			)
-			s.processBackup(ctx, task)
 		}
+	}()
+
+	backupErr := s.processBackup(ctx, task)
+	close(done)
+
+	if backupErr == nil {
+		if err := s.db.AckTask(queued); err != nil {
+			s.logger.Error("failed to ack backup task", zap.String("path", task.FilePath), zap.Error(err))
+		}
+		return
 	}
+
+	if ctx.Err() != nil {
+		s.logger.Info("backup task interrupted, returning it to the queue", zap.String("path", task.FilePath))
+		if err := s.db.ReleaseTask(queued); err != nil {
+			s.logger.Error("failed to release backup task", zap.String("path", task.FilePath), zap.Error(err))
+		}
+		return
+	}
+
+	s.handleFailure(queued, backupErr)
 }
 
-func (s *Service) processBackup(ctx context.Context, task BackupTask) {
+// handleFailure reschedules a failed task with backoff, or moves it to the
+// dead-letter list when the error is permanent or retries are exhausted.
+func (s *Service) handleFailure(queued *database.QueueTask, backupErr error) {
+	s.mu.RLock()
+	policy := s.retryPolicy
+	s.mu.RUnlock()
+
+	if isPermanent(backupErr) || queued.Attempts >= policy.MaxAttempts {
+		s.logger.Error("backup failed permanently, moving to dead-letter list",
+			zap.String("path", queued.FilePath),
+			zap.Int("attempts", queued.Attempts),
+			zap.Error(backupErr),
+		)
+		if err := s.db.DeadLetterTask(queued, backupErr.Error()); err != nil {
+			s.logger.Error("failed to dead-letter backup task", zap.String("path", queued.FilePath), zap.Error(err))
+		}
+		return
+	}
+
+	delay := policy.Backoff(queued.Attempts)
+	s.logger.Warn("backup failed, scheduling retry",
+		zap.String("path", queued.FilePath),
+		zap.Int("attempt", queued.Attempts),
+		zap.Duration("delay", delay),
+		zap.Error(backupErr),
+	)
+	if err := s.db.RetryTask(queued, time.Now().Add(delay), backupErr.Error()); err != nil {
+		s.logger.Error("failed to schedule retry", zap.String("path", queued.FilePath), zap.Error(err))
+	}
+}
+
+// processBackup backs up a single task. The returned error decides whether
+// the task is retried or dead-lettered; skipped files return nil.
+func (s *Service) processBackup(ctx context.Context, task BackupTask) error {
+	// Resolved again, as the configuration may have been reloaded since
+	// the task was queued
+	policy := s.Policy(task.FilePath)
+	compress := policy.Compress
+
 	result := BackupResult{
 		FilePath:   task.FilePath,
 		Operation:  task.Operation,
 		StartTime:  time.Now(),
 		Size:       task.Size,
-		Compressed: s.compression,
+		Compressed: compress,
+		Encrypted:  policy.Password != "",
 	}
 
 	// Handle delete operations
@@ -204,123 +660,353 @@ func (s *Service) processBackup(ctx context.Context, task BackupTask) {
 		Checksum:       result.Checksum,
 		Compressed:     result.Compressed,
 	})
-		return
+		return nil
 	}
 
-	// Calculate file checksum
-	checksum, err := s.calculateChecksum(task.FilePath)
+	meta, err := metadata.Capture(task.FilePath)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to read file metadata: %w", err)
+		result.EndTime = time.Now()
+		s.reporter.AddResult(s.convertToReportResult(result))
+		return result.Error
+	}
+	result.Metadata = meta
+
+	// Calculate file checksum. A symbolic link is backed up as its target.
+	if meta.IsSymlink() {
+		task.Size = int64(len(meta.Link))
+		result.Size = task.Size
+	}
+	checksum, err := pathChecksum(task.FilePath, meta)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to calculate checksum: %w", err)
 		result.EndTime = time.Now()
 		s.reporter.AddResult(s.convertToReportResult(result))
-		return
+		return result.Error
 	}
 	result.Checksum = checksum
 
-	// Check if file has changed
-	s.mu.RLock()
-	state, exists := s.backupState[task.FilePath]
-	s.mu.RUnlock()
+	keyID, err := s.keys.get(policy.Password)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to derive key ID: %w", err)
+		result.EndTime = time.Now()
+		s.reporter.AddResult(s.convertToReportResult(result))
+		return result.Error
+	}
+
+	latest, err := s.db.LatestBackupRecord(task.FilePath)
+	if err != nil {
+		s.logger.Warn("failed to look up latest version", zap.String("path", task.FilePath), zap.Error(err))
+		latest = nil
+	}
+
+	// Check if file has changed. A file whose metadata changed alone, on a
+	// chmod for example, gets a new version without being uploaded again.
+	state, exists := s.fileState(task.FilePath)
 
-	if exists && state.LastChecksum == checksum {
+	if exists && state.LastChecksum == checksum && state.Status == "success" &&
+		(latest == nil || latest.Checksum != checksum || sameMetadata(*latest, meta)) {
 		s.logger.Debug("file unchanged, skipping backup", zap.String("path", task.FilePath))
-		return
+		return nil
 	}
 
-	// Open file for reading
-	file, err := os.Open(task.FilePath)
+	// Content that reached the primary before, for example on an attempt
+	// that failed on a replica, is not uploaded to it again, unless it was
+	// encrypted otherwise than the policy asks for now
+	record, err := s.db.GetBackupRecord(task.FilePath, checksum)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to open file: %w", err)
-		result.EndTime = time.Now()
-		s.reporter.AddResult(s.convertToReportResult(result))
-		return
+		s.logger.Warn("failed to look up backup record", zap.String("path", task.FilePath), zap.Error(err))
+		record = nil
+	}
+	if record != nil && !sameEncryption(*record, keyID) {
+		record = nil
+	}
+	if record == nil && latest != nil && rebuilt(*latest) {
+		record = s.adoptRebuilt(ctx, *latest, checksum, keyID, policy)
+	}
+	if record != nil && latest != nil && (latest.ID != record.ID || latest.Checksum != record.Checksum || !sameMetadata(*latest, meta)) {
+		// A file reverted to earlier content, with new metadata, or whose
+		// rebuilt version holds its content, is a new version sharing the
+		// earlier uploads
+		reverted := *record
+		setRecordMetadata(&reverted, meta)
+		reverted.SnapshotID = 0
+		reverted.BackupTime = time.Now()
+		reverted.Operation = task.Operation
+		if reverted.ID, err = s.db.InsertBackupRecordFrom(reverted, record.ID); err != nil {
+			s.logger.Error("failed to save backup record to database", zap.Error(err))
+		} else {
+			record = &reverted
+		}
+	}
+	copied := make(map[string]bool)
+	if record != nil {
+		copies, err := s.db.ListTargetCopies(record.ID)
+		if err != nil {
+			s.logger.Warn("failed to look up target copies", zap.String("path", task.FilePath), zap.Error(err))
+		}
+		for _, c := range copies {
+			if c.Status == database.CopySuccess || c.Status == database.CopyPending {
+				copied[c.Target] = true
+			}
+		}
 	}
-	defer file.Close()
 
-	var uploadData io.Reader = file
-	var uploadSize int64 = task.Size
+	s.mu.RLock()
+	replicas := s.replicas
+	s.mu.RUnlock()
 
-	// Compress if enabled
-	if s.compression {
-		compressedData, err := compression.CompressFile(file, s.compressor)
-		if err != nil {
-			result.Error = fmt.Errorf("failed to compress file: %w", err)
+	// The file is read only when a target still needs its content
+	upload := record == nil
+	for _, replica := range replicas {
+		upload = upload || !copied[replica.Target.Name()]
+	}
+	var payload *payload
+	if upload {
+		if payload, err = s.preparePayload(task.FilePath, meta, policy); err != nil {
+			result.Error = err
 			result.EndTime = time.Now()
 			s.reporter.AddResult(s.convertToReportResult(result))
-			return
+			return result.Error
+		}
+		defer payload.Close()
+		if compress {
+			result.CompressedSize = payload.size
 		}
-		
-		uploadData = bytes.NewReader(compressedData)
-		uploadSize = int64(len(compressedData))
-		result.CompressedSize = uploadSize
-		
-		compressionRatio := compression.CompressionRatio(task.Size, uploadSize)
-		s.logger.Debug("file compressed",
-			zap.String("path", task.FilePath),
-			zap.Int64("originalSize", task.Size),
-			zap.Int64("compressedSize", uploadSize),
-			zap.Float64("compressionRatio", compressionRatio),
-		)
-	}
-
-	// Upload file to Koneksi
-	uploadResp, err := s.client.UploadFile(ctx, task.FilePath, uploadData, uploadSize, checksum)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to upload file: %w", err)
-		result.EndTime = time.Now()
-		s.updateBackupState(task.FilePath, "failed", checksum)
-		s.reporter.AddResult(s.convertToReportResult(result))
-		return
 	}
 
-	result.FileID = uploadResp.FileID
-	result.Success = true
-	result.EndTime = time.Now()
-
-	s.updateBackupState(task.FilePath, "success", checksum)
-	s.reporter.AddResult(s.convertToReportResult(result))
+	if record == nil {
+		// Upload file to Koneksi, into the mirror of its directory when the
+		// remote layout is mirror
+		var uploadResp *api.FileUploadResponse
+		if s.tree != nil {
+			uploadResp, err = s.tree.Upload(ctx, filepath.Dir(task.FilePath), payload.name, payload.data, payload.size, checksum)
+		} else {
+			uploadResp, err = s.client.UploadFile(ctx, payload.name, payload.data, payload.size, checksum)
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("failed to upload file: %w", err)
+			result.EndTime = time.Now()
+			s.updateBackupState(task.FilePath, "failed", checksum)
+			s.reporter.AddResult(s.convertToReportResult(result))
+			return result.Error
+		}
 
-	// Save to database
-	if s.db != nil {
-		dbRecord := database.BackupRecord{
+		// Save to database
+		record = &database.BackupRecord{
 			FilePath:       task.FilePath,
 			FileID:         uploadResp.FileID,
 			Checksum:       checksum,
 			OriginalSize:   task.Size,
-			CompressedSize: uploadSize,
-			IsCompressed:   s.compression,
+			CompressedSize: payload.size,
+			IsCompressed:   compress,
 			BackupTime:     time.Now(),
 			Status:         "success",
 			Operation:      task.Operation,
 		}
-		if _, err := s.db.InsertBackupRecord(dbRecord); err != nil {
+		setRecordMetadata(record, meta)
+		setRecordEncryption(record, keyID)
+		if record.ID, err = s.db.InsertBackupRecord(*record); err != nil {
 			s.logger.Error("failed to save backup record to database", zap.Error(err))
 		}
 	}
+	result.FileID = record.FileID
+
+	// Copy to the replicas that do not have this content yet
+	var replicaErr error
+	queued := false
+	for _, replica := range replicas {
+		name := replica.Target.Name()
+		if copied[name] {
+			continue
+		}
+		if record.ID == 0 {
+			s.logger.Warn("backup record missing, file not copied to target", zap.String("path", task.FilePath), zap.String("target", name))
+			continue
+		}
+
+		c := database.TargetCopy{RecordID: record.ID, Target: name, Status: database.CopyPending}
+		if !replica.Async {
+			c.FileID, err = s.uploadCopy(ctx, replica.Target, payload, checksum)
+			c.Status = database.CopySuccess
+			if err != nil {
+				c.Status, c.Error = database.CopyFailed, err.Error()
+				replicaErr = fmt.Errorf("failed to copy file to target %s: %w", name, err)
+			}
+		}
+		if err := s.db.SetTargetCopy(c); err != nil {
+			s.logger.Error("failed to save target copy", zap.String("path", task.FilePath), zap.String("target", name), zap.Error(err))
+		}
+		queued = queued || c.Status == database.CopyPending
+	}
+	if queued {
+		s.notifyReplicator()
+	}
+
+	if replicaErr != nil {
+		result.Error = replicaErr
+		result.EndTime = time.Now()
+		s.updateBackupState(task.FilePath, "failed", checksum)
+		s.reporter.AddResult(s.convertToReportResult(result))
+		return result.Error
+	}
+
+	result.Success = true
+	result.EndTime = time.Now()
+
+	s.updateBackupState(task.FilePath, "success", checksum)
+	s.reporter.AddResult(s.convertToReportResult(result))
 
 	s.logger.Info("file backed up successfully",
 		zap.String("path", task.FilePath),
-		zap.String("fileID", uploadResp.FileID),
+		zap.String("fileID", result.FileID),
 		zap.Duration("duration", result.EndTime.Sub(result.StartTime)),
-		zap.Bool("compressed", s.compression),
+		zap.Bool("compressed", compress),
+		zap.Bool("encrypted", policy.Password != ""),
+		zap.Int("replicas", len(replicas)),
 	)
+	return nil
+}
+
+// payload is the data uploaded for a file, compressed and encrypted as its
+// policy requires. It is rewound before every upload, so one payload can be
+// sent to several targets.
+type payload struct {
+	name    string
+	data    io.ReadSeeker
+	size    int64
+	cleanup []func()
+}
+
+func (p *payload) Close() {
+	for _, f := range p.cleanup {
+		f()
+	}
+}
+
+// preparePayload reads, compresses and encrypts a file according to policy.
+// The content of a symbolic link, with metadata meta, is its target. The
+// caller closes the payload.
+func (s *Service) preparePayload(filePath string, meta *metadata.Metadata, policy Policy) (*payload, error) {
+	var content io.ReadSeeker
+	p := &payload{name: filePath}
+	if meta.IsSymlink() {
+		content = strings.NewReader(meta.Link)
+		p.size = int64(len(meta.Link))
+	} else {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		p.cleanup = append(p.cleanup, func() { file.Close() })
+
+		info, err := file.Stat()
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		content, p.size = file, info.Size()
+	}
+	p.data = content
+	originalSize := p.size
+
+	// Compress if enabled
+	if policy.Compress {
+		compressedData, err := compression.CompressFile(content, policy.Compressor)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to compress file: %w", err)
+		}
+
+		p.data = bytes.NewReader(compressedData)
+		p.size = int64(len(compressedData))
+
+		s.logger.Debug("file compressed",
+			zap.String("path", filePath),
+			zap.Int64("originalSize", originalSize),
+			zap.Int64("compressedSize", p.size),
+			zap.Float64("compressionRatio", compression.CompressionRatio(originalSize, p.size)),
+		)
+	}
+
+	// Encrypt if the directory policy asks for it
+	if policy.Password != "" {
+		encrypted, err := encryptToTemp(p.data, policy.Password)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to encrypt file: %w", err)
+		}
+		p.cleanup = append(p.cleanup, func() {
+			encrypted.Close()
+			os.Remove(encrypted.Name())
+		})
+
+		info, err := encrypted.Stat()
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to stat encrypted file: %w", err)
+		}
+		p.data = encrypted
+		p.size = info.Size()
+		p.name = encryption.GetEncryptedFileName(filePath)
+	}
+
+	return p, nil
+}
+
+// uploadCopy uploads a payload to a secondary target.
+func (s *Service) uploadCopy(ctx context.Context, target Target, p *payload, checksum string) (string, error) {
+	if _, err := p.data.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %w", err)
+	}
+	return target.Upload(ctx, p.name, p.data, p.size, checksum)
+}
+
+// encryptToTemp encrypts the data read from r into a temporary file and
+// returns it opened for reading. The caller closes and removes it.
+func encryptToTemp(r io.Reader, password string) (*os.File, error) {
+	plain, err := os.CreateTemp("", "koneksi-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(plain.Name())
+
+	_, err = io.Copy(plain, r)
+	if closeErr := plain.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	encryptedPath := plain.Name() + ".enc"
+	if err := encryption.NewEncryptor(password).EncryptFile(plain.Name(), encryptedPath); err != nil {
+		os.Remove(encryptedPath)
+		return nil, err
+	}
+
+	encrypted, err := os.Open(encryptedPath)
+	if err != nil {
+		os.Remove(encryptedPath)
+		return nil, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	return encrypted, nil
 }
 
 func (s *Service) needsBackup(filePath, operation string) bool {
-	// Always backup on create or modify
-	if operation == "create" || operation == "modify" {
+	// Always backup on create, modify, chmod or an explicit scan; unchanged
+	// files are skipped later by checksum and metadata
+	if operation == "create" || operation == "modify" || operation == "chmod" || operation == "scan" {
 		return true
 	}
 
 	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if _, err := os.Lstat(filePath); os.IsNotExist(err) {
 		return false
 	}
 
 	// Check last backup time
-	s.mu.RLock()
-	state, exists := s.backupState[filePath]
-	s.mu.RUnlock()
+	state, exists := s.fileState(filePath)
 
 	if !exists {
 		return true
@@ -335,6 +1021,11 @@ func (s *Service) needsBackup(filePath, operation string) bool {
 }
 
 func (s *Service) calculateChecksum(filePath string) (string, error) {
+	return checksumFile(filePath)
+}
+
+// checksumFile returns the hex SHA-256 of a file's content.
+func checksumFile(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
@@ -349,16 +1040,19 @@ func (s *Service) calculateChecksum(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// updateBackupState updates the in-memory state of a file and saves it to
+// the database after releasing s.mu, so a slow write does not block the
+// other workers.
 func (s *Service) updateBackupState(filePath, status, checksum string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// A miss reads the database, so the state is loaded before taking s.mu
+	// and taken from the cache again in case another worker updated it
+	loaded, _ := s.fileState(filePath)
 
-	state, exists := s.backupState[filePath]
-	if !exists {
-		state = &FileBackupState{}
-		s.backupState[filePath] = state
+	s.mu.Lock()
+	state, ok := s.backupState.get(filePath)
+	if !ok {
+		state = loaded
 	}
-
 	state.Status = status
 	state.LastBackup = time.Now()
 	if checksum != "" {
@@ -367,16 +1061,18 @@ func (s *Service) updateBackupState(filePath, status, checksum string) {
 	if status == "success" {
 		state.BackupCount++
 	}
+	s.backupState.put(filePath, state)
+	dbState := database.FileState{
+		FilePath:     filePath,
+		LastChecksum: state.LastChecksum,
+		LastBackup:   state.LastBackup,
+		BackupCount:  state.BackupCount,
+		Status:       state.Status,
+	}
+	s.mu.Unlock()
 
 	// Update database
 	if s.db != nil {
-		dbState := database.FileState{
-			FilePath:     filePath,
-			LastChecksum: state.LastChecksum,
-			LastBackup:   state.LastBackup,
-			BackupCount:  state.BackupCount,
-			Status:       state.Status,
-		}
 		if err := s.db.UpdateFileState(dbState); err != nil {
 			s.logger.Error("failed to update file state in database", zap.Error(err))
 		}
@@ -398,21 +1094,20 @@ func (s *Service) cleanupRoutine(ctx context.Context) {
 }
 
 func (s *Service) cleanupDeletedFiles() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for path, state := range s.backupState {
-		if state.Status == "deleted" {
-			// Keep deleted file state for 24 hours
-			if time.Since(state.LastBackup) > 24*time.Hour {
-				delete(s.backupState, path)
-			}
-		}
-	}
+	// Deleted file states are kept in memory for 24 hours
+	s.backupState.removeIf(func(path string, state FileBackupState) bool {
+		return state.Status == "deleted" && time.Since(state.LastBackup) > 24*time.Hour
+	})
 }
 
+// Stop waits for the workers to finish the tasks that are ready and exit;
+// with a batch, only the tasks of that batch. Tasks still queued when the
+// context is cancelled stay in the database and are picked up on the next
+// start.
 func (s *Service) Stop() {
-	close(s.backupQueue)
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
 	s.wg.Wait()
 }
 
@@ -429,6 +1124,8 @@ func (s *Service) convertToReportResult(result BackupResult) report.BackupResult
 		CompressedSize: result.CompressedSize,
 		Checksum:       result.Checksum,
 		Compressed:     result.Compressed,
+		Encrypted:      result.Encrypted,
+		Metadata:       result.Metadata,
 	}
 }
 
@@ -442,18 +1139,15 @@ func (s *Service) GetBackupStats() map[string]interface{} {
 		s.logger.Warn("failed to get stats from database, using in-memory stats", zap.Error(err))
 	}
 
-	// Fallback to in-memory stats
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	// Fallback to the cached states
 	stats := map[string]interface{}{
-		"total_files":      len(s.backupState),
+		"total_files":      s.backupState.len(),
 		"successful_files": 0,
 		"failed_files":     0,
 		"deleted_files":    0,
 	}
 
-	for _, state := range s.backupState {
+	s.backupState.each(func(path string, state FileBackupState) {
 		switch state.Status {
 		case "success":
 			stats["successful_files"] = stats["successful_files"].(int) + 1
@@ -462,46 +1156,57 @@ func (s *Service) GetBackupStats() map[string]interface{} {
 		case "deleted":
 			stats["deleted_files"] = stats["deleted_files"].(int) + 1
 		}
-	}
+	})
 
 	return stats
 }
 
+// loadFileStatesFromDB warms the state cache with the most recently backed
+// up files. The states of other files are read when they are first needed.
 func (s *Service) loadFileStatesFromDB() error {
 	if s.db == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	// Query all file states from database
-	criteria := database.SearchCriteria{
-		Limit: 10000, // Load up to 10k files
-	}
-	
-	records, err := s.db.SearchBackups(criteria)
+	err := s.db.ForEachFileState(stateCacheSize, func(state database.FileState) bool {
+		return s.backupState.fill(state.FilePath, fileBackupState(state))
+	})
 	if err != nil {
 		return err
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.logger.Info("loaded file states from database", zap.Int("count", s.backupState.len()))
+	return nil
+}
 
-	// Populate in-memory state from database
-	for _, record := range records {
-		state := &FileBackupState{
-			LastBackup:   record.BackupTime,
-			LastChecksum: record.Checksum,
-			Status:       record.Status,
-		}
-		
-		// Get backup count from file state
-		dbState, err := s.db.GetFileState(record.FilePath)
-		if err == nil && dbState != nil {
-			state.BackupCount = dbState.BackupCount
-		}
-		
-		s.backupState[record.FilePath] = state
+// fileState returns the state of a file from the cache, reading it from
+// the database on a miss.
+func (s *Service) fileState(filePath string) (FileBackupState, bool) {
+	if state, ok := s.backupState.get(filePath); ok {
+		return state, true
+	}
+	if s.db == nil {
+		return FileBackupState{}, false
 	}
 
-	s.logger.Info("loaded file states from database", zap.Int("count", len(s.backupState)))
-	return nil
-}
\ No newline at end of file
+	dbState, err := s.db.GetFileState(filePath)
+	if err != nil {
+		s.logger.Warn("failed to get file state", zap.String("path", filePath), zap.Error(err))
+		return FileBackupState{}, false
+	}
+	if dbState == nil {
+		return FileBackupState{}, false
+	}
+	state := fileBackupState(*dbState)
+	s.backupState.put(filePath, state)
+	return state, true
+}
+
+func fileBackupState(state database.FileState) FileBackupState {
+	return FileBackupState{
+		LastBackup:   state.LastBackup,
+		LastChecksum: state.LastChecksum,
+		BackupCount:  state.BackupCount,
+		Status:       state.Status,
+	}
+}
@@ -3,16 +3,20 @@ package backup
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/api/apitest"
 	"github.com/koneksi/backup-cli/internal/config"
 	"github.com/koneksi/backup-cli/internal/monitor"
 	"github.com/koneksi/backup-cli/internal/report"
 	"github.com/koneksi/backup-cli/pkg/database"
+	"github.com/koneksi/backup-cli/pkg/encryption"
 	"go.uber.org/zap"
 )
 
@@ -283,9 +287,7 @@ func TestBackupService_UpdateBackupState(t *testing.T) {
 	service.updateBackupState(testFile, "success", "checksum123")
 
 	// Verify state
-	service.mu.RLock()
-	state, exists := service.backupState[testFile]
-	service.mu.RUnlock()
+	state, exists := service.fileState(testFile)
 
 	if !exists {
 		t.Fatal("backup state should exist")
@@ -306,9 +308,7 @@ func TestBackupService_UpdateBackupState(t *testing.T) {
 	// Update again with success
 	service.updateBackupState(testFile, "success", "checksum456")
 
-	service.mu.RLock()
-	state, _ = service.backupState[testFile]
-	service.mu.RUnlock()
+	state, _ = service.fileState(testFile)
 
 	if state.BackupCount != 2 {
 		t.Errorf("expected backup count 2, got %d", state.BackupCount)
@@ -426,9 +426,7 @@ func TestBackupService_ProcessBackupWithError(t *testing.T) {
 	service.processBackup(ctx, task)
 
 	// Verify state shows failure
-	service.mu.RLock()
-	state, exists := service.backupState[testFile]
-	service.mu.RUnlock()
+	state, exists := service.fileState(testFile)
 
 	if !exists {
 		t.Fatal("backup state should exist")
@@ -437,4 +435,402 @@ func TestBackupService_ProcessBackupWithError(t *testing.T) {
 	if state.Status != "failed" {
 		t.Errorf("expected status 'failed', got '%s'", state.Status)
 	}
-}
\ No newline at end of file
+}
+func TestBackupService_PauseHoldsQueue(t *testing.T) {
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 2
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	service, err := NewService(&api.Client{}, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	service.Pause()
+	if !service.IsPaused() {
+		t.Fatal("service should be paused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	service.ProcessChange(monitor.FileChange{
+		Path:      filepath.Join(t.TempDir(), "queued.txt"),
+		Operation: "create",
+		Timestamp: time.Now(),
+		Size:      10,
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	depth, err := service.QueueDepth()
+	if err != nil {
+		t.Fatalf("failed to get queue depth: %v", err)
+	}
+	if depth != 1 {
+		t.Errorf("paused service should keep the task queued, depth is %d", depth)
+	}
+
+	workers := service.Workers()
+	if len(workers) != 2 {
+		t.Fatalf("expected 2 workers, got %d", len(workers))
+	}
+	for _, w := range workers {
+		if w.State != WorkerPaused {
+			t.Errorf("worker %d should be paused, got %s", w.ID, w.State)
+		}
+	}
+
+	cancel()
+	service.Stop()
+}
+
+func TestBackupService_BatchLeavesOtherTasksQueued(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 2
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// A task queued by the daemon
+	dir := t.TempDir()
+	queued := filepath.Join(dir, "queued.txt")
+	writeTestFile(t, queued, "daemon")
+	if err := db.EnqueueTask(queued, "create", 6); err != nil {
+		t.Fatalf("failed to enqueue task: %v", err)
+	}
+
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	service.SetBatch("one-shot")
+	service.Start(context.Background())
+
+	file := filepath.Join(dir, "manual.txt")
+	writeTestFile(t, file, "manual")
+	service.ProcessChange(monitor.FileChange{Path: file, Operation: "manual", Timestamp: time.Now(), Size: 6})
+	service.Stop()
+
+	files := server.Files(base)
+	if len(files) != 1 || files[0].Name != "manual.txt" {
+		t.Errorf("expected only the batch's file to be uploaded, got %+v", files)
+	}
+	if depth, _ := db.QueueDepth(); depth != 1 {
+		t.Errorf("expected the daemon's task to stay queued, depth is %d", depth)
+	}
+}
+
+func TestBackupService_ReloadResizesPool(t *testing.T) {
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 2
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	service, err := NewService(&api.Client{}, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	service.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	service.ProcessChange(monitor.FileChange{
+		Path:      filepath.Join(t.TempDir(), "queued.txt"),
+		Operation: "create",
+		Timestamp: time.Now(),
+		Size:      10,
+	})
+
+	waitForWorkers := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(service.Workers()) != n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(service.Workers()); got != n {
+			t.Fatalf("expected %d workers, got %d", n, got)
+		}
+	}
+	waitForWorkers(2)
+
+	grown := *cfg
+	grown.Backup.Concurrent = 4
+	grown.Backup.MaxFileSize = 5
+	if err := service.Reload(&grown); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	waitForWorkers(4)
+
+	shrunk := grown
+	shrunk.Backup.Concurrent = 1
+	if err := service.Reload(&shrunk); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	waitForWorkers(1)
+
+	// An invalid compressor rejects the whole reload
+	invalid := shrunk
+	invalid.Backup.Concurrent = 3
+	invalid.Backup.Compression.Enabled = true
+	invalid.Backup.Compression.Format = "brotli"
+	if err := service.Reload(&invalid); err == nil {
+		t.Error("expected reload with unsupported compression to fail")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(service.Workers()); got != 1 {
+		t.Errorf("rejected reload changed the pool to %d workers", got)
+	}
+
+	depth, err := service.QueueDepth()
+	if err != nil {
+		t.Fatalf("failed to get queue depth: %v", err)
+	}
+	if depth != 1 {
+		t.Errorf("reload should keep queued work, depth is %d", depth)
+	}
+
+	// The new maximum file size applies to later changes
+	service.ProcessChange(monitor.FileChange{
+		Path:      filepath.Join(t.TempDir(), "large.txt"),
+		Operation: "create",
+		Timestamp: time.Now(),
+		Size:      10,
+	})
+	if depth, _ := service.QueueDepth(); depth != 1 {
+		t.Errorf("file above the reloaded size limit was queued, depth is %d", depth)
+	}
+
+	cancel()
+	service.Stop()
+}
+
+func TestBackupService_DrainRequeuesInterruptedUpload(t *testing.T) {
+	// The upload blocks until the request is cancelled. The body must be
+	// read for the server to notice the client going away.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	client := api.NewClient(server.URL, "id", "secret", "dir", time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	testFile := filepath.Join(t.TempDir(), "slow.txt")
+	if err := os.WriteFile(testFile, []byte("slow upload"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	service.ProcessChange(monitor.FileChange{Path: testFile, Operation: "create", Timestamp: time.Now(), Size: 11})
+
+	// Wait for the worker to start the upload
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		workers := service.Workers()
+		if len(workers) == 1 && workers[0].Path == testFile {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for upload to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := service.Drain(100 * time.Millisecond); err == nil {
+		t.Fatal("expected drain to time out with a blocked upload")
+	}
+	if !service.IsPaused() {
+		t.Error("service should stay paused after draining")
+	}
+
+	// The interrupted task is back in the queue without a counted attempt
+	task, err := db.LeaseTask(time.Minute)
+	if err != nil || task == nil {
+		t.Fatalf("expected interrupted task to be queued, got %v (%v)", task, err)
+	}
+	if task.FilePath != testFile || task.Attempts != 1 {
+		t.Errorf("unexpected requeued task: %s with %d attempts", task.FilePath, task.Attempts)
+	}
+	db.ReleaseTask(task)
+
+	if err := service.Drain(time.Second); err != nil {
+		t.Errorf("drain with no running uploads should succeed: %v", err)
+	}
+
+	cancel()
+	service.Stop()
+}
+
+func TestBackupService_RevertedContentIsNewVersion(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	for _, content := range []string{"first", "second", "first"} {
+		writeTestFile(t, file, content)
+		if err := service.processBackup(context.Background(), BackupTask{FilePath: file, Operation: "modify", Size: int64(len(content))}); err != nil {
+			t.Fatalf("backup of %q failed: %v", content, err)
+		}
+	}
+
+	if uploaded := len(server.Files(base)); uploaded != 2 {
+		t.Errorf("expected 2 uploads, got %d", uploaded)
+	}
+	history, err := db.GetBackupHistory(file, 10)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("expected 3 versions, got %d, %v", len(history), err)
+	}
+	if history[0].Version != 3 || history[0].FileID != history[2].FileID || history[0].Checksum != history[2].Checksum {
+		t.Errorf("reverted version does not share the first upload: %+v", history)
+	}
+	if history[0].ModTime.IsZero() || history[0].Mode == 0 {
+		t.Errorf("file metadata not recorded: %+v", history[0])
+	}
+}
+
+func TestBackupService_EncryptionChangeIsNewUpload(t *testing.T) {
+	server := apitest.NewServer(t)
+	base := server.AddDirectory("base", "")
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+
+	client := api.NewClient(server.URL, "id", "secret", base, time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	backup := func(content string) {
+		writeTestFile(t, file, content)
+		if err := service.processBackup(context.Background(), BackupTask{FilePath: file, Operation: "modify", Size: int64(len(content))}); err != nil {
+			t.Fatalf("backup of %q failed: %v", content, err)
+		}
+	}
+	setPassword := func(password string) {
+		cfg.Backup.Directories = []config.Directory{{Path: dir, Encryption: &config.Encryption{Enabled: true, Password: password}}}
+		if err := service.Reload(cfg); err != nil {
+			t.Fatalf("failed to reload: %v", err)
+		}
+	}
+
+	// Reverting to content uploaded unencrypted, once the directory is
+	// encrypted, uploads it again; so does a change of password
+	backup("first")
+	backup("second")
+	setPassword("pw")
+	backup("first")
+	setPassword("other")
+	backup("second")
+	backup("first")
+
+	if uploaded := len(server.Files(base)); uploaded != 5 {
+		t.Errorf("expected 5 uploads, got %d", uploaded)
+	}
+	history, err := db.GetBackupHistory(file, 10)
+	if err != nil || len(history) != 5 {
+		t.Fatalf("expected 5 versions, got %d, %v", len(history), err)
+	}
+	salt, err := db.KeySalt()
+	if err != nil {
+		t.Fatalf("failed to get key salt: %v", err)
+	}
+	expected := []struct {
+		encrypted bool
+		keyID     string
+	}{
+		{true, encryption.KeyID("other", salt)},
+		{true, encryption.KeyID("other", salt)},
+		{true, encryption.KeyID("pw", salt)},
+		{false, ""},
+		{false, ""},
+	}
+	for i, e := range expected {
+		r := history[i]
+		if r.Encrypted == nil || *r.Encrypted != e.encrypted || r.KeyID != e.keyID {
+			t.Errorf("version %d: expected encrypted %v with key %q, got %v with %q", r.Version, e.encrypted, e.keyID, r.Encrypted, r.KeyID)
+		}
+	}
+	if history[0].FileID == history[2].FileID || history[2].FileID == history[4].FileID {
+		t.Errorf("differently encrypted versions share an upload: %+v", history)
+	}
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"container/list"
+	"sync"
+)
+
+// stateCacheSize bounds the number of file states kept in memory. Others
+// are read from the file_states table when needed.
+const stateCacheSize = 100000
+
+// stateCache is a least recently used cache of file backup states in front
+// of the database. It has its own lock, so lookups do not contend with
+// Service.mu.
+type stateCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type stateEntry struct {
+	path  string
+	state FileBackupState
+}
+
+func newStateCache(capacity int) *stateCache {
+	return &stateCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns a copy of the cached state of path.
+func (c *stateCache) get(path string) (FileBackupState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[path]
+	if !ok {
+		return FileBackupState{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*stateEntry).state, true
+}
+
+// put caches the state of path, evicting the least recently used state
+// when the cache is full.
+func (c *stateCache) put(path string, state FileBackupState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[path]; ok {
+		elem.Value.(*stateEntry).state = state
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[path] = c.order.PushFront(&stateEntry{path: path, state: state})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*stateEntry).path)
+	}
+}
+
+// fill adds a state without evicting others, for loading the most recent
+// states at startup. It reports whether the cache has room for more.
+func (c *stateCache) fill(path string, state FileBackupState) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.order.Len() >= c.capacity {
+		return false
+	}
+	if _, ok := c.entries[path]; !ok {
+		c.entries[path] = c.order.PushBack(&stateEntry{path: path, state: state})
+	}
+	return c.order.Len() < c.capacity
+}
+
+// removeIf drops the cached states for which fn returns true.
+func (c *stateCache) removeIf(fn func(path string, state FileBackupState) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, elem := range c.entries {
+		if fn(path, elem.Value.(*stateEntry).state) {
+			c.order.Remove(elem)
+			delete(c.entries, path)
+		}
+	}
+}
+
+// each calls fn with every cached state, most recently used first.
+func (c *stateCache) each(fn func(path string, state FileBackupState)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*stateEntry)
+		fn(entry.path, entry.state)
+	}
+}
+
+func (c *stateCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
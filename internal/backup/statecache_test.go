@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+func TestStateCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newStateCache(2)
+	cache.put("a", FileBackupState{Status: "success"})
+	cache.put("b", FileBackupState{Status: "success"})
+
+	// Using a makes b the least recently used
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.put("c", FileBackupState{Status: "failed"})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if state, ok := cache.get("c"); !ok || state.Status != "failed" {
+		t.Errorf("expected c to be cached as failed, got %+v, %v", state, ok)
+	}
+	if cache.len() != 2 {
+		t.Errorf("expected 2 cached states, got %d", cache.len())
+	}
+
+	// fill never evicts
+	if cache.fill("d", FileBackupState{}) {
+		t.Error("expected fill to report a full cache")
+	}
+	if _, ok := cache.get("d"); ok {
+		t.Error("expected d not to be cached")
+	}
+}
+
+func TestBackupService_FileStateBeyondCache(t *testing.T) {
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	cfg := &config.Config{}
+	cfg.Backup.Concurrent = 1
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// More files than the cache holds, the oldest is not loaded at startup
+	now := time.Now()
+	for i, path := range []string{"/data/old.txt", "/data/new.txt"} {
+		err := db.UpdateFileState(database.FileState{
+			FilePath:     path,
+			LastChecksum: "checksum-" + path,
+			LastBackup:   now.Add(time.Duration(i) * time.Minute),
+			BackupCount:  3,
+			Status:       "success",
+		})
+		if err != nil {
+			t.Fatalf("failed to update file state: %v", err)
+		}
+	}
+
+	service, err := NewService(&api.Client{}, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	service.backupState = newStateCache(1)
+	if err := service.loadFileStatesFromDB(); err != nil {
+		t.Fatalf("failed to load file states: %v", err)
+	}
+	if _, ok := service.backupState.get("/data/new.txt"); !ok {
+		t.Fatal("expected the most recent state to be loaded")
+	}
+	if _, ok := service.backupState.get("/data/old.txt"); ok {
+		t.Fatal("expected the oldest state not to be loaded")
+	}
+
+	// A miss reads the database and keeps counting from the stored state
+	state, ok := service.fileState("/data/old.txt")
+	if !ok || state.LastChecksum != "checksum-/data/old.txt" {
+		t.Fatalf("expected the stored state, got %+v, %v", state, ok)
+	}
+	service.updateBackupState("/data/old.txt", "success", "checksum-2")
+
+	stored, err := db.GetFileState("/data/old.txt")
+	if err != nil || stored == nil {
+		t.Fatalf("failed to get file state: %v", err)
+	}
+	if stored.BackupCount != 4 || stored.LastChecksum != "checksum-2" {
+		t.Errorf("expected backup count 4 with checksum-2, got %+v", stored)
+	}
+	if service.backupState.len() != 1 {
+		t.Errorf("expected the cache to stay bounded, got %d states", service.backupState.len())
+	}
+}
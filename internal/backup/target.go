@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+)
+
+// Target stores uploaded files. A target assigns the ID under which a file
+// can be downloaded again.
+type Target interface {
+	Name() string
+	Upload(ctx context.Context, name string, data io.Reader, size int64, checksum string) (string, error)
+	Download(ctx context.Context, fileID string) (io.ReadCloser, error)
+}
+
+// Replica is a secondary target files are copied to after the primary
+// upload. Async replicas are written in the background by the service.
+type Replica struct {
+	Target Target
+	Async  bool
+}
+
+type koneksiTarget struct {
+	name   string
+	client *api.Client
+}
+
+// NewKoneksiTarget returns a target storing files in the Koneksi directory
+// of client.
+func NewKoneksiTarget(name string, client *api.Client) Target {
+	return &koneksiTarget{name: name, client: client}
+}
+
+func (t *koneksiTarget) Name() string {
+	return t.name
+}
+
+func (t *koneksiTarget) Upload(ctx context.Context, name string, data io.Reader, size int64, checksum string) (string, error) {
+	resp, err := t.client.UploadFile(ctx, name, data, size, checksum)
+	if err != nil {
+		return "", err
+	}
+	return resp.FileID, nil
+}
+
+func (t *koneksiTarget) Download(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	return t.client.DownloadFile(ctx, fileID)
+}
+
+// Delete removes a stored file. The API reports a file that is already
+// gone as api.ErrNotFound.
+func (t *koneksiTarget) Delete(ctx context.Context, fileID string) error {
+	return t.client.DeleteFile(ctx, fileID)
+}
+
+type localTarget struct {
+	name string
+	dir  string
+}
+
+// NewLocalTarget returns a target storing files below dir. File IDs are
+// paths relative to dir of the form YYYY/MM/DD/<random>-<name>.
+func NewLocalTarget(name, dir string) Target {
+	return &localTarget{name: name, dir: dir}
+}
+
+func (t *localTarget) Name() string {
+	return t.name
+}
+
+func (t *localTarget) Upload(ctx context.Context, name string, data io.Reader, size int64, checksum string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate file ID: %w", err)
+	}
+	fileID := path.Join(time.Now().Format("2006/01/02"), hex.EncodeToString(suffix)+"-"+filepath.Base(name))
+	target := filepath.Join(t.dir, filepath.FromSlash(fileID))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	// Write to a temporary file first so that an interrupted copy never
+	// looks complete
+	tempFile, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	written, err := io.Copy(tempFile, contextReader{ctx: ctx, r: data})
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if written != size {
+		return "", fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+
+	if err := os.Rename(tempFile.Name(), target); err != nil {
+		return "", fmt.Errorf("failed to store file: %w", err)
+	}
+	return fileID, nil
+}
+
+func (t *localTarget) Download(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	target := filepath.Join(t.dir, filepath.FromSlash(fileID))
+	if !isWithin(target, t.dir) || target == filepath.Clean(t.dir) {
+		return nil, fmt.Errorf("invalid file ID %q", fileID)
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// Delete removes a stored file. A file that is already gone is not an
+// error.
+func (t *localTarget) Delete(ctx context.Context, fileID string) error {
+	target := filepath.Join(t.dir, filepath.FromSlash(fileID))
+	if !isWithin(target, t.dir) || target == filepath.Clean(t.dir) {
+		return fmt.Errorf("invalid file ID %q", fileID)
+	}
+
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// contextReader stops a copy once ctx is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/config"
+	"github.com/koneksi/backup-cli/internal/report"
+	"github.com/koneksi/backup-cli/pkg/database"
+	"go.uber.org/zap"
+)
+
+// flakyTarget fails its first uploads and then stores into a local target.
+type flakyTarget struct {
+	Target
+	failures int
+}
+
+func (f *flakyTarget) Upload(ctx context.Context, name string, data io.Reader, size int64, checksum string) (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("target unavailable")
+	}
+	return f.Target.Upload(ctx, name, data, size, checksum)
+}
+
+func TestLocalTarget(t *testing.T) {
+	target := NewLocalTarget("nas", t.TempDir())
+	ctx := context.Background()
+
+	content := "local copy"
+	fileID, err := target.Upload(ctx, "/data/report.txt", strings.NewReader(content), int64(len(content)), "sum")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if !strings.HasSuffix(fileID, "-report.txt") {
+		t.Errorf("unexpected file ID %q", fileID)
+	}
+
+	reader, err := target.Download(ctx, fileID)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != content {
+		t.Errorf("downloaded %q, want %q", data, content)
+	}
+
+	if _, err := target.Upload(ctx, "short.txt", strings.NewReader("abc"), 10, "sum"); err == nil {
+		t.Error("expected a short upload to fail")
+	}
+	if _, err := target.Download(ctx, "../outside"); err == nil {
+		t.Error("expected a file ID outside the target to be rejected")
+	}
+}
+
+func newReplicaTestService(t *testing.T) (*Service, *uploadServer, *database.DB, *api.Client) {
+	t.Helper()
+
+	uploads := &uploadServer{}
+	server := httptest.NewServer(uploads)
+	t.Cleanup(server.Close)
+
+	logger := zap.NewNop()
+	reporter, _ := report.NewReporter(logger, t.TempDir(), "json", 10)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := &config.Config{}
+	cfg.Backup.MaxFileSize = 1024 * 1024
+	cfg.Backup.Concurrent = 1
+
+	client := api.NewClient(server.URL, "id", "secret", "dir", time.Minute, 0, logger)
+	service, err := NewService(client, logger, reporter, cfg, db)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	return service, uploads, db, client
+}
+
+func TestBackupService_ReplicatesToTargets(t *testing.T) {
+	service, uploads, db, client := newReplicaTestService(t)
+
+	nas := &flakyTarget{Target: NewLocalTarget("nas", t.TempDir()), failures: 1}
+	cold := NewLocalTarget("cold", t.TempDir())
+	service.SetReplicas([]Replica{{Target: nas}, {Target: cold, Async: true}})
+
+	path := filepath.Join(t.TempDir(), "ledger.csv")
+	writeTestFile(t, path, "account,amount")
+	task := BackupTask{FilePath: path, Operation: "create", Size: 14}
+
+	// The synchronous replica fails, so the backup fails and is retried
+	// without uploading to the primary again
+	if err := service.processBackup(context.Background(), task); err == nil {
+		t.Fatal("expected the backup to fail while a synchronous replica is down")
+	}
+	if err := service.processBackup(context.Background(), task); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if got := len(uploads.uploads()); got != 1 {
+		t.Errorf("expected 1 primary upload, got %d", got)
+	}
+
+	// The asynchronous replica is copied in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending, _ := db.PendingCopies(10); len(pending) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	service.Stop()
+
+	targets, err := service.Targets()
+	if err != nil {
+		t.Fatalf("failed to get target status: %v", err)
+	}
+	if len(targets) != 2 || targets[0].Name != "cold" || targets[0].Copied != 1 || targets[1].Name != "nas" || targets[1].Copied != 1 {
+		t.Errorf("unexpected target status: %+v", targets)
+	}
+
+	// The primary cannot serve downloads, so restore uses a copy
+	restore := NewRestoreService(client, zap.NewNop(), 1)
+	restore.SetFallback(db, []Target{nas, cold})
+	restored := filepath.Join(t.TempDir(), "ledger.csv")
+	if err := restore.RestoreFile(context.Background(), "file-1", restored); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	data, err := os.ReadFile(restored)
+	if err != nil || string(data) != "account,amount" {
+		t.Errorf("unexpected restored content %q, %v", data, err)
+	}
+}
+
+func TestBackupService_RetriesFailedCopies(t *testing.T) {
+	service, _, db, _ := newReplicaTestService(t)
+	service.retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	cold := &flakyTarget{Target: NewLocalTarget("cold", t.TempDir()), failures: 1}
+	service.SetReplicas([]Replica{{Target: cold, Async: true}})
+
+	path := filepath.Join(t.TempDir(), "ledger.csv")
+	writeTestFile(t, path, "account,amount")
+	if err := service.processBackup(context.Background(), BackupTask{FilePath: path, Operation: "create", Size: 14}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	// The failed copy stays pending until its next attempt
+	service.replicatePending(context.Background())
+	record, _ := db.LatestBackupRecord(path)
+	copies, err := db.ListTargetCopies(record.ID)
+	if err != nil || len(copies) != 1 || copies[0].Status != database.CopyPending || copies[0].Attempts != 1 || copies[0].Error != "target unavailable" {
+		t.Fatalf("expected a pending copy with one failed attempt, got %+v, %v", copies, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	service.replicatePending(context.Background())
+	targets, err := service.Targets()
+	if err != nil {
+		t.Fatalf("failed to get target status: %v", err)
+	}
+	if len(targets) != 1 || targets[0].Copied != 1 || targets[0].Pending != 0 || targets[0].Failed != 0 {
+		t.Errorf("expected the retry to copy the file, got %+v", targets)
+	}
+}
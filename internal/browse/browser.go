@@ -0,0 +1,414 @@
+// Package browse implements an interactive terminal browser over the
+// backup catalog, to pick files and directories to restore.
+package browse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// Restorer restores versions of files. It is implemented by
+// backup.RestoreService.
+type Restorer interface {
+	RestoreRecords(ctx context.Context, records []database.BackupRecord, base string, opts backup.PathRestoreOptions) ([]backup.RestoredFile, error)
+	GetProgress() backup.RestoreProgress
+}
+
+type mode int
+
+const (
+	modeBrowse mode = iota
+	modeHelp
+	modePrompt
+	modeRestore
+)
+
+// Browser is the state of the browser. Key presses and ticks update it and
+// View renders it, so it can be driven without a terminal.
+type Browser struct {
+	source    Source
+	restorer  Restorer
+	target    string
+	passwords []string
+
+	dir     string
+	entries []database.CatalogEntry
+	cursor  int
+	offset  int
+	// page is the number of entries shown by the last view
+	page int
+	// selected holds the selected entries by path
+	selected map[string]database.CatalogEntry
+
+	mode    mode
+	input   []rune
+	message string
+
+	// The running restore; start is the progress before it started
+	cancel   context.CancelFunc
+	done     chan restoreResult
+	start    backup.RestoreProgress
+	progress backup.RestoreProgress
+
+	quitting bool
+	quit     bool
+}
+
+type restoreResult struct {
+	restored []backup.RestoredFile
+	err      error
+}
+
+// New returns a browser over source, in the directory containing every
+// file of it. Restores go through restorer, by default to target, trying
+// passwords to decrypt encrypted uploads.
+func New(source Source, restorer Restorer, target string, passwords []string) (*Browser, error) {
+	root, err := source.Root()
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		return nil, fmt.Errorf("no files in %s", source.Label())
+	}
+
+	b := &Browser{
+		source:    source,
+		restorer:  restorer,
+		target:    target,
+		passwords: passwords,
+		page:      10,
+		selected:  make(map[string]database.CatalogEntry),
+	}
+	if err := b.open(root, ""); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Done reports whether the browser has been quit.
+func (b *Browser) Done() bool {
+	return b.quit
+}
+
+// Quit quits the browser, once a running restore has been cancelled.
+func (b *Browser) Quit() {
+	if b.mode != modeRestore {
+		b.quit = true
+		return
+	}
+	b.quitting = true
+	b.cancel()
+	b.message = "Cancelling restore..."
+}
+
+// open lists dir, with the cursor on the entry at path if it has one.
+func (b *Browser) open(dir, path string) error {
+	entries, err := b.source.List(dir)
+	if err != nil {
+		return err
+	}
+	b.dir, b.entries, b.cursor, b.offset = dir, entries, 0, 0
+	for i, e := range entries {
+		if e.Path == path {
+			b.cursor = i
+		}
+	}
+	return nil
+}
+
+func (b *Browser) current() *database.CatalogEntry {
+	if b.cursor < len(b.entries) {
+		return &b.entries[b.cursor]
+	}
+	return nil
+}
+
+// HandleKey updates the browser for a key press.
+func (b *Browser) HandleKey(k KeyEvent) {
+	switch b.mode {
+	case modeHelp:
+		b.mode = modeBrowse
+	case modePrompt:
+		b.handlePromptKey(k)
+	case modeRestore:
+		b.handleRestoreKey(k)
+	default:
+		b.handleBrowseKey(k)
+	}
+}
+
+func (b *Browser) handleBrowseKey(k KeyEvent) {
+	b.message = ""
+	if k.Key == KeyRune {
+		switch k.Rune {
+		case 'k':
+			k.Key = KeyUp
+		case 'j':
+			k.Key = KeyDown
+		case 'l':
+			k.Key = KeyRight
+		case 'h':
+			k.Key = KeyLeft
+		case 'g':
+			k.Key = KeyHome
+		case 'G':
+			k.Key = KeyEnd
+		case ' ':
+			b.toggle()
+		case 'a':
+			b.toggleAll()
+		case 'r':
+			b.prompt()
+		case '?':
+			b.mode = modeHelp
+		case 'q':
+			b.quit = true
+		}
+	}
+
+	switch k.Key {
+	case KeyUp:
+		b.move(-1)
+	case KeyDown:
+		b.move(1)
+	case KeyPageUp:
+		b.move(-b.page)
+	case KeyPageDown:
+		b.move(b.page)
+	case KeyHome:
+		b.move(-len(b.entries))
+	case KeyEnd:
+		b.move(len(b.entries))
+	case KeyEnter, KeyRight:
+		if e := b.current(); e != nil && e.Dir {
+			if err := b.open(e.Path, ""); err != nil {
+				b.message = err.Error()
+			}
+		}
+	case KeyLeft, KeyBackspace:
+		if parent := filepath.Dir(b.dir); parent != b.dir {
+			if err := b.open(parent, b.dir); err != nil {
+				b.message = err.Error()
+			}
+		}
+	case KeyEsc, KeyCtrlC:
+		b.quit = true
+	}
+}
+
+func (b *Browser) move(n int) {
+	b.cursor = max(0, min(b.cursor+n, len(b.entries)-1))
+}
+
+func (b *Browser) toggle() {
+	e := b.current()
+	if e == nil {
+		return
+	}
+	if _, ok := b.selected[e.Path]; ok {
+		delete(b.selected, e.Path)
+	} else {
+		b.selected[e.Path] = *e
+	}
+	b.move(1)
+}
+
+// toggleAll selects every entry of the directory, or clears them when all
+// are selected.
+func (b *Browser) toggleAll() {
+	all := true
+	for _, e := range b.entries {
+		if _, ok := b.selected[e.Path]; !ok {
+			all = false
+		}
+	}
+	for _, e := range b.entries {
+		if all {
+			delete(b.selected, e.Path)
+		} else {
+			b.selected[e.Path] = e
+		}
+	}
+}
+
+// restoreEntries returns the selected entries, or the entry under the
+// cursor when none are selected.
+func (b *Browser) restoreEntries() []database.CatalogEntry {
+	var entries []database.CatalogEntry
+	for _, e := range b.selected {
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		if e := b.current(); e != nil {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+func (b *Browser) prompt() {
+	if len(b.restoreEntries()) == 0 {
+		b.message = "Nothing to restore"
+		return
+	}
+	b.mode = modePrompt
+	b.input = []rune(b.target)
+}
+
+func (b *Browser) handlePromptKey(k KeyEvent) {
+	switch k.Key {
+	case KeyRune:
+		b.input = append(b.input, k.Rune)
+	case KeyBackspace:
+		if len(b.input) > 0 {
+			b.input = b.input[:len(b.input)-1]
+		}
+	case KeyEnter:
+		b.mode = modeBrowse
+		if err := b.startRestore(strings.TrimSpace(string(b.input))); err != nil {
+			b.message = err.Error()
+		}
+	case KeyEsc, KeyCtrlC:
+		b.mode = modeBrowse
+	}
+}
+
+// startRestore restores the chosen entries in the background, to target
+// or, when it is empty, to their original paths.
+func (b *Browser) startRestore(target string) error {
+	entries := b.restoreEntries()
+	var records []database.BackupRecord
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		files := []database.BackupRecord{}
+		if e.Dir {
+			var err error
+			if files, err = b.source.Files(e.Path); err != nil {
+				return err
+			}
+		} else if e.Record != nil {
+			files = append(files, *e.Record)
+		}
+		for _, f := range files {
+			if !seen[f.FilePath] {
+				seen[f.FilePath] = true
+				records = append(records, f)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return errors.New("nothing to restore")
+	}
+
+	opts := backup.PathRestoreOptions{Passwords: b.passwords}
+	if target == "" {
+		opts.InPlace = true
+	} else {
+		var err error
+		if opts.To, err = filepath.Abs(target); err != nil {
+			return fmt.Errorf("invalid target directory: %w", err)
+		}
+		b.target = target
+	}
+
+	// Restored files keep their paths relative to the directory holding
+	// all the entries
+	base := filepath.Dir(entries[0].Path)
+	for _, e := range entries[1:] {
+		base = commonDir(base, filepath.Dir(e.Path))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	b.done = make(chan restoreResult, 1)
+	b.start = b.restorer.GetProgress()
+	b.progress = b.start
+	b.mode = modeRestore
+
+	go func(done chan<- restoreResult) {
+		restored, err := b.restorer.RestoreRecords(ctx, records, base, opts)
+		done <- restoreResult{restored: restored, err: err}
+	}(b.done)
+	return nil
+}
+
+// commonDir returns the deepest directory containing both a and b.
+func commonDir(a, b string) string {
+	for !isWithin(b, a) {
+		parent := filepath.Dir(a)
+		if parent == a {
+			return a
+		}
+		a = parent
+	}
+	return a
+}
+
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (b *Browser) handleRestoreKey(k KeyEvent) {
+	switch {
+	case k.Key == KeyRune && k.Rune == 'q':
+		b.Quit()
+	case k.Key == KeyRune && k.Rune == 'c', k.Key == KeyEsc, k.Key == KeyCtrlC:
+		b.cancel()
+		b.message = "Cancelling restore..."
+	}
+}
+
+// Tick polls the progress of a running restore.
+func (b *Browser) Tick() {
+	if b.mode != modeRestore {
+		return
+	}
+	b.progress = b.restorer.GetProgress()
+	select {
+	case result := <-b.done:
+		b.progress = b.restorer.GetProgress()
+		b.finishRestore(result)
+	default:
+	}
+}
+
+func (b *Browser) finishRestore(result restoreResult) {
+	b.cancel()
+	b.mode = modeBrowse
+	if b.quitting {
+		b.quit = true
+	}
+
+	restored, failed, total := b.restored()
+	switch {
+	case errors.Is(result.err, context.Canceled):
+		b.message = fmt.Sprintf("Restore cancelled after %d of %d files", restored, total)
+	case result.err != nil:
+		b.message = "Restore failed: " + result.err.Error()
+	case failed > 0:
+		errs := b.progress.Errors[len(b.start.Errors):]
+		b.message = fmt.Sprintf("Restored %d of %d files, %d failed", restored, total, failed)
+		if len(errs) > 0 {
+			b.message += fmt.Sprintf(": %s: %s", errs[0].FilePath, errs[0].Error)
+		}
+	default:
+		b.selected = make(map[string]database.CatalogEntry)
+		b.message = fmt.Sprintf("Restored %d files (%s)", restored, formatBytes(b.progress.RestoredSize-b.start.RestoredSize))
+	}
+}
+
+// restored returns the counts of the running or last restore.
+func (b *Browser) restored() (restored, failed, total int) {
+	return b.progress.RestoredFiles - b.start.RestoredFiles,
+		b.progress.FailedFiles - b.start.FailedFiles,
+		b.progress.TotalFiles - b.start.TotalFiles
+}
@@ -0,0 +1,248 @@
+package browse
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// fakeSource lists a fixed set of files.
+type fakeSource struct {
+	files []database.BackupRecord
+}
+
+func (s *fakeSource) Label() string { return "test files" }
+
+func (s *fakeSource) Root() (string, error) { return "/data", nil }
+
+func (s *fakeSource) List(dir string) ([]database.CatalogEntry, error) {
+	seen := make(map[string]bool)
+	var entries []database.CatalogEntry
+	for i, f := range s.files {
+		rel, err := filepath.Rel(dir, f.FilePath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		name, _, nested := strings.Cut(rel, "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		entry := database.CatalogEntry{Name: name, Path: filepath.Join(dir, name), Dir: nested}
+		if !nested {
+			entry.Record = &s.files[i]
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func (s *fakeSource) Files(path string) ([]database.BackupRecord, error) {
+	var records []database.BackupRecord
+	for _, f := range s.files {
+		if f.FilePath == path || strings.HasPrefix(f.FilePath, path+"/") {
+			records = append(records, f)
+		}
+	}
+	return records, nil
+}
+
+// fakeRestorer records the restores and blocks them until release is
+// closed.
+type fakeRestorer struct {
+	mu       sync.Mutex
+	progress backup.RestoreProgress
+	records  []database.BackupRecord
+	base     string
+	opts     backup.PathRestoreOptions
+	release  chan struct{}
+}
+
+func (r *fakeRestorer) RestoreRecords(ctx context.Context, records []database.BackupRecord, base string, opts backup.PathRestoreOptions) ([]backup.RestoredFile, error) {
+	r.mu.Lock()
+	r.records, r.base, r.opts = records, base, opts
+	r.progress.TotalFiles += len(records)
+	r.mu.Unlock()
+
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var restored []backup.RestoredFile
+	for _, record := range records {
+		r.progress.RestoredFiles++
+		r.progress.RestoredSize += record.OriginalSize
+		restored = append(restored, backup.RestoredFile{Record: record})
+	}
+	return restored, nil
+}
+
+func (r *fakeRestorer) GetProgress() backup.RestoreProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+func newTestBrowser(t *testing.T) (*Browser, *fakeRestorer) {
+	t.Helper()
+	source := &fakeSource{files: []database.BackupRecord{
+		{FilePath: "/data/a.txt", Checksum: "a", OriginalSize: 100, Version: 2},
+		{FilePath: "/data/docs/b.txt", Checksum: "b", OriginalSize: 200},
+		{FilePath: "/data/docs/sub/c.txt", Checksum: "c", OriginalSize: 300},
+		{FilePath: "/data/z.txt", Checksum: "z", OriginalSize: 400},
+	}}
+	restorer := &fakeRestorer{release: make(chan struct{})}
+	b, err := New(source, restorer, "/tmp/out", []string{"pw"})
+	if err != nil {
+		t.Fatalf("failed to create browser: %v", err)
+	}
+	return b, restorer
+}
+
+func press(b *Browser, keys ...KeyEvent) {
+	for _, k := range keys {
+		b.HandleKey(k)
+	}
+}
+
+func runes(s string) []KeyEvent {
+	var keys []KeyEvent
+	for _, r := range s {
+		keys = append(keys, KeyEvent{Key: KeyRune, Rune: r})
+	}
+	return keys
+}
+
+// waitRestore ticks until the running restore has finished.
+func waitRestore(t *testing.T, b *Browser) {
+	t.Helper()
+	result := <-b.done
+	b.done <- result
+	b.Tick()
+	if b.mode == modeRestore {
+		t.Fatal("restore still running")
+	}
+}
+
+func TestBrowserNavigation(t *testing.T) {
+	b, _ := newTestBrowser(t)
+
+	var names []string
+	for _, e := range b.entries {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, " "); got != "a.txt docs z.txt" {
+		t.Fatalf("unexpected entries: %s", got)
+	}
+
+	press(b, KeyEvent{Key: KeyDown}, KeyEvent{Key: KeyEnter})
+	if b.dir != "/data/docs" || len(b.entries) != 2 {
+		t.Fatalf("expected to open /data/docs, got %s with %d entries", b.dir, len(b.entries))
+	}
+
+	// Going back puts the cursor on the directory left
+	press(b, KeyEvent{Key: KeyLeft})
+	if b.dir != "/data" || b.current().Name != "docs" {
+		t.Errorf("expected the cursor on docs in /data, got %s in %s", b.current().Name, b.dir)
+	}
+
+	press(b, runes("G")...)
+	if b.current().Name != "z.txt" {
+		t.Errorf("expected the cursor on the last entry, got %s", b.current().Name)
+	}
+
+	view := strings.Join(b.View(100, 20), "\n")
+	for _, want := range []string{"test files", "z.txt", "400 B", "Checksum:"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected the view to contain %q:\n%s", want, view)
+		}
+	}
+	for _, line := range b.View(100, 20) {
+		if n := len([]rune(line)); n != 100 {
+			t.Errorf("expected lines of 100 columns, got %d: %q", n, line)
+		}
+	}
+
+	press(b, runes("q")...)
+	if !b.Done() {
+		t.Error("expected q to quit")
+	}
+}
+
+func TestBrowserRestoreSelection(t *testing.T) {
+	b, restorer := newTestBrowser(t)
+
+	// Select a.txt and docs, then restore them below /restored
+	press(b, runes("  ")...)
+	if len(b.selected) != 2 {
+		t.Fatalf("expected 2 selected entries, got %d", len(b.selected))
+	}
+	press(b, runes("r")...)
+	if b.mode != modePrompt || string(b.input) != "/tmp/out" {
+		t.Fatalf("expected a prompt for the target, got mode %d with %q", b.mode, string(b.input))
+	}
+	b.input = nil
+	press(b, runes("/restored")...)
+	press(b, KeyEvent{Key: KeyEnter})
+	if b.mode != modeRestore {
+		t.Fatalf("expected a running restore, got %q", b.message)
+	}
+
+	if status := b.statusLine(); !strings.HasPrefix(status, "Restoring [") {
+		t.Errorf("unexpected progress: %s", status)
+	}
+
+	close(restorer.release)
+	waitRestore(t, b)
+
+	var paths []string
+	for _, r := range restorer.records {
+		paths = append(paths, r.FilePath)
+	}
+	if got := strings.Join(paths, " "); got != "/data/a.txt /data/docs/b.txt /data/docs/sub/c.txt" {
+		t.Errorf("unexpected restored files: %s", got)
+	}
+	if restorer.base != "/data" || restorer.opts.To != "/restored" || restorer.opts.InPlace || restorer.opts.Passwords[0] != "pw" {
+		t.Errorf("unexpected restore options: %s %+v", restorer.base, restorer.opts)
+	}
+	if b.message != "Restored 3 files (600 B)" || len(b.selected) != 0 {
+		t.Errorf("unexpected result: %q with %d selected", b.message, len(b.selected))
+	}
+}
+
+func TestBrowserRestoreInPlaceAndCancel(t *testing.T) {
+	b, restorer := newTestBrowser(t)
+
+	// Without a selection the entry under the cursor is restored; an
+	// empty target restores to the original paths
+	press(b, KeyEvent{Key: KeyEnd}, runes("r")[0])
+	b.input = nil
+	press(b, KeyEvent{Key: KeyEnter})
+	if b.mode != modeRestore {
+		t.Fatalf("expected a running restore, got %q", b.message)
+	}
+
+	press(b, runes("q")...)
+	if b.Done() {
+		t.Fatal("expected quit to wait for the restore")
+	}
+	waitRestore(t, b)
+
+	if !b.Done() || !restorer.opts.InPlace || len(restorer.records) != 1 || restorer.records[0].FilePath != "/data/z.txt" {
+		t.Errorf("unexpected restore: done %v, %+v, %+v", b.Done(), restorer.opts, restorer.records)
+	}
+	if !strings.Contains(b.message, "cancelled") {
+		t.Errorf("expected a cancelled restore, got %q", b.message)
+	}
+}
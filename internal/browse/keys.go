@@ -0,0 +1,95 @@
+package browse
+
+import (
+	"unicode/utf8"
+)
+
+// Key is a key press other than a printable character.
+type Key int
+
+const (
+	KeyRune Key = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPageUp
+	KeyPageDown
+	KeyEnter
+	KeyBackspace
+	KeyTab
+	KeyEsc
+	KeyCtrlC
+)
+
+// KeyEvent is a key press. Rune is set for KeyRune.
+type KeyEvent struct {
+	Key  Key
+	Rune rune
+}
+
+// escapeKeys are the keys sent as escape sequences by common terminals,
+// including the Windows console in virtual terminal mode.
+var escapeKeys = map[string]Key{
+	"[A": KeyUp, "[B": KeyDown, "[C": KeyRight, "[D": KeyLeft,
+	"OA": KeyUp, "OB": KeyDown, "OC": KeyRight, "OD": KeyLeft,
+	"[H": KeyHome, "[F": KeyEnd, "OH": KeyHome, "OF": KeyEnd,
+	"[1~": KeyHome, "[4~": KeyEnd, "[7~": KeyHome, "[8~": KeyEnd,
+	"[5~": KeyPageUp, "[6~": KeyPageDown,
+}
+
+// parseKeys decodes the key presses in input read from a terminal in raw
+// mode. A lone escape is the escape key; unknown sequences are dropped.
+func parseKeys(input []byte) []KeyEvent {
+	var keys []KeyEvent
+	for len(input) > 0 {
+		switch c := input[0]; c {
+		case 0x1b:
+			n, key := parseEscape(input[1:])
+			if key != KeyRune {
+				keys = append(keys, KeyEvent{Key: key})
+			}
+			input = input[1+n:]
+			continue
+		case '\r', '\n':
+			keys = append(keys, KeyEvent{Key: KeyEnter})
+			// A terminal may send both for a single press
+			if c == '\r' && len(input) > 1 && input[1] == '\n' {
+				input = input[1:]
+			}
+		case 0x7f, 0x08:
+			keys = append(keys, KeyEvent{Key: KeyBackspace})
+		case '\t':
+			keys = append(keys, KeyEvent{Key: KeyTab})
+		case 0x03:
+			keys = append(keys, KeyEvent{Key: KeyCtrlC})
+		default:
+			r, size := utf8.DecodeRune(input)
+			if r >= ' ' {
+				keys = append(keys, KeyEvent{Key: KeyRune, Rune: r})
+			}
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// parseEscape decodes the escape sequence at the start of seq, which
+// follows an escape character. It returns the length of the sequence and
+// its key, KeyEsc for a lone escape or KeyRune for an unknown sequence.
+func parseEscape(seq []byte) (int, Key) {
+	if len(seq) == 0 || (seq[0] != '[' && seq[0] != 'O') {
+		return 0, KeyEsc
+	}
+	// A sequence ends with its first byte in @ to ~ after the introducer
+	for i := 1; i < len(seq); i++ {
+		if seq[i] >= 0x40 && seq[i] <= 0x7e {
+			return i + 1, escapeKeys[string(seq[:i+1])]
+		}
+	}
+	return len(seq), KeyRune
+}
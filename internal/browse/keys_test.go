@@ -0,0 +1,26 @@
+package browse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []KeyEvent
+	}{
+		{"j", []KeyEvent{{Key: KeyRune, Rune: 'j'}}},
+		{"\x1b[A\x1bOB", []KeyEvent{{Key: KeyUp}, {Key: KeyDown}}},
+		{"\x1b[5~\x1b[6~", []KeyEvent{{Key: KeyPageUp}, {Key: KeyPageDown}}},
+		{"\r\n\x7f", []KeyEvent{{Key: KeyEnter}, {Key: KeyBackspace}}},
+		{"\x1b", []KeyEvent{{Key: KeyEsc}}},
+		{"\x1b[1;5Cé", []KeyEvent{{Key: KeyRune, Rune: 'é'}}},
+		{"\x03", []KeyEvent{{Key: KeyCtrlC}}},
+	}
+	for _, tt := range tests {
+		if got := parseKeys([]byte(tt.input)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeys(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package browse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotTerminal is returned by Run when standard input or output is not
+// an interactive terminal.
+var ErrNotTerminal = errors.New("browse needs an interactive terminal")
+
+// tickInterval is how often the progress of a restore is redrawn.
+const tickInterval = 200 * time.Millisecond
+
+// Run shows b on the terminal of standard input and output until it is
+// quit or ctx is done. A running restore is cancelled and waited for.
+func Run(ctx context.Context, b *Browser) error {
+	restore, err := makeRaw(os.Stdin, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotTerminal, err)
+	}
+	defer restore()
+
+	// Draw on the alternate screen, so the shell is left as it was
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan KeyEvent, 64)
+	go readKeys(os.Stdin, keys)
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for !b.Done() {
+		draw(b)
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				b.Quit()
+				keys = nil
+				continue
+			}
+			b.HandleKey(k)
+		case <-ticker.C:
+			b.Tick()
+		case <-ctx.Done():
+			b.Quit()
+			if b.mode == modeRestore {
+				b.finishRestore(<-b.done)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func draw(b *Browser) {
+	width, height, err := terminalSize(os.Stdout)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	// Leave the last column free, so full lines do not wrap
+	lines := b.View(width-1, height)
+	os.Stdout.WriteString("\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K\x1b[J")
+}
+
+// readKeys sends the key presses read from in until it fails.
+func readKeys(in *os.File, keys chan<- KeyEvent) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package browse
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+// Source is the point in time of the backup catalog being browsed.
+type Source interface {
+	// Label describes the source in the header
+	Label() string
+	// Root returns the directory browsing starts in
+	Root() (string, error)
+	// List returns the entries directly in dir
+	List(dir string) ([]database.CatalogEntry, error)
+	// Files returns the versions of path and of every file below it
+	Files(path string) ([]database.BackupRecord, error)
+}
+
+// VersionsAt returns the newest versions of the backed up files as of at,
+// or the newest versions when at is zero.
+func VersionsAt(db *database.DB, at time.Time) Source {
+	return &versionSource{db: db, at: at}
+}
+
+type versionSource struct {
+	db *database.DB
+	at time.Time
+}
+
+func (s *versionSource) Label() string {
+	if s.at.IsZero() {
+		return "latest versions"
+	}
+	return "versions as of " + s.at.Local().Format("2006-01-02 15:04")
+}
+
+func (s *versionSource) Root() (string, error) {
+	return s.db.CatalogRoot(0)
+}
+
+func (s *versionSource) List(dir string) ([]database.CatalogEntry, error) {
+	return s.db.ListVersionsDir(dir, s.at)
+}
+
+func (s *versionSource) Files(path string) ([]database.BackupRecord, error) {
+	return s.db.LatestVersionsUnder(path, s.at)
+}
+
+// Snapshot returns the files of a snapshot.
+func Snapshot(db *database.DB, snapshot *database.Snapshot) Source {
+	return &snapshotSource{db: db, snapshot: snapshot}
+}
+
+type snapshotSource struct {
+	db       *database.DB
+	snapshot *database.Snapshot
+}
+
+func (s *snapshotSource) Label() string {
+	return fmt.Sprintf("snapshot %d of %s, %s", s.snapshot.ID, s.snapshot.Job, s.snapshot.StartedAt.Local().Format("2006-01-02 15:04"))
+}
+
+func (s *snapshotSource) Root() (string, error) {
+	return s.db.CatalogRoot(s.snapshot.ID)
+}
+
+func (s *snapshotSource) List(dir string) ([]database.CatalogEntry, error) {
+	return s.db.ListSnapshotDir(s.snapshot.ID, dir)
+}
+
+func (s *snapshotSource) Files(path string) ([]database.BackupRecord, error) {
+	return s.db.SnapshotVersionsUnder(s.snapshot.ID, path)
+}
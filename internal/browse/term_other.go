@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package browse
+
+import (
+	"errors"
+	"os"
+)
+
+func makeRaw(in, out *os.File) (func() error, error) {
+	return nil, errors.New("terminal not supported on this platform")
+}
+
+func terminalSize(out *os.File) (int, int, error) {
+	return 0, 0, errors.New("terminal not supported on this platform")
+}
@@ -0,0 +1,260 @@
+package browse
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/koneksi/backup-cli/pkg/database"
+)
+
+var helpText = []string{
+	"Keys",
+	"",
+	"  up, down, k, j      move",
+	"  pgup, pgdn, g, G    move by a page, to the first or last entry",
+	"  enter, right, l     open the directory",
+	"  left, backspace, h  go to the parent directory",
+	"  space               select or unselect the entry",
+	"  a                   select or unselect every entry here",
+	"  r                   restore the selection, or the entry under the cursor",
+	"  q, esc              quit",
+	"",
+	"A restore asks for the directory to restore to. Restored files keep",
+	"their paths below the directory containing the selection. Clear the",
+	"directory to restore files to their original paths; a current file",
+	"with other content is kept with a .bak-<time> suffix.",
+	"",
+	"While restoring, c cancels the restore.",
+	"",
+	"Press any key to return.",
+}
+
+// View renders the browser in width columns and height lines.
+func (b *Browser) View(width, height int) []string {
+	lines := []string{
+		"koneksi-backup browse: " + b.source.Label(),
+		b.dir + b.selectionNote(),
+	}
+
+	rows := max(height-len(lines)-2, 1)
+	b.page = rows
+	if b.mode == modeHelp {
+		lines = append(lines, pad(helpText, rows)...)
+	} else {
+		lines = append(lines, b.listView(width, rows)...)
+	}
+	lines = append(lines, b.statusLine(), b.bottomLine())
+
+	for i := range lines {
+		lines[i] = fit(lines[i], width)
+	}
+	if len(lines) > height {
+		lines = lines[:max(height, 0)]
+	}
+	return lines
+}
+
+func (b *Browser) selectionNote() string {
+	if len(b.selected) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("  (%d selected)", len(b.selected))
+}
+
+// listView renders the entries, next to the details of the entry under
+// the cursor when there is room.
+func (b *Browser) listView(width, rows int) []string {
+	b.offset = max(min(b.offset, b.cursor), b.cursor-rows+1, 0)
+
+	listWidth, previewWidth := width, 0
+	if width >= 60 {
+		listWidth = width * 3 / 5
+		previewWidth = width - listWidth - 3
+	}
+
+	var list []string
+	if len(b.entries) == 0 {
+		list = append(list, "  (empty)")
+	}
+	for i := b.offset; i < len(b.entries) && len(list) < rows; i++ {
+		list = append(list, b.entryLine(i, listWidth))
+	}
+	list = pad(list, rows)
+	if previewWidth == 0 {
+		return list
+	}
+
+	preview := pad(b.preview(), rows)
+	for i := range list {
+		list[i] = fit(list[i], listWidth) + " | " + fit(preview[i], previewWidth)
+	}
+	return list
+}
+
+func (b *Browser) entryLine(i, width int) string {
+	e := b.entries[i]
+	cursor, mark := " ", " "
+	if i == b.cursor {
+		cursor = ">"
+	}
+	if _, ok := b.selected[e.Path]; ok {
+		mark = "*"
+	}
+
+	name := e.Name
+	size := ""
+	if e.Dir {
+		name += "/"
+	} else if e.Record != nil {
+		size = formatBytes(e.Record.OriginalSize)
+	}
+	line := cursor + mark + " " + name
+	if gap := width - utf8.RuneCountInString(line) - utf8.RuneCountInString(size); gap > 0 {
+		return line + strings.Repeat(" ", gap) + size
+	}
+	return line + " " + size
+}
+
+// preview describes the entry under the cursor.
+func (b *Browser) preview() []string {
+	e := b.current()
+	if e == nil {
+		return nil
+	}
+	if e.Dir {
+		return []string{
+			"Directory " + e.Name,
+			"",
+			"Enter to open it, space to select",
+			"every file below it.",
+		}
+	}
+
+	r := e.Record
+	kind := "File"
+	switch {
+	case r.Mode.IsDir():
+		kind = "Directory archive"
+	case r.LinkTarget != "":
+		kind = "Link"
+	}
+	lines := []string{kind + " " + e.Name, ""}
+	field := func(name, value string) {
+		lines = append(lines, fmt.Sprintf("%-10s %s", name+":", value))
+	}
+	if r.Version != 0 {
+		field("Version", fmt.Sprint(r.Version))
+	}
+	if !r.BackupTime.IsZero() {
+		field("Backed up", r.BackupTime.Local().Format("2006-01-02 15:04:05"))
+	}
+	field("Size", formatBytes(r.OriginalSize))
+	if r.IsCompressed {
+		field("Stored", formatBytes(r.CompressedSize))
+	}
+	if r.LinkTarget != "" {
+		field("Target", r.LinkTarget)
+	}
+	if r.Mode != 0 {
+		field("Mode", r.Mode.String())
+	}
+	if r.Owner != nil {
+		field("Owner", fmt.Sprintf("%d:%d", r.Owner.UID, r.Owner.GID))
+	}
+	if !r.ModTime.IsZero() {
+		field("Modified", r.ModTime.Local().Format("2006-01-02 15:04:05"))
+	}
+	field("Checksum", shorten(r.Checksum, 16))
+	field("File ID", r.FileID)
+	if r.SnapshotID != 0 {
+		field("Snapshot", fmt.Sprint(r.SnapshotID))
+	}
+	return lines
+}
+
+func (b *Browser) statusLine() string {
+	if b.mode != modeRestore {
+		return b.message
+	}
+
+	restored, failed, total := b.restored()
+	done := b.progress.RestoredSize - b.start.RestoredSize
+	size := b.progress.TotalSize - b.start.TotalSize
+	fraction := 0.0
+	switch {
+	case size > 0:
+		fraction = float64(done) / float64(size)
+	case total > 0:
+		fraction = float64(restored+failed) / float64(total)
+	}
+
+	const barWidth = 20
+	filled := min(int(fraction*barWidth), barWidth)
+	line := fmt.Sprintf("Restoring [%s%s] %3.0f%%  %d/%d files  %s of %s",
+		strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled), fraction*100,
+		restored, total, formatBytes(done), formatBytes(size))
+	if failed > 0 {
+		line += fmt.Sprintf("  %d failed", failed)
+	}
+	if b.message != "" {
+		line += "  " + b.message
+	}
+	return line
+}
+
+func (b *Browser) bottomLine() string {
+	switch b.mode {
+	case modePrompt:
+		return fmt.Sprintf("Restore %s to (empty for original paths): %s_", describe(b.restoreEntries()), string(b.input))
+	case modeRestore:
+		return "c cancel  q cancel and quit"
+	case modeHelp:
+		return ""
+	}
+	return "space select  enter open  left back  r restore  ? help  q quit"
+}
+
+// describe names the entries to restore.
+func describe(entries []database.CatalogEntry) string {
+	if len(entries) == 1 {
+		return entries[0].Name
+	}
+	return fmt.Sprintf("%d entries", len(entries))
+}
+
+// fit pads or truncates s to width columns.
+func fit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n <= width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return string([]rune(s)[:max(width, 0)])
+}
+
+// pad extends lines to n lines, dropping the lines past n.
+func pad(lines []string, n int) []string {
+	padded := make([]string, n)
+	copy(padded, lines)
+	return padded
+}
+
+func shorten(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -4,7 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
@@ -16,25 +22,71 @@ type Config struct {
 		DirectoryID  string `mapstructure:"directory_id"`
 		Timeout      int    `mapstructure:"timeout"`
 		RetryCount   int    `mapstructure:"retry_count"`
+		// Delays between retries in seconds; Retry-After takes precedence up to
+		// the maximum
+		RetryBaseDelay int `mapstructure:"retry_base_delay"`
+		RetryMaxDelay  int `mapstructure:"retry_max_delay"`
+		// Shared limit for uploads and downloads, e.g. "2MB" per second
+		BandwidthLimit struct {
+			Rate     string            `mapstructure:"rate"`
+			Schedule []BandwidthWindow `mapstructure:"schedule"`
+		} `mapstructure:"bandwidth_limit"`
 	} `mapstructure:"api"`
 
 	Backup struct {
-		Directories   []string `mapstructure:"directories"`
+		// Directories are watched backup roots, each a path or an object
+		// with per-directory overrides
+		Directories   []Directory `mapstructure:"directories"`
 		ExcludePatterns []string `mapstructure:"exclude_patterns"`
 		CheckInterval int      `mapstructure:"check_interval"`
 		MaxFileSize   int64    `mapstructure:"max_file_size"`
 		Concurrent    int      `mapstructure:"concurrent"`
-		Compression   struct {
-			Enabled bool   `mapstructure:"enabled"`
-			Level   int    `mapstructure:"level"`
-			Format  string `mapstructure:"format"`
-		} `mapstructure:"compression"`
-		Encryption struct {
-			Enabled  bool   `mapstructure:"enabled"`
-			Password string `mapstructure:"password"`
-		} `mapstructure:"encryption"`
+		// Seconds to wait for running uploads on shutdown
+		ShutdownTimeout int `mapstructure:"shutdown_timeout"`
+		Compression   Compression `mapstructure:"compression"`
+		Encryption    Encryption  `mapstructure:"encryption"`
+		Retry struct {
+			MaxAttempts int `mapstructure:"max_attempts"`
+			BaseDelay   int `mapstructure:"base_delay"`
+			MaxDelay    int `mapstructure:"max_delay"`
+		} `mapstructure:"retry"`
+		Watch struct {
+			Mode            string   `mapstructure:"mode"`
+			PollInterval    int      `mapstructure:"poll_interval"`
+			PollDirectories []string `mapstructure:"poll_directories"`
+		} `mapstructure:"watch"`
+		// Jobs back up paths on a cron schedule instead of watching them
+		Jobs []Job `mapstructure:"jobs"`
+		// RemoteLayout is LayoutFlat or LayoutMirror
+		RemoteLayout string `mapstructure:"remote_layout"`
 	} `mapstructure:"backup"`
 
+	// Targets receive a copy of every file backed up by the service in
+	// addition to the Koneksi directory of the api section, the primary
+	Targets []Target `mapstructure:"targets"`
+
+	// Prune removes old versions of backed up files and the remote objects
+	// no longer referenced
+	Prune struct {
+		// Enabled runs prune in the backup service every Interval hours
+		Enabled    bool `mapstructure:"enabled"`
+		Interval   int  `mapstructure:"interval"`
+		KeepPolicy `mapstructure:",squash"`
+	} `mapstructure:"prune"`
+
+	// Catalog is an encrypted copy of the backup database uploaded to the
+	// Koneksi directory, used to set up a new machine after a loss
+	Catalog struct {
+		// Enabled uploads the catalog in the backup service every Interval
+		// hours
+		Enabled  bool `mapstructure:"enabled"`
+		Interval int  `mapstructure:"interval"`
+		// Versions is the number of uploaded catalogs kept
+		Versions int `mapstructure:"versions"`
+		// Password defaults to backup.encryption.password
+		Password string `mapstructure:"password"`
+	} `mapstructure:"catalog"`
+
 	Report struct {
 		Directory string `mapstructure:"directory"`
 		Format    string `mapstructure:"format"`
@@ -51,8 +103,182 @@ type Config struct {
 		Path      string `mapstructure:"path"`
 		Retention int    `mapstructure:"retention"`
 	} `mapstructure:"database"`
+
+	Control struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Socket  string `mapstructure:"socket"`
+	} `mapstructure:"control"`
+}
+
+// BandwidthWindow applies a rate between two times of day ("HH:MM").
+type BandwidthWindow struct {
+	Start string `mapstructure:"start"`
+	End   string `mapstructure:"end"`
+	Rate  string `mapstructure:"rate"`
+}
+
+// Compression configures compression of uploaded files.
+type Compression struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Level   int    `mapstructure:"level"`
+	Format  string `mapstructure:"format"`
+}
+
+// Encryption configures encryption of uploaded files.
+type Encryption struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Password string `mapstructure:"password"`
+}
+
+// Directory is a backup root from backup.directories. Unset overrides
+// fall back to the global backup settings.
+type Directory struct {
+	Path string `mapstructure:"path"`
+	// ExcludePatterns are applied in addition to the global ones
+	ExcludePatterns []string `mapstructure:"exclude_patterns"`
+	MaxFileSize     *int64   `mapstructure:"max_file_size"`
+	// Compression replaces the global block; an unset level or format is
+	// taken from it
+	Compression *Compression `mapstructure:"compression"`
+	// Encryption replaces the global block; without a password the
+	// global one is used
+	Encryption *Encryption `mapstructure:"encryption"`
+	// Retention is the number of days backup records below the directory
+	// are kept instead of database.retention
+	Retention *int `mapstructure:"retention"`
+	// Prune replaces the global prune rules for files below the directory
+	Prune *KeepPolicy `mapstructure:"prune"`
+}
+
+// KeepPolicy selects the versions of a file or the snapshots of a job that
+// prune keeps. An item is kept when any rule selects it; zero disables a
+// rule.
+type KeepPolicy struct {
+	KeepLast    int `mapstructure:"keep_last"`
+	KeepHourly  int `mapstructure:"keep_hourly"`
+	KeepDaily   int `mapstructure:"keep_daily"`
+	KeepWeekly  int `mapstructure:"keep_weekly"`
+	KeepMonthly int `mapstructure:"keep_monthly"`
+	// KeepWithin keeps everything younger than this duration, counted
+	// back from the newest item, e.g. "30d" or "12h"
+	KeepWithin string `mapstructure:"keep_within"`
 }
 
+// IsZero reports whether no rule is set, in which case nothing is pruned.
+func (k KeepPolicy) IsZero() bool {
+	return k == KeepPolicy{}
+}
+
+// Within parses KeepWithin with ParseAge; an empty value is zero.
+func (k KeepPolicy) Within() (time.Duration, error) {
+	if k.KeepWithin == "" {
+		return 0, nil
+	}
+	d, err := ParseAge(k.KeepWithin)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keep_within %q: must be a duration such as 30d or 12h", k.KeepWithin)
+	}
+	return d, nil
+}
+
+// ParseAge parses a non-negative age. Besides Go durations it accepts a
+// number of days ("30d") or weeks ("4w").
+func ParseAge(s string) (time.Duration, error) {
+	if s != "" {
+		value, unit := s[:len(s)-1], s[len(s)-1]
+		switch unit {
+		case 'd', 'w':
+			days, err := strconv.Atoi(value)
+			if err == nil && days >= 0 {
+				if unit == 'w' {
+					days *= 7
+				}
+				return time.Duration(days) * 24 * time.Hour, nil
+			}
+		default:
+			if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+				return d, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid age %q: must be a duration such as 7d or 12h", s)
+}
+
+func (k KeepPolicy) validate() error {
+	if k.KeepLast < 0 || k.KeepHourly < 0 || k.KeepDaily < 0 || k.KeepWeekly < 0 || k.KeepMonthly < 0 {
+		return fmt.Errorf("keep values must not be negative")
+	}
+	_, err := k.Within()
+	return err
+}
+
+// Remote layouts.
+const (
+	// LayoutFlat uploads every file into the directory of the api section
+	LayoutFlat = "flat"
+	// LayoutMirror recreates the local directory tree below it
+	LayoutMirror = "mirror"
+)
+
+// Target types.
+const (
+	// TargetKoneksi is a Koneksi directory, possibly of another account
+	TargetKoneksi = "koneksi"
+	// TargetLocal is a directory on a local or mounted filesystem
+	TargetLocal = "local"
+)
+
+// PrimaryTarget is the name of the target configured in the api section.
+const PrimaryTarget = "primary"
+
+// Target is a secondary backup target.
+type Target struct {
+	Name string `mapstructure:"name"`
+	Type string `mapstructure:"type"`
+	// Async targets are written in the background after the primary upload;
+	// otherwise a backup only succeeds once the target has a copy
+	Async bool `mapstructure:"async"`
+	// Path is the directory of a local target
+	Path string `mapstructure:"path"`
+	// API settings of a Koneksi target; unset ones are taken from the api
+	// section
+	API struct {
+		BaseURL      string `mapstructure:"base_url"`
+		ClientID     string `mapstructure:"client_id"`
+		ClientSecret string `mapstructure:"client_secret"`
+		DirectoryID  string `mapstructure:"directory_id"`
+	} `mapstructure:"api"`
+}
+
+// Job modes.
+const (
+	// JobModeFiles uploads changed files one by one
+	JobModeFiles = "files"
+	// JobModeArchive uploads each path as a single tar.gz archive
+	JobModeArchive = "archive"
+)
+
+// Job is a scheduled backup of a set of paths. Every run creates one
+// snapshot and one report.
+type Job struct {
+	Name            string   `mapstructure:"name"`
+	Schedule        string   `mapstructure:"schedule"`
+	Paths           []string `mapstructure:"paths"`
+	Mode            string   `mapstructure:"mode"`
+	ExcludePatterns []string `mapstructure:"exclude_patterns"`
+	// Retention keeps the snapshots selected by the keep rules and those
+	// younger than KeepDays; zero disables a rule
+	Retention struct {
+		KeepPolicy `mapstructure:",squash"`
+		KeepDays   int `mapstructure:"keep_days"`
+	} `mapstructure:"retention"`
+	// Encryption defaults to backup.encryption.password when no password
+	// is set for the job
+	Encryption Encryption `mapstructure:"encryption"`
+}
+
+var jobNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 var cfg *Config
 
 func Load(configPath string) (*Config, error) {
@@ -76,14 +302,28 @@ func Load(configPath string) (*Config, error) {
 	viper.SetDefault("api.directory_id", "6839deb70fe80fe0747654b2") // Default directory
 	viper.SetDefault("api.timeout", 30)
 	viper.SetDefault("api.retry_count", 3)
+	viper.SetDefault("api.retry_base_delay", 1)
+	viper.SetDefault("api.retry_max_delay", 30)
 	viper.SetDefault("backup.check_interval", 300)
 	viper.SetDefault("backup.max_file_size", 1073741824) // 1GB
 	viper.SetDefault("backup.concurrent", 5)
+	viper.SetDefault("backup.shutdown_timeout", 30)
 	viper.SetDefault("backup.compression.enabled", false)
 	viper.SetDefault("backup.compression.level", 6) // 1-9, 6 is default gzip
 	viper.SetDefault("backup.compression.format", "gzip")
 	viper.SetDefault("backup.encryption.enabled", false)
 	viper.SetDefault("backup.encryption.password", "")
+	viper.SetDefault("backup.retry.max_attempts", 10)
+	viper.SetDefault("backup.retry.base_delay", 5) // seconds
+	viper.SetDefault("backup.retry.max_delay", 3600)
+	viper.SetDefault("backup.watch.mode", "auto")
+	viper.SetDefault("backup.watch.poll_interval", 60)
+	viper.SetDefault("backup.remote_layout", LayoutFlat)
+	viper.SetDefault("prune.enabled", false)
+	viper.SetDefault("prune.interval", 24) // hours
+	viper.SetDefault("catalog.enabled", false)
+	viper.SetDefault("catalog.interval", 24) // hours
+	viper.SetDefault("catalog.versions", 7)
 	viper.SetDefault("report.directory", "./reports")
 	viper.SetDefault("report.format", "json")
 	viper.SetDefault("report.retention", 30)
@@ -91,6 +331,7 @@ func Load(configPath string) (*Config, error) {
 	viper.SetDefault("log.format", "json")
 	viper.SetDefault("database.path", "./backup.db")
 	viper.SetDefault("database.retention", 90)
+	viper.SetDefault("control.enabled", true)
 
 	viper.SetEnvPrefix("KONEKSI")
 	viper.AutomaticEnv()
@@ -102,13 +343,28 @@ func Load(configPath string) (*Config, error) {
 	}
 
 	cfg = &Config{}
-	if err := viper.Unmarshal(cfg); err != nil {
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToDirectoryHook,
+	))
+	if err := viper.Unmarshal(cfg, decodeHook); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	cfg.Control.Socket = controlSocketPath(cfg.Control.Socket)
 
 	return cfg, nil
 }
 
+// stringToDirectoryHook decodes a plain path in backup.directories into a
+// Directory without overrides.
+func stringToDirectoryHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(Directory{}) {
+		return Directory{Path: data.(string)}, nil
+	}
+	return data, nil
+}
+
 func Get() *Config {
 	if cfg == nil {
 		panic("config not loaded")
@@ -123,8 +379,271 @@ func (c *Config) Validate() error {
 	if c.API.ClientSecret == "" {
 		return fmt.Errorf("API client secret is required. Set it in config.yaml or use KONEKSI_API_CLIENT_SECRET environment variable")
 	}
-	if len(c.Backup.Directories) == 0 {
-		return fmt.Errorf("at least one backup directory must be specified")
+	if len(c.Backup.Directories) == 0 && len(c.Backup.Jobs) == 0 {
+		return fmt.Errorf("at least one backup directory or job must be specified")
+	}
+	switch c.Backup.Watch.Mode {
+	case "", "auto", "inotify", "poll":
+	default:
+		return fmt.Errorf("invalid backup.watch.mode %q: must be auto, inotify or poll", c.Backup.Watch.Mode)
+	}
+	switch c.Backup.RemoteLayout {
+	case "", LayoutFlat, LayoutMirror:
+	default:
+		return fmt.Errorf("invalid backup.remote_layout %q: must be flat or mirror", c.Backup.RemoteLayout)
+	}
+
+	roots := make(map[string]bool)
+	for i, dir := range c.Backup.Directories {
+		if dir.Path == "" {
+			return fmt.Errorf("backup.directories[%d]: path is required", i)
+		}
+		absPath, err := filepath.Abs(dir.Path)
+		if err != nil {
+			return fmt.Errorf("backup.directories[%d]: failed to resolve path %s: %w", i, dir.Path, err)
+		}
+		if roots[absPath] {
+			return fmt.Errorf("backup.directories[%d]: duplicate directory %s", i, dir.Path)
+		}
+		roots[absPath] = true
+
+		if dir.MaxFileSize != nil && *dir.MaxFileSize <= 0 {
+			return fmt.Errorf("backup directory %s: max_file_size must be positive", dir.Path)
+		}
+		if dir.Retention != nil && *dir.Retention <= 0 {
+			return fmt.Errorf("backup directory %s: retention must be at least one day", dir.Path)
+		}
+		if c.DirectoryEncryption(dir).Enabled && c.DirectoryPassword(dir) == "" {
+			return fmt.Errorf("backup directory %s: encryption is enabled but no password is set", dir.Path)
+		}
+		if dir.Prune != nil {
+			if err := dir.Prune.validate(); err != nil {
+				return fmt.Errorf("backup directory %s: prune: %w", dir.Path, err)
+			}
+		}
+	}
+
+	if err := c.Prune.validate(); err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+	if c.Prune.Enabled && c.Prune.Interval < 1 {
+		return fmt.Errorf("prune.interval must be at least one hour")
+	}
+	if c.Catalog.Enabled {
+		if c.Catalog.Interval < 1 {
+			return fmt.Errorf("catalog.interval must be at least one hour")
+		}
+		if c.CatalogPassword() == "" {
+			return fmt.Errorf("catalog is enabled but no password is set")
+		}
+	}
+	if c.Catalog.Versions < 0 {
+		return fmt.Errorf("catalog.versions must not be negative")
+	}
+
+	targets := map[string]bool{PrimaryTarget: true}
+	for i, target := range c.Targets {
+		if !jobNamePattern.MatchString(target.Name) {
+			return fmt.Errorf("targets[%d]: name %q must be non-empty and contain only letters, digits, '-' and '_'", i, target.Name)
+		}
+		if targets[target.Name] {
+			return fmt.Errorf("targets[%d]: duplicate or reserved target name %q", i, target.Name)
+		}
+		targets[target.Name] = true
+
+		switch target.Type {
+		case TargetLocal:
+			if target.Path == "" {
+				return fmt.Errorf("target %q: path is required for a local target", target.Name)
+			}
+		case TargetKoneksi:
+		default:
+			return fmt.Errorf("target %q: invalid type %q: must be koneksi or local", target.Name, target.Type)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, job := range c.Backup.Jobs {
+		if !jobNamePattern.MatchString(job.Name) {
+			return fmt.Errorf("backup.jobs[%d]: name %q must be non-empty and contain only letters, digits, '-' and '_'", i, job.Name)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("backup.jobs[%d]: duplicate job name %q", i, job.Name)
+		}
+		names[job.Name] = true
+
+		if job.Schedule == "" {
+			return fmt.Errorf("backup job %q: schedule is required", job.Name)
+		}
+		if len(job.Paths) == 0 {
+			return fmt.Errorf("backup job %q: at least one path is required", job.Name)
+		}
+		switch job.Mode {
+		case "", JobModeFiles, JobModeArchive:
+		default:
+			return fmt.Errorf("backup job %q: invalid mode %q: must be files or archive", job.Name, job.Mode)
+		}
+		if job.Retention.KeepDays < 0 {
+			return fmt.Errorf("backup job %q: retention values must not be negative", job.Name)
+		}
+		if err := job.Retention.validate(); err != nil {
+			return fmt.Errorf("backup job %q: retention: %w", job.Name, err)
+		}
+		if job.Encryption.Enabled && c.JobPassword(job) == "" {
+			return fmt.Errorf("backup job %q: encryption is enabled but no password is set", job.Name)
+		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// JobPassword returns the encryption password for a job: its own, the
+// global backup.encryption.password, or KONEKSI_BACKUP_ENCRYPTION_PASSWORD.
+func (c *Config) JobPassword(job Job) string {
+	return c.password(job.Encryption.Password)
+}
+
+// CatalogPassword returns the password the catalog is encrypted with, with
+// the same fallbacks as JobPassword.
+func (c *Config) CatalogPassword() string {
+	return c.password(c.Catalog.Password)
+}
+
+// DirectoryPaths returns the paths of backup.directories.
+func (c *Config) DirectoryPaths() []string {
+	paths := make([]string, 0, len(c.Backup.Directories))
+	for _, dir := range c.Backup.Directories {
+		paths = append(paths, dir.Path)
+	}
+	return paths
+}
+
+// DirectoryCompression returns the compression settings in effect for a
+// backup directory.
+func (c *Config) DirectoryCompression(dir Directory) Compression {
+	if dir.Compression == nil {
+		return c.Backup.Compression
+	}
+	compression := *dir.Compression
+	if compression.Format == "" {
+		compression.Format = c.Backup.Compression.Format
+	}
+	if compression.Level == 0 {
+		compression.Level = c.Backup.Compression.Level
+	}
+	return compression
+}
+
+// DirectoryEncryption returns the encryption settings in effect for a
+// backup directory.
+func (c *Config) DirectoryEncryption(dir Directory) Encryption {
+	if dir.Encryption == nil {
+		return c.Backup.Encryption
+	}
+	return *dir.Encryption
+}
+
+// DirectoryPassword returns the encryption password for a backup
+// directory, with the same fallbacks as JobPassword.
+func (c *Config) DirectoryPassword(dir Directory) string {
+	return c.password(c.DirectoryEncryption(dir).Password)
+}
+
+// RestorePasswords returns the distinct passwords backups may be encrypted
+// with under this configuration: those of the backup directories, the jobs
+// and the global one.
+func (c *Config) RestorePasswords() []string {
+	candidates := []string{c.password("")}
+	for _, dir := range c.Backup.Directories {
+		candidates = append(candidates, c.DirectoryPassword(dir))
+	}
+	for _, job := range c.Backup.Jobs {
+		candidates = append(candidates, c.JobPassword(job))
+	}
+
+	var passwords []string
+	seen := make(map[string]bool)
+	for _, password := range candidates {
+		if password != "" && !seen[password] {
+			seen[password] = true
+			passwords = append(passwords, password)
+		}
+	}
+	return passwords
+}
+
+func (c *Config) password(own string) string {
+	if own != "" {
+		return own
+	}
+	if c.Backup.Encryption.Password != "" {
+		return c.Backup.Encryption.Password
+	}
+	return os.Getenv("KONEKSI_BACKUP_ENCRYPTION_PASSWORD")
+}
+
+// RecordRetention maps the absolute path of every backup directory with
+// its own retention to the number of days its backup records are kept.
+func (c *Config) RecordRetention() map[string]int {
+	rules := make(map[string]int)
+	for _, dir := range c.Backup.Directories {
+		if dir.Retention == nil {
+			continue
+		}
+		absPath, err := filepath.Abs(dir.Path)
+		if err != nil {
+			absPath = dir.Path
+		}
+		rules[absPath] = *dir.Retention
+	}
+	return rules
+}
+
+// DirectoryPrune returns the prune rules in effect for a backup directory.
+func (c *Config) DirectoryPrune(dir Directory) KeepPolicy {
+	if dir.Prune == nil {
+		return c.Prune.KeepPolicy
+	}
+	return *dir.Prune
+}
+
+// WatchMode returns the watch mode for a backup directory. Directories listed
+// in backup.watch.poll_directories are always polled.
+func (c *Config) WatchMode(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	for _, pollDir := range c.Backup.Watch.PollDirectories {
+		absPoll, err := filepath.Abs(pollDir)
+		if err != nil {
+			absPoll = pollDir
+		}
+		if absPoll == absDir {
+			return "poll"
+		}
+	}
+	if c.Backup.Watch.Mode == "" {
+		return "auto"
+	}
+	return c.Backup.Watch.Mode
+}
+
+// controlSocketPath expands a leading "~/" in the configured socket path and
+// falls back to ~/.koneksi-backup/control.sock when none is set.
+func controlSocketPath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		if path == "" {
+			return filepath.Join(os.TempDir(), "koneksi-backup.sock")
+		}
+		return path
+	}
+
+	if path == "" {
+		return filepath.Join(home, ".koneksi-backup", "control.sock")
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadDirectoryPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+api:
+  client_id: "id"
+  client_secret: "secret"
+backup:
+  directories:
+    - "/home/user/documents"
+    - path: "/srv/media"
+      max_file_size: 21474836480
+      compression:
+        enabled: false
+    - path: "/home/finance"
+      exclude_patterns: ["*.bak"]
+      encryption:
+        enabled: true
+        password: "finance"
+      retention: 30
+  compression:
+    enabled: true
+    level: 9
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	dirs := cfg.Backup.Directories
+	if len(dirs) != 3 {
+		t.Fatalf("expected 3 directories, got %d", len(dirs))
+	}
+	if dirs[0].Path != "/home/user/documents" || dirs[0].Compression != nil || dirs[0].MaxFileSize != nil {
+		t.Errorf("plain path should have no overrides: %+v", dirs[0])
+	}
+	if dirs[1].MaxFileSize == nil || *dirs[1].MaxFileSize != 21474836480 {
+		t.Errorf("unexpected media max_file_size: %v", dirs[1].MaxFileSize)
+	}
+	if got := cfg.DirectoryCompression(dirs[1]); got.Enabled || got.Level != 9 || got.Format != "gzip" {
+		t.Errorf("unexpected media compression: %+v", got)
+	}
+	if got := cfg.DirectoryCompression(dirs[0]); !got.Enabled {
+		t.Errorf("plain path should use the global compression: %+v", got)
+	}
+	if cfg.DirectoryPassword(dirs[2]) != "finance" || len(dirs[2].ExcludePatterns) != 1 {
+		t.Errorf("unexpected finance directory: %+v", dirs[2])
+	}
+	if rules := cfg.RecordRetention(); len(rules) != 1 || rules["/home/finance"] != 30 {
+		t.Errorf("unexpected record retention: %v", rules)
+	}
+
+	cfg.Backup.Directories = append(cfg.Backup.Directories, Directory{Path: "/srv/media/"})
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected a duplicate directory to be rejected")
+	}
+	cfg.Backup.Directories = dirs
+
+	cfg.Backup.Directories[2].Encryption.Password = ""
+	t.Setenv("KONEKSI_BACKUP_ENCRYPTION_PASSWORD", "")
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected encryption without a password to be rejected")
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	cfg := &Config{}
+	cfg.API.ClientID = "id"
+	cfg.API.ClientSecret = "secret"
+	cfg.Backup.Directories = []Directory{{Path: "/data"}}
+
+	cfg.Targets = []Target{{Name: "nas", Type: TargetLocal, Path: "/mnt/nas"}, {Name: "offsite", Type: TargetKoneksi, Async: true}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	cfg.Backup.RemoteLayout = "nested"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an invalid remote layout to be rejected")
+	}
+	cfg.Backup.RemoteLayout = LayoutMirror
+
+	invalid := []Target{
+		{Name: "primary", Type: TargetKoneksi},
+		{Name: "nas", Type: TargetLocal},
+		{Name: "tape", Type: "tape"},
+		{Name: "bad name", Type: TargetKoneksi},
+	}
+	for _, target := range invalid {
+		cfg.Targets = []Target{target}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected target %+v to be rejected", target)
+		}
+	}
+}
+
+func TestLoadPruneRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+api:
+  client_id: "id"
+  client_secret: "secret"
+backup:
+  directories:
+    - path: "/srv/media"
+      prune:
+        keep_last: 1
+  jobs:
+    - name: "db"
+      schedule: "@daily"
+      paths: ["/var/backups/db"]
+      retention:
+        keep_days: 30
+        keep_monthly: 6
+prune:
+  keep_daily: 7
+  keep_within: "2w"
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	if cfg.Prune.Enabled || cfg.Prune.Interval != 24 || cfg.Prune.KeepDaily != 7 {
+		t.Errorf("unexpected prune settings: %+v", cfg.Prune)
+	}
+	if within, err := cfg.Prune.Within(); err != nil || within != 14*24*time.Hour {
+		t.Errorf("unexpected keep_within: %v, %v", within, err)
+	}
+	if got := cfg.DirectoryPrune(cfg.Backup.Directories[0]); got != (KeepPolicy{KeepLast: 1}) {
+		t.Errorf("unexpected directory prune rules: %+v", got)
+	}
+	if retention := cfg.Backup.Jobs[0].Retention; retention.KeepDays != 30 || retention.KeepMonthly != 6 {
+		t.Errorf("unexpected job retention: %+v", retention)
+	}
+
+	for _, within := range []string{"36h", "30d", "0d"} {
+		if _, err := (KeepPolicy{KeepWithin: within}).Within(); err != nil {
+			t.Errorf("%s: unexpected error: %v", within, err)
+		}
+	}
+	for _, within := range []string{"d", "-1d", "month", "-5h"} {
+		cfg.Prune.KeepWithin = within
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected keep_within %q to be rejected", within)
+		}
+	}
+}
+
+func TestRestorePasswords(t *testing.T) {
+	t.Setenv("KONEKSI_BACKUP_ENCRYPTION_PASSWORD", "")
+
+	cfg := &Config{}
+	cfg.Backup.Encryption.Password = "global"
+	cfg.Backup.Directories = []Directory{
+		{Path: "/data"},
+		{Path: "/private", Encryption: &Encryption{Enabled: true, Password: "private"}},
+	}
+	cfg.Backup.Jobs = []Job{{Name: "dumps", Encryption: Encryption{Enabled: true, Password: "private"}}}
+
+	got := cfg.RestorePasswords()
+	if len(got) != 2 || got[0] != "global" || got[1] != "private" {
+		t.Errorf("expected the distinct passwords, got %v", got)
+	}
+}
+
+func TestValidateCatalog(t *testing.T) {
+	t.Setenv("KONEKSI_BACKUP_ENCRYPTION_PASSWORD", "")
+
+	cfg := &Config{}
+	cfg.API.ClientID = "id"
+	cfg.API.ClientSecret = "secret"
+	cfg.Backup.Directories = []Directory{{Path: "/data"}}
+	cfg.Catalog.Enabled = true
+	cfg.Catalog.Interval = 24
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an enabled catalog without a password to be rejected")
+	}
+
+	cfg.Backup.Encryption.Password = "backup"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if got := cfg.CatalogPassword(); got != "backup" {
+		t.Errorf("expected the backup password, got %q", got)
+	}
+	cfg.Catalog.Password = "catalog"
+	if got := cfg.CatalogPassword(); got != "catalog" {
+		t.Errorf("expected the catalog password, got %q", got)
+	}
+
+	cfg.Catalog.Versions = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected negative catalog.versions to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// requestTimeout bounds requests that have no deadline of their own.
+const requestTimeout = 30 * time.Second
+
+// Client talks to a running daemon over its control socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the live daemon status.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.call(ctx, "GET", "/v1/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) Pause(ctx context.Context) error {
+	return c.call(ctx, "POST", "/v1/pause", nil, nil)
+}
+
+func (c *Client) Resume(ctx context.Context) error {
+	return c.call(ctx, "POST", "/v1/resume", nil, nil)
+}
+
+// Drain pauses the daemon and waits for running uploads to finish. The
+// request stays open until the daemon has drained or timeout has passed.
+func (c *Client) Drain(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+requestTimeout)
+	defer cancel()
+	return c.call(ctx, "POST", "/v1/drain", DrainRequest{Timeout: timeout.String()}, nil)
+}
+
+// Scan asks the daemon to rescan path, or all watched directories when
+// path is empty, and returns the number of files checked.
+func (c *Client) Scan(ctx context.Context, path string) (int, error) {
+	var resp ScanResponse
+	if err := c.call(ctx, "POST", "/v1/scan", ScanRequest{Path: path}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Files, nil
+}
+
+// AddDirectory starts watching an absolute path. An empty mode uses the
+// configured watch mode.
+func (c *Client) AddDirectory(ctx context.Context, path, mode string) error {
+	return c.call(ctx, "POST", "/v1/directories", DirectoryRequest{Path: path, Mode: mode}, nil)
+}
+
+func (c *Client) RemoveDirectory(ctx context.Context, path string) error {
+	return c.call(ctx, "DELETE", "/v1/directories?path="+url.QueryEscape(path), nil, nil)
+}
+
+// SetBandwidth overrides the bandwidth limit with a rate such as "2MB".
+func (c *Client) SetBandwidth(ctx context.Context, rate string) error {
+	return c.call(ctx, "PUT", "/v1/bandwidth", BandwidthRequest{Rate: rate}, nil)
+}
+
+// ResetBandwidth returns to the configured bandwidth schedule.
+func (c *Client) ResetBandwidth(ctx context.Context) error {
+	return c.call(ctx, "DELETE", "/v1/bandwidth", nil, nil)
+}
+
+// RunJob starts a run of a scheduled backup job. It returns once the run
+// has started.
+func (c *Client) RunJob(ctx context.Context, name string) error {
+	return c.call(ctx, "POST", "/v1/jobs/"+url.PathEscape(name)+"/run", nil, nil)
+}
+
+// Reload asks the daemon to reread its configuration file.
+func (c *Client) Reload(ctx context.Context) error {
+	return c.call(ctx, "POST", "/v1/reload", nil, nil)
+}
+
+func (c *Client) call(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// The host is ignored; requests always go to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://koneksi-backup"+endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return ErrNotRunning
+		}
+		return fmt.Errorf("failed to reach daemon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+		}
+		return errors.New(errResp.Error)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+// Package control exposes a running backup daemon over a Unix domain socket
+// so that CLI commands can inspect and steer it.
+package control
+
+import (
+	"errors"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/monitor"
+)
+
+// ErrNotRunning is returned by the client when no daemon is listening on
+// the control socket.
+var ErrNotRunning = errors.New("backup daemon is not running")
+
+// Daemon is the part of the running service that the control server
+// exposes. It is implemented by the run command.
+type Daemon interface {
+	Status() (*Status, error)
+	Pause() error
+	Resume() error
+	// Drain pauses the service and waits up to timeout for running
+	// uploads; uploads still running afterwards are requeued.
+	Drain(timeout time.Duration) error
+	// Scan queues every file below path, or below all watched
+	// directories when path is empty, and returns the number of files.
+	Scan(path string) (int, error)
+	AddDirectory(path, mode string) error
+	RemoveDirectory(path string) error
+	// SetBandwidth overrides the configured bandwidth schedule; a rate of
+	// 0 disables limiting.
+	SetBandwidth(rate int64) error
+	// ResetBandwidth returns to the configured schedule.
+	ResetBandwidth() error
+	// RunJob starts a run of a scheduled backup job without waiting for it.
+	RunJob(name string) error
+	// Reload rereads the configuration file. An invalid configuration is
+	// rejected and the current one is kept.
+	Reload() error
+}
+
+// Status is a live snapshot of the daemon.
+type Status struct {
+	PID           int                       `json:"pid"`
+	StartedAt     time.Time                 `json:"started_at"`
+	Paused        bool                      `json:"paused"`
+	QueueDepth    int                       `json:"queue_depth"`
+	DeadLetters   int                       `json:"dead_letters"`
+	ActiveUploads []Upload                  `json:"active_uploads"`
+	Workers       []backup.WorkerStatus     `json:"workers"`
+	Directories   []monitor.DirectoryStatus `json:"directories"`
+	Bandwidth     Bandwidth                 `json:"bandwidth"`
+	Jobs          []backup.JobStatus        `json:"jobs"`
+	Targets       []backup.TargetStatus     `json:"targets,omitempty"`
+}
+
+// Upload is a file currently being backed up.
+type Upload struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Worker    int       `json:"worker"`
+	Attempt   int       `json:"attempt"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Bandwidth is the rate limit currently in effect, in bytes per second.
+// A rate of 0 means unlimited.
+type Bandwidth struct {
+	Rate     int64 `json:"rate"`
+	Override bool  `json:"override"`
+}
+
+// DrainRequest asks the daemon to drain within Timeout, a duration such as
+// "5m". An empty timeout uses DefaultDrainTimeout.
+type DrainRequest struct {
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// DefaultDrainTimeout is used when a drain request has no timeout.
+const DefaultDrainTimeout = 30 * time.Second
+
+// ScanRequest asks the daemon to rescan a directory.
+type ScanRequest struct {
+	Path string `json:"path"`
+}
+
+// ScanResponse reports how many files a scan queued for checking.
+type ScanResponse struct {
+	Files int `json:"files"`
+}
+
+// DirectoryRequest adds a directory to the watch list.
+type DirectoryRequest struct {
+	Path string `json:"path"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// BandwidthRequest overrides the bandwidth limit, e.g. "2MB" or "unlimited".
+type BandwidthRequest struct {
+	Rate string `json:"rate"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+package control
+
+import "net"
+
+// peerUID is not supported on this platform; access relies on the
+// permissions of the socket alone.
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errNoPeerCredentials
+}
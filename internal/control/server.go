@@ -0,0 +1,320 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/api"
+	"github.com/koneksi/backup-cli/internal/backup"
+	"go.uber.org/zap"
+)
+
+// Server serves the control API over HTTP on a Unix domain socket. The
+// socket is only accessible to the user running the daemon.
+type Server struct {
+	socketPath string
+	daemon     Daemon
+	logger     *zap.Logger
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+func NewServer(socketPath string, daemon Daemon, logger *zap.Logger) *Server {
+	s := &Server{
+		socketPath: socketPath,
+		daemon:     daemon,
+		logger:     logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	mux.HandleFunc("POST /v1/pause", s.handlePause)
+	mux.HandleFunc("POST /v1/resume", s.handleResume)
+	mux.HandleFunc("POST /v1/drain", s.handleDrain)
+	mux.HandleFunc("POST /v1/scan", s.handleScan)
+	mux.HandleFunc("POST /v1/directories", s.handleAddDirectory)
+	mux.HandleFunc("DELETE /v1/directories", s.handleRemoveDirectory)
+	mux.HandleFunc("PUT /v1/bandwidth", s.handleSetBandwidth)
+	mux.HandleFunc("DELETE /v1/bandwidth", s.handleResetBandwidth)
+	mux.HandleFunc("POST /v1/reload", s.handleReload)
+	mux.HandleFunc("POST /v1/jobs/{name}/run", s.handleRunJob)
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Start listens on the socket and serves requests in the background. It
+// fails if another daemon is already listening on the same socket; a stale
+// socket left behind by a crashed daemon is replaced.
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	if _, err := os.Stat(s.socketPath); err == nil {
+		if conn, err := net.DialTimeout("unix", s.socketPath, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("another daemon is already listening on %s", s.socketPath)
+		}
+		if err := os.Remove(s.socketPath); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := listenPrivate(s.socketPath)
+	if err != nil {
+		return err
+	}
+	s.listener = &peerListener{Listener: listener, logger: s.logger}
+
+	go func() {
+		if err := s.httpServer.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("control server stopped", zap.Error(err))
+		}
+	}()
+
+	s.logger.Info("control server listening", zap.String("socket", s.socketPath))
+	return nil
+}
+
+// listenPrivate creates the socket in a new directory only the current user
+// can enter, restricts it to the owner and then moves it into place, so no
+// other user can connect before its mode is set.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// The socket is renamed below; Close removes it by its final name
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+	if err := os.Rename(tmp, socketPath); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to create control socket: %w", err)
+	}
+	return listener, nil
+}
+
+var errNoPeerCredentials = errors.New("peer credentials not supported on this platform")
+
+// peerListener accepts only connections from processes of the user running
+// the daemon.
+type peerListener struct {
+	net.Listener
+	logger *zap.Logger
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		switch {
+		case errors.Is(err, errNoPeerCredentials):
+			return conn, nil
+		case err != nil:
+			l.logger.Warn("rejected control connection", zap.Error(err))
+		case uid != os.Getuid():
+			l.logger.Warn("rejected control connection from another user", zap.Int("uid", uid))
+		default:
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// rawConn returns the socket of a Unix domain connection.
+func rawConn(conn net.Conn) (syscall.RawConn, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix socket connection: %T", conn)
+	}
+	return unixConn.SyscallConn()
+}
+
+// Close stops the server and removes the socket.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.httpServer.Shutdown(ctx)
+	os.Remove(s.socketPath)
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.daemon.Status()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, s.daemon.Pause())
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, s.daemon.Resume())
+}
+
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	var req DrainRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	timeout := DefaultDrainTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid drain timeout %q", req.Timeout))
+			return
+		}
+		timeout = d
+	}
+
+	if err := s.daemon.Drain(timeout); err != nil {
+		writeError(w, http.StatusGatewayTimeout, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	files, err := s.daemon.Scan(req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ScanResponse{Files: files})
+}
+
+func (s *Server) handleAddDirectory(w http.ResponseWriter, r *http.Request) {
+	var req DirectoryRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("directory path must be absolute: %q", req.Path))
+		return
+	}
+
+	if err := s.daemon.AddDirectory(req.Path, req.Mode); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRemoveDirectory(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if !filepath.IsAbs(path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("directory path must be absolute: %q", path))
+		return
+	}
+
+	if err := s.daemon.RemoveDirectory(path); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSetBandwidth(w http.ResponseWriter, r *http.Request) {
+	var req BandwidthRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	rate, err := api.ParseRate(req.Rate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.respond(w, s.daemon.SetBandwidth(rate))
+}
+
+func (s *Server) handleResetBandwidth(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, s.daemon.ResetBandwidth())
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, s.daemon.Reload())
+}
+
+func (s *Server) handleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.daemon.RunJob(name); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, backup.ErrJobRunning) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// respond writes an empty success response or the error.
+func (s *Server) respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
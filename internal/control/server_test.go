@@ -0,0 +1,285 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/internal/backup"
+	"github.com/koneksi/backup-cli/internal/monitor"
+	"go.uber.org/zap"
+)
+
+type fakeDaemon struct {
+	mu          sync.Mutex
+	paused      bool
+	directories map[string]string
+	bandwidth   int64
+	override    bool
+	reloads     int
+	jobRuns     []string
+}
+
+func (f *fakeDaemon) Status() (*Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := &Status{
+		Paused:     f.paused,
+		QueueDepth: 3,
+		Bandwidth:  Bandwidth{Rate: f.bandwidth, Override: f.override},
+	}
+	for path, mode := range f.directories {
+		status.Directories = append(status.Directories, monitor.DirectoryStatus{Path: path, Mode: mode})
+	}
+	return status, nil
+}
+
+func (f *fakeDaemon) Pause() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused = true
+	return nil
+}
+
+func (f *fakeDaemon) Resume() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused = false
+	return nil
+}
+
+func (f *fakeDaemon) Drain(timeout time.Duration) error {
+	if timeout < time.Second {
+		return fmt.Errorf("drain timed out after %s", timeout)
+	}
+	return f.Pause()
+}
+
+func (f *fakeDaemon) Scan(path string) (int, error) {
+	if path == "/missing" {
+		return 0, fmt.Errorf("failed to scan %s", path)
+	}
+	return 7, nil
+}
+
+func (f *fakeDaemon) AddDirectory(path, mode string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.directories[path] = mode
+	return nil
+}
+
+func (f *fakeDaemon) RemoveDirectory(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.directories, path)
+	return nil
+}
+
+func (f *fakeDaemon) SetBandwidth(rate int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bandwidth, f.override = rate, true
+	return nil
+}
+
+func (f *fakeDaemon) ResetBandwidth() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bandwidth, f.override = 0, false
+	return nil
+}
+
+func (f *fakeDaemon) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reloads++
+	if f.reloads > 1 {
+		return fmt.Errorf("invalid configuration: backup.concurrent must be at least 1")
+	}
+	return nil
+}
+
+func (f *fakeDaemon) RunJob(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch name {
+	case "nightly":
+		f.jobRuns = append(f.jobRuns, name)
+		return nil
+	case "busy":
+		return backup.ErrJobRunning
+	}
+	return fmt.Errorf("unknown job %q", name)
+}
+
+func startTestServer(t *testing.T) (*fakeDaemon, *Client) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	daemon := &fakeDaemon{directories: make(map[string]string)}
+
+	server := NewServer(socket, daemon, zap.NewNop())
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return daemon, NewClient(socket)
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	daemon, client := startTestServer(t)
+	ctx := context.Background()
+
+	if err := client.Pause(ctx); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !status.Paused || status.QueueDepth != 3 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	if err := client.Resume(ctx); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if daemon.paused {
+		t.Error("daemon should be resumed")
+	}
+
+	if err := client.Drain(ctx, time.Minute); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if !daemon.paused {
+		t.Error("drain should leave the daemon paused")
+	}
+	if err := client.Drain(ctx, time.Millisecond); err == nil || err.Error() != "drain timed out after 1ms" {
+		t.Errorf("expected drain timeout error, got %v", err)
+	}
+	client.Resume(ctx)
+
+	files, err := client.Scan(ctx, "/data")
+	if err != nil || files != 7 {
+		t.Errorf("expected 7 scanned files, got %d (%v)", files, err)
+	}
+	if _, err := client.Scan(ctx, "/missing"); err == nil || err.Error() != "failed to scan /missing" {
+		t.Errorf("expected daemon error to be returned, got %v", err)
+	}
+
+	if err := client.AddDirectory(ctx, "/data/photos", monitor.ModePoll); err != nil {
+		t.Fatalf("add directory failed: %v", err)
+	}
+	if daemon.directories["/data/photos"] != monitor.ModePoll {
+		t.Error("directory was not added with the requested mode")
+	}
+	if err := client.AddDirectory(ctx, "relative", ""); err == nil {
+		t.Error("expected relative path to be rejected")
+	}
+	if err := client.RemoveDirectory(ctx, "/data/photos"); err != nil {
+		t.Fatalf("remove directory failed: %v", err)
+	}
+	if _, ok := daemon.directories["/data/photos"]; ok {
+		t.Error("directory was not removed")
+	}
+
+	if err := client.SetBandwidth(ctx, "2MB"); err != nil {
+		t.Fatalf("set bandwidth failed: %v", err)
+	}
+	if daemon.bandwidth != 2<<20 || !daemon.override {
+		t.Errorf("unexpected bandwidth override: %d", daemon.bandwidth)
+	}
+	if err := client.SetBandwidth(ctx, "fast"); err == nil {
+		t.Error("expected invalid rate to be rejected")
+	}
+	if err := client.ResetBandwidth(ctx); err != nil {
+		t.Fatalf("reset bandwidth failed: %v", err)
+	}
+	if daemon.override {
+		t.Error("bandwidth override was not cleared")
+	}
+
+	if err := client.Reload(ctx); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if err := client.Reload(ctx); err == nil || err.Error() != "invalid configuration: backup.concurrent must be at least 1" {
+		t.Errorf("expected rejected reload, got %v", err)
+	}
+
+	if err := client.RunJob(ctx, "nightly"); err != nil {
+		t.Fatalf("run job failed: %v", err)
+	}
+	if len(daemon.jobRuns) != 1 {
+		t.Errorf("expected 1 job run, got %d", len(daemon.jobRuns))
+	}
+	if err := client.RunJob(ctx, "busy"); err == nil || err.Error() != backup.ErrJobRunning.Error() {
+		t.Errorf("expected job running error, got %v", err)
+	}
+	if err := client.RunJob(ctx, "missing"); err == nil {
+		t.Error("expected unknown job to be rejected")
+	}
+}
+
+func TestClientNotRunning(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+
+	if _, err := client.Status(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected ErrNotRunning, got %v", err)
+	}
+}
+
+func TestServerRefusesSecondDaemon(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	daemon := &fakeDaemon{directories: make(map[string]string)}
+
+	first := NewServer(socket, daemon, zap.NewNop())
+	if err := first.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer first.Close()
+
+	second := NewServer(socket, daemon, zap.NewNop())
+	if err := second.Start(); err == nil {
+		second.Close()
+		t.Fatal("expected second server to fail while the first is running")
+	}
+}
+
+func TestServerSocketIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket permissions are POSIX")
+	}
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "control.sock")
+
+	server := NewServer(socket, &fakeDaemon{directories: make(map[string]string)}, zap.NewNop())
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	info, err := os.Stat(socket)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a socket only the owner can use, got %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the socket in its directory, got %v", entries)
+	}
+
+	// Connections from the daemon's own user are accepted
+	if _, err := NewClient(socket).Status(context.Background()); err != nil {
+		t.Errorf("status failed: %v", err)
+	}
+
+	server.Close()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+}
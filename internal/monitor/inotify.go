@@ -0,0 +1,196 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// inotifySource watches directories with fsnotify. Every directory in a tree
+// needs its own watch, so large trees can exhaust fs.inotify.max_user_watches.
+type inotifySource struct {
+	watcher *fsnotify.Watcher
+	logger  *zap.Logger
+	emit    func(FileChange)
+	exclude func(string) bool
+	// onAddError is called when a directory created after Add cannot be
+	// watched, so the owner can fall back to polling for that tree.
+	onAddError func(path string, err error)
+	onError    func(error)
+	mu         sync.RWMutex
+	watched    map[string]bool
+}
+
+func newInotifySource(logger *zap.Logger, emit func(FileChange), exclude func(string) bool, onAddError func(string, error), onError func(error)) (*inotifySource, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	return &inotifySource{
+		watcher:    watcher,
+		logger:     logger,
+		emit:       emit,
+		exclude:    exclude,
+		onAddError: onAddError,
+		onError:    onError,
+		watched:    make(map[string]bool),
+	}, nil
+}
+
+func (s *inotifySource) Name() string {
+	return ModeInotify
+}
+
+func (s *inotifySource) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-s.watcher.Events:
+				if !ok {
+					return
+				}
+				s.handleEvent(event)
+			case err, ok := <-s.watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.Error("watcher error", zap.Error(err))
+				s.onError(err)
+			}
+		}
+	}()
+}
+
+func (s *inotifySource) handleEvent(event fsnotify.Event) {
+	if s.exclude(event.Name) {
+		return
+	}
+
+	// A symbolic link is reported as itself, not as what it points to
+	info, err := os.Lstat(event.Name)
+	if err != nil && !os.IsNotExist(err) {
+		s.logger.Error("failed to stat file", zap.String("path", event.Name), zap.Error(err))
+		return
+	}
+
+	change := FileChange{
+		Path:      event.Name,
+		Timestamp: time.Now(),
+	}
+
+	if info != nil {
+		change.Size = info.Size()
+		change.IsDir = info.IsDir()
+	}
+
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		change.Operation = "create"
+		if info != nil && info.IsDir() {
+			if err := s.Add(event.Name); err != nil && s.onAddError != nil {
+				s.onAddError(event.Name, err)
+			}
+		}
+	case event.Op&fsnotify.Write == fsnotify.Write:
+		change.Operation = "modify"
+	case event.Op&fsnotify.Remove == fsnotify.Remove:
+		change.Operation = "delete"
+		s.mu.Lock()
+		delete(s.watched, event.Name)
+		s.mu.Unlock()
+	case event.Op&fsnotify.Rename == fsnotify.Rename:
+		change.Operation = "rename"
+		s.mu.Lock()
+		delete(s.watched, event.Name)
+		s.mu.Unlock()
+	case event.Op&fsnotify.Chmod == fsnotify.Chmod:
+		change.Operation = "chmod"
+	}
+
+	s.emit(change)
+}
+
+// Add registers a watch for every directory below path. If any registration
+// fails, the watches added by this call are removed again so a failed tree
+// does not hold on to part of the inotify budget.
+func (s *inotifySource) Add(path string) error {
+	var added []string
+
+	err := filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if s.exclude(walkPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, exists := s.watched[walkPath]; !exists {
+				if err := s.watcher.Add(walkPath); err != nil {
+					return fmt.Errorf("failed to add directory %s: %w", walkPath, err)
+				}
+				s.watched[walkPath] = true
+				added = append(added, walkPath)
+				s.logger.Debug("watching directory", zap.String("path", walkPath))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		s.mu.Lock()
+		for _, dir := range added {
+			_ = s.watcher.Remove(dir)
+			delete(s.watched, dir)
+		}
+		s.mu.Unlock()
+	}
+
+	return err
+}
+
+func (s *inotifySource) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for watched := range s.watched {
+		if isWithin(watched, path) {
+			if err := s.watcher.Remove(watched); err != nil {
+				s.logger.Error("failed to remove watch", zap.String("path", watched), zap.Error(err))
+			}
+			delete(s.watched, watched)
+		}
+	}
+
+	return nil
+}
+
+func (s *inotifySource) Close() error {
+	return s.watcher.Close()
+}
+
+// isWithin reports whether path is root or a descendant of root.
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
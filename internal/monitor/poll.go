@@ -0,0 +1,186 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultPollInterval is used when no poll interval is configured.
+const DefaultPollInterval = 60 * time.Second
+
+// pollSource detects changes by walking each registered tree on an interval
+// and comparing size and modification time against the previous scan. It
+// works on network filesystems and needs no kernel watch budget.
+type pollSource struct {
+	interval time.Duration
+	logger   *zap.Logger
+	emit     func(FileChange)
+	exclude  func(string) bool
+	mu       sync.Mutex
+	roots    map[string]map[string]fileSnapshot
+}
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func newPollSource(logger *zap.Logger, interval time.Duration, emit func(FileChange), exclude func(string) bool) *pollSource {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	return &pollSource{
+		interval: interval,
+		logger:   logger,
+		emit:     emit,
+		exclude:  exclude,
+		roots:    make(map[string]map[string]fileSnapshot),
+	}
+}
+
+func (p *pollSource) Name() string {
+	return ModePoll
+}
+
+// Add takes the initial snapshot of path. Files present at this point are
+// not reported as changes.
+func (p *pollSource) Add(path string) error {
+	snapshot, err := p.scan(path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.roots[path] = snapshot
+	p.mu.Unlock()
+
+	p.logger.Info("polling directory", zap.String("path", path), zap.Duration("interval", p.interval))
+	return nil
+}
+
+func (p *pollSource) Remove(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for root := range p.roots {
+		if isWithin(root, path) {
+			delete(p.roots, root)
+		}
+	}
+	return nil
+}
+
+func (p *pollSource) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.poll()
+			}
+		}
+	}()
+}
+
+func (p *pollSource) Close() error {
+	p.mu.Lock()
+	p.roots = make(map[string]map[string]fileSnapshot)
+	p.mu.Unlock()
+	return nil
+}
+
+// poll rescans every root and emits the differences since the last scan.
+func (p *pollSource) poll() {
+	p.mu.Lock()
+	roots := make([]string, 0, len(p.roots))
+	for root := range p.roots {
+		roots = append(roots, root)
+	}
+	p.mu.Unlock()
+
+	for _, root := range roots {
+		current, err := p.scan(root)
+		if err != nil {
+			p.logger.Error("failed to poll directory", zap.String("path", root), zap.Error(err))
+			continue
+		}
+
+		p.mu.Lock()
+		previous, ok := p.roots[root]
+		if ok {
+			p.roots[root] = current
+		}
+		p.mu.Unlock()
+
+		// Root was removed while scanning
+		if !ok {
+			continue
+		}
+
+		p.diff(previous, current)
+	}
+}
+
+func (p *pollSource) diff(previous, current map[string]fileSnapshot) {
+	now := time.Now()
+
+	for path, cur := range current {
+		prev, existed := previous[path]
+		switch {
+		case !existed:
+			p.emit(FileChange{Path: path, Operation: "create", Timestamp: now, Size: cur.size, IsDir: cur.isDir})
+		case !cur.isDir && (prev.size != cur.size || !prev.modTime.Equal(cur.modTime)):
+			p.emit(FileChange{Path: path, Operation: "modify", Timestamp: now, Size: cur.size})
+		}
+	}
+
+	for path, prev := range previous {
+		if _, exists := current[path]; !exists {
+			p.emit(FileChange{Path: path, Operation: "delete", Timestamp: now, IsDir: prev.isDir})
+		}
+	}
+}
+
+func (p *pollSource) scan(root string) (map[string]fileSnapshot, error) {
+	snapshot := make(map[string]fileSnapshot)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The root itself must be readable; entries that vanish
+			// mid-walk are picked up on the next scan.
+			if path == root {
+				return err
+			}
+			return nil
+		}
+
+		if p.exclude(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if path != root {
+			snapshot[path] = fileSnapshot{
+				size:    info.Size(),
+				modTime: info.ModTime(),
+				isDir:   info.IsDir(),
+			}
+		}
+		return nil
+	})
+
+	return snapshot, err
+}
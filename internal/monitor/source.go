@@ -0,0 +1,46 @@
+package monitor
+
+import "context"
+
+// Watch modes that can be configured per backup directory.
+const (
+	// ModeAuto uses inotify and falls back to polling when watch
+	// registration fails (for example when max_user_watches is exhausted).
+	ModeAuto = "auto"
+	// ModeInotify uses inotify only and reports registration failures.
+	ModeInotify = "inotify"
+	// ModePoll periodically stats the tree; required for NFS/SMB mounts
+	// where inotify does not see remote changes.
+	ModePoll = "poll"
+)
+
+// Source delivers file changes for the directory trees registered with it.
+type Source interface {
+	// Name identifies the source in status output ("inotify" or "poll").
+	Name() string
+	// Add starts watching the tree rooted at path.
+	Add(path string) error
+	// Remove stops watching path and everything below it.
+	Remove(path string) error
+	// Start runs the source until ctx is cancelled.
+	Start(ctx context.Context)
+	// Close releases resources held by the source.
+	Close() error
+}
+
+// DirectoryStatus describes how a watched root is being monitored.
+type DirectoryStatus struct {
+	Path   string `json:"path"`
+	Mode   string `json:"mode"`
+	Source string `json:"source"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ValidMode reports whether mode is a supported watch mode.
+func ValidMode(mode string) bool {
+	switch mode {
+	case ModeAuto, ModeInotify, ModePoll:
+		return true
+	}
+	return false
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"go.uber.org/zap"
 )
 
@@ -21,94 +21,61 @@ type FileChange struct {
 }
 
 type Watcher struct {
-	watcher    *fsnotify.Watcher
-	logger     *zap.Logger
-	changes    chan FileChange
-	errors     chan error
-	excludes   []string
-	mu         sync.RWMutex
-	watched    map[string]bool
+	inotify  *inotifySource
+	poller   *pollSource
+	logger   *zap.Logger
+	changes  chan FileChange
+	errors   chan error
+	excludes []string
+	// excludeMu guards excludes separately from mu because the sources
+	// consult the filter while mu may be held
+	excludeMu sync.RWMutex
+	mu        sync.RWMutex
+	roots     map[string]*DirectoryStatus
+	closed    bool
 }
 
 func NewWatcher(logger *zap.Logger, excludePatterns []string) (*Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create watcher: %w", err)
-	}
-
-	return &Watcher{
-		watcher:  watcher,
+	w := &Watcher{
 		logger:   logger,
 		changes:  make(chan FileChange, 1000),
 		errors:   make(chan error, 100),
 		excludes: excludePatterns,
-		watched:  make(map[string]bool),
-	}, nil
-}
-
-func (w *Watcher) Start(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case event, ok := <-w.watcher.Events:
-				if !ok {
-					return
-				}
-				w.handleEvent(event)
-			case err, ok := <-w.watcher.Errors:
-				if !ok {
-					return
-				}
-				w.logger.Error("watcher error", zap.Error(err))
-				w.errors <- err
-			}
-		}
-	}()
-}
-
-func (w *Watcher) handleEvent(event fsnotify.Event) {
-	if w.shouldExclude(event.Name) {
-		return
+		roots:    make(map[string]*DirectoryStatus),
 	}
 
-	info, err := os.Stat(event.Name)
-	if err != nil && !os.IsNotExist(err) {
-		w.logger.Error("failed to stat file", zap.String("path", event.Name), zap.Error(err))
-		return
+	inotify, err := newInotifySource(logger, w.emit, w.shouldExclude, w.handleAddError, w.reportError)
+	if err != nil {
+		// Running out of inotify instances should not stop the daemon;
+		// directories in auto mode will be polled instead.
+		logger.Warn("inotify unavailable, falling back to polling", zap.Error(err))
+	} else {
+		w.inotify = inotify
 	}
+	w.poller = newPollSource(logger, DefaultPollInterval, w.emit, w.shouldExclude)
 
-	change := FileChange{
-		Path:      event.Name,
-		Timestamp: time.Now(),
-	}
+	return w, nil
+}
+
+// SetPollInterval changes how often polled directories are rescanned. It
+// must be called before Start.
+func (w *Watcher) SetPollInterval(interval time.Duration) {
+	w.poller = newPollSource(w.logger, interval, w.emit, w.shouldExclude)
+}
 
-	if info != nil {
-		change.Size = info.Size()
-		change.IsDir = info.IsDir()
+func (w *Watcher) Start(ctx context.Context) {
+	if w.inotify != nil {
+		w.inotify.Start(ctx)
 	}
+	w.poller.Start(ctx)
+}
 
-	switch {
-	case event.Op&fsnotify.Create == fsnotify.Create:
-		change.Operation = "create"
-		if info != nil && info.IsDir() {
-			w.AddDirectory(event.Name)
-		}
-	case event.Op&fsnotify.Write == fsnotify.Write:
-		change.Operation = "modify"
-	case event.Op&fsnotify.Remove == fsnotify.Remove:
-		change.Operation = "delete"
-		w.mu.Lock()
-		delete(w.watched, event.Name)
-		w.mu.Unlock()
-	case event.Op&fsnotify.Rename == fsnotify.Rename:
-		change.Operation = "rename"
-		w.mu.Lock()
-		delete(w.watched, event.Name)
-		w.mu.Unlock()
-	case event.Op&fsnotify.Chmod == fsnotify.Chmod:
-		change.Operation = "chmod"
+func (w *Watcher) emit(change FileChange) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
 	}
 
 	w.logger.Debug("file change detected",
@@ -120,63 +87,260 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	select {
 	case w.changes <- change:
 	default:
-		w.logger.Warn("changes channel full, dropping event", zap.String("path", event.Name))
+		w.logger.Warn("changes channel full, dropping event", zap.String("path", change.Path))
 	}
 }
 
+func (w *Watcher) reportError(err error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+// AddDirectory watches path in auto mode.
 func (w *Watcher) AddDirectory(path string) error {
-	return filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
+	return w.AddDirectoryWithMode(path, ModeAuto)
+}
+
+// AddDirectoryWithMode watches path using the given mode. In auto mode a
+// failed inotify registration falls back to polling; the registration error
+// is kept in Status so it can be surfaced to the user.
+func (w *Watcher) AddDirectoryWithMode(path, mode string) error {
+	if mode == "" {
+		mode = ModeAuto
+	}
+	if !ValidMode(mode) {
+		return fmt.Errorf("unsupported watch mode: %s", mode)
+	}
+
+	status := &DirectoryStatus{Path: path, Mode: mode}
+
+	var err error
+	switch mode {
+	case ModePoll:
+		err = w.poller.Add(path)
+		status.Source = w.poller.Name()
+	case ModeInotify:
+		if w.inotify == nil {
+			err = fmt.Errorf("inotify is not available")
+		} else {
+			err = w.inotify.Add(path)
+			status.Source = w.inotify.Name()
+		}
+	case ModeAuto:
+		if w.inotify != nil {
+			err = w.inotify.Add(path)
+			status.Source = w.inotify.Name()
+		} else {
+			err = fmt.Errorf("inotify is not available")
+		}
 		if err != nil {
-			return err
+			w.logger.Warn("inotify registration failed, falling back to polling",
+				zap.String("path", path),
+				zap.Error(err),
+			)
+			status.Error = err.Error()
+			err = w.poller.Add(path)
+			status.Source = w.poller.Name()
 		}
+	}
 
-		if w.shouldExclude(walkPath) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	if err != nil {
+		status.Source = ""
+		status.Error = err.Error()
+	}
 
-		if info.IsDir() {
-			w.mu.Lock()
-			if _, exists := w.watched[walkPath]; !exists {
-				if err := w.watcher.Add(walkPath); err != nil {
-					w.mu.Unlock()
-					return fmt.Errorf("failed to add directory %s: %w", walkPath, err)
-				}
-				w.watched[walkPath] = true
-				w.logger.Info("watching directory", zap.String("path", walkPath))
-			}
-			w.mu.Unlock()
+	w.mu.Lock()
+	w.roots[path] = status
+	w.mu.Unlock()
+
+	return err
+}
+
+// handleAddError is called when a directory created inside an inotify root
+// cannot be watched. Auto roots are moved over to polling as a whole.
+func (w *Watcher) handleAddError(path string, addErr error) {
+	w.mu.Lock()
+	var root *DirectoryStatus
+	for rootPath, status := range w.roots {
+		if isWithin(path, rootPath) && (root == nil || len(rootPath) > len(root.Path)) {
+			root = status
 		}
+	}
+	switchToPoll := root != nil && root.Source == ModeInotify && root.Mode == ModeAuto
+	if root != nil && !switchToPoll {
+		root.Error = addErr.Error()
+	}
+	w.mu.Unlock()
 
-		return nil
-	})
+	if !switchToPoll {
+		w.logger.Error("failed to watch new directory", zap.String("path", path), zap.Error(addErr))
+		w.reportError(addErr)
+		return
+	}
+
+	w.logger.Warn("inotify registration failed, switching directory to polling",
+		zap.String("root", root.Path),
+		zap.String("path", path),
+		zap.Error(addErr),
+	)
+
+	w.inotify.Remove(root.Path)
+	pollErr := w.poller.Add(root.Path)
+
+	w.mu.Lock()
+	if pollErr != nil {
+		root.Source = ""
+		root.Error = pollErr.Error()
+	} else {
+		root.Source = w.poller.Name()
+		root.Error = addErr.Error()
+	}
+	w.mu.Unlock()
+
+	if pollErr != nil {
+		w.reportError(pollErr)
+	}
 }
 
 func (w *Watcher) RemoveDirectory(path string) error {
+	if w.inotify != nil {
+		w.inotify.Remove(path)
+	}
+	w.poller.Remove(path)
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	for watched := range w.watched {
-		if filepath.HasPrefix(watched, path) {
-			if err := w.watcher.Remove(watched); err != nil {
-				w.logger.Error("failed to remove watch", zap.String("path", watched), zap.Error(err))
-			}
-			delete(w.watched, watched)
+	for root := range w.roots {
+		if isWithin(root, path) {
+			delete(w.roots, root)
 		}
 	}
 
 	return nil
 }
 
+// Status returns the watch state of every registered root, sorted by path.
+func (w *Watcher) Status() []DirectoryStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	statuses := make([]DirectoryStatus, 0, len(w.roots))
+	for _, status := range w.roots {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Path < statuses[j].Path
+	})
+
+	return statuses
+}
+
+// Scan walks path, or every watched root when path is empty, and passes
+// each file and directory that is not excluded to fn as a "scan" change.
+// It is used to pick up changes that happened while nothing was watching.
+// A path outside the watched roots is refused. The returned count is of
+// files.
+func (w *Watcher) Scan(path string, fn func(FileChange)) (int, error) {
+	var roots []string
+	switch {
+	case path == "":
+		for _, status := range w.Status() {
+			roots = append(roots, status.Path)
+		}
+	case w.isWatched(filepath.Clean(path)):
+		roots = []string{filepath.Clean(path)}
+	default:
+		return 0, fmt.Errorf("cannot scan %s: not inside a watched directory", path)
+	}
+
+	count := 0
+	for _, root := range roots {
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if p == root {
+					return err
+				}
+				return nil
+			}
+
+			if w.shouldExclude(p) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				fn(FileChange{Path: p, Operation: "scan", Timestamp: time.Now(), IsDir: true})
+				return nil
+			}
+
+			fn(FileChange{
+				Path:      p,
+				Operation: "scan",
+				Timestamp: time.Now(),
+				Size:      info.Size(),
+			})
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+
+	return count, nil
+}
+
+// isWatched reports whether path is inside a watched root, also once
+// symbolic links in either are resolved.
+func (w *Watcher) isWatched(path string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// A missing path fails when it is walked
+		real = path
+	}
+
+	for _, status := range w.Status() {
+		realRoot, err := filepath.EvalSymlinks(status.Path)
+		if err != nil {
+			realRoot = status.Path
+		}
+		if isWithin(path, status.Path) && isWithin(real, realRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetExcludes replaces the exclude patterns. It takes effect for changes
+// seen afterwards; files already queued are not affected.
+func (w *Watcher) SetExcludes(patterns []string) {
+	w.excludeMu.Lock()
+	w.excludes = patterns
+	w.excludeMu.Unlock()
+}
+
 func (w *Watcher) shouldExclude(path string) bool {
-	for _, pattern := range w.excludes {
+	w.excludeMu.RLock()
+	excludes := w.excludes
+	w.excludeMu.RUnlock()
+
+	for _, pattern := range excludes {
 		matched, err := filepath.Match(pattern, filepath.Base(path))
 		if err == nil && matched {
 			return true
 		}
-		
+
 		if filepath.HasPrefix(path, pattern) {
 			return true
 		}
@@ -193,7 +357,15 @@ func (w *Watcher) Errors() <-chan error {
 }
 
 func (w *Watcher) Close() error {
+	w.mu.Lock()
+	w.closed = true
 	close(w.changes)
 	close(w.errors)
-	return w.watcher.Close()
-}
\ No newline at end of file
+	w.mu.Unlock()
+
+	w.poller.Close()
+	if w.inotify != nil {
+		return w.inotify.Close()
+	}
+	return nil
+}
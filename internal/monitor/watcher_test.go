@@ -145,6 +145,23 @@ func TestWatcherExclusions(t *testing.T) {
 	}
 }
 
+func TestWatcherSetExcludes(t *testing.T) {
+	watcher, err := NewWatcher(zap.NewNop(), []string{"*.tmp"})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	watcher.SetExcludes([]string{"*.log"})
+
+	if watcher.shouldExclude("test.tmp") {
+		t.Error("old pattern should no longer exclude test.tmp")
+	}
+	if !watcher.shouldExclude("app.log") {
+		t.Error("new pattern should exclude app.log")
+	}
+}
+
 func TestWatcherSubdirectories(t *testing.T) {
 	logger := zap.NewNop()
 	watcher, err := NewWatcher(logger, []string{})
@@ -172,10 +189,10 @@ func TestWatcherSubdirectories(t *testing.T) {
 	}
 
 	// Verify subdirectory is watched
-	watcher.mu.RLock()
-	_, rootWatched := watcher.watched[testDir]
-	_, subWatched := watcher.watched[subDir]
-	watcher.mu.RUnlock()
+	watcher.inotify.mu.RLock()
+	_, rootWatched := watcher.inotify.watched[testDir]
+	_, subWatched := watcher.inotify.watched[subDir]
+	watcher.inotify.mu.RUnlock()
 
 	if !rootWatched {
 		t.Error("root directory should be watched")
@@ -218,9 +235,9 @@ func TestWatcherRemoveDirectory(t *testing.T) {
 	}
 
 	// Verify it's watched
-	watcher.mu.RLock()
-	_, watched := watcher.watched[testDir]
-	watcher.mu.RUnlock()
+	watcher.inotify.mu.RLock()
+	_, watched := watcher.inotify.watched[testDir]
+	watcher.inotify.mu.RUnlock()
 	if !watched {
 		t.Error("directory should be watched after adding")
 	}
@@ -232,10 +249,171 @@ func TestWatcherRemoveDirectory(t *testing.T) {
 	}
 
 	// Verify it's not watched
-	watcher.mu.RLock()
-	_, watched = watcher.watched[testDir]
-	watcher.mu.RUnlock()
+	watcher.inotify.mu.RLock()
+	_, watched = watcher.inotify.watched[testDir]
+	watcher.inotify.mu.RUnlock()
 	if watched {
 		t.Error("directory should not be watched after removal")
 	}
-}
\ No newline at end of file
+}
+func TestWatcherPollMode(t *testing.T) {
+	logger := zap.NewNop()
+	watcher, err := NewWatcher(logger, []string{"*.tmp"})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+	watcher.SetPollInterval(50 * time.Millisecond)
+
+	testDir := t.TempDir()
+	existing := filepath.Join(testDir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher.Start(ctx)
+
+	if err := watcher.AddDirectoryWithMode(testDir, ModePoll); err != nil {
+		t.Fatalf("failed to add directory: %v", err)
+	}
+
+	status := watcher.Status()
+	if len(status) != 1 || status[0].Source != ModePoll {
+		t.Fatalf("expected directory to be polled, got %+v", status)
+	}
+
+	// Excluded files must not be reported
+	if err := os.WriteFile(filepath.Join(testDir, "skip.tmp"), []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to create excluded file: %v", err)
+	}
+
+	newFile := filepath.Join(testDir, "new.txt")
+	if err := os.WriteFile(newFile, []byte("new content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	select {
+	case change := <-watcher.Changes():
+		if change.Path != newFile || change.Operation != "create" {
+			t.Errorf("expected create of %s, got %s of %s", newFile, change.Operation, change.Path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for polled create event")
+	}
+
+	if err := os.Remove(existing); err != nil {
+		t.Fatalf("failed to remove test file: %v", err)
+	}
+
+	select {
+	case change := <-watcher.Changes():
+		if change.Path != existing || change.Operation != "delete" {
+			t.Errorf("expected delete of %s, got %s of %s", existing, change.Operation, change.Path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for polled delete event")
+	}
+}
+
+func TestWatcherAutoFallsBackToPolling(t *testing.T) {
+	logger := zap.NewNop()
+	watcher, err := NewWatcher(logger, []string{})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	// Simulate an exhausted inotify budget by closing the underlying watcher
+	if watcher.inotify != nil {
+		watcher.inotify.watcher.Close()
+	}
+
+	testDir := t.TempDir()
+	if err := watcher.AddDirectory(testDir); err != nil {
+		t.Fatalf("auto mode should fall back to polling, got error: %v", err)
+	}
+
+	status := watcher.Status()
+	if len(status) != 1 {
+		t.Fatalf("expected 1 watched directory, got %d", len(status))
+	}
+	if status[0].Source != ModePoll {
+		t.Errorf("expected source %q, got %q", ModePoll, status[0].Source)
+	}
+	if status[0].Error == "" {
+		t.Error("expected registration error to be recorded")
+	}
+
+	if err := watcher.AddDirectoryWithMode(t.TempDir(), ModeInotify); err == nil {
+		t.Error("inotify mode should not fall back to polling")
+	}
+}
+
+func TestWatcherScan(t *testing.T) {
+	logger := zap.NewNop()
+	watcher, err := NewWatcher(logger, []string{"*.tmp", "cache"})
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	testDir := t.TempDir()
+	for _, name := range []string{"a.txt", "skip.tmp", "sub/b.txt", "cache/c.txt"} {
+		path := filepath.Join(testDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+	}
+
+	if err := watcher.AddDirectoryWithMode(testDir, ModePoll); err != nil {
+		t.Fatalf("failed to add directory: %v", err)
+	}
+
+	var scanned, dirs []string
+	count, err := watcher.Scan("", func(change FileChange) {
+		if change.Operation != "scan" {
+			t.Errorf("expected scan operation, got %s", change.Operation)
+		}
+		if change.IsDir {
+			dirs = append(dirs, change.Path)
+			return
+		}
+		scanned = append(scanned, change.Path)
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	if count != 2 || len(scanned) != 2 {
+		t.Errorf("expected 2 scanned files, got %d: %v", count, scanned)
+	}
+	if len(dirs) != 2 || dirs[0] != testDir || dirs[1] != filepath.Join(testDir, "sub") {
+		t.Errorf("expected the root and sub to be scanned, got %v", dirs)
+	}
+
+	if _, err := watcher.Scan(filepath.Join(testDir, "missing"), func(FileChange) {}); err == nil {
+		t.Error("expected error scanning a missing directory")
+	}
+
+	// Paths outside the watched roots are refused, also through a link
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if _, err := watcher.Scan(outside, func(FileChange) { t.Error("unexpected change outside the roots") }); err == nil {
+		t.Error("expected error scanning outside the watched roots")
+	}
+	if err := os.Symlink(outside, filepath.Join(testDir, "out")); err == nil {
+		if _, err := watcher.Scan(filepath.Join(testDir, "out")+"/", func(FileChange) { t.Error("unexpected change through a link") }); err == nil {
+			t.Error("expected error scanning through a link out of the roots")
+		}
+	}
+	if count, err := watcher.Scan(filepath.Join(testDir, "sub"), func(FileChange) {}); err != nil || count != 1 {
+		t.Errorf("expected 1 file below sub, got %d, %v", count, err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/koneksi/backup-cli/pkg/metadata"
 )
 
 type Reporter struct {
@@ -32,6 +34,19 @@ type BackupReport struct {
 	Duration    time.Duration          `json:"duration"`
 	Results     []BackupResult         `json:"results"`
 	Statistics  map[string]interface{} `json:"statistics"`
+	Watches     []WatchStatus          `json:"watches,omitempty"`
+	// Job and SnapshotID are set for reports of scheduled job runs
+	Job        string `json:"job,omitempty"`
+	SnapshotID int64  `json:"snapshot_id,omitempty"`
+}
+
+// WatchStatus records how a backup directory is being monitored and any
+// error hit while registering it.
+type WatchStatus struct {
+	Path   string `json:"path"`
+	Mode   string `json:"mode"`
+	Source string `json:"source"`
+	Error  string `json:"error,omitempty"`
 }
 
 type BackupResult struct {
@@ -48,6 +63,11 @@ type BackupResult struct {
 	CompressedSize int64         `json:"compressed_size,omitempty"`
 	Checksum       string        `json:"checksum,omitempty"`
 	Compressed     bool          `json:"compressed"`
+	// Encrypted files are stored under their name with an .enc suffix
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Metadata is the file's mode, owner, times, link target and extended
+	// attributes as backed up
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
 }
 
 func NewReporter(logger *zap.Logger, reportDir, format string, retention int) (*Reporter, error) {
@@ -85,6 +105,28 @@ func (r *Reporter) StartNewReport() {
 	r.logger.Info("started new backup report", zap.String("reportID", r.currentReport.ID))
 }
 
+// StartJobReport starts a report for one run of a scheduled backup job.
+func (r *Reporter) StartJobReport(job string, snapshotID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.currentReport != nil {
+		r.saveReport()
+	}
+
+	r.currentReport = &BackupReport{
+		ID:         fmt.Sprintf("job-%s-%d", job, snapshotID),
+		StartTime:  time.Now(),
+		Results:    make([]BackupResult, 0),
+		Statistics: make(map[string]interface{}),
+		Job:        job,
+		SnapshotID: snapshotID,
+	}
+	r.results = make([]BackupResult, 0)
+
+	r.logger.Info("started job report", zap.String("reportID", r.currentReport.ID))
+}
+
 func (r *Reporter) AddResult(result BackupResult) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -125,6 +167,21 @@ func (r *Reporter) AddResult(result BackupResult) {
 	}
 }
 
+// SetWatches records the current watch status and saves the report so that
+// the status command can show it while the daemon is running.
+func (r *Reporter) SetWatches(watches []WatchStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.currentReport == nil {
+		return fmt.Errorf("no active report")
+	}
+
+	r.currentReport.Watches = watches
+	r.currentReport.Results = r.results
+	return r.saveReport()
+}
+
 func (r *Reporter) FinishReport(stats map[string]interface{}) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
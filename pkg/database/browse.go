@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CatalogEntry is a file or directory in a listing of the backup catalog.
+type CatalogEntry struct {
+	Name string
+	Path string
+	Dir  bool
+	// Record is the version of a file the listing is of
+	Record *BackupRecord
+}
+
+// Directories are not stored: a listing finds the children of a directory
+// by skipping through the sorted paths below it, one index lookup per
+// child, so it does not read the files further down.
+
+// nextPathFunc returns the first path after from, or at from when
+// inclusive, and before end; or "" when there is none.
+type nextPathFunc func(from string, inclusive bool, end string) (string, error)
+
+func listDir(dir string, next nextPathFunc, record func(path string) (*BackupRecord, error)) ([]CatalogEntry, error) {
+	prefix, end := pathRange(dir)
+	sep := string(filepath.Separator)
+
+	var entries []CatalogEntry
+	from, inclusive := prefix, true
+	for {
+		path, err := next(from, inclusive, end)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return entries, nil
+		}
+
+		name := path[len(prefix):]
+		if i := strings.Index(name, sep); i >= 0 {
+			name = name[:i]
+			entries = append(entries, CatalogEntry{Name: name, Path: prefix + name, Dir: true})
+			// Skip the rest of the directory
+			from, inclusive = prefix+name+string(rune(filepath.Separator+1)), true
+			continue
+		}
+
+		r, err := record(path)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			entries = append(entries, CatalogEntry{Name: name, Path: path, Record: r})
+		}
+		from, inclusive = path, false
+	}
+}
+
+// ListVersionsDir lists the files and directories directly in dir that had
+// been backed up as of at, with the newest version of each file as of then.
+// A zero at lists the newest versions.
+func (db *DB) ListVersionsDir(dir string, at time.Time) ([]CatalogEntry, error) {
+	next := func(from string, inclusive bool, end string) (string, error) {
+		op := ">"
+		if inclusive {
+			op = ">="
+		}
+		query := `SELECT file_path FROM file_versions
+			WHERE file_path ` + op + ` ? AND file_path < ? AND (? OR backup_time <= ?)
+			ORDER BY file_path LIMIT 1`
+		return firstPath(db.conn.QueryRow(query, from, end, at.IsZero(), at))
+	}
+	record := func(path string) (*BackupRecord, error) {
+		return db.versionAt(path, at)
+	}
+	return listDir(dir, next, record)
+}
+
+// ListSnapshotDir lists the files and directories directly in dir in a
+// snapshot. The record of a file is the version with its content, with the
+// file ID of the snapshot.
+func (db *DB) ListSnapshotDir(snapshotID int64, dir string) ([]CatalogEntry, error) {
+	next := func(from string, inclusive bool, end string) (string, error) {
+		op := ">"
+		if inclusive {
+			op = ">="
+		}
+		query := `SELECT file_path FROM snapshot_files
+			WHERE snapshot_id = ? AND file_path ` + op + ` ? AND file_path < ?
+			ORDER BY file_path LIMIT 1`
+		return firstPath(db.conn.QueryRow(query, snapshotID, from, end))
+	}
+	record := func(path string) (*BackupRecord, error) {
+		return db.snapshotRecord(snapshotID, path)
+	}
+	return listDir(dir, next, record)
+}
+
+func firstPath(row *sql.Row) (string, error) {
+	var path string
+	err := row.Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list catalog: %w", err)
+	}
+	return path, nil
+}
+
+// snapshotRecord returns the version of a file in a snapshot, or nil when
+// the snapshot does not have the file.
+func (db *DB) snapshotRecord(snapshotID int64, path string) (*BackupRecord, error) {
+	f, err := scanSnapshotFile(db.conn.QueryRow(
+		`SELECT `+snapshotFileColumns+` FROM snapshot_files WHERE snapshot_id = ? AND file_path = ?`,
+		snapshotID, path,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot file: %w", err)
+	}
+	return db.snapshotFileRecord(f)
+}
+
+func (db *DB) snapshotFileRecord(f SnapshotFile) (*BackupRecord, error) {
+	r, err := db.GetBackupRecord(f.FilePath, f.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		r = &BackupRecord{FilePath: f.FilePath, Checksum: f.Checksum, OriginalSize: f.Size, Status: "success"}
+	}
+	// The snapshot refers to its own upload, encrypted as recorded for it
+	r.FileID, r.SnapshotID = f.FileID, f.SnapshotID
+	r.Encrypted, r.KeyID = &f.Encrypted, f.KeyID
+	return r, nil
+}
+
+// SnapshotVersionsUnder returns the versions of root and of every file
+// below it in a snapshot, like ListSnapshotDir, ordered by path.
+func (db *DB) SnapshotVersionsUnder(snapshotID int64, root string) ([]BackupRecord, error) {
+	prefix, end := pathRange(root)
+	rows, err := db.conn.Query(`
+		SELECT `+snapshotFileColumns+`
+		FROM snapshot_files
+		WHERE snapshot_id = ? AND (file_path = ? OR (file_path >= ? AND file_path < ?))
+		ORDER BY file_path`,
+		snapshotID, root, prefix, end,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot files: %w", err)
+	}
+	var files []SnapshotFile
+	for rows.Next() {
+		f, err := scanSnapshotFile(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan snapshot file: %w", err)
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]BackupRecord, 0, len(files))
+	for _, f := range files {
+		r, err := db.snapshotFileRecord(f)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+	return records, nil
+}
+
+// CatalogRoot returns the deepest directory containing every backed up
+// file, or of the files of a snapshot when snapshotID is not zero. It
+// returns "" for an empty catalog.
+func (db *DB) CatalogRoot(snapshotID int64) (string, error) {
+	var first, last sql.NullString
+	var err error
+	if snapshotID == 0 {
+		err = db.conn.QueryRow(`SELECT MIN(file_path), MAX(file_path) FROM file_versions`).Scan(&first, &last)
+	} else {
+		err = db.conn.QueryRow(`SELECT MIN(file_path), MAX(file_path) FROM snapshot_files WHERE snapshot_id = ?`, snapshotID).Scan(&first, &last)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get catalog root: %w", err)
+	}
+	if !first.Valid {
+		return "", nil
+	}
+
+	if first.String == last.String {
+		return filepath.Dir(first.String), nil
+	}
+
+	// The first and last paths share the prefix of all paths between them
+	n := 0
+	for n < len(first.String) && n < len(last.String) && first.String[n] == last.String[n] {
+		n++
+	}
+	i := strings.LastIndex(first.String[:n], string(filepath.Separator))
+	if i < 0 {
+		return "", nil
+	}
+	return filepath.Clean(first.String[:i+1]), nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// entryNames returns the names of entries, directories with a trailing
+// slash and files with their checksum.
+func entryNames(entries []CatalogEntry) string {
+	var names []string
+	for _, e := range entries {
+		if e.Dir {
+			names = append(names, e.Name+"/")
+		} else {
+			names = append(names, e.Name+"@"+e.Record.Checksum)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestListVersionsDir(t *testing.T) {
+	db := newTestDB(t)
+
+	start := time.Now().Add(-time.Hour)
+	for i, r := range []BackupRecord{
+		{FilePath: "/data/a.txt", Checksum: "a1"},
+		{FilePath: "/data/docs/x/y.txt", Checksum: "y1"},
+		{FilePath: "/data/docs/z.txt", Checksum: "z1"},
+		{FilePath: "/data/a.txt", Checksum: "a2"},
+		{FilePath: "/data/b.txt", Checksum: "b1"},
+		{FilePath: "/data-old/c.txt", Checksum: "c1"},
+	} {
+		r.FileID, r.BackupTime, r.Status = "id", start.Add(time.Duration(i)*time.Minute), "success"
+		if _, err := db.InsertBackupRecord(r); err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+	}
+
+	entries, err := db.ListVersionsDir("/data", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to list directory: %v", err)
+	}
+	if got, want := entryNames(entries), "a.txt@a2 b.txt@b1 docs/"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if entries[2].Path != "/data/docs" {
+		t.Errorf("unexpected directory path %s", entries[2].Path)
+	}
+
+	// Before the second version of a.txt and the backup of b.txt
+	entries, err = db.ListVersionsDir("/data", start.Add(150*time.Second))
+	if err != nil {
+		t.Fatalf("failed to list directory: %v", err)
+	}
+	if got, want := entryNames(entries), "a.txt@a1 docs/"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	entries, err = db.ListVersionsDir("/data/docs", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to list directory: %v", err)
+	}
+	if got, want := entryNames(entries), "x/ z.txt@z1"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	root, err := db.CatalogRoot(0)
+	if err != nil || root != "/" {
+		t.Errorf("expected root /, got %q, %v", root, err)
+	}
+}
+
+func TestListSnapshotDir(t *testing.T) {
+	db := newTestDB(t)
+
+	record := BackupRecord{FilePath: "/srv/dumps/a.sql", FileID: "f1", Checksum: "c1", OriginalSize: 10, BackupTime: time.Now(), Status: "success"}
+	if _, err := db.InsertBackupRecord(record); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+
+	id, err := db.CreateSnapshot("dumps", time.Now())
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	for _, f := range []SnapshotFile{
+		{SnapshotID: id, FilePath: "/srv/dumps/a.sql", FileID: "s1", Checksum: "c1", Size: 10},
+		{SnapshotID: id, FilePath: "/srv/dumps/old/b.sql", FileID: "s2", Checksum: "c2", Size: 20},
+	} {
+		if err := db.AddSnapshotFile(f); err != nil {
+			t.Fatalf("failed to add snapshot file: %v", err)
+		}
+	}
+
+	root, err := db.CatalogRoot(id)
+	if err != nil || root != "/srv/dumps" {
+		t.Fatalf("expected root /srv/dumps, got %q, %v", root, err)
+	}
+
+	entries, err := db.ListSnapshotDir(id, root)
+	if err != nil {
+		t.Fatalf("failed to list snapshot: %v", err)
+	}
+	if got, want := entryNames(entries), "a.sql@c1 old/"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	// Files keep the version details of the catalog with the file ID of
+	// the snapshot
+	if r := entries[0].Record; r.FileID != "s1" || r.Version != 1 || r.SnapshotID != id {
+		t.Errorf("unexpected snapshot record: %+v", r)
+	}
+
+	records, err := db.SnapshotVersionsUnder(id, "/srv/dumps/old")
+	if err != nil {
+		t.Fatalf("failed to get snapshot files: %v", err)
+	}
+	if len(records) != 1 || records[0].FileID != "s2" || records[0].OriginalSize != 20 {
+		t.Errorf("unexpected snapshot files: %+v", records)
+	}
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotEmpty is returned when a catalog is imported into a database that
+// already holds backups.
+var ErrNotEmpty = errors.New("database is not empty")
+
+// Catalog is what a new machine needs from the database to find, restore
+// and continue backups: the successful backup records with their target
+// copies, the finished snapshots with their files, the mirrored remote
+// directories, the metadata of the backed up directories and the salt key
+// IDs are derived with.
+type Catalog struct {
+	Records           []CatalogRecord
+	Snapshots         []CatalogSnapshot
+	RemoteDirectories []RemoteDirectory
+	Directories       []Directory
+	KeySalt           []byte
+}
+
+// CatalogRecord is a backup record with its copies on secondary targets.
+type CatalogRecord struct {
+	BackupRecord
+	Copies []TargetCopy
+}
+
+// CatalogSnapshot is a snapshot with its files.
+type CatalogSnapshot struct {
+	Snapshot
+	SnapshotFiles []SnapshotFile
+}
+
+// ForEachBackupRecord calls fn with every successful backup record, oldest
+// first. fn must not use the database.
+func (db *DB) ForEachBackupRecord(fn func(record BackupRecord) error) error {
+	query := `
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
+		WHERE v.status = 'success'
+		ORDER BY v.id
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query backup records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanBackupRecord(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan record: %w", err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read backup records: %w", err)
+	}
+	return nil
+}
+
+// ImportBackupRecords inserts backup records recovered from elsewhere in a
+// single transaction, as new versions of their files. Records whose upload
+// is already a version of the path, or without an upload whose content is,
+// are skipped. Files without a state get one from their newest imported
+// record. It returns the number of records inserted.
+func (db *DB) ImportBackupRecords(records []BackupRecord) (int, error) {
+	var imported []BackupRecord
+	err := db.write(func(tx *sql.Tx) error {
+		for _, r := range records {
+			query := `SELECT EXISTS (SELECT 1 FROM file_versions WHERE file_path = ? AND file_id = ?)`
+			args := []interface{}{r.FilePath, r.FileID}
+			if r.FileID == "" {
+				query = `SELECT EXISTS (SELECT 1 FROM ` + recordTables + ` WHERE v.file_path = ? AND c.checksum = ?)`
+				args = []interface{}{r.FilePath, r.Checksum}
+			}
+			var known bool
+			if err := tx.QueryRow(query, args...).Scan(&known); err != nil {
+				return fmt.Errorf("failed to look up backup record: %w", err)
+			}
+			if known {
+				continue
+			}
+
+			if _, err := insertVersion(tx, r); err != nil {
+				return err
+			}
+			imported = append(imported, r)
+		}
+
+		if err := insertFileStates(tx, imported); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(imported), nil
+}
+
+// insertFileStates gives the files of imported records without a state one
+// from their newest successful record.
+func insertFileStates(tx *sql.Tx, records []BackupRecord) error {
+	newest := make(map[string]BackupRecord)
+	versions := make(map[string]int)
+	for _, r := range records {
+		if r.Status != "success" {
+			continue
+		}
+		versions[r.FilePath]++
+		if cur, ok := newest[r.FilePath]; !ok || r.BackupTime.After(cur.BackupTime) {
+			newest[r.FilePath] = r
+		}
+	}
+
+	for path, r := range newest {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO file_states
+			(file_path, last_checksum, last_backup, backup_count, status)
+			VALUES (?, ?, ?, ?, ?)`,
+			path, r.Checksum, r.BackupTime, versions[path], "success",
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update file state: %w", err)
+		}
+	}
+	return nil
+}
+
+// ExportCatalog returns the catalog of the database.
+func (db *DB) ExportCatalog() (*Catalog, error) {
+	copies, err := db.queryTargetCopies(`
+		SELECT record_id, target, file_id, status, error_message, attempts, updated_at
+		FROM target_copies
+		ORDER BY record_id, target
+	`)
+	if err != nil {
+		return nil, err
+	}
+	byRecord := make(map[int64][]TargetCopy)
+	for _, c := range copies {
+		byRecord[c.RecordID] = append(byRecord[c.RecordID], c)
+	}
+
+	catalog := &Catalog{}
+	err = db.ForEachBackupRecord(func(r BackupRecord) error {
+		catalog.Records = append(catalog.Records, CatalogRecord{BackupRecord: r, Copies: byRecord[r.ID]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := db.ListSnapshots("")
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Status == SnapshotRunning {
+			continue
+		}
+		files, err := db.ListSnapshotFiles(snapshots[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		catalog.Snapshots = append(catalog.Snapshots, CatalogSnapshot{Snapshot: snapshots[i], SnapshotFiles: files})
+	}
+
+	if catalog.RemoteDirectories, err = db.ListRemoteDirectories(); err != nil {
+		return nil, err
+	}
+	if catalog.Directories, err = db.DirectoriesUnder(""); err != nil {
+		return nil, err
+	}
+	if catalog.KeySalt, err = db.setting(keySaltSetting); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// ImportCatalog fills an empty database from a catalog in a single
+// transaction. Records and snapshots get new IDs, and records are numbered
+// as versions in catalog order. A database that already holds backup
+// records or snapshots is left alone with ErrNotEmpty.
+func (db *DB) ImportCatalog(catalog *Catalog) error {
+	return db.write(func(tx *sql.Tx) error {
+		var existing int
+		err := tx.QueryRow(`SELECT (SELECT COUNT(*) FROM file_versions) + (SELECT COUNT(*) FROM snapshots)`).Scan(&existing)
+		if err != nil {
+			return fmt.Errorf("failed to count backups: %w", err)
+		}
+		if existing > 0 {
+			return ErrNotEmpty
+		}
+
+		snapshotIDs := make(map[int64]int64)
+		for _, s := range catalog.Snapshots {
+			var finishedAt interface{}
+			if !s.FinishedAt.IsZero() {
+				finishedAt = s.FinishedAt.UnixMilli()
+			}
+			result, err := tx.Exec(`
+				INSERT INTO snapshots (job, started_at, finished_at, status, files, total_size, error)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				s.Job, s.StartedAt.UnixMilli(), finishedAt, s.Status, s.Files, s.TotalSize, s.Error,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert snapshot: %w", err)
+			}
+			snapshotID, err := result.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("failed to insert snapshot: %w", err)
+			}
+			snapshotIDs[s.ID] = snapshotID
+
+			for _, f := range s.SnapshotFiles {
+				_, err := tx.Exec(`
+					INSERT INTO snapshot_files (snapshot_id, file_path, file_id, checksum, size, encrypted, key_id)
+					VALUES (?, ?, ?, ?, ?, ?, ?)`,
+					snapshotID, f.FilePath, f.FileID, f.Checksum, f.Size, f.Encrypted,
+					sql.NullString{String: f.KeyID, Valid: f.KeyID != ""},
+				)
+				if err != nil {
+					return fmt.Errorf("failed to insert snapshot file: %w", err)
+				}
+			}
+		}
+
+		records := make([]BackupRecord, 0, len(catalog.Records))
+		for _, r := range catalog.Records {
+			record := r.BackupRecord
+			record.SnapshotID = snapshotIDs[r.SnapshotID]
+			recordID, err := insertVersion(tx, record)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+
+			for _, c := range r.Copies {
+				_, err := tx.Exec(`
+					INSERT INTO target_copies (record_id, target, file_id, status, error_message, updated_at)
+					VALUES (?, ?, ?, ?, ?, ?)`,
+					recordID, c.Target, c.FileID, c.Status, c.Error, c.UpdatedAt.UnixMilli(),
+				)
+				if err != nil {
+					return fmt.Errorf("failed to insert target copy: %w", err)
+				}
+			}
+		}
+		if err := insertFileStates(tx, records); err != nil {
+			return err
+		}
+
+		now := time.Now().UnixMilli()
+		for _, d := range catalog.RemoteDirectories {
+			_, err := tx.Exec(`
+				INSERT OR REPLACE INTO remote_directories (base_id, path, directory_id, created_at)
+				VALUES (?, ?, ?, ?)`,
+				d.BaseID, d.Path, d.DirectoryID, now,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to insert remote directory: %w", err)
+			}
+		}
+		for _, d := range catalog.Directories {
+			if err := insertDirectory(tx, d); err != nil {
+				return err
+			}
+		}
+		if len(catalog.KeySalt) > 0 {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO settings (name, value) VALUES (?, ?)`, keySaltSetting, catalog.KeySalt)
+			if err != nil {
+				return fmt.Errorf("failed to insert key salt: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -3,23 +3,62 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/koneksi/backup-cli/pkg/metadata"
+)
+
+// Connection settings. WAL lets readers run while a write commits,
+// busy_timeout makes a writer wait for the lock held by another process
+// instead of failing with "database is locked", and immediate transactions
+// take the write lock up front so two transactions cannot deadlock
+// upgrading their read locks.
+const (
+	connParams   = "_journal_mode=WAL&_busy_timeout=10000&_synchronous=NORMAL&_txlock=immediate"
+	maxOpenConns = 8
 )
 
 type DB struct {
 	conn *sql.DB
+
+	// writes are committed in batches by the writer goroutine, which
+	// makes every write once the database is migrated
+	writes     chan writeOp
+	closing    chan struct{}
+	writerDone chan struct{}
+	closeOnce  sync.Once
 }
 
+// BackupRecord is a version of a file. Versions are numbered per path
+// from 1; Checksum and OriginalSize describe its content, which versions
+// with the same checksum share. Owner is nil when it was not captured. A
+// symbolic link has a LinkTarget, which is stored as its content.
+// Encrypted is nil for versions backed up before it was recorded; KeyID
+// identifies the password of an encrypted upload.
 type BackupRecord struct {
 	ID             int64
 	FilePath       string
+	Version        int
 	FileID         string
 	Checksum       string
 	OriginalSize   int64
 	CompressedSize int64
 	IsCompressed   bool
+	Mode           os.FileMode
+	ModTime        time.Time
+	Owner          *metadata.Owner
+	LinkTarget     string
+	Xattrs         map[string][]byte
+	Encrypted      *bool
+	KeyID          string
+	SnapshotID     int64
 	BackupTime     time.Time
 	Status         string
 	ErrorMessage   string
@@ -35,76 +74,42 @@ type FileState struct {
 }
 
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath)
+	dsn := dbPath + "?" + connParams
+	if strings.Contains(dbPath, "?") {
+		dsn = dbPath + "&" + connParams
+	}
+	conn, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-
-	db := &DB{conn: conn}
-	if err := db.initialize(); err != nil {
+	conn.SetMaxOpenConns(maxOpenConns)
+	conn.SetMaxIdleConns(maxOpenConns)
+	conn.SetConnMaxIdleTime(5 * time.Minute)
+
+	db := &DB{
+		conn:       conn,
+		writes:     make(chan writeOp),
+		closing:    make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	if err := db.migrate(migrations); err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	go db.writer()
 
 	return db, nil
 }
 
-func (db *DB) initialize() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS backup_records (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			file_path TEXT NOT NULL,
-			file_id TEXT,
-			checksum TEXT NOT NULL,
-			original_size INTEGER NOT NULL,
-			compressed_size INTEGER,
-			is_compressed BOOLEAN DEFAULT FALSE,
-			backup_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT NOT NULL,
-			error_message TEXT,
-			operation TEXT,
-			UNIQUE(file_path, checksum)
-		)`,
-		`CREATE TABLE IF NOT EXISTS file_states (
-			file_path TEXT PRIMARY KEY,
-			last_checksum TEXT,
-			last_backup TIMESTAMP,
-			backup_count INTEGER DEFAULT 0,
-			status TEXT NOT NULL
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_backup_records_file_path ON backup_records(file_path)`,
-		`CREATE INDEX IF NOT EXISTS idx_backup_records_status ON backup_records(status)`,
-		`CREATE INDEX IF NOT EXISTS idx_backup_records_backup_time ON backup_records(backup_time)`,
-	}
-
-	for _, query := range queries {
-		if _, err := db.conn.Exec(query); err != nil {
-			return fmt.Errorf("failed to execute query: %w", err)
-		}
-	}
-
-	return nil
-}
-
-// InsertBackupRecord inserts a new backup record
+// InsertBackupRecord inserts a record as the next version of its file
 func (db *DB) InsertBackupRecord(record BackupRecord) (int64, error) {
-	query := `
-		INSERT INTO backup_records 
-		(file_path, file_id, checksum, original_size, compressed_size, is_compressed, 
-		 backup_time, status, error_message, operation)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-
-	result, err := db.conn.Exec(query,
-		record.FilePath, record.FileID, record.Checksum,
-		record.OriginalSize, record.CompressedSize, record.IsCompressed,
-		record.BackupTime, record.Status, record.ErrorMessage, record.Operation,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to insert backup record: %w", err)
-	}
-
-	return result.LastInsertId()
+	var id int64
+	err := db.write(func(tx *sql.Tx) error {
+		var err error
+		id, err = insertVersion(tx, record)
+		return err
+	})
+	return id, err
 }
 
 // UpdateFileState updates or inserts file state
@@ -115,10 +120,13 @@ func (db *DB) UpdateFileState(state FileState) error {
 		VALUES (?, ?, ?, ?, ?)
 	`
 
-	_, err := db.conn.Exec(query,
-		state.FilePath, state.LastChecksum, state.LastBackup,
-		state.BackupCount, state.Status,
-	)
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query,
+			state.FilePath, state.LastChecksum, state.LastBackup,
+			state.BackupCount, state.Status,
+		)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update file state: %w", err)
 	}
@@ -149,38 +157,51 @@ func (db *DB) GetFileState(filePath string) (*FileState, error) {
 	return &state, nil
 }
 
-// GetBackupHistory retrieves backup history for a file
-func (db *DB) GetBackupHistory(filePath string, limit int) ([]BackupRecord, error) {
+// ForEachFileState calls fn with the states of the most recently backed
+// up files, newest first, until limit states were read or fn returns
+// false. Rows are streamed, so memory does not grow with the table.
+func (db *DB) ForEachFileState(limit int, fn func(FileState) bool) error {
 	query := `
-		SELECT id, file_path, file_id, checksum, original_size, compressed_size,
-		       is_compressed, backup_time, status, error_message, operation
-		FROM backup_records
-		WHERE file_path = ?
-		ORDER BY backup_time DESC
+		SELECT file_path, last_checksum, last_backup, backup_count, status
+		FROM file_states
+		ORDER BY last_backup DESC
 		LIMIT ?
 	`
 
-	rows, err := db.conn.Query(query, filePath, limit)
+	rows, err := db.conn.Query(query, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query backup history: %w", err)
+		return fmt.Errorf("failed to query file states: %w", err)
 	}
 	defer rows.Close()
 
-	var records []BackupRecord
 	for rows.Next() {
-		var r BackupRecord
-		err := rows.Scan(
-			&r.ID, &r.FilePath, &r.FileID, &r.Checksum,
-			&r.OriginalSize, &r.CompressedSize, &r.IsCompressed,
-			&r.BackupTime, &r.Status, &r.ErrorMessage, &r.Operation,
+		var (
+			state      FileState
+			checksum   sql.NullString
+			lastBackup sql.NullTime
 		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan record: %w", err)
+		if err := rows.Scan(&state.FilePath, &checksum, &lastBackup, &state.BackupCount, &state.Status); err != nil {
+			return fmt.Errorf("failed to scan file state: %w", err)
+		}
+		state.LastChecksum, state.LastBackup = checksum.String, lastBackup.Time
+		if !fn(state) {
+			break
 		}
-		records = append(records, r)
 	}
+	return rows.Err()
+}
+
+// GetBackupHistory retrieves backup history for a file, newest first
+func (db *DB) GetBackupHistory(filePath string, limit int) ([]BackupRecord, error) {
+	query := `
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
+		WHERE v.file_path = ?
+		ORDER BY v.version DESC
+		LIMIT ?
+	`
 
-	return records, nil
+	return db.queryBackupRecords(query, filePath, limit)
 }
 
 // GetBackupStats retrieves backup statistics
@@ -221,9 +242,9 @@ func (db *DB) GetBackupStats() (map[string]interface{}, error) {
 	// Total backup size
 	var totalOriginalSize, totalCompressedSize sql.NullInt64
 	sizeQuery := `
-		SELECT SUM(original_size), SUM(compressed_size)
-		FROM backup_records
-		WHERE status = 'success'
+		SELECT SUM(c.size), SUM(v.compressed_size)
+		FROM ` + recordTables + `
+		WHERE v.status = 'success'
 	`
 	err = db.conn.QueryRow(sizeQuery).Scan(&totalOriginalSize, &totalCompressedSize)
 	if err != nil {
@@ -236,7 +257,7 @@ func (db *DB) GetBackupStats() (map[string]interface{}, error) {
 	var recentCount int
 	recentQuery := `
 		SELECT COUNT(*) 
-		FROM backup_records 
+		FROM file_versions 
 		WHERE backup_time > datetime('now', '-24 hours')
 	`
 	err = db.conn.QueryRow(recentQuery).Scan(&recentCount)
@@ -245,96 +266,264 @@ func (db *DB) GetBackupStats() (map[string]interface{}, error) {
 	}
 	stats["recent_backups_24h"] = recentCount
 
+	// Pending work
+	queueDepth, err := db.QueueDepth()
+	if err != nil {
+		return nil, err
+	}
+	stats["queue_depth"] = queueDepth
+
+	deadLetters, err := db.DeadLetterCount()
+	if err != nil {
+		return nil, err
+	}
+	stats["dead_letters"] = deadLetters
+
 	return stats, nil
 }
 
-// SearchBackups searches for backups based on criteria
+// SearchBackups searches for backups based on criteria, newest first
 func (db *DB) SearchBackups(criteria SearchCriteria) ([]BackupRecord, error) {
+	if criteria.Name != "" {
+		if _, err := filepath.Match(criteria.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", criteria.Name, err)
+		}
+	}
+
 	query := `
-		SELECT id, file_path, file_id, checksum, original_size, compressed_size,
-		       is_compressed, backup_time, status, error_message, operation
-		FROM backup_records
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
 		WHERE 1=1
 	`
 	args := []interface{}{}
 
 	if criteria.FilePath != "" {
-		query += " AND file_path LIKE ?"
+		query += " AND v.file_path LIKE ?"
 		args = append(args, "%"+criteria.FilePath+"%")
 	}
 
 	if criteria.Status != "" {
-		query += " AND status = ?"
+		query += " AND v.status = ?"
 		args = append(args, criteria.Status)
 	}
 
 	if !criteria.StartTime.IsZero() {
-		query += " AND backup_time >= ?"
+		query += " AND v.backup_time >= ?"
 		args = append(args, criteria.StartTime)
 	}
 
 	if !criteria.EndTime.IsZero() {
-		query += " AND backup_time <= ?"
+		query += " AND v.backup_time <= ?"
 		args = append(args, criteria.EndTime)
 	}
 
-	query += " ORDER BY backup_time DESC LIMIT ?"
-	args = append(args, criteria.Limit)
+	// GLOB narrows the rows to those whose path ends like the name; the
+	// name is then matched against the base name only
+	if criteria.Name != "" {
+		query += " AND v.file_path GLOB ?"
+		args = append(args, "*"+criteria.Name)
+	}
+
+	query += " ORDER BY v.backup_time DESC, v.id DESC"
+	if criteria.Name == "" {
+		// SQLite reads a negative LIMIT as no limit
+		limit := criteria.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ?"
+		args = append(args, limit)
+		return db.queryBackupRecords(query, args...)
+	}
 
 	rows, err := db.conn.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search backups: %w", err)
+		return nil, fmt.Errorf("failed to query backup records: %w", err)
 	}
 	defer rows.Close()
 
 	var records []BackupRecord
-	for rows.Next() {
-		var r BackupRecord
-		err := rows.Scan(
-			&r.ID, &r.FilePath, &r.FileID, &r.Checksum,
-			&r.OriginalSize, &r.CompressedSize, &r.IsCompressed,
-			&r.BackupTime, &r.Status, &r.ErrorMessage, &r.Operation,
-		)
+	for (criteria.Limit <= 0 || len(records) < criteria.Limit) && rows.Next() {
+		r, err := scanBackupRecord(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan record: %w", err)
 		}
-		records = append(records, r)
+		if ok, _ := filepath.Match(criteria.Name, filepath.Base(r.FilePath)); ok {
+			records = append(records, r)
+		}
+	}
+	return records, rows.Err()
+}
+
+// SearchFileStates searches the last backup attempts of files, newest
+// first. Unlike SearchBackups it finds the files whose last attempt failed
+// or that were deleted, which have no backup record for that attempt.
+func (db *DB) SearchFileStates(criteria SearchCriteria) ([]FileState, error) {
+	if criteria.Name != "" {
+		if _, err := filepath.Match(criteria.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", criteria.Name, err)
+		}
+	}
+
+	query := `
+		SELECT file_path, last_checksum, last_backup, backup_count, status
+		FROM file_states
+		WHERE 1=1
+	`
+	args := []interface{}{}
+	if criteria.FilePath != "" {
+		query += " AND file_path LIKE ?"
+		args = append(args, "%"+criteria.FilePath+"%")
+	}
+	if criteria.Status != "" {
+		query += " AND status = ?"
+		args = append(args, criteria.Status)
+	}
+	if !criteria.StartTime.IsZero() {
+		query += " AND last_backup >= ?"
+		args = append(args, criteria.StartTime)
+	}
+	if !criteria.EndTime.IsZero() {
+		query += " AND last_backup <= ?"
+		args = append(args, criteria.EndTime)
 	}
+	if criteria.Name != "" {
+		query += " AND file_path GLOB ?"
+		args = append(args, "*"+criteria.Name)
+	}
+	query += " ORDER BY last_backup DESC, file_path"
 
-	return records, nil
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file states: %w", err)
+	}
+	defer rows.Close()
+
+	var states []FileState
+	for (criteria.Limit <= 0 || len(states) < criteria.Limit) && rows.Next() {
+		var (
+			state      FileState
+			checksum   sql.NullString
+			lastBackup sql.NullTime
+		)
+		if err := rows.Scan(&state.FilePath, &checksum, &lastBackup, &state.BackupCount, &state.Status); err != nil {
+			return nil, fmt.Errorf("failed to scan file state: %w", err)
+		}
+		if criteria.Name != "" {
+			if ok, _ := filepath.Match(criteria.Name, filepath.Base(state.FilePath)); !ok {
+				continue
+			}
+		}
+		state.LastChecksum, state.LastBackup = checksum.String, lastBackup.Time
+		states = append(states, state)
+	}
+	return states, rows.Err()
 }
 
 type SearchCriteria struct {
-	FilePath  string
+	FilePath string
+	// Name is a shell pattern, such as "*.pdf", matched against the base
+	// name of the file
+	Name      string
 	Status    string
 	StartTime time.Time
 	EndTime   time.Time
-	Limit     int
+	// Limit bounds the number of results; zero or less means no limit
+	Limit int
 }
 
 // CleanupOldRecords removes old backup records
 func (db *DB) CleanupOldRecords(days int) error {
+	return db.CleanupOldRecordsByPath(days, nil)
+}
+
+// CleanupOldRecordsByPath removes old backup records like CleanupOldRecords,
+// except that records below a path in rules are kept for that path's number
+// of days. When rule paths are nested, the most specific one applies.
+func (db *DB) CleanupOldRecordsByPath(days int, rules map[string]int) error {
+	paths := make([]string, 0, len(rules))
+	for path := range rules {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i]) > len(paths[j])
+	})
+
+	return db.write(func(tx *sql.Tx) error {
+		var affected int64
+		for i, path := range paths {
+			n, err := cleanupRecords(tx, rules[path], path, paths[:i])
+			if err != nil {
+				return err
+			}
+			affected += n
+		}
+		n, err := cleanupRecords(tx, days, "", paths)
+		if err != nil {
+			return err
+		}
+		affected += n
+
+		if affected > 0 {
+			// Copies and contents belong to the records just removed
+			if _, err := tx.Exec(`DELETE FROM target_copies WHERE record_id NOT IN (SELECT id FROM file_versions)`); err != nil {
+				return fmt.Errorf("failed to cleanup target copies: %w", err)
+			}
+			if err := deleteUnusedContents(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// cleanupRecords removes successful records older than days that are below
+// under, or anywhere when under is empty, and not below any of skip.
+func cleanupRecords(tx *sql.Tx, days int, under string, skip []string) (int64, error) {
 	query := `
-		DELETE FROM backup_records 
+		DELETE FROM file_versions 
 		WHERE backup_time < datetime('now', '-' || ? || ' days')
 		AND status = 'success'
 	`
+	args := []interface{}{days}
+	if under != "" {
+		query += ` AND ` + pathUnderCondition
+		args = append(args, pathUnderArgs(under)...)
+	}
+	for _, path := range skip {
+		query += ` AND NOT ` + pathUnderCondition
+		args = append(args, pathUnderArgs(path)...)
+	}
 
-	result, err := db.conn.Exec(query, days)
+	result, err := tx.Exec(query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup old records: %w", err)
+		return 0, fmt.Errorf("failed to cleanup old records: %w", err)
 	}
-
 	affected, _ := result.RowsAffected()
-	if affected > 0 {
-		// Vacuum to reclaim space
-		_, _ = db.conn.Exec("VACUUM")
-	}
+	return affected, nil
+}
 
-	return nil
+// pathUnderCondition matches a file_path equal to or below a directory. It
+// compares prefixes instead of using LIKE, which would treat '%' and '_'
+// in paths as wildcards.
+const pathUnderCondition = `(file_path = ? OR substr(file_path, 1, length(?)) = ?)`
+
+func pathUnderArgs(dir string) []interface{} {
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return []interface{}{dir, prefix, prefix}
 }
 
 // Close closes the database connection
 func (db *DB) Close() error {
-	return db.conn.Close()
+	var err error
+	db.closeOnce.Do(func() {
+		// Writes in progress are committed first
+		close(db.closing)
+		<-db.writerDone
+		err = db.conn.Close()
+	})
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,534 @@
+package database
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/metadata"
+)
+
+func TestCleanupOldRecordsByPath(t *testing.T) {
+	db := newTestDB(t)
+
+	old := time.Now().UTC().AddDate(0, 0, -10)
+	for _, path := range []string{
+		"/data/report.txt",
+		"/home/finance/ledger.xlsx",
+		"/home/finance/archive/2020.xlsx",
+		"/home/finance_old/notes.txt",
+	} {
+		record := BackupRecord{FilePath: path, FileID: "id", Checksum: path, BackupTime: old, Status: "success"}
+		if _, err := db.InsertBackupRecord(record); err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+	}
+
+	// Records are kept 30 days by default, 5 days below /home/finance and
+	// 60 days below its archive
+	err := db.CleanupOldRecordsByPath(30, map[string]int{
+		"/home/finance":         5,
+		"/home/finance/archive": 60,
+	})
+	if err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	records, err := db.SearchBackups(SearchCriteria{Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to search records: %v", err)
+	}
+	remaining := map[string]bool{}
+	for _, r := range records {
+		remaining[r.FilePath] = true
+	}
+
+	if len(remaining) != 3 || remaining["/home/finance/ledger.xlsx"] {
+		t.Errorf("only the ledger should be removed, remaining: %v", remaining)
+	}
+}
+
+func TestTargetCopies(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/a.txt", FileID: "primary-1", Checksum: "c1", BackupTime: time.Now(), Status: "success"})
+	if err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+
+	record, err := db.GetBackupRecord("/data/a.txt", "c1")
+	if err != nil || record == nil || record.ID != id || record.FileID != "primary-1" {
+		t.Fatalf("unexpected record: %+v, %v", record, err)
+	}
+	if record, err := db.GetBackupRecord("/data/a.txt", "other"); err != nil || record != nil {
+		t.Errorf("expected no record for other content, got %+v, %v", record, err)
+	}
+
+	for _, c := range []TargetCopy{
+		{RecordID: id, Target: "nas", FileID: "2024/01/01/x-a.txt", Status: CopySuccess},
+		{RecordID: id, Target: "offsite", Status: CopyPending},
+	} {
+		if err := db.SetTargetCopy(c); err != nil {
+			t.Fatalf("failed to set target copy: %v", err)
+		}
+	}
+
+	pending, err := db.PendingCopies(10)
+	if err != nil {
+		t.Fatalf("failed to list pending copies: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Target != "offsite" || pending[0].FilePath != "/data/a.txt" || pending[0].Checksum != "c1" {
+		t.Errorf("unexpected pending copies: %+v", pending)
+	}
+
+	// A failed attempt is not due again before its next attempt
+	pending[0].Error = "target unavailable"
+	if err := db.RetryTargetCopy(pending[0].TargetCopy, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if pending, _ := db.PendingCopies(10); len(pending) != 0 {
+		t.Errorf("expected no due copies, got %+v", pending)
+	}
+	if err := db.RetryTargetCopy(pending[0].TargetCopy, time.Now()); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if pending, _ := db.PendingCopies(10); len(pending) != 1 || pending[0].Attempts != 2 || pending[0].Error != "target unavailable" {
+		t.Errorf("expected the retried copy to be due, got %+v", pending)
+	}
+
+	copies, err := db.CopiesForFileID("primary-1")
+	if err != nil {
+		t.Fatalf("failed to get copies: %v", err)
+	}
+	if len(copies) != 1 || copies[0].Target != "nas" || copies[0].FileID != "2024/01/01/x-a.txt" {
+		t.Errorf("only successful copies should be returned, got %+v", copies)
+	}
+
+	counts, err := db.TargetCopyCounts()
+	if err != nil {
+		t.Fatalf("failed to count copies: %v", err)
+	}
+	if counts["nas"][CopySuccess] != 1 || counts["offsite"][CopyPending] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestRemoteDirectories(t *testing.T) {
+	db := newTestDB(t)
+
+	for path, id := range map[string]string{"home": "d1", "home/user": "d2", "home/user2": "d3", "srv": "d4"} {
+		if err := db.SetRemoteDirectory("base", path, id); err != nil {
+			t.Fatalf("failed to set remote directory: %v", err)
+		}
+	}
+	if id, err := db.GetRemoteDirectory("base", "home/user"); err != nil || id != "d2" {
+		t.Errorf("unexpected directory %q, %v", id, err)
+	}
+	if id, err := db.GetRemoteDirectory("other", "home/user"); err != nil || id != "" {
+		t.Errorf("expected no directory below another base, got %q, %v", id, err)
+	}
+
+	// Descendants are forgotten with their parent, siblings are kept
+	if err := db.DeleteRemoteDirectory("base", "home/user"); err != nil {
+		t.Fatalf("failed to delete remote directory: %v", err)
+	}
+	for path, want := range map[string]string{"home": "d1", "home/user": "", "home/user2": "d3"} {
+		if id, _ := db.GetRemoteDirectory("base", path); id != want {
+			t.Errorf("%s: got %q, want %q", path, id, want)
+		}
+	}
+	if err := db.DeleteRemoteDirectory("base", "home"); err != nil {
+		t.Fatalf("failed to delete remote directory: %v", err)
+	}
+	for path, want := range map[string]string{"home": "", "home/user2": "", "srv": "d4"} {
+		if id, _ := db.GetRemoteDirectory("base", path); id != want {
+			t.Errorf("%s: got %q, want %q", path, id, want)
+		}
+	}
+}
+
+func TestImportBackupRecords(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now().UTC()
+	existing := BackupRecord{FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1", BackupTime: now.Add(-2 * time.Hour), Status: "success"}
+	if _, err := db.InsertBackupRecord(existing); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+
+	inserted, err := db.ImportBackupRecords([]BackupRecord{
+		existing,
+		{FilePath: "/data/a.txt", FileID: "f2", Checksum: "c2", BackupTime: now.Add(-time.Hour), Status: "success"},
+		{FilePath: "/data/b.txt", FileID: "f3", Checksum: "c3", BackupTime: now.Add(-3 * time.Hour), Status: "success"},
+		{FilePath: "/data/b.txt", FileID: "f4", Checksum: "c4", BackupTime: now, Status: "success"},
+	})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if inserted != 3 {
+		t.Errorf("expected 3 records inserted, got %d", inserted)
+	}
+
+	var ids []string
+	if err := db.ForEachBackupRecord(func(r BackupRecord) error {
+		ids = append(ids, r.FileID)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	if len(ids) != 4 || ids[0] != "f1" || ids[3] != "f4" {
+		t.Errorf("unexpected records: %v", ids)
+	}
+
+	state, err := db.GetFileState("/data/b.txt")
+	if err != nil || state == nil {
+		t.Fatalf("expected a file state, got %v, %v", state, err)
+	}
+	if state.LastChecksum != "c4" || state.BackupCount != 2 {
+		t.Errorf("unexpected file state: %+v", state)
+	}
+}
+
+func TestFileMetadataAndDirectories(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	encrypted := true
+	record := BackupRecord{
+		FilePath:   "/data/current",
+		FileID:     "f1",
+		Checksum:   "c1",
+		Mode:       os.ModeSymlink | 0777,
+		ModTime:    now,
+		Owner:      &metadata.Owner{UID: 1000, GID: 100},
+		LinkTarget: "releases/v2",
+		Xattrs:     map[string][]byte{"user.origin": []byte("deploy")},
+		Encrypted:  &encrypted,
+		KeyID:      "k1",
+		BackupTime: now,
+		Status:     "success",
+	}
+	if _, err := db.InsertBackupRecord(record); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+	latest, err := db.LatestBackupRecord("/data/current")
+	if err != nil || latest == nil {
+		t.Fatalf("failed to get record: %+v, %v", latest, err)
+	}
+	if latest.Mode != record.Mode || latest.Owner == nil || *latest.Owner != *record.Owner ||
+		latest.LinkTarget != "releases/v2" || string(latest.Xattrs["user.origin"]) != "deploy" ||
+		latest.Encrypted == nil || !*latest.Encrypted || latest.KeyID != "k1" {
+		t.Errorf("metadata not kept: %+v", latest)
+	}
+	// Whether a version is encrypted can be unknown
+	if _, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/old.txt", Checksum: "c0", BackupTime: now, Status: "success"}); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+	if r, _ := db.LatestBackupRecord("/data/old.txt"); r == nil || r.Encrypted != nil || r.KeyID != "" {
+		t.Errorf("expected unknown encryption, got %+v", r)
+	}
+
+	for _, d := range []Directory{
+		{Path: "/data", Mode: os.ModeDir | 0755, Owner: &metadata.Owner{UID: 0, GID: 0}},
+		{Path: "/data/empty", Mode: os.ModeDir | 0700, ModTime: now},
+		{Path: "/database", Mode: os.ModeDir | 0755},
+		// Saving again replaces what was known
+		{Path: "/data/empty", Mode: os.ModeDir | 0750, ModTime: now},
+	} {
+		if err := db.SaveDirectory(d); err != nil {
+			t.Fatalf("failed to save directory: %v", err)
+		}
+	}
+	dirs, err := db.DirectoriesUnder("/data")
+	if err != nil {
+		t.Fatalf("failed to list directories: %v", err)
+	}
+	if len(dirs) != 2 || dirs[0].Path != "/data" || dirs[0].Owner == nil || dirs[1].Mode.Perm() != 0750 || !dirs[1].ModTime.Equal(now) {
+		t.Errorf("unexpected directories: %+v", dirs)
+	}
+
+	// Both travel with the catalog
+	catalog, err := db.ExportCatalog()
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	fresh := newTestDB(t)
+	if err := fresh.ImportCatalog(catalog); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if r, _ := fresh.LatestBackupRecord("/data/current"); r == nil || r.LinkTarget != "releases/v2" || r.Owner == nil ||
+		r.Encrypted == nil || !*r.Encrypted || r.KeyID != "k1" {
+		t.Errorf("record metadata not imported: %+v", r)
+	}
+	if dirs, _ := fresh.DirectoriesUnder(""); len(dirs) != 3 {
+		t.Errorf("expected 3 imported directories, got %+v", dirs)
+	}
+}
+
+func TestExportImportCatalog(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	recordID, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1", OriginalSize: 10, BackupTime: now, Status: "success"})
+	if err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+	if _, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/b.txt", Checksum: "c2", BackupTime: now, Status: "failed"}); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+	if err := db.SetTargetCopy(TargetCopy{RecordID: recordID, Target: "offsite", FileID: "r1", Status: CopySuccess}); err != nil {
+		t.Fatalf("failed to set copy: %v", err)
+	}
+	snapshotID, err := db.CreateSnapshot("daily", now)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	if err := db.AddSnapshotFile(SnapshotFile{SnapshotID: snapshotID, FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1", Size: 10}); err != nil {
+		t.Fatalf("failed to add snapshot file: %v", err)
+	}
+	if err := db.FinishSnapshot(snapshotID, SnapshotSuccess, ""); err != nil {
+		t.Fatalf("failed to finish snapshot: %v", err)
+	}
+	if _, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/c.txt", FileID: "f3", Checksum: "c3", SnapshotID: snapshotID, BackupTime: now, Status: "success"}); err != nil {
+		t.Fatalf("failed to insert record: %v", err)
+	}
+	// A running snapshot is not exported
+	if _, err := db.CreateSnapshot("daily", now); err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	if err := db.SetRemoteDirectory("base", "data", "dir-1"); err != nil {
+		t.Fatalf("failed to set remote directory: %v", err)
+	}
+
+	catalog, err := db.ExportCatalog()
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if len(catalog.Records) != 2 || len(catalog.Records[0].Copies) != 1 || len(catalog.Snapshots) != 1 || len(catalog.RemoteDirectories) != 1 {
+		t.Fatalf("unexpected catalog: %+v", catalog)
+	}
+
+	if err := db.ImportCatalog(catalog); err != ErrNotEmpty {
+		t.Errorf("expected ErrNotEmpty importing into a used database, got %v", err)
+	}
+
+	fresh := newTestDB(t)
+	if err := fresh.ImportCatalog(catalog); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	record, err := fresh.GetBackupRecord("/data/a.txt", "c1")
+	if err != nil || record == nil || record.FileID != "f1" {
+		t.Fatalf("unexpected record %+v, %v", record, err)
+	}
+	if copies, _ := fresh.ListTargetCopies(record.ID); len(copies) != 1 || copies[0].FileID != "r1" {
+		t.Errorf("unexpected copies: %+v", copies)
+	}
+	if state, _ := fresh.GetFileState("/data/a.txt"); state == nil || state.LastChecksum != "c1" {
+		t.Errorf("unexpected file state: %+v", state)
+	}
+	snapshots, _ := fresh.ListSnapshots("daily")
+	if len(snapshots) != 1 || snapshots[0].Status != SnapshotSuccess || snapshots[0].Files != 1 {
+		t.Fatalf("unexpected snapshots: %+v", snapshots)
+	}
+	if files, _ := fresh.ListSnapshotFiles(snapshots[0].ID); len(files) != 1 || files[0].FileID != "f1" {
+		t.Errorf("unexpected snapshot files: %+v", files)
+	}
+	if record, _ := fresh.GetBackupRecord("/data/c.txt", "c3"); record == nil || record.SnapshotID != snapshots[0].ID {
+		t.Errorf("record not linked to the imported snapshot: %+v", record)
+	}
+	if id, _ := fresh.GetRemoteDirectory("base", "data"); id != "dir-1" {
+		t.Errorf("remote directory imported as %q", id)
+	}
+}
+
+func TestFileVersions(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now().UTC()
+	insert := func(checksum, fileID string, at time.Time) int64 {
+		t.Helper()
+		id, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/a.txt", FileID: fileID, Checksum: checksum, OriginalSize: 5, BackupTime: at, Status: "success"})
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", checksum, err)
+		}
+		return id
+	}
+	first := insert("c1", "f1", now.Add(-2*time.Hour))
+	insert("c2", "f2", now.Add(-time.Hour))
+	// Reverting to earlier content is a new version
+	reverted := insert("c1", "f3", now)
+
+	history, err := db.GetBackupHistory("/data/a.txt", 10)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("expected 3 versions, got %d, %v", len(history), err)
+	}
+	for i, want := range []int{3, 2, 1} {
+		if history[i].Version != want {
+			t.Errorf("version %d at %d, want %d", history[i].Version, i, want)
+		}
+	}
+	if record, _ := db.GetBackupRecord("/data/a.txt", "c1"); record == nil || record.ID != reverted {
+		t.Errorf("expected the newest version with the content, got %+v", record)
+	}
+
+	var contents int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM contents`).Scan(&contents)
+	if contents != 2 {
+		t.Errorf("expected 2 contents, got %d", contents)
+	}
+
+	// A version sharing an earlier upload gets its copies
+	if err := db.SetTargetCopy(TargetCopy{RecordID: first, Target: "nas", FileID: "n1", Status: CopySuccess}); err != nil {
+		t.Fatalf("failed to set copy: %v", err)
+	}
+	shared, err := db.InsertBackupRecordFrom(BackupRecord{FilePath: "/data/a.txt", FileID: "f1", Checksum: "c1", OriginalSize: 5, BackupTime: now, Status: "success"}, first)
+	if err != nil {
+		t.Fatalf("failed to insert shared version: %v", err)
+	}
+	if copies, _ := db.ListTargetCopies(shared); len(copies) != 1 || copies[0].FileID != "n1" {
+		t.Errorf("unexpected copies of the shared version: %+v", copies)
+	}
+	if latest, _ := db.LatestBackupRecord("/data/a.txt"); latest == nil || latest.ID != shared || latest.Version != 4 {
+		t.Errorf("unexpected latest version: %+v", latest)
+	}
+
+	// Contents no version has anymore are removed
+	versions, _ := db.GetBackupHistory("/data/a.txt", 10)
+	var c2 []int64
+	for _, v := range versions {
+		if v.Checksum == "c2" {
+			c2 = append(c2, v.ID)
+		}
+	}
+	if _, err := db.DeleteBackupRecords(c2); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	db.conn.QueryRow(`SELECT COUNT(*) FROM contents`).Scan(&contents)
+	if contents != 1 {
+		t.Errorf("expected 1 content left, got %d", contents)
+	}
+}
+
+func TestForEachFileState(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now().UTC()
+	for i, path := range []string{"/data/a.txt", "/data/b.txt", "/data/c.txt"} {
+		state := FileState{FilePath: path, LastChecksum: path, LastBackup: now.Add(time.Duration(i) * time.Minute), Status: "success"}
+		if err := db.UpdateFileState(state); err != nil {
+			t.Fatalf("failed to update file state: %v", err)
+		}
+	}
+
+	var paths []string
+	err := db.ForEachFileState(10, func(state FileState) bool {
+		paths = append(paths, state.FilePath)
+		return len(paths) < 2
+	})
+	if err != nil {
+		t.Fatalf("failed to read file states: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/data/c.txt" || paths[1] != "/data/b.txt" {
+		t.Errorf("expected the two newest states, got %v", paths)
+	}
+}
+
+func TestSearchByName(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now()
+	for _, path := range []string{"/docs/report.pdf", "/docs/report.txt", "/scans.pdf/notes.txt", "/docs/old/invoice.pdf"} {
+		record := BackupRecord{FilePath: path, FileID: "id", Checksum: path, BackupTime: now, Status: "success"}
+		if _, err := db.InsertBackupRecord(record); err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+	}
+
+	records, err := db.SearchBackups(SearchCriteria{Name: "*.pdf", Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to search records: %v", err)
+	}
+	found := map[string]bool{}
+	for _, r := range records {
+		found[r.FilePath] = true
+	}
+	if len(found) != 2 || !found["/docs/report.pdf"] || !found["/docs/old/invoice.pdf"] {
+		t.Errorf("expected only the PDF files, got %v", found)
+	}
+
+	if records, _ := db.SearchBackups(SearchCriteria{Name: "*.pdf", Limit: 1}); len(records) != 1 {
+		t.Errorf("expected the limit to apply, got %d records", len(records))
+	}
+	// No limit means the same with and without a name
+	for _, limit := range []int{0, -1} {
+		if records, _ := db.SearchBackups(SearchCriteria{Name: "*.pdf", Limit: limit}); len(records) != 2 {
+			t.Errorf("limit %d: expected both PDF files, got %d records", limit, len(records))
+		}
+		if records, _ := db.SearchBackups(SearchCriteria{Limit: limit}); len(records) != 4 {
+			t.Errorf("limit %d: expected every record, got %d", limit, len(records))
+		}
+	}
+	if records, _ := db.SearchBackups(SearchCriteria{Limit: 3}); len(records) != 3 {
+		t.Errorf("expected the limit to apply without a name, got %d records", len(records))
+	}
+	if _, err := db.SearchBackups(SearchCriteria{Name: "[", Limit: 10}); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+
+	for _, state := range []FileState{
+		{FilePath: "/docs/report.pdf", LastBackup: now, Status: "failed"},
+		{FilePath: "/docs/report.txt", LastBackup: now, Status: "failed"},
+		{FilePath: "/docs/old/invoice.pdf", LastBackup: now.Add(-10 * 24 * time.Hour), Status: "failed"},
+	} {
+		if err := db.UpdateFileState(state); err != nil {
+			t.Fatalf("failed to update file state: %v", err)
+		}
+	}
+	states, err := db.SearchFileStates(SearchCriteria{Name: "*.pdf", Status: "failed", StartTime: now.Add(-7 * 24 * time.Hour), Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to search file states: %v", err)
+	}
+	if len(states) != 1 || states[0].FilePath != "/docs/report.pdf" {
+		t.Errorf("expected the recently failed PDF, got %+v", states)
+	}
+}
+
+func TestLatestVersionsUnder(t *testing.T) {
+	db := newTestDB(t)
+
+	now := time.Now()
+	for i, r := range []BackupRecord{
+		{FilePath: "/data/reports/a.txt", Checksum: "a1"},
+		{FilePath: "/data/reports/a.txt", Checksum: "a2"},
+		{FilePath: "/data/reports/2024/b.txt", Checksum: "b1"},
+		{FilePath: "/data/reports-old/c.txt", Checksum: "c1"},
+		{FilePath: "/data/reports", Checksum: "archive"},
+	} {
+		r.FileID, r.BackupTime, r.Status = "id", now.Add(time.Duration(i)*time.Second), "success"
+		if _, err := db.InsertBackupRecord(r); err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+	}
+
+	records, err := db.LatestVersionsUnder("/data/reports", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to get versions: %v", err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.FilePath+"@"+r.Checksum)
+	}
+	want := "/data/reports@archive /data/reports/2024/b.txt@b1 /data/reports/a.txt@a2"
+	if strings.Join(got, " ") != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+
+	if r, err := db.GetFileVersion("/data/reports/a.txt", 1); err != nil || r == nil || r.Checksum != "a1" {
+		t.Errorf("unexpected first version: %+v, %v", r, err)
+	}
+	if r, err := db.GetFileVersion("/data/reports/a.txt", 3); err != nil || r != nil {
+		t.Errorf("expected no third version, got %+v, %v", r, err)
+	}
+}
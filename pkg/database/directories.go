@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/koneksi/backup-cli/pkg/metadata"
+)
+
+// Directory is the metadata of a backed up directory as last seen, kept so
+// that a restore recreates directories, empty ones included, as they were.
+type Directory struct {
+	Path    string
+	Mode    os.FileMode
+	ModTime time.Time
+	// Owner is nil when it was not captured
+	Owner     *metadata.Owner
+	Xattrs    map[string][]byte
+	UpdatedAt time.Time
+}
+
+// SaveDirectory records the metadata of a directory, replacing what was
+// known of it.
+func (db *DB) SaveDirectory(d Directory) error {
+	return db.write(func(tx *sql.Tx) error {
+		return insertDirectory(tx, d)
+	})
+}
+
+func insertDirectory(q execQuerier, d Directory) error {
+	var modTime sql.NullInt64
+	if !d.ModTime.IsZero() {
+		modTime = sql.NullInt64{Int64: d.ModTime.UnixMilli(), Valid: true}
+	}
+	uid, gid := ownerValues(d.Owner)
+	xattrs, err := encodeXattrs(d.Xattrs)
+	if err != nil {
+		return err
+	}
+	updatedAt := d.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	_, err = q.Exec(`
+		INSERT OR REPLACE INTO directories (path, mode, uid, gid, mod_time, xattrs, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.Path, uint32(d.Mode), uid, gid, modTime, xattrs, updatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save directory: %w", err)
+	}
+	return nil
+}
+
+// DirectoriesUnder returns root, when it is a known directory, and the
+// known directories below it, ordered by path. An empty root returns them
+// all.
+func (db *DB) DirectoriesUnder(root string) ([]Directory, error) {
+	prefix, end := pathRange(root)
+	rows, err := db.conn.Query(`
+		SELECT path, mode, uid, gid, mod_time, xattrs, updated_at
+		FROM directories
+		WHERE path = ? OR (path >= ? AND path < ?)
+		ORDER BY path`,
+		root, prefix, end,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query directories: %w", err)
+	}
+	defer rows.Close()
+
+	var dirs []Directory
+	for rows.Next() {
+		var (
+			d                 Directory
+			mode, updatedAt   int64
+			uid, gid, modTime sql.NullInt64
+			xattrs            []byte
+		)
+		if err := rows.Scan(&d.Path, &mode, &uid, &gid, &modTime, &xattrs, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan directory: %w", err)
+		}
+		d.Mode, d.Owner, d.UpdatedAt = os.FileMode(mode), scanOwner(uid, gid), time.UnixMilli(updatedAt)
+		if modTime.Valid {
+			d.ModTime = time.UnixMilli(modTime.Int64)
+		}
+		if d.Xattrs, err = decodeXattrs(xattrs); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, rows.Err()
+}
+
+func ownerValues(owner *metadata.Owner) (uid, gid sql.NullInt64) {
+	if owner == nil {
+		return uid, gid
+	}
+	return sql.NullInt64{Int64: int64(owner.UID), Valid: true}, sql.NullInt64{Int64: int64(owner.GID), Valid: true}
+}
+
+func scanOwner(uid, gid sql.NullInt64) *metadata.Owner {
+	if !uid.Valid || !gid.Valid {
+		return nil
+	}
+	return &metadata.Owner{UID: int(uid.Int64), GID: int(gid.Int64)}
+}
+
+// Extended attributes are stored as a JSON object of base64 values.
+
+func encodeXattrs(xattrs map[string][]byte) ([]byte, error) {
+	if len(xattrs) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(xattrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode extended attributes: %w", err)
+	}
+	return data, nil
+}
+
+func decodeXattrs(data []byte) (map[string][]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var xattrs map[string][]byte
+	if err := json.Unmarshal(data, &xattrs); err != nil {
+		return nil, fmt.Errorf("failed to decode extended attributes: %w", err)
+	}
+	return xattrs, nil
+}
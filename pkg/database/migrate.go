@@ -0,0 +1,377 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migration is a versioned schema change. Its statements run in a single
+// transaction together with recording the version in schema_version.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// MigrationStatus is a known migration and when it was applied to a
+// database.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	// AppliedAt is zero while the migration is pending
+	AppliedAt time.Time
+}
+
+// Applied reports whether the migration was applied.
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// migrations are applied in order by New. Released migrations must never
+// change; add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "baseline schema",
+		// Databases created before migrations already have these tables
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS backup_records (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				file_path TEXT NOT NULL,
+				file_id TEXT,
+				checksum TEXT NOT NULL,
+				original_size INTEGER NOT NULL,
+				compressed_size INTEGER,
+				is_compressed BOOLEAN DEFAULT FALSE,
+				backup_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				status TEXT NOT NULL,
+				error_message TEXT,
+				operation TEXT,
+				UNIQUE(file_path, checksum)
+			)`,
+			`CREATE TABLE IF NOT EXISTS file_states (
+				file_path TEXT PRIMARY KEY,
+				last_checksum TEXT,
+				last_backup TIMESTAMP,
+				backup_count INTEGER DEFAULT 0,
+				status TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS backup_queue (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				file_path TEXT NOT NULL UNIQUE,
+				operation TEXT NOT NULL,
+				size INTEGER NOT NULL DEFAULT 0,
+				enqueued_at INTEGER NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at INTEGER NOT NULL,
+				leased_until INTEGER,
+				last_error TEXT,
+				version INTEGER NOT NULL DEFAULT 1
+			)`,
+			`CREATE TABLE IF NOT EXISTS dead_letters (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				file_path TEXT NOT NULL UNIQUE,
+				operation TEXT NOT NULL,
+				size INTEGER NOT NULL DEFAULT 0,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL,
+				failed_at INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS snapshots (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				job TEXT NOT NULL,
+				started_at INTEGER NOT NULL,
+				finished_at INTEGER,
+				status TEXT NOT NULL,
+				files INTEGER NOT NULL DEFAULT 0,
+				total_size INTEGER NOT NULL DEFAULT 0,
+				error TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS snapshot_files (
+				snapshot_id INTEGER NOT NULL,
+				file_path TEXT NOT NULL,
+				file_id TEXT NOT NULL,
+				checksum TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (snapshot_id, file_path)
+			)`,
+			`CREATE TABLE IF NOT EXISTS target_copies (
+				record_id INTEGER NOT NULL,
+				target TEXT NOT NULL,
+				file_id TEXT,
+				status TEXT NOT NULL,
+				error_message TEXT,
+				updated_at INTEGER NOT NULL,
+				PRIMARY KEY (record_id, target)
+			)`,
+			`CREATE TABLE IF NOT EXISTS remote_deletions (
+				target TEXT NOT NULL,
+				file_id TEXT NOT NULL,
+				queued_at INTEGER NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT,
+				PRIMARY KEY (target, file_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS remote_directories (
+				base_id TEXT NOT NULL,
+				path TEXT NOT NULL,
+				directory_id TEXT NOT NULL,
+				created_at INTEGER NOT NULL,
+				PRIMARY KEY (base_id, path)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_backup_records_file_path ON backup_records(file_path)`,
+			`CREATE INDEX IF NOT EXISTS idx_snapshots_job ON snapshots(job, started_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_backup_records_status ON backup_records(status)`,
+			`CREATE INDEX IF NOT EXISTS idx_backup_records_backup_time ON backup_records(backup_time)`,
+			`CREATE INDEX IF NOT EXISTS idx_backup_queue_next_attempt ON backup_queue(next_attempt_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_target_copies_status ON target_copies(status)`,
+			`CREATE INDEX IF NOT EXISTS idx_backup_records_file_id ON backup_records(file_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_snapshot_files_file_id ON snapshot_files(file_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_target_copies_file_id ON target_copies(target, file_id)`,
+		},
+	},
+	{
+		version:     2,
+		description: "file versions with shared contents",
+		// Records keep their IDs, so target copies still refer to them.
+		// Versions are numbered by backup time.
+		statements: []string{
+			`CREATE TABLE contents (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				checksum TEXT NOT NULL UNIQUE,
+				size INTEGER NOT NULL
+			)`,
+			`CREATE TABLE file_versions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				file_path TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				content_id INTEGER NOT NULL REFERENCES contents(id),
+				file_id TEXT,
+				compressed_size INTEGER,
+				is_compressed BOOLEAN NOT NULL DEFAULT FALSE,
+				mode INTEGER,
+				mod_time INTEGER,
+				snapshot_id INTEGER,
+				backup_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				status TEXT NOT NULL,
+				error_message TEXT,
+				operation TEXT,
+				UNIQUE(file_path, version)
+			)`,
+			`INSERT INTO contents (checksum, size)
+				SELECT checksum, MAX(original_size) FROM backup_records GROUP BY checksum`,
+			`INSERT INTO file_versions
+				(id, file_path, version, content_id, file_id, compressed_size, is_compressed,
+				 backup_time, status, error_message, operation)
+				SELECT r.id, r.file_path,
+				       ROW_NUMBER() OVER (PARTITION BY r.file_path ORDER BY r.backup_time, r.id),
+				       c.id, r.file_id, r.compressed_size, COALESCE(r.is_compressed, FALSE),
+				       COALESCE(r.backup_time, CURRENT_TIMESTAMP), r.status, r.error_message, r.operation
+				FROM backup_records r
+				JOIN contents c ON c.checksum = r.checksum`,
+			`DROP TABLE backup_records`,
+			`CREATE INDEX idx_file_versions_content ON file_versions(content_id)`,
+			`CREATE INDEX idx_file_versions_file_id ON file_versions(file_id)`,
+			`CREATE INDEX idx_file_versions_status ON file_versions(status)`,
+			`CREATE INDEX idx_file_versions_backup_time ON file_versions(backup_time)`,
+			`CREATE INDEX idx_file_versions_snapshot ON file_versions(snapshot_id)`,
+		},
+	},
+	{
+		version:     3,
+		description: "file states by last backup",
+		statements: []string{
+			`CREATE INDEX idx_file_states_last_backup ON file_states(last_backup)`,
+		},
+	},
+	{
+		version:     4,
+		description: "file owners, links, extended attributes and directories",
+		// Versions backed up before have no owner; xattrs hold JSON
+		statements: []string{
+			`ALTER TABLE file_versions ADD COLUMN uid INTEGER`,
+			`ALTER TABLE file_versions ADD COLUMN gid INTEGER`,
+			`ALTER TABLE file_versions ADD COLUMN link_target TEXT`,
+			`ALTER TABLE file_versions ADD COLUMN xattrs BLOB`,
+			`CREATE TABLE directories (
+				path TEXT PRIMARY KEY,
+				mode INTEGER NOT NULL,
+				uid INTEGER,
+				gid INTEGER,
+				mod_time INTEGER,
+				xattrs BLOB,
+				updated_at INTEGER NOT NULL
+			)`,
+		},
+	},
+	{
+		version:     5,
+		description: "installation settings",
+		statements: []string{
+			`CREATE TABLE settings (
+				name TEXT PRIMARY KEY,
+				value BLOB NOT NULL
+			)`,
+		},
+	},
+	{
+		version:     6,
+		description: "encryption of file versions",
+		// Whether a version was encrypted is unknown before, except for
+		// versions of job snapshots
+		statements: []string{
+			`ALTER TABLE file_versions ADD COLUMN encrypted INTEGER`,
+			`ALTER TABLE file_versions ADD COLUMN key_id TEXT`,
+			`ALTER TABLE snapshot_files ADD COLUMN key_id TEXT`,
+			`UPDATE file_versions SET encrypted = (
+				SELECT f.encrypted FROM snapshot_files f
+				WHERE f.snapshot_id = file_versions.snapshot_id
+				  AND f.file_path = file_versions.file_path
+				  AND f.file_id = file_versions.file_id
+			) WHERE snapshot_id IS NOT NULL`,
+		},
+	},
+	{
+		version:     7,
+		description: "retries of target copies",
+		statements: []string{
+			`ALTER TABLE target_copies ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE target_copies ADD COLUMN next_attempt_at INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     8,
+		description: "batches of queued tasks",
+		statements: []string{
+			`ALTER TABLE backup_queue ADD COLUMN batch TEXT`,
+		},
+	},
+}
+
+const schemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+)`
+
+// migrate applies the pending migrations, each in its own transaction. A
+// database migrated by a newer version is rejected.
+func (db *DB) migrate(migrations []migration) error {
+	if _, err := db.conn.Exec(schemaVersionTable); err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	current, err := schemaVersion(db.conn)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].version; current > latest {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) apply(m migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Another process may have applied it since the version was read
+	var applied int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_version WHERE version = ?`, m.version).Scan(&applied); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.description, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(conn *sql.DB) (int, error) {
+	var version int
+	if err := conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// SchemaVersion returns the schema version of the database.
+func (db *DB) SchemaVersion() (int, error) {
+	return schemaVersion(db.conn)
+}
+
+// MigrationStatuses returns the known migrations of the database at
+// dbPath, and when each was applied, without migrating it. Versions
+// applied by a newer release are included with their own description.
+func MigrationStatuses(dbPath string) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		statuses = append(statuses, MigrationStatus{Version: m.version, Description: m.description})
+	}
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return statuses, nil
+	}
+
+	conn, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+
+	var exists int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	if exists == 0 {
+		return statuses, nil
+	}
+
+	rows, err := conn.Query(`SELECT version, description, applied_at FROM schema_version ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version     int
+			description string
+			appliedAt   int64
+		)
+		if err := rows.Scan(&version, &description, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema version: %w", err)
+		}
+		if version <= len(statuses) && statuses[version-1].Version == version {
+			statuses[version-1].AppliedAt = time.UnixMilli(appliedAt)
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{Version: version, Description: description, AppliedAt: time.UnixMilli(appliedAt)})
+	}
+	return statuses, rows.Err()
+}
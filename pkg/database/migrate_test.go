@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// originalSchema is the schema databases were created with before
+// migrations were introduced.
+var originalSchema = []string{
+	`CREATE TABLE IF NOT EXISTS backup_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_path TEXT NOT NULL,
+		file_id TEXT,
+		checksum TEXT NOT NULL,
+		original_size INTEGER NOT NULL,
+		compressed_size INTEGER,
+		is_compressed BOOLEAN DEFAULT FALSE,
+		backup_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		status TEXT NOT NULL,
+		error_message TEXT,
+		operation TEXT,
+		UNIQUE(file_path, checksum)
+	)`,
+	`CREATE TABLE IF NOT EXISTS file_states (
+		file_path TEXT PRIMARY KEY,
+		last_checksum TEXT,
+		last_backup TIMESTAMP,
+		backup_count INTEGER DEFAULT 0,
+		status TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_backup_records_file_path ON backup_records(file_path)`,
+	`CREATE INDEX IF NOT EXISTS idx_backup_records_status ON backup_records(status)`,
+	`CREATE INDEX IF NOT EXISTS idx_backup_records_backup_time ON backup_records(backup_time)`,
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// A database created before migrations has the original tables only
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, statement := range originalSchema {
+		if _, err := conn.Exec(statement); err != nil {
+			t.Fatalf("failed to create original schema: %v", err)
+		}
+	}
+	now := time.Now().UTC()
+	baseline := []struct {
+		id                     int64
+		path, fileID, checksum string
+		backupTime             time.Time
+	}{
+		{7, "/data/a.txt", "f2", "c2", now},
+		{3, "/data/a.txt", "f1", "c1", now.Add(-time.Hour)},
+		{5, "/data/b.txt", "f3", "c1", now},
+	}
+	for _, r := range baseline {
+		_, err := conn.Exec(`INSERT INTO backup_records (id, file_path, file_id, checksum, original_size, backup_time, status) VALUES (?, ?, ?, ?, 10, ?, 'success')`,
+			r.id, r.path, r.fileID, r.checksum, r.backupTime)
+		if err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+	}
+	_, err = conn.Exec(`INSERT INTO file_states (file_path, last_checksum, last_backup, backup_count, status) VALUES ('/data/a.txt', 'c2', ?, 2, 'success')`, now)
+	if err != nil {
+		t.Fatalf("failed to insert file state: %v", err)
+	}
+
+	statuses, err := MigrationStatuses(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	if len(statuses) != len(migrations) || statuses[0].Applied() {
+		t.Fatalf("expected every migration pending, got %+v", statuses)
+	}
+
+	// The baseline migration adds the tables of later features, which may
+	// be used before the records become versions
+	if err := (&DB{conn: conn}).migrate(migrations[:1]); err != nil {
+		t.Fatalf("failed to apply the baseline migration: %v", err)
+	}
+	_, err = conn.Exec(`INSERT INTO target_copies (record_id, target, file_id, status, updated_at) VALUES (7, 'nas', 'n2', 'success', 0)`)
+	if err != nil {
+		t.Fatalf("failed to insert copy: %v", err)
+	}
+	conn.Close()
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	defer db.Close()
+
+	if version, err := db.SchemaVersion(); err != nil || version != migrations[len(migrations)-1].version {
+		t.Errorf("unexpected schema version %d, %v", version, err)
+	}
+	// Records keep their IDs and are numbered by backup time
+	history, err := db.GetBackupHistory("/data/a.txt", 10)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("expected 2 versions, got %+v, %v", history, err)
+	}
+	if history[0].ID != 7 || history[0].Version != 2 || history[1].ID != 3 || history[1].Version != 1 || history[1].FileID != "f1" {
+		t.Errorf("unexpected migrated versions: %+v", history)
+	}
+	if copies, _ := db.ListTargetCopies(7); len(copies) != 1 || copies[0].FileID != "n2" {
+		t.Errorf("target copy lost in migration: %+v", copies)
+	}
+	if state, _ := db.GetFileState("/data/a.txt"); state == nil || state.LastChecksum != "c2" || state.BackupCount != 2 {
+		t.Errorf("file state lost in migration: %+v", state)
+	}
+	var contents int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM contents`).Scan(&contents)
+	if contents != 2 {
+		t.Errorf("expected 2 contents, got %d", contents)
+	}
+	if id, err := db.InsertBackupRecord(BackupRecord{FilePath: "/data/a.txt", Checksum: "c1", BackupTime: now, Status: "success"}); err != nil || id <= 7 {
+		t.Errorf("unexpected new record ID %d, %v", id, err)
+	}
+
+	statuses, err = MigrationStatuses(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied() {
+			t.Errorf("migration %d not applied", s.Version)
+		}
+	}
+
+	// Opening again applies nothing
+	db.Close()
+	if db, err = New(path); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	var applied int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("expected %d schema versions, got %d", len(migrations), applied)
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	db := newTestDB(t)
+	before, _ := db.SchemaVersion()
+
+	next := before + 1
+	failing := append(append([]migration{}, migrations...), migration{
+		version:     next,
+		description: "broken",
+		statements:  []string{`CREATE TABLE extra (id INTEGER)`, `INSERT INTO missing VALUES (1)`},
+	})
+	if err := db.migrate(failing); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if version, _ := db.SchemaVersion(); version != before {
+		t.Errorf("schema version moved to %d", version)
+	}
+	var tables int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'extra'`).Scan(&tables)
+	if tables != 0 {
+		t.Error("the failed migration was not rolled back")
+	}
+
+	failing[len(failing)-1].statements = []string{`CREATE TABLE extra (id INTEGER)`}
+	if err := db.migrate(failing); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if version, _ := db.SchemaVersion(); version != next {
+		t.Errorf("expected schema version %d, got %d", next, version)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	_, err = db.conn.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, 'future', ?)`,
+		len(migrations)+10, time.Now().UnixMilli())
+	if err != nil {
+		t.Fatalf("failed to insert version: %v", err)
+	}
+	db.Close()
+
+	if _, err := New(path); err == nil {
+		t.Error("expected a newer schema to be rejected")
+	}
+	statuses, err := MigrationStatuses(path)
+	if err != nil || len(statuses) != len(migrations)+1 || statuses[len(statuses)-1].Description != "future" {
+		t.Errorf("unexpected status %+v, %v", statuses, err)
+	}
+}
+
+func TestMigrationStatusesMissingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.db")
+	statuses, err := MigrationStatuses(path)
+	if err != nil || len(statuses) != len(migrations) {
+		t.Fatalf("unexpected status %+v, %v", statuses, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("reading the status created the database")
+	}
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PrimaryTarget names the primary target in remote_deletions. It matches
+// config.PrimaryTarget.
+const PrimaryTarget = "primary"
+
+// RemoteDeletion is a remote object that is no longer referenced and waits
+// to be deleted from its target.
+type RemoteDeletion struct {
+	Target   string
+	FileID   string
+	QueuedAt time.Time
+	Attempts int
+	Error    string
+}
+
+// ForEachFileVersions calls fn with the successful backup records of every
+// file, newest first. Files are visited in path order; fn must not use the
+// database.
+func (db *DB) ForEachFileVersions(fn func(versions []BackupRecord) error) error {
+	query := `
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
+		WHERE v.status = 'success'
+		ORDER BY v.file_path, v.backup_time DESC, v.version DESC
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query file versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []BackupRecord
+	for rows.Next() {
+		r, err := scanBackupRecord(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan file version: %w", err)
+		}
+
+		if len(versions) > 0 && versions[0].FilePath != r.FilePath {
+			if err := fn(versions); err != nil {
+				return err
+			}
+			versions = nil
+		}
+		versions = append(versions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read file versions: %w", err)
+	}
+
+	if len(versions) > 0 {
+		return fn(versions)
+	}
+	return nil
+}
+
+// DeleteBackupRecords removes backup records and their target copies, and
+// queues the remote objects no longer referenced for deletion. It returns
+// the number of objects queued.
+func (db *DB) DeleteBackupRecords(ids []int64) (int, error) {
+	queued := 0
+	err := db.write(func(tx *sql.Tx) error {
+		var objects []RemoteDeletion
+		for _, id := range ids {
+			var fileID sql.NullString
+			err := tx.QueryRow(`SELECT file_id FROM file_versions WHERE id = ?`, id).Scan(&fileID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get backup record: %w", err)
+			}
+			objects = append(objects, RemoteDeletion{Target: PrimaryTarget, FileID: fileID.String})
+
+			rows, err := tx.Query(`SELECT target, file_id FROM target_copies WHERE record_id = ? AND file_id IS NOT NULL`, id)
+			if err != nil {
+				return fmt.Errorf("failed to query target copies: %w", err)
+			}
+			for rows.Next() {
+				var o RemoteDeletion
+				if err := rows.Scan(&o.Target, &o.FileID); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan target copy: %w", err)
+				}
+				objects = append(objects, o)
+			}
+			rows.Close()
+
+			if _, err := tx.Exec(`DELETE FROM target_copies WHERE record_id = ?`, id); err != nil {
+				return fmt.Errorf("failed to delete target copies: %w", err)
+			}
+			if _, err := tx.Exec(`DELETE FROM file_versions WHERE id = ?`, id); err != nil {
+				return fmt.Errorf("failed to delete backup record: %w", err)
+			}
+		}
+		if err := deleteUnusedContents(tx); err != nil {
+			return err
+		}
+
+		var err error
+		queued, err = queueUnreferenced(tx, objects)
+		return err
+	})
+	return queued, err
+}
+
+// queueUnreferenced queues the objects that nothing refers to anymore for
+// deletion.
+func queueUnreferenced(tx *sql.Tx, objects []RemoteDeletion) (int, error) {
+	queued := 0
+	now := time.Now().UnixMilli()
+	for _, o := range objects {
+		if o.FileID == "" {
+			continue
+		}
+		referenced, err := fileIDReferenced(tx, o.Target, o.FileID)
+		if err != nil {
+			return 0, err
+		}
+		if referenced {
+			continue
+		}
+
+		result, err := tx.Exec(`INSERT OR IGNORE INTO remote_deletions (target, file_id, queued_at) VALUES (?, ?, ?)`, o.Target, o.FileID, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to queue remote deletion: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// FileIDReferenced reports whether a backup record, snapshot or target copy
+// still refers to the object fileID on target.
+func (db *DB) FileIDReferenced(target, fileID string) (bool, error) {
+	return fileIDReferenced(db.conn, target, fileID)
+}
+
+func fileIDReferenced(q rowQuerier, target, fileID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM target_copies WHERE target = ? AND file_id = ?)`
+	args := []interface{}{target, fileID}
+	if target == PrimaryTarget {
+		query = `
+			SELECT EXISTS (SELECT 1 FROM file_versions WHERE file_id = ?)
+			    OR EXISTS (SELECT 1 FROM snapshot_files WHERE file_id = ?)
+		`
+		args = []interface{}{fileID, fileID}
+	}
+
+	var referenced bool
+	if err := q.QueryRow(query, args...).Scan(&referenced); err != nil {
+		return false, fmt.Errorf("failed to check references: %w", err)
+	}
+	return referenced, nil
+}
+
+// PendingDeletions returns the remote objects waiting to be deleted,
+// oldest first.
+func (db *DB) PendingDeletions() ([]RemoteDeletion, error) {
+	query := `
+		SELECT target, file_id, queued_at, attempts, last_error
+		FROM remote_deletions
+		ORDER BY queued_at, target, file_id
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query remote deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var deletions []RemoteDeletion
+	for rows.Next() {
+		var (
+			d        RemoteDeletion
+			queuedAt int64
+			errMsg   sql.NullString
+		)
+		if err := rows.Scan(&d.Target, &d.FileID, &queuedAt, &d.Attempts, &errMsg); err != nil {
+			return nil, fmt.Errorf("failed to scan remote deletion: %w", err)
+		}
+		d.QueuedAt, d.Error = time.UnixMilli(queuedAt), errMsg.String
+		deletions = append(deletions, d)
+	}
+
+	return deletions, rows.Err()
+}
+
+// CompleteDeletion removes a remote object from the deletion queue, after
+// it was deleted or found to be referenced again.
+func (db *DB) CompleteDeletion(target, fileID string) error {
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM remote_deletions WHERE target = ? AND file_id = ?`, target, fileID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete remote deletion: %w", err)
+	}
+	return nil
+}
+
+// FailDeletion records a failed attempt to delete a remote object. The
+// object stays queued.
+func (db *DB) FailDeletion(target, fileID, errMsg string) error {
+	query := `
+		UPDATE remote_deletions
+		SET attempts = attempts + 1, last_error = ?
+		WHERE target = ? AND file_id = ?
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, errMsg, target, fileID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record remote deletion failure: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QueueTask is a pending backup task stored in the backup_queue table.
+// Times are stored as unix milliseconds so they compare correctly in SQL.
+type QueueTask struct {
+	ID            int64
+	FilePath      string
+	Operation     string
+	Size          int64
+	EnqueuedAt    time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+	LeasedUntil   time.Time
+	LastError     string
+	// Version is bumped every time a change for the same path is coalesced
+	// into the task, so an ack for an older version does not drop it.
+	Version int64
+}
+
+const queueColumns = `id, file_path, operation, size, enqueued_at, attempts,
+	next_attempt_at, leased_until, last_error, version`
+
+// EnqueueTask adds a task to the queue. A task already queued for the same
+// path is updated in place instead of adding a duplicate.
+func (db *DB) EnqueueTask(filePath, operation string, size int64) error {
+	return db.EnqueueBatchTask("", filePath, operation, size)
+}
+
+// EnqueueBatchTask adds a task to the queue as part of batch, so that
+// LeaseBatchTask hands it out. A task already queued for the same path
+// moves to the batch of the latest change; an empty batch is none.
+func (db *DB) EnqueueBatchTask(batch, filePath, operation string, size int64) error {
+	now := time.Now().UnixMilli()
+	query := `
+		INSERT INTO backup_queue
+		(file_path, operation, size, enqueued_at, attempts, next_attempt_at, version, batch)
+		VALUES (?, ?, ?, ?, 0, ?, 1, ?)
+		ON CONFLICT(file_path) DO UPDATE SET
+			operation = excluded.operation,
+			size = excluded.size,
+			enqueued_at = excluded.enqueued_at,
+			attempts = 0,
+			next_attempt_at = excluded.next_attempt_at,
+			last_error = NULL,
+			version = backup_queue.version + 1,
+			batch = excluded.batch
+	`
+
+	batchID := sql.NullString{String: batch, Valid: batch != ""}
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, filePath, operation, size, now, now, batchID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue task: %w", err)
+	}
+
+	return nil
+}
+
+// LeaseTask claims the next due task for the given duration. It returns nil
+// when no task is ready. Leases that expire, for example because the
+// process crashed, make the task available again.
+func (db *DB) LeaseTask(lease time.Duration) (*QueueTask, error) {
+	return db.LeaseBatchTask(lease, "")
+}
+
+// LeaseBatchTask is LeaseTask restricted to the tasks of batch. An empty
+// batch claims tasks of any batch.
+func (db *DB) LeaseBatchTask(lease time.Duration, batch string) (*QueueTask, error) {
+	now := time.Now()
+	query := `
+		UPDATE backup_queue
+		SET leased_until = ?, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM backup_queue
+			WHERE next_attempt_at <= ?
+			  AND (leased_until IS NULL OR leased_until < ?)
+			  AND (? = '' OR batch = ?)
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+		)
+		RETURNING ` + queueColumns
+
+	var task *QueueTask
+	err := db.write(func(tx *sql.Tx) error {
+		var err error
+		task, err = scanQueueTask(tx.QueryRow(query,
+			now.Add(lease).UnixMilli(), now.UnixMilli(), now.UnixMilli(), batch, batch,
+		))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease task: %w", err)
+	}
+
+	return task, nil
+}
+
+// RenewLease extends the lease of a task that is still being processed.
+func (db *DB) RenewLease(id int64, lease time.Duration) error {
+	query := `UPDATE backup_queue SET leased_until = ? WHERE id = ?`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, time.Now().Add(lease).UnixMilli(), id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	return nil
+}
+
+// AckTask removes a completed task. If the task was updated by a newer
+// change while it was leased, it is released instead so the newer change
+// is processed.
+func (db *DB) AckTask(task *QueueTask) error {
+	return db.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM backup_queue WHERE id = ? AND version = ?`, task.ID, task.Version)
+		if err != nil {
+			return fmt.Errorf("failed to ack task: %w", err)
+		}
+
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			// A successful backup supersedes an earlier permanent failure
+			if _, err := tx.Exec(`DELETE FROM dead_letters WHERE file_path = ?`, task.FilePath); err != nil {
+				return fmt.Errorf("failed to clear dead letter: %w", err)
+			}
+			return nil
+		}
+
+		return releaseSuperseded(tx, task)
+	})
+}
+
+// RetryTask releases a failed task and schedules its next attempt.
+func (db *DB) RetryTask(task *QueueTask, nextAttempt time.Time, errMsg string) error {
+	query := `
+		UPDATE backup_queue
+		SET leased_until = NULL, next_attempt_at = ?, last_error = ?
+		WHERE id = ? AND version = ?
+	`
+	return db.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec(query, nextAttempt.UnixMilli(), errMsg, task.ID, task.Version)
+		if err != nil {
+			return fmt.Errorf("failed to schedule retry: %w", err)
+		}
+
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			return nil
+		}
+
+		return releaseSuperseded(tx, task)
+	})
+}
+
+// releaseSuperseded releases the lease of a task that received a newer
+// change while it was being processed, so the change runs right away.
+func releaseSuperseded(tx *sql.Tx, task *QueueTask) error {
+	if _, err := tx.Exec(`UPDATE backup_queue SET leased_until = NULL WHERE id = ?`, task.ID); err != nil {
+		return fmt.Errorf("failed to release superseded task: %w", err)
+	}
+	return nil
+}
+
+// ReleaseTask returns a leased task to the queue without counting the
+// attempt, for work interrupted by shutdown.
+func (db *DB) ReleaseTask(task *QueueTask) error {
+	query := `
+		UPDATE backup_queue
+		SET leased_until = NULL, attempts = MAX(attempts - 1, 0)
+		WHERE id = ?
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, task.ID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release task: %w", err)
+	}
+	return nil
+}
+
+// DeadLetter is a task that failed permanently or ran out of retries.
+type DeadLetter struct {
+	ID        int64
+	FilePath  string
+	Operation string
+	Size      int64
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+// DeadLetterTask moves a leased task out of the queue into the dead-letter
+// list. A task that was superseded by a newer change stays queued instead.
+func (db *DB) DeadLetterTask(task *QueueTask, errMsg string) error {
+	return db.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM backup_queue WHERE id = ? AND version = ?`, task.ID, task.Version)
+		if err != nil {
+			return fmt.Errorf("failed to remove task from queue: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return releaseSuperseded(tx, task)
+		}
+
+		query := `
+			INSERT INTO dead_letters (file_path, operation, size, attempts, last_error, failed_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(file_path) DO UPDATE SET
+				operation = excluded.operation,
+				size = excluded.size,
+				attempts = excluded.attempts,
+				last_error = excluded.last_error,
+				failed_at = excluded.failed_at
+		`
+		_, err = tx.Exec(query, task.FilePath, task.Operation, task.Size, task.Attempts, errMsg, time.Now().UnixMilli())
+		if err != nil {
+			return fmt.Errorf("failed to insert dead letter: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListDeadLetters returns all dead-lettered tasks, most recent first.
+func (db *DB) ListDeadLetters() ([]DeadLetter, error) {
+	query := `
+		SELECT id, file_path, operation, size, attempts, last_error, failed_at
+		FROM dead_letters
+		ORDER BY failed_at DESC
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		var (
+			d        DeadLetter
+			failedAt int64
+		)
+		if err := rows.Scan(&d.ID, &d.FilePath, &d.Operation, &d.Size, &d.Attempts, &d.LastError, &failedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		d.FailedAt = time.UnixMilli(failedAt)
+		letters = append(letters, d)
+	}
+
+	return letters, rows.Err()
+}
+
+// DeadLetterCount returns the number of dead-lettered tasks.
+func (db *DB) DeadLetterCount() (int, error) {
+	var count int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM dead_letters`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	return count, nil
+}
+
+// RequeueDeadLetters moves dead-lettered tasks back into the queue with a
+// fresh attempt count. With no paths, every dead letter is requeued.
+func (db *DB) RequeueDeadLetters(paths []string) (int, error) {
+	// The upsert below needs a WHERE clause on the SELECT to parse
+	where := " WHERE 1=1"
+	args := []interface{}{}
+	if len(paths) > 0 {
+		where = " WHERE file_path IN (?" + strings.Repeat(", ?", len(paths)-1) + ")"
+		for _, p := range paths {
+			args = append(args, p)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	insert := `
+		INSERT INTO backup_queue
+		(file_path, operation, size, enqueued_at, attempts, next_attempt_at, version)
+		SELECT file_path, operation, size, ?, 0, ?, 1 FROM dead_letters` + where + `
+		ON CONFLICT(file_path) DO UPDATE SET
+			attempts = 0,
+			next_attempt_at = excluded.next_attempt_at,
+			last_error = NULL,
+			version = backup_queue.version + 1
+	`
+	var requeued int64
+	err := db.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(insert, append([]interface{}{now, now}, args...)...); err != nil {
+			return fmt.Errorf("failed to requeue dead letters: %w", err)
+		}
+
+		result, err := tx.Exec(`DELETE FROM dead_letters`+where, args...)
+		if err != nil {
+			return fmt.Errorf("failed to remove dead letters: %w", err)
+		}
+		requeued, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(requeued), nil
+}
+
+// QueueDepth returns the number of queued tasks, including leased ones.
+func (db *DB) QueueDepth() (int, error) {
+	var depth int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM backup_queue`).Scan(&depth); err != nil {
+		return 0, fmt.Errorf("failed to count queue: %w", err)
+	}
+	return depth, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanQueueTask(row rowScanner) (*QueueTask, error) {
+	var (
+		t                         QueueTask
+		enqueuedAt, nextAttemptAt int64
+		leasedUntil               sql.NullInt64
+		lastError                 sql.NullString
+	)
+
+	err := row.Scan(
+		&t.ID, &t.FilePath, &t.Operation, &t.Size, &enqueuedAt, &t.Attempts,
+		&nextAttemptAt, &leasedUntil, &lastError, &t.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.EnqueuedAt = time.UnixMilli(enqueuedAt)
+	t.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+	if leasedUntil.Valid {
+		t.LeasedUntil = time.UnixMilli(leasedUntil.Int64)
+	}
+	t.LastError = lastError.String
+
+	return &t, nil
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQueueCoalescesSamePath(t *testing.T) {
+	db := newTestDB(t)
+
+	for i := 0; i < 3; i++ {
+		if err := db.EnqueueTask("/data/file.txt", "modify", int64(i)); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+	}
+	if err := db.EnqueueTask("/data/other.txt", "create", 1); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	depth, err := db.QueueDepth()
+	if err != nil {
+		t.Fatalf("failed to get queue depth: %v", err)
+	}
+	if depth != 2 {
+		t.Errorf("expected 2 queued tasks, got %d", depth)
+	}
+}
+
+func TestQueueLeaseAndAck(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := db.EnqueueTask("/data/file.txt", "create", 10); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	task, err := db.LeaseTask(time.Minute)
+	if err != nil || task == nil {
+		t.Fatalf("expected a task, got %v (err %v)", task, err)
+	}
+	if task.FilePath != "/data/file.txt" || task.Attempts != 1 {
+		t.Errorf("unexpected task: %+v", task)
+	}
+
+	// A leased task is not handed out twice
+	if again, err := db.LeaseTask(time.Minute); err != nil || again != nil {
+		t.Fatalf("expected no task while leased, got %v (err %v)", again, err)
+	}
+
+	if err := db.AckTask(task); err != nil {
+		t.Fatalf("failed to ack: %v", err)
+	}
+	if depth, _ := db.QueueDepth(); depth != 0 {
+		t.Errorf("expected empty queue after ack, got %d", depth)
+	}
+}
+
+func TestQueueLeaseBatch(t *testing.T) {
+	db := newTestDB(t)
+
+	db.EnqueueTask("/data/daemon.txt", "create", 10)
+	if err := db.EnqueueBatchTask("cli", "/data/cli.txt", "manual", 20); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+
+	task, err := db.LeaseBatchTask(time.Minute, "cli")
+	if err != nil || task == nil || task.FilePath != "/data/cli.txt" {
+		t.Fatalf("expected the batch task, got %v (err %v)", task, err)
+	}
+	if other, err := db.LeaseBatchTask(time.Minute, "cli"); err != nil || other != nil {
+		t.Fatalf("expected no other task of the batch, got %v (err %v)", other, err)
+	}
+
+	// Without a batch any task is handed out
+	if other, err := db.LeaseTask(time.Minute); err != nil || other == nil || other.FilePath != "/data/daemon.txt" {
+		t.Fatalf("expected the task outside the batch, got %v (err %v)", other, err)
+	}
+}
+
+func TestQueueChangeDuringLeaseIsKept(t *testing.T) {
+	db := newTestDB(t)
+
+	db.EnqueueTask("/data/file.txt", "create", 10)
+	task, _ := db.LeaseTask(time.Minute)
+
+	// The file changes again while it is being uploaded
+	if err := db.EnqueueTask("/data/file.txt", "modify", 20); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+	if err := db.AckTask(task); err != nil {
+		t.Fatalf("failed to ack: %v", err)
+	}
+
+	next, err := db.LeaseTask(time.Minute)
+	if err != nil || next == nil {
+		t.Fatalf("expected the newer change to remain queued, got %v (err %v)", next, err)
+	}
+	if next.Operation != "modify" || next.Size != 20 {
+		t.Errorf("unexpected task: %+v", next)
+	}
+}
+
+func TestQueueExpiredLeaseIsRetried(t *testing.T) {
+	db := newTestDB(t)
+
+	db.EnqueueTask("/data/file.txt", "create", 10)
+	if task, _ := db.LeaseTask(-time.Second); task == nil {
+		t.Fatal("expected a task")
+	}
+
+	// Simulates a crash: the lease has expired without an ack
+	task, err := db.LeaseTask(time.Minute)
+	if err != nil || task == nil {
+		t.Fatalf("expected expired task to be leased again, got %v (err %v)", task, err)
+	}
+	if task.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", task.Attempts)
+	}
+
+	if err := db.ReleaseTask(task); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	released, _ := db.LeaseTask(time.Minute)
+	if released == nil || released.Attempts != 2 {
+		t.Errorf("released task should not count the interrupted attempt: %+v", released)
+	}
+}
+
+func TestQueueRetryAndDeadLetter(t *testing.T) {
+	db := newTestDB(t)
+
+	db.EnqueueTask("/data/file.txt", "create", 10)
+	task, _ := db.LeaseTask(time.Minute)
+
+	if err := db.RetryTask(task, time.Now().Add(time.Hour), "server error"); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if next, _ := db.LeaseTask(time.Minute); next != nil {
+		t.Fatalf("task should not be due before its retry time, got %+v", next)
+	}
+
+	// Make the retry due and fail it permanently
+	if err := db.RetryTask(task, time.Now().Add(-time.Second), "server error"); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	task, _ = db.LeaseTask(time.Minute)
+	if task == nil || task.LastError != "server error" {
+		t.Fatalf("expected retried task with last error, got %+v", task)
+	}
+	if err := db.DeadLetterTask(task, "not found"); err != nil {
+		t.Fatalf("failed to dead-letter task: %v", err)
+	}
+
+	letters, err := db.ListDeadLetters()
+	if err != nil {
+		t.Fatalf("failed to list dead letters: %v", err)
+	}
+	if len(letters) != 1 || letters[0].LastError != "not found" || letters[0].Attempts != 2 {
+		t.Fatalf("unexpected dead letters: %+v", letters)
+	}
+	if depth, _ := db.QueueDepth(); depth != 0 {
+		t.Errorf("expected empty queue, got %d", depth)
+	}
+
+	requeued, err := db.RequeueDeadLetters(nil)
+	if err != nil {
+		t.Fatalf("failed to requeue: %v", err)
+	}
+	if requeued != 1 {
+		t.Errorf("expected 1 requeued task, got %d", requeued)
+	}
+	task, _ = db.LeaseTask(time.Minute)
+	if task == nil || task.FilePath != "/data/file.txt" || task.Attempts != 1 {
+		t.Fatalf("expected requeued task with fresh attempts, got %+v", task)
+	}
+	if letters, _ := db.ListDeadLetters(); len(letters) != 0 {
+		t.Errorf("expected dead letters to be cleared, got %d", len(letters))
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RemoteDirectory is a remote directory mirroring a local one.
+type RemoteDirectory struct {
+	BaseID      string
+	Path        string
+	DirectoryID string
+}
+
+// GetRemoteDirectory returns the ID of the remote directory mirroring path
+// below the directory baseID, or an empty string when none is known.
+func (db *DB) GetRemoteDirectory(baseID, path string) (string, error) {
+	var directoryID string
+	err := db.conn.QueryRow(
+		`SELECT directory_id FROM remote_directories WHERE base_id = ? AND path = ?`,
+		baseID, path,
+	).Scan(&directoryID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote directory: %w", err)
+	}
+	return directoryID, nil
+}
+
+// SetRemoteDirectory records the ID of the remote directory mirroring path
+// below the directory baseID.
+func (db *DB) SetRemoteDirectory(baseID, path, directoryID string) error {
+	query := `
+		INSERT OR REPLACE INTO remote_directories (base_id, path, directory_id, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, baseID, path, directoryID, time.Now().UnixMilli())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set remote directory: %w", err)
+	}
+	return nil
+}
+
+// DeleteRemoteDirectory forgets the remote directory mirroring path and
+// those of its descendants, e.g. after it was deleted remotely. Paths are
+// slash-separated.
+func (db *DB) DeleteRemoteDirectory(baseID, path string) error {
+	query := `
+		DELETE FROM remote_directories
+		WHERE base_id = ? AND (path = ? OR substr(path, 1, length(?)) = ?)
+	`
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, baseID, path, prefix, prefix)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete remote directory: %w", err)
+	}
+	return nil
+}
+
+// ListRemoteDirectories returns every cached remote directory.
+func (db *DB) ListRemoteDirectories() ([]RemoteDirectory, error) {
+	rows, err := db.conn.Query(`SELECT base_id, path, directory_id FROM remote_directories ORDER BY base_id, path`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query remote directories: %w", err)
+	}
+	defer rows.Close()
+
+	var dirs []RemoteDirectory
+	for rows.Next() {
+		var d RemoteDirectory
+		if err := rows.Scan(&d.BaseID, &d.Path, &d.DirectoryID); err != nil {
+			return nil, fmt.Errorf("failed to scan remote directory: %w", err)
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, rows.Err()
+}
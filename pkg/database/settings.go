@@ -0,0 +1,54 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// keySaltSetting holds the random salt this installation derives key IDs
+// with.
+const keySaltSetting = "key_salt"
+
+const keySaltSize = 16
+
+// KeySalt returns the random salt this installation derives key IDs with,
+// creating it on first use. It is part of the catalog, so that a database
+// restored from it derives the same IDs.
+func (db *DB) KeySalt() ([]byte, error) {
+	salt, err := db.setting(keySaltSetting)
+	if err != nil || salt != nil {
+		return salt, err
+	}
+
+	salt = make([]byte, keySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate key salt: %w", err)
+	}
+	err = db.write(func(tx *sql.Tx) error {
+		// Another caller may have created it in the meantime
+		_, err := tx.Exec(`INSERT OR IGNORE INTO settings (name, value) VALUES (?, ?)`, keySaltSetting, salt)
+		if err != nil {
+			return fmt.Errorf("failed to save key salt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.setting(keySaltSetting)
+}
+
+// setting returns the value of a setting, or nil when it is not set.
+func (db *DB) setting(name string) ([]byte, error) {
+	var value []byte
+	err := db.conn.QueryRow(`SELECT value FROM settings WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read setting %s: %w", name, err)
+	}
+	return value, nil
+}
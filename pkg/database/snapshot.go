@@ -0,0 +1,328 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Snapshot states.
+const (
+	SnapshotRunning = "running"
+	SnapshotSuccess = "success"
+	// SnapshotPartial means some files failed; the others are restorable.
+	SnapshotPartial = "partial"
+	SnapshotFailed  = "failed"
+)
+
+// Snapshot is one run of a scheduled backup job. Times are stored as unix
+// milliseconds.
+type Snapshot struct {
+	ID         int64
+	Job        string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Status     string
+	Files      int
+	TotalSize  int64
+	Error      string
+}
+
+// SnapshotFile is a file, or a directory archive, contained in a snapshot.
+type SnapshotFile struct {
+	SnapshotID int64
+	FilePath   string
+	FileID     string
+	Checksum   string
+	Size       int64
+	Encrypted  bool
+	// KeyID identifies the password of an encrypted upload
+	KeyID string
+}
+
+// snapshotFileColumns selects a SnapshotFile; see scanSnapshotFile.
+const snapshotFileColumns = `snapshot_id, file_path, file_id, checksum, size, encrypted, key_id`
+
+func scanSnapshotFile(row rowScanner) (SnapshotFile, error) {
+	var f SnapshotFile
+	var keyID sql.NullString
+	err := row.Scan(&f.SnapshotID, &f.FilePath, &f.FileID, &f.Checksum, &f.Size, &f.Encrypted, &keyID)
+	f.KeyID = keyID.String
+	return f, err
+}
+
+const snapshotColumns = `id, job, started_at, finished_at, status, files, total_size, error`
+
+// CreateSnapshot records the start of a job run and returns its ID.
+func (db *DB) CreateSnapshot(job string, startedAt time.Time) (int64, error) {
+	var id int64
+	err := db.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`INSERT INTO snapshots (job, started_at, status) VALUES (?, ?, ?)`,
+			job, startedAt.UnixMilli(), SnapshotRunning,
+		)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	return id, nil
+}
+
+// AddSnapshotFile adds a file to a snapshot.
+func (db *DB) AddSnapshotFile(file SnapshotFile) error {
+	query := `
+		INSERT OR REPLACE INTO snapshot_files
+		(snapshot_id, file_path, file_id, checksum, size, encrypted, key_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	keyID := sql.NullString{String: file.KeyID, Valid: file.KeyID != ""}
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, file.SnapshotID, file.FilePath, file.FileID, file.Checksum, file.Size, file.Encrypted, keyID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add snapshot file: %w", err)
+	}
+	return nil
+}
+
+// FinishSnapshot sets the final status of a snapshot and totals its files.
+func (db *DB) FinishSnapshot(id int64, status, errMsg string) error {
+	query := `
+		UPDATE snapshots SET
+			finished_at = ?,
+			status = ?,
+			error = ?,
+			files = (SELECT COUNT(*) FROM snapshot_files WHERE snapshot_id = ?),
+			total_size = (SELECT COALESCE(SUM(size), 0) FROM snapshot_files WHERE snapshot_id = ?)
+		WHERE id = ?
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, time.Now().UnixMilli(), status, errMsg, id, id, id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to finish snapshot: %w", err)
+	}
+	return nil
+}
+
+// AbortRunningSnapshots marks snapshots that are still running, for
+// example after a crash, as failed.
+func (db *DB) AbortRunningSnapshots(errMsg string) (int, error) {
+	var affected int64
+	err := db.write(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`UPDATE snapshots SET status = ?, error = ?, finished_at = ? WHERE status = ?`,
+			SnapshotFailed, errMsg, time.Now().UnixMilli(), SnapshotRunning,
+		)
+		if err != nil {
+			return err
+		}
+		affected, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to abort running snapshots: %w", err)
+	}
+	return int(affected), nil
+}
+
+// GetSnapshot returns a snapshot by ID.
+func (db *DB) GetSnapshot(id int64) (*Snapshot, error) {
+	row := db.conn.QueryRow(`SELECT `+snapshotColumns+` FROM snapshots WHERE id = ?`, id)
+	return scanSnapshot(row)
+}
+
+// ListSnapshots returns the snapshots of a job, or of all jobs when job is
+// empty, most recent first.
+func (db *DB) ListSnapshots(job string) ([]Snapshot, error) {
+	query := `SELECT ` + snapshotColumns + ` FROM snapshots`
+	args := []interface{}{}
+	if job != "" {
+		query += ` WHERE job = ?`
+		args = append(args, job)
+	}
+	query += ` ORDER BY started_at DESC, id DESC`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// LatestSnapshotFiles returns the files of the most recent successful or
+// partial snapshot of job, keyed by path. It returns an empty map when the
+// job has no such snapshot.
+func (db *DB) LatestSnapshotFiles(job string) (map[string]SnapshotFile, error) {
+	var id int64
+	err := db.conn.QueryRow(
+		`SELECT id FROM snapshots WHERE job = ? AND status IN (?, ?) ORDER BY started_at DESC, id DESC LIMIT 1`,
+		job, SnapshotSuccess, SnapshotPartial,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return map[string]SnapshotFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find latest snapshot: %w", err)
+	}
+
+	files, err := db.ListSnapshotFiles(id)
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string]SnapshotFile, len(files))
+	for _, f := range files {
+		byPath[f.FilePath] = f
+	}
+	return byPath, nil
+}
+
+// ListSnapshotFiles returns the files of a snapshot ordered by path.
+func (db *DB) ListSnapshotFiles(id int64) ([]SnapshotFile, error) {
+	query := `
+		SELECT ` + snapshotFileColumns + `
+		FROM snapshot_files
+		WHERE snapshot_id = ?
+		ORDER BY file_path
+	`
+
+	rows, err := db.conn.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []SnapshotFile
+	for rows.Next() {
+		f, err := scanSnapshotFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot file: %w", err)
+		}
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// DeleteSnapshot removes a snapshot and its file list. Uploaded files no
+// other snapshot or backup record refers to are queued for deletion.
+func (db *DB) DeleteSnapshot(id int64) error {
+	return db.write(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT DISTINCT file_id FROM snapshot_files WHERE snapshot_id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("failed to query snapshot files: %w", err)
+		}
+		var objects []RemoteDeletion
+		for rows.Next() {
+			o := RemoteDeletion{Target: PrimaryTarget}
+			if err := rows.Scan(&o.FileID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan snapshot file: %w", err)
+			}
+			objects = append(objects, o)
+		}
+		rows.Close()
+
+		if _, err := tx.Exec(`DELETE FROM snapshot_files WHERE snapshot_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete snapshot files: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM snapshots WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete snapshot: %w", err)
+		}
+		_, err = queueUnreferenced(tx, objects)
+		return err
+	})
+}
+
+// Kinds of SnapshotChange.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// SnapshotChange is a file that differs between two snapshots. Old is nil
+// for an added file and New for a removed one.
+type SnapshotChange struct {
+	Kind     string
+	FilePath string
+	Old      *SnapshotFile
+	New      *SnapshotFile
+}
+
+// DiffSnapshots returns the files added, removed or changed in snapshot to
+// compared with snapshot from, ordered by path. A file changed when its
+// content did.
+func (db *DB) DiffSnapshots(from, to int64) ([]SnapshotChange, error) {
+	var lists [2][]SnapshotFile
+	for i, id := range []int64{from, to} {
+		if _, err := db.GetSnapshot(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("snapshot %d not found", id)
+			}
+			return nil, err
+		}
+		files, err := db.ListSnapshotFiles(id)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = files
+	}
+
+	var changes []SnapshotChange
+	old, cur := lists[0], lists[1]
+	for len(old) > 0 || len(cur) > 0 {
+		switch {
+		case len(cur) == 0 || len(old) > 0 && old[0].FilePath < cur[0].FilePath:
+			changes = append(changes, SnapshotChange{Kind: ChangeRemoved, FilePath: old[0].FilePath, Old: &old[0]})
+			old = old[1:]
+		case len(old) == 0 || cur[0].FilePath < old[0].FilePath:
+			changes = append(changes, SnapshotChange{Kind: ChangeAdded, FilePath: cur[0].FilePath, New: &cur[0]})
+			cur = cur[1:]
+		default:
+			if old[0].Checksum != cur[0].Checksum {
+				changes = append(changes, SnapshotChange{Kind: ChangeChanged, FilePath: cur[0].FilePath, Old: &old[0], New: &cur[0]})
+			}
+			old, cur = old[1:], cur[1:]
+		}
+	}
+	return changes, nil
+}
+
+func scanSnapshot(row rowScanner) (*Snapshot, error) {
+	var (
+		s          Snapshot
+		startedAt  int64
+		finishedAt sql.NullInt64
+		errMsg     sql.NullString
+	)
+	if err := row.Scan(&s.ID, &s.Job, &startedAt, &finishedAt, &s.Status, &s.Files, &s.TotalSize, &errMsg); err != nil {
+		return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+	}
+	s.StartedAt = time.UnixMilli(startedAt)
+	if finishedAt.Valid {
+		s.FinishedAt = time.UnixMilli(finishedAt.Int64)
+	}
+	s.Error = errMsg.String
+	return &s, nil
+}
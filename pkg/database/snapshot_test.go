@@ -0,0 +1,150 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotLifecycle(t *testing.T) {
+	db := newTestDB(t)
+
+	first, err := db.CreateSnapshot("dumps", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	for _, f := range []SnapshotFile{
+		{SnapshotID: first, FilePath: "/data/a.sql", FileID: "f1", Checksum: "c1", Size: 10},
+		{SnapshotID: first, FilePath: "/data/b.sql", FileID: "f2", Checksum: "c2", Size: 20},
+	} {
+		if err := db.AddSnapshotFile(f); err != nil {
+			t.Fatalf("failed to add snapshot file: %v", err)
+		}
+	}
+	if err := db.FinishSnapshot(first, SnapshotSuccess, ""); err != nil {
+		t.Fatalf("failed to finish snapshot: %v", err)
+	}
+
+	snapshot, err := db.GetSnapshot(first)
+	if err != nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+	if snapshot.Status != SnapshotSuccess || snapshot.Files != 2 || snapshot.TotalSize != 30 || snapshot.FinishedAt.IsZero() {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// A failed run does not replace the latest usable snapshot
+	failed, err := db.CreateSnapshot("dumps", time.Now())
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	if err := db.FinishSnapshot(failed, SnapshotFailed, "disk full"); err != nil {
+		t.Fatalf("failed to finish snapshot: %v", err)
+	}
+
+	latest, err := db.LatestSnapshotFiles("dumps")
+	if err != nil {
+		t.Fatalf("failed to get latest snapshot files: %v", err)
+	}
+	if len(latest) != 2 || latest["/data/b.sql"].FileID != "f2" {
+		t.Errorf("unexpected latest files: %+v", latest)
+	}
+
+	snapshots, err := db.ListSnapshots("dumps")
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != failed || snapshots[0].Error != "disk full" {
+		t.Errorf("unexpected snapshots: %+v", snapshots)
+	}
+
+	if err := db.DeleteSnapshot(first); err != nil {
+		t.Fatalf("failed to delete snapshot: %v", err)
+	}
+	files, err := db.ListSnapshotFiles(first)
+	if err != nil {
+		t.Fatalf("failed to list snapshot files: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("snapshot files should be deleted, got %d", len(files))
+	}
+}
+
+func TestAbortRunningSnapshots(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.CreateSnapshot("nightly", time.Now())
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+
+	aborted, err := db.AbortRunningSnapshots("interrupted")
+	if err != nil {
+		t.Fatalf("failed to abort snapshots: %v", err)
+	}
+	if aborted != 1 {
+		t.Errorf("expected 1 aborted snapshot, got %d", aborted)
+	}
+
+	snapshot, err := db.GetSnapshot(id)
+	if err != nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+	if snapshot.Status != SnapshotFailed || snapshot.Error != "interrupted" {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	db := newTestDB(t)
+
+	var ids []int64
+	for _, files := range [][]SnapshotFile{
+		{
+			{FilePath: "/data/a.sql", FileID: "f1", Checksum: "c1", Size: 10},
+			{FilePath: "/data/b.sql", FileID: "f2", Checksum: "c2", Size: 20},
+			{FilePath: "/data/c.sql", FileID: "f3", Checksum: "c3", Size: 30},
+		},
+		{
+			{FilePath: "/data/b.sql", FileID: "f4", Checksum: "c4", Size: 25},
+			{FilePath: "/data/c.sql", FileID: "f3", Checksum: "c3", Size: 30},
+			{FilePath: "/data/d.sql", FileID: "f5", Checksum: "c5", Size: 40},
+		},
+	} {
+		id, err := db.CreateSnapshot("dumps", time.Now())
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		for _, f := range files {
+			f.SnapshotID = id
+			if err := db.AddSnapshotFile(f); err != nil {
+				t.Fatalf("failed to add snapshot file: %v", err)
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	changes, err := db.DiffSnapshots(ids[0], ids[1])
+	if err != nil {
+		t.Fatalf("failed to diff snapshots: %v", err)
+	}
+	want := []struct{ kind, path string }{
+		{ChangeRemoved, "/data/a.sql"},
+		{ChangeChanged, "/data/b.sql"},
+		{ChangeAdded, "/data/d.sql"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), changes)
+	}
+	for i, w := range want {
+		if changes[i].Kind != w.kind || changes[i].FilePath != w.path {
+			t.Errorf("change %d: expected %s %s, got %s %s", i, w.kind, w.path, changes[i].Kind, changes[i].FilePath)
+		}
+	}
+	if changes[1].Old.FileID != "f2" || changes[1].New.FileID != "f4" {
+		t.Errorf("unexpected changed file: %+v, %+v", changes[1].Old, changes[1].New)
+	}
+
+	if _, err := db.DiffSnapshots(ids[0], 999); err == nil {
+		t.Error("expected an error for a missing snapshot")
+	}
+}
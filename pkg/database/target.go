@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Target copy states.
+const (
+	// CopyPending copies are waiting for an asynchronous replica
+	CopyPending = "pending"
+	CopySuccess = "success"
+	CopyFailed  = "failed"
+)
+
+// TargetCopy is the copy of a backed up file on a secondary target. The
+// primary copy is the backup record itself. Attempts counts the failed
+// attempts of a pending copy.
+type TargetCopy struct {
+	RecordID  int64
+	Target    string
+	FileID    string
+	Status    string
+	Error     string
+	Attempts  int
+	UpdatedAt time.Time
+}
+
+// PendingCopy is a copy still to be made, with the file it is made of.
+type PendingCopy struct {
+	TargetCopy
+	FilePath string
+	Checksum string
+}
+
+// GetBackupRecord returns the newest version of a file with the given
+// checksum, or nil when the content was never backed up.
+func (db *DB) GetBackupRecord(filePath, checksum string) (*BackupRecord, error) {
+	query := `
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
+		WHERE v.file_path = ? AND c.checksum = ?
+		ORDER BY v.version DESC
+		LIMIT 1
+	`
+
+	r, err := scanBackupRecord(db.conn.QueryRow(query, filePath, checksum))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup record: %w", err)
+	}
+	return &r, nil
+}
+
+// SetTargetCopy inserts or updates the copy of a record on a target. Its
+// failed attempts are reset.
+func (db *DB) SetTargetCopy(c TargetCopy) error {
+	query := `
+		INSERT OR REPLACE INTO target_copies
+		(record_id, target, file_id, status, error_message, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, c.RecordID, c.Target, c.FileID, c.Status, c.Error, time.Now().UnixMilli())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set target copy: %w", err)
+	}
+	return nil
+}
+
+// RetryTargetCopy counts a failed attempt of a pending copy and schedules
+// the next one.
+func (db *DB) RetryTargetCopy(c TargetCopy, nextAttempt time.Time) error {
+	query := `
+		UPDATE target_copies
+		SET attempts = attempts + 1, next_attempt_at = ?, error_message = ?, updated_at = ?
+		WHERE record_id = ? AND target = ? AND status = ?
+	`
+	err := db.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, nextAttempt.UnixMilli(), c.Error, time.Now().UnixMilli(), c.RecordID, c.Target, CopyPending)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule target copy retry: %w", err)
+	}
+	return nil
+}
+
+// ListTargetCopies returns the copies of a record ordered by target.
+func (db *DB) ListTargetCopies(recordID int64) ([]TargetCopy, error) {
+	return db.queryTargetCopies(`
+		SELECT record_id, target, file_id, status, error_message, attempts, updated_at
+		FROM target_copies
+		WHERE record_id = ?
+		ORDER BY target
+	`, recordID)
+}
+
+// CopiesForFileID returns the successful copies of the record whose primary
+// upload has fileID.
+func (db *DB) CopiesForFileID(fileID string) ([]TargetCopy, error) {
+	return db.queryTargetCopies(`
+		SELECT c.record_id, c.target, c.file_id, c.status, c.error_message, c.attempts, c.updated_at
+		FROM target_copies c
+		JOIN file_versions v ON v.id = c.record_id
+		WHERE v.file_id = ? AND c.status = ?
+		ORDER BY c.target
+	`, fileID, CopySuccess)
+}
+
+// PendingCopies returns up to limit copies waiting to be made whose next
+// attempt is due, oldest first.
+func (db *DB) PendingCopies(limit int) ([]PendingCopy, error) {
+	query := `
+		SELECT c.record_id, c.target, c.file_id, c.status, c.error_message, c.attempts, c.updated_at,
+		       v.file_path, n.checksum
+		FROM target_copies c
+		JOIN file_versions v ON v.id = c.record_id
+		JOIN contents n ON n.id = v.content_id
+		WHERE c.status = ? AND c.next_attempt_at <= ?
+		ORDER BY c.updated_at, c.record_id
+		LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, CopyPending, time.Now().UnixMilli(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending copies: %w", err)
+	}
+	defer rows.Close()
+
+	var copies []PendingCopy
+	for rows.Next() {
+		var p PendingCopy
+		if err := scanTargetCopy(rows, &p.TargetCopy, &p.FilePath, &p.Checksum); err != nil {
+			return nil, err
+		}
+		copies = append(copies, p)
+	}
+
+	return copies, rows.Err()
+}
+
+// TargetCopyCounts returns the number of copies per target and status.
+func (db *DB) TargetCopyCounts() (map[string]map[string]int, error) {
+	rows, err := db.conn.Query(`SELECT target, status, COUNT(*) FROM target_copies GROUP BY target, status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count target copies: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[string]int)
+	for rows.Next() {
+		var (
+			target, status string
+			n              int
+		)
+		if err := rows.Scan(&target, &status, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan target copy count: %w", err)
+		}
+		if counts[target] == nil {
+			counts[target] = make(map[string]int)
+		}
+		counts[target][status] = n
+	}
+
+	return counts, rows.Err()
+}
+
+func (db *DB) queryTargetCopies(query string, args ...interface{}) ([]TargetCopy, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query target copies: %w", err)
+	}
+	defer rows.Close()
+
+	var copies []TargetCopy
+	for rows.Next() {
+		var c TargetCopy
+		if err := scanTargetCopy(rows, &c); err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+
+	return copies, rows.Err()
+}
+
+func scanTargetCopy(row rowScanner, c *TargetCopy, extra ...interface{}) error {
+	var (
+		fileID    sql.NullString
+		errMsg    sql.NullString
+		updatedAt int64
+	)
+	dest := append([]interface{}{&c.RecordID, &c.Target, &fileID, &c.Status, &errMsg, &c.Attempts, &updatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return fmt.Errorf("failed to scan target copy: %w", err)
+	}
+	c.FileID = fileID.String
+	c.Error = errMsg.String
+	c.UpdatedAt = time.UnixMilli(updatedAt)
+	return nil
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup records are stored as file versions. A content, its checksum and
+// original size, is stored once in contents and shared by the versions of
+// any file that had it, so reverting a file to earlier content is a new
+// version rather than a conflict.
+
+// recordColumns selects a BackupRecord from recordTables; see
+// scanBackupRecord.
+const recordColumns = `v.id, v.file_path, v.version, v.file_id, c.checksum, c.size,
+	v.compressed_size, v.is_compressed, v.mode, v.mod_time, v.uid, v.gid,
+	v.link_target, v.xattrs, v.encrypted, v.key_id, v.snapshot_id,
+	v.backup_time, v.status, v.error_message, v.operation`
+
+const recordTables = `file_versions v JOIN contents c ON c.id = v.content_id`
+
+func scanBackupRecord(row rowScanner) (BackupRecord, error) {
+	var (
+		r                                     BackupRecord
+		fileID, errMsg, operation, linkTarget sql.NullString
+		keyID                                 sql.NullString
+		compressedSize, mode                  sql.NullInt64
+		modTime, snapshotID, uid, gid         sql.NullInt64
+		encrypted                             sql.NullBool
+		xattrs                                []byte
+	)
+	err := row.Scan(
+		&r.ID, &r.FilePath, &r.Version, &fileID, &r.Checksum, &r.OriginalSize,
+		&compressedSize, &r.IsCompressed, &mode, &modTime, &uid, &gid,
+		&linkTarget, &xattrs, &encrypted, &keyID, &snapshotID,
+		&r.BackupTime, &r.Status, &errMsg, &operation,
+	)
+	if err != nil {
+		return r, err
+	}
+	r.FileID, r.ErrorMessage, r.Operation = fileID.String, errMsg.String, operation.String
+	r.CompressedSize, r.Mode, r.SnapshotID = compressedSize.Int64, os.FileMode(mode.Int64), snapshotID.Int64
+	if modTime.Valid {
+		r.ModTime = time.UnixMilli(modTime.Int64)
+	}
+	r.Owner = scanOwner(uid, gid)
+	r.LinkTarget, r.KeyID = linkTarget.String, keyID.String
+	if encrypted.Valid {
+		r.Encrypted = &encrypted.Bool
+	}
+	if r.Xattrs, err = decodeXattrs(xattrs); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (db *DB) queryBackupRecords(query string, args ...interface{}) ([]BackupRecord, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query backup records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []BackupRecord
+	for rows.Next() {
+		r, err := scanBackupRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan record: %w", err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+type execQuerier interface {
+	rowQuerier
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertVersion inserts r as the next version of its file and returns its
+// ID. The content is added unless it is known.
+func insertVersion(q execQuerier, r BackupRecord) (int64, error) {
+	_, err := q.Exec(`INSERT INTO contents (checksum, size) VALUES (?, ?) ON CONFLICT (checksum) DO NOTHING`, r.Checksum, r.OriginalSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert content: %w", err)
+	}
+	var contentID int64
+	if err := q.QueryRow(`SELECT id FROM contents WHERE checksum = ?`, r.Checksum).Scan(&contentID); err != nil {
+		return 0, fmt.Errorf("failed to get content: %w", err)
+	}
+
+	var modTime sql.NullInt64
+	if !r.ModTime.IsZero() {
+		modTime = sql.NullInt64{Int64: r.ModTime.UnixMilli(), Valid: true}
+	}
+	snapshotID := sql.NullInt64{Int64: r.SnapshotID, Valid: r.SnapshotID != 0}
+	uid, gid := ownerValues(r.Owner)
+	linkTarget := sql.NullString{String: r.LinkTarget, Valid: r.LinkTarget != ""}
+	keyID := sql.NullString{String: r.KeyID, Valid: r.KeyID != ""}
+	var encrypted sql.NullBool
+	if r.Encrypted != nil {
+		encrypted = sql.NullBool{Bool: *r.Encrypted, Valid: true}
+	}
+	xattrs, err := encodeXattrs(r.Xattrs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := q.Exec(`
+		INSERT INTO file_versions
+		(file_path, version, content_id, file_id, compressed_size, is_compressed,
+		 mode, mod_time, uid, gid, link_target, xattrs, encrypted, key_id,
+		 snapshot_id, backup_time, status, error_message, operation)
+		VALUES (?, (SELECT COALESCE(MAX(version), 0) + 1 FROM file_versions WHERE file_path = ?),
+		        ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.FilePath, r.FilePath, contentID, r.FileID, r.CompressedSize, r.IsCompressed,
+		uint32(r.Mode), modTime, uid, gid, linkTarget, xattrs, encrypted, keyID,
+		snapshotID, r.BackupTime, r.Status, r.ErrorMessage, r.Operation,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert backup record: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// deleteUnusedContents removes the contents no version has anymore.
+func deleteUnusedContents(q execQuerier) error {
+	if _, err := q.Exec(`DELETE FROM contents WHERE id NOT IN (SELECT content_id FROM file_versions)`); err != nil {
+		return fmt.Errorf("failed to cleanup contents: %w", err)
+	}
+	return nil
+}
+
+// LatestBackupRecord returns the newest version of a file, or nil when it
+// has none.
+func (db *DB) LatestBackupRecord(filePath string) (*BackupRecord, error) {
+	query := `SELECT ` + recordColumns + ` FROM ` + recordTables + ` WHERE v.file_path = ? ORDER BY v.version DESC LIMIT 1`
+	r, err := scanBackupRecord(db.conn.QueryRow(query, filePath))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup record: %w", err)
+	}
+	return &r, nil
+}
+
+// InsertBackupRecordFrom inserts record as the next version of its file,
+// sharing the uploads of the version sourceID: its copies on other targets
+// are recorded for the new version as well.
+func (db *DB) InsertBackupRecordFrom(record BackupRecord, sourceID int64) (int64, error) {
+	var id int64
+	err := db.write(func(tx *sql.Tx) error {
+		var err error
+		if id, err = insertVersion(tx, record); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO target_copies (record_id, target, file_id, status, error_message, updated_at)
+			SELECT ?, target, file_id, status, error_message, updated_at
+			FROM target_copies
+			WHERE record_id = ?`,
+			id, sourceID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to copy target copies: %w", err)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// GetFileVersion returns a version of a file, or nil when it has no such
+// version.
+func (db *DB) GetFileVersion(filePath string, version int) (*BackupRecord, error) {
+	query := `SELECT ` + recordColumns + ` FROM ` + recordTables + ` WHERE v.file_path = ? AND v.version = ?`
+	r, err := scanBackupRecord(db.conn.QueryRow(query, filePath, version))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup record: %w", err)
+	}
+	return &r, nil
+}
+
+// LatestVersionsUnder returns the newest version of root and of every file
+// below it as of at, ordered by path. A zero at returns the newest
+// versions.
+func (db *DB) LatestVersionsUnder(root string, at time.Time) ([]BackupRecord, error) {
+	prefix, end := pathRange(root)
+	query := `
+		SELECT ` + recordColumns + `
+		FROM ` + recordTables + `
+		WHERE (v.file_path = ? OR (v.file_path >= ? AND v.file_path < ?))
+		  AND v.version = (
+			SELECT MAX(version) FROM file_versions
+			WHERE file_path = v.file_path AND (? OR backup_time <= ?))
+		ORDER BY v.file_path
+	`
+	return db.queryBackupRecords(query, root, prefix, end, at.IsZero(), at)
+}
+
+// versionAt returns the newest version of a file as of at, or nil.
+func (db *DB) versionAt(filePath string, at time.Time) (*BackupRecord, error) {
+	if at.IsZero() {
+		return db.LatestBackupRecord(filePath)
+	}
+	query := `SELECT ` + recordColumns + ` FROM ` + recordTables + `
+		WHERE v.file_path = ? AND v.backup_time <= ? ORDER BY v.version DESC LIMIT 1`
+	r, err := scanBackupRecord(db.conn.QueryRow(query, filePath, at))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup record: %w", err)
+	}
+	return &r, nil
+}
+
+// pathRange returns the bounds of the paths below dir: they sort at or
+// after prefix and before end.
+func pathRange(dir string) (prefix, end string) {
+	if dir == "" {
+		return "", maxPath
+	}
+	prefix = dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return prefix, prefix[:len(prefix)-1] + string(rune(filepath.Separator+1))
+}
+
+// maxPath sorts after every path.
+const maxPath = "\U0010FFFF"
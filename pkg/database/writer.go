@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is returned by writes to a closed database.
+var ErrClosed = errors.New("database is closed")
+
+// writeBatchSize bounds the number of writes committed in one transaction.
+const writeBatchSize = 64
+
+type writeOp struct {
+	fn   func(tx *sql.Tx) error
+	done chan error
+}
+
+// write runs fn in the writer goroutine and returns its error. Writes
+// queued meanwhile by other goroutines are committed in the same
+// transaction, each in its own savepoint so that a failing write does not
+// undo the others. fn must only use tx.
+func (db *DB) write(fn func(tx *sql.Tx) error) error {
+	op := writeOp{fn: fn, done: make(chan error, 1)}
+	select {
+	case db.writes <- op:
+	case <-db.closing:
+		return ErrClosed
+	}
+	return <-op.done
+}
+
+// writer commits the queued writes in batches until the database is
+// closed. It is the only goroutine writing through write, so workers never
+// contend for the SQLite write lock among themselves.
+func (db *DB) writer() {
+	defer close(db.writerDone)
+	for {
+		var batch []writeOp
+		select {
+		case op := <-db.writes:
+			batch = append(batch, op)
+		case <-db.closing:
+			return
+		}
+
+	collect:
+		for len(batch) < writeBatchSize {
+			select {
+			case op := <-db.writes:
+				batch = append(batch, op)
+			default:
+				break collect
+			}
+		}
+		db.commitBatch(batch)
+	}
+}
+
+func (db *DB) commitBatch(batch []writeOp) {
+	errs := make([]error, len(batch))
+	err := func() error {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for i, op := range batch {
+			if _, err := tx.Exec(`SAVEPOINT write`); err != nil {
+				return err
+			}
+			if errs[i] = op.fn(tx); errs[i] != nil {
+				if _, err := tx.Exec(`ROLLBACK TO write`); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(`RELEASE write`); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+
+	for i, op := range batch {
+		if errs[i] == nil && err != nil {
+			errs[i] = fmt.Errorf("failed to commit write: %w", err)
+		}
+		op.done <- errs[i]
+	}
+}
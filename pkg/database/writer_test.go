@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	db := newTestDB(t)
+
+	var mode string
+	if err := db.conn.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("expected WAL journal mode, got %q, %v", mode, err)
+	}
+
+	const workers, files = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*files)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				path := fmt.Sprintf("/data/%d/%d.txt", w, i)
+				checksum := fmt.Sprintf("c-%d-%d", w, i)
+				if _, err := db.InsertBackupRecord(BackupRecord{FilePath: path, Checksum: checksum, BackupTime: time.Now(), Status: "success"}); err != nil {
+					errs <- err
+				}
+				if err := db.UpdateFileState(FileState{FilePath: path, LastChecksum: checksum, Status: "success", BackupCount: 1}); err != nil {
+					errs <- err
+				}
+				// Reads run while other workers write
+				if _, err := db.GetFileState(path); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+
+	var records, states int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM file_versions`).Scan(&records)
+	db.conn.QueryRow(`SELECT COUNT(*) FROM file_states`).Scan(&states)
+	if records != workers*files || states != workers*files {
+		t.Errorf("expected %d records and states, got %d and %d", workers*files, records, states)
+	}
+}
+
+func TestMaintenanceDuringWrites(t *testing.T) {
+	db := newTestDB(t)
+
+	// Old records for prune and cleanup to remove
+	var old []int64
+	for i := 0; i < 50; i++ {
+		id, err := db.InsertBackupRecord(BackupRecord{FilePath: fmt.Sprintf("/old/%d.txt", i), FileID: fmt.Sprintf("o%d", i), Checksum: fmt.Sprintf("old-%d", i), BackupTime: time.Now().AddDate(0, 0, -30), Status: "success"})
+		if err != nil {
+			t.Fatalf("failed to insert record: %v", err)
+		}
+		old = append(old, id)
+	}
+
+	const workers, files = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*files+100)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				path := fmt.Sprintf("/data/%d/%d.txt", w, i)
+				if _, err := db.InsertBackupRecord(BackupRecord{FilePath: path, Checksum: path, BackupTime: time.Now(), Status: "success"}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+
+	// Snapshots, prune and cleanup run while the workers write
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			id, err := db.CreateSnapshot("daily", time.Now())
+			if err == nil {
+				err = db.AddSnapshotFile(SnapshotFile{SnapshotID: id, FilePath: "/data/a.txt", FileID: fmt.Sprintf("s%d", i), Checksum: "c"})
+			}
+			if err == nil {
+				err = db.FinishSnapshot(id, SnapshotSuccess, "")
+			}
+			if err == nil {
+				err = db.DeleteSnapshot(id)
+			}
+			if err == nil {
+				err = db.SetRemoteDirectory("base", fmt.Sprintf("dir-%d", i), "id")
+			}
+			if err != nil {
+				errs <- err
+			}
+		}
+		if _, err := db.DeleteBackupRecords(old[:25]); err != nil {
+			errs <- err
+		}
+		if err := db.CleanupOldRecords(7); err != nil {
+			errs <- err
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("write failed: %v", err)
+	}
+
+	var records, snapshots int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM file_versions`).Scan(&records)
+	db.conn.QueryRow(`SELECT COUNT(*) FROM snapshots`).Scan(&snapshots)
+	if records != workers*files || snapshots != 0 {
+		t.Errorf("expected %d records and no snapshots, got %d and %d", workers*files, records, snapshots)
+	}
+}
+
+func TestWriteBatchIsolatesFailures(t *testing.T) {
+	db := newTestDB(t)
+
+	failing := errors.New("failing write")
+	batch := []writeOp{
+		{fn: func(tx *sql.Tx) error {
+			_, err := insertVersion(tx, BackupRecord{FilePath: "/data/a.txt", Checksum: "c1", Status: "success"})
+			return err
+		}},
+		{fn: func(tx *sql.Tx) error {
+			if _, err := insertVersion(tx, BackupRecord{FilePath: "/data/b.txt", Checksum: "c2", Status: "success"}); err != nil {
+				return err
+			}
+			return failing
+		}},
+		{fn: func(tx *sql.Tx) error {
+			_, err := insertVersion(tx, BackupRecord{FilePath: "/data/c.txt", Checksum: "c3", Status: "success"})
+			return err
+		}},
+	}
+	for i := range batch {
+		batch[i].done = make(chan error, 1)
+	}
+	db.commitBatch(batch)
+
+	for i, op := range batch {
+		err := <-op.done
+		if (i == 1) != errors.Is(err, failing) {
+			t.Errorf("write %d: unexpected error %v", i, err)
+		}
+	}
+	for path, want := range map[string]bool{"/data/a.txt": true, "/data/b.txt": false, "/data/c.txt": true} {
+		latest, err := db.LatestBackupRecord(path)
+		if err != nil || (latest != nil) != want {
+			t.Errorf("%s: got %+v, %v", path, latest, err)
+		}
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := db.UpdateFileState(FileState{FilePath: "/data/a.txt", Status: "success"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -23,6 +25,10 @@ const (
 	IterationCount = 100000
 )
 
+// ErrDecrypt is returned when data cannot be decrypted, usually because
+// the password is wrong
+var ErrDecrypt = errors.New("wrong password or corrupted data")
+
 // Encryptor handles file encryption operations
 type Encryptor struct {
 	password string
@@ -51,6 +57,31 @@ func (e *Encryptor) EncryptFile(inputPath string, outputPath string) error {
 	}
 	defer outputFile.Close()
 
+	return e.Encrypt(outputFile, inputFile)
+}
+
+// DecryptFile decrypts a file and returns the path to the decrypted file
+func (e *Encryptor) DecryptFile(inputPath string, outputPath string) error {
+	// Open input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	// Create output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	return e.Decrypt(outputFile, inputFile)
+}
+
+// Encrypt reads r to the end and writes it encrypted to w, in the format
+// of EncryptFile.
+func (e *Encryptor) Encrypt(w io.Writer, r io.Reader) error {
 	// Generate random salt
 	salt := make([]byte, SaltSize)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
@@ -79,10 +110,10 @@ func (e *Encryptor) EncryptFile(inputPath string, outputPath string) error {
 	}
 
 	// Write salt and nonce to output file
-	if _, err := outputFile.Write(salt); err != nil {
+	if _, err := w.Write(salt); err != nil {
 		return fmt.Errorf("failed to write salt: %w", err)
 	}
-	if _, err := outputFile.Write(nonce); err != nil {
+	if _, err := w.Write(nonce); err != nil {
 		return fmt.Errorf("failed to write nonce: %w", err)
 	}
 
@@ -91,9 +122,9 @@ func (e *Encryptor) EncryptFile(inputPath string, outputPath string) error {
 	buffer := make([]byte, chunkSize)
 
 	for {
-		n, err := inputFile.Read(buffer)
+		n, err := r.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read input file: %w", err)
+			return fmt.Errorf("failed to read input: %w", err)
 		}
 		if n == 0 {
 			break
@@ -109,10 +140,10 @@ func (e *Encryptor) EncryptFile(inputPath string, outputPath string) error {
 		chunkSizeBytes[2] = byte(len(encrypted) >> 8)
 		chunkSizeBytes[3] = byte(len(encrypted))
 		
-		if _, err := outputFile.Write(chunkSizeBytes); err != nil {
+		if _, err := w.Write(chunkSizeBytes); err != nil {
 			return fmt.Errorf("failed to write chunk size: %w", err)
 		}
-		if _, err := outputFile.Write(encrypted); err != nil {
+		if _, err := w.Write(encrypted); err != nil {
 			return fmt.Errorf("failed to write encrypted data: %w", err)
 		}
 
@@ -123,25 +154,12 @@ func (e *Encryptor) EncryptFile(inputPath string, outputPath string) error {
 	return nil
 }
 
-// DecryptFile decrypts a file and returns the path to the decrypted file
-func (e *Encryptor) DecryptFile(inputPath string, outputPath string) error {
-	// Open input file
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
-	}
-	defer inputFile.Close()
-
-	// Create output file
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outputFile.Close()
-
+// Decrypt reads data written by Encrypt from r and writes it decrypted to
+// w. A wrong password fails with ErrDecrypt.
+func (e *Encryptor) Decrypt(w io.Writer, r io.Reader) error {
 	// Read salt
 	salt := make([]byte, SaltSize)
-	if _, err := io.ReadFull(inputFile, salt); err != nil {
+	if _, err := io.ReadFull(r, salt); err != nil {
 		return fmt.Errorf("failed to read salt: %w", err)
 	}
 
@@ -162,7 +180,7 @@ func (e *Encryptor) DecryptFile(inputPath string, outputPath string) error {
 
 	// Read nonce
 	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(inputFile, nonce); err != nil {
+	if _, err := io.ReadFull(r, nonce); err != nil {
 		return fmt.Errorf("failed to read nonce: %w", err)
 	}
 
@@ -170,7 +188,7 @@ func (e *Encryptor) DecryptFile(inputPath string, outputPath string) error {
 	for {
 		// Read chunk size
 		chunkSizeBytes := make([]byte, 4)
-		n, err := io.ReadFull(inputFile, chunkSizeBytes)
+		n, err := io.ReadFull(r, chunkSizeBytes)
 		if err == io.EOF || n == 0 {
 			break
 		}
@@ -183,18 +201,18 @@ func (e *Encryptor) DecryptFile(inputPath string, outputPath string) error {
 
 		// Read encrypted chunk
 		encryptedChunk := make([]byte, chunkSize)
-		if _, err := io.ReadFull(inputFile, encryptedChunk); err != nil {
+		if _, err := io.ReadFull(r, encryptedChunk); err != nil {
 			return fmt.Errorf("failed to read encrypted chunk: %w", err)
 		}
 
 		// Decrypt chunk
 		decrypted, err := gcm.Open(nil, nonce, encryptedChunk, nil)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt chunk: %w", err)
+			return fmt.Errorf("failed to decrypt chunk: %w", ErrDecrypt)
 		}
 
 		// Write decrypted data
-		if _, err := outputFile.Write(decrypted); err != nil {
+		if _, err := w.Write(decrypted); err != nil {
 			return fmt.Errorf("failed to write decrypted data: %w", err)
 		}
 
@@ -215,6 +233,15 @@ func incrementNonce(nonce []byte) {
 	}
 }
 
+// KeyID returns a short identifier of a password, so that data encrypted
+// with different passwords can be told apart without revealing them. salt
+// is random per installation: a fixed salt would let one precomputed table
+// test guesses against the IDs of every installation.
+func KeyID(password string, salt []byte) string {
+	id := pbkdf2.Key([]byte(password), salt, IterationCount, 8, sha256.New)
+	return hex.EncodeToString(id)
+}
+
 // GetEncryptedFileName returns the encrypted file name with .enc extension
 func GetEncryptedFileName(originalPath string) string {
 	return originalPath + ".enc"
@@ -3,6 +3,7 @@ package encryption
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -114,6 +115,23 @@ func TestEncryptWithWrongPassword(t *testing.T) {
 	if err == nil {
 		t.Error("decryption with wrong password should fail")
 	}
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt, got %v", err)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	salt := []byte("installation-one")
+	id := KeyID("correct-password", salt)
+	if len(id) != 16 || id != KeyID("correct-password", salt) {
+		t.Errorf("unexpected key ID %q", id)
+	}
+	if id == KeyID("wrong-password", salt) {
+		t.Error("different passwords have the same key ID")
+	}
+	if id == KeyID("correct-password", []byte("installation-two")) {
+		t.Error("different installations have the same key ID")
+	}
 }
 
 func TestGetEncryptedFileName(t *testing.T) {
@@ -0,0 +1,108 @@
+// Package metadata captures the POSIX metadata of files at backup time and
+// applies it again on restore: mode, owner, modification time, symbolic
+// link targets and extended attributes, which hold ACLs on Linux.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+)
+
+// Metadata is the metadata of a file, a directory or a symbolic link.
+type Metadata struct {
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time,omitzero"`
+	// Owner is nil where file ownership is not supported
+	Owner *Owner `json:"owner,omitempty"`
+	// Link is the target of a symbolic link
+	Link string `json:"link,omitempty"`
+	// Xattrs are the extended attributes that could be read, including
+	// the POSIX ACLs in system.posix_acl_access and system.posix_acl_default
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// Owner is the numeric owner and group of a file.
+type Owner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// IsSymlink reports whether m is the metadata of a symbolic link.
+func (m *Metadata) IsSymlink() bool {
+	return m.Mode&os.ModeSymlink != 0
+}
+
+// Equal reports whether m and other describe the same metadata.
+func (m *Metadata) Equal(other *Metadata) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	sameOwner := m.Owner == other.Owner ||
+		(m.Owner != nil && other.Owner != nil && *m.Owner == *other.Owner)
+	return m.Mode == other.Mode && m.ModTime.Equal(other.ModTime) && sameOwner &&
+		m.Link == other.Link && maps.EqualFunc(m.Xattrs, other.Xattrs, func(a, b []byte) bool {
+		return string(a) == string(b)
+	})
+}
+
+// Capture returns the metadata of path, without following a symbolic link
+// at path. Extended attributes that cannot be read, for lack of permission
+// or support, are left out.
+func Capture(path string) (*Metadata, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		Owner:   fileOwner(info),
+	}
+	if m.IsSymlink() {
+		if m.Link, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("failed to read link: %w", err)
+		}
+	}
+	m.Xattrs = readXattrs(path)
+	return m, nil
+}
+
+// ApplyOptions selects the metadata Apply leaves alone.
+type ApplyOptions struct {
+	// NoOwner keeps the owner of the user restoring, which is needed to
+	// restore as another user than root
+	NoOwner bool
+}
+
+// Apply sets the metadata of the file, directory or symbolic link at path,
+// which must exist. It applies as much as it is permitted to: the returned
+// error joins what could not be applied. The modification time is set
+// last, as setting the rest may change it.
+func Apply(path string, m *Metadata, opts ApplyOptions) error {
+	var errs []error
+	if m.Owner != nil && !opts.NoOwner {
+		if err := os.Lchown(path, m.Owner.UID, m.Owner.GID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set owner: %w", err))
+		}
+	}
+	// The mode of a symbolic link cannot be set, and changing it would
+	// change its target
+	if !m.IsSymlink() {
+		// Set after the owner, as changing the owner clears setuid
+		if err := os.Chmod(path, m.Mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set mode: %w", err))
+		}
+	}
+	if err := writeXattrs(path, m.Xattrs); err != nil {
+		errs = append(errs, err)
+	}
+	if !m.ModTime.IsZero() {
+		if err := setModTime(path, m.ModTime, m.IsSymlink()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set modification time: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
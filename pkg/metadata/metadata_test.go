@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCaptureAndApply(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.sh")
+	if err := os.WriteFile(src, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Chmod(src, 0750); err != nil {
+		t.Fatalf("failed to set mode: %v", err)
+	}
+	modTime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatalf("failed to set times: %v", err)
+	}
+
+	m, err := Capture(src)
+	if err != nil {
+		t.Fatalf("failed to capture metadata: %v", err)
+	}
+	if m.Mode.Perm() != 0750 || !m.ModTime.Equal(modTime) || m.IsSymlink() {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+	if runtime.GOOS != "windows" && (m.Owner == nil || m.Owner.UID != os.Getuid()) {
+		t.Errorf("expected the owner to be captured, got %+v", m.Owner)
+	}
+
+	dst := filepath.Join(dir, "dst.sh")
+	if err := os.WriteFile(dst, []byte("#!/bin/sh\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := Apply(dst, m, ApplyOptions{}); err != nil {
+		t.Fatalf("failed to apply metadata: %v", err)
+	}
+	applied, err := Capture(dst)
+	if err != nil {
+		t.Fatalf("failed to capture metadata: %v", err)
+	}
+	if !applied.Equal(m) {
+		t.Errorf("expected %+v, got %+v", m, applied)
+	}
+}
+
+func TestCaptureSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+	dir := t.TempDir()
+	link := filepath.Join(dir, "current")
+	if err := os.Symlink("releases/v2", link); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	m, err := Capture(link)
+	if err != nil {
+		t.Fatalf("failed to capture metadata: %v", err)
+	}
+	if !m.IsSymlink() || m.Link != "releases/v2" {
+		t.Errorf("unexpected link metadata: %+v", m)
+	}
+
+	// The times of the link are set, not of its missing target
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.ModTime = modTime
+	if err := Apply(link, m, ApplyOptions{NoOwner: true}); err != nil {
+		t.Fatalf("failed to apply metadata: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("expected the link time to be set, got %v, %v", info.ModTime(), err)
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("extended attributes are captured on Linux only")
+	}
+	path := filepath.Join(t.TempDir(), "tagged.txt")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	m := &Metadata{Mode: 0644, Xattrs: map[string][]byte{"user.origin": []byte("scanner")}}
+	if err := Apply(path, m, ApplyOptions{NoOwner: true}); err != nil {
+		t.Skipf("extended attributes not supported here: %v", err)
+	}
+	captured, err := Capture(path)
+	if err != nil {
+		t.Fatalf("failed to capture metadata: %v", err)
+	}
+	if string(captured.Xattrs["user.origin"]) != "scanner" {
+		t.Errorf("unexpected extended attributes: %q", captured.Xattrs)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package metadata
+
+import (
+	"os"
+	"time"
+)
+
+func fileOwner(info os.FileInfo) *Owner {
+	return nil
+}
+
+// setModTime sets the access and modification times of path to t. The
+// times of symbolic links are not supported.
+func setModTime(path string, t time.Time, symlink bool) error {
+	if symlink {
+		return nil
+	}
+	return os.Chtimes(path, t, t)
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func fileOwner(info os.FileInfo) *Owner {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Owner{UID: int(st.Uid), GID: int(st.Gid)}
+}
+
+// setModTime sets the access and modification times of path to t, of the
+// link itself when symlink is set.
+func setModTime(path string, t time.Time, symlink bool) error {
+	if !symlink {
+		return os.Chtimes(path, t, t)
+	}
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path, not following a
+// symbolic link, or nil when it has none that can be read.
+func readXattrs(path string) map[string][]byte {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil
+	}
+
+	var xattrs map[string][]byte
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err != nil {
+			continue
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, value)
+		// The value may have grown since its size was read
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+}
+
+// writeXattrs sets the extended attributes of path, not following a
+// symbolic link. Attributes in namespaces the user may not write, such as
+// trusted and security, fail unless running as root.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed []string
+	var lastErr error
+	for _, name := range names {
+		if err := unix.Lsetxattr(path, name, xattrs[name], 0); err != nil {
+			failed = append(failed, name)
+			lastErr = err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to set extended attributes %v: %w", failed, lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package metadata
+
+import "errors"
+
+func readXattrs(path string) map[string][]byte {
+	return nil
+}
+
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	if len(xattrs) > 0 {
+		return errors.New("extended attributes are not supported on this platform")
+	}
+	return nil
+}